@@ -0,0 +1,278 @@
+package lbadd
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DB is a database which can be embedded in a program, and is safe for use by
+// multiple goroutines. The database is held in memory, and optionally kept in
+// a file between uses.
+//
+// Each statement is executed in a transaction of its own, unless one has been
+// begun with BEGIN, in which case the statements take effect once it is
+// committed with COMMIT, or not at all if it is rolled back with ROLLBACK. A
+// transaction holds the statements of every goroutine using the DB, so the
+// database/sql driver, whose connections each have transactions of their own,
+// should be used for transactions across goroutines.
+type DB struct {
+	mu       sync.Mutex
+	executor *executor
+	path     string // the file the database is kept in, empty if it isn't
+	closed   bool
+}
+
+// Options configure a database opened with Open.
+type Options struct {
+	// Order is the order of the B-trees rows are stored in, the default
+	// order is used if it is zero.
+	Order int
+
+	// LockTimeout is how long a statement waits for a lock held by another
+	// transaction before failing with ErrLockTimeout. Statements wait until
+	// their context is done if it is zero.
+	LockTimeout time.Duration
+
+	// FileCopy allows COPY statements to read and write files, with the
+	// permissions of the process. It is off by default, as any SQL executed
+	// could otherwise read or overwrite the process's files.
+	FileCopy bool
+}
+
+// Result describes the effects of statements executed with Exec.
+type Result struct {
+	RowsAffected int // the number of rows inserted, updated or deleted
+	Created      int // the number of tables created
+}
+
+// ErrClosed is returned when using a database which has been closed.
+var ErrClosed = errors.New("database is closed")
+
+// Open opens a database. If a path is given, the database is restored from
+// the dump in that file if it exists, and dumped back into it when closed.
+// In between, committed transactions are kept in a log next to the file, the
+// path followed by -wal, which is replayed when the database is next opened
+// if it wasn't closed. Without a path, the database only exists in memory.
+// Options may be nil, in which case the defaults are used.
+func Open(path string, opts *Options) (*DB, error) {
+	cfg := exeConfig{order: defaultOrder}
+	if opts != nil && opts.Order != 0 {
+		cfg.order = opts.Order
+	}
+	if cfg.order < 2 {
+		return nil, fmt.Errorf("invalid order %d, the order must be at least 2", cfg.order)
+	}
+	if opts != nil {
+		cfg.lockTimeout = opts.LockTimeout
+		cfg.fileCopy = opts.FileCopy
+	}
+	if cfg.lockTimeout < 0 {
+		return nil, fmt.Errorf("invalid lock timeout %s", cfg.lockTimeout)
+	}
+
+	db := &DB{executor: newExecutor(cfg), path: path}
+	if path == "" {
+		return db, nil
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if _, err := db.executor.restore(context.Background(), bytes.NewReader(data)); err != nil {
+		return nil, err
+	}
+
+	log, err := openWAL(db.walPath(), checksum(data), db.executor)
+	if err != nil {
+		return nil, err
+	}
+	db.executor.db.log = log
+
+	return db, nil
+}
+
+// Exec executes the SQL statement with the arguments bound to its
+// parameters, see Query for how parameters are written. Without any
+// arguments, the SQL may hold several statements separated by semicolons,
+// which are executed in order, stopping at the first which fails. Statements
+// stop early with an error matching ErrCanceled once the context is done.
+func (db *DB) Exec(ctx context.Context, sql string, args ...interface{}) (Result, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if err := db.check(ctx); err != nil {
+		return Result{}, err
+	}
+
+	return db.executor.exec(ctx, sql, args...)
+}
+
+// exec executes SQL in the session, as described by DB.Exec.
+func (e *executor) exec(ctx context.Context, sql string, args ...interface{}) (Result, error) {
+	if len(args) == 0 {
+		stmts, err := parseScript(sql)
+		if err != nil {
+			return Result{}, err
+		}
+
+		res := Result{}
+		for _, s := range stmts {
+			r, err := e.executeQuery(ctx, s.query)
+			if err != nil {
+				return res, err
+			}
+			res.RowsAffected += r.rowsAffected
+			res.Created += r.created
+		}
+		return res, nil
+	}
+
+	stmt, err := e.prepare(trimStatement(sql))
+	if err != nil {
+		return Result{}, err
+	}
+	r, err := stmt.exec(ctx, args...)
+	if err != nil {
+		return Result{}, err
+	}
+
+	return Result{RowsAffected: r.rowsAffected, Created: r.created}, nil
+}
+
+// Query executes the SELECT statement with the arguments bound to its
+// parameters, returning the selected rows. Parameters are written as
+// placeholders, either positional (? or $1) or named (:name), and arguments
+// are bound in order, or by name with sql.Named.
+func (db *DB) Query(ctx context.Context, sql string, args ...interface{}) (*Rows, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if err := db.check(ctx); err != nil {
+		return nil, err
+	}
+
+	return db.executor.query(ctx, sql, args...)
+}
+
+// query executes the SELECT statement in the session, as described by
+// DB.Query.
+func (e *executor) query(ctx context.Context, sql string, args ...interface{}) (*Rows, error) {
+	stmt, err := e.prepare(trimStatement(sql))
+	if err != nil {
+		return nil, err
+	}
+	res, err := stmt.query(ctx, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Rows{columns: res.columns, rows: res.rows}, nil
+}
+
+// Close closes the database, rolling back any transaction in progress, and
+// dumping the database into its file if it has one. The file is replaced at
+// once, so it is left as it was if dumping fails, in which case the log of
+// committed transactions is kept.
+func (db *DB) Close() error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if db.closed {
+		return ErrClosed
+	}
+	db.closed = true
+
+	if db.executor.tx != nil {
+		db.executor.rollback()
+	}
+	err := db.save()
+	log := db.executor.db.log
+	if log == nil {
+		return err
+	}
+
+	if cerr := log.close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Remove(db.walPath())
+	}
+	return err
+}
+
+// save dumps the database into its file, if it has one, after which the log
+// is cleared. Transactions wait to commit until the database has been saved,
+// so that none are lost from both the dump and the log.
+func (db *DB) save() error {
+	if db.path == "" {
+		return nil
+	}
+
+	shared := db.executor.db
+	shared.commitMu.Lock()
+	defer shared.commitMu.Unlock()
+
+	var buf bytes.Buffer
+	if err := db.executor.dump(context.Background(), &buf); err != nil {
+		return err
+	}
+
+	f, err := ioutil.TempFile(filepath.Dir(db.path), filepath.Base(db.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	_, err = f.Write(buf.Bytes())
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), db.path)
+	}
+	if err != nil {
+		return err
+	}
+
+	return shared.log.reset(checksum(buf.Bytes()))
+}
+
+// walPath returns the path of the file the log of the database is kept in.
+func (db *DB) walPath() string {
+	return db.path + "-wal"
+}
+
+// inTransaction reports whether a transaction begun with BEGIN is in
+// progress.
+func (db *DB) inTransaction() bool {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	return db.executor.inTransaction()
+}
+
+// check returns an error if the database can't be used for the context.
+func (db *DB) check(ctx context.Context) error {
+	if db.closed {
+		return ErrClosed
+	}
+
+	return checkContext(ctx)
+}
+
+// trimStatement removes the semicolon which may terminate a single statement.
+func trimStatement(sql string) string {
+	return strings.TrimSuffix(strings.TrimSpace(sql), ";")
+}
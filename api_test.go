@@ -0,0 +1,230 @@
+package lbadd
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDB(t *testing.T) {
+	ctx := context.Background()
+	db, err := Open("", &Options{Order: 4})
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	res, err := db.Exec(ctx, "CREATE TABLE users (name string NOT NULL, age integer); INSERT INTO users VALUES ('Jane', 7), ('John', 42);")
+	assert.NoError(t, err)
+	assert.Equal(t, Result{RowsAffected: 2, Created: 1}, res)
+
+	res, err = db.Exec(ctx, "UPDATE users SET age = :age WHERE name = :name;", sql.Named("name", "Jane"), sql.Named("age", 8))
+	assert.NoError(t, err)
+	assert.Equal(t, Result{RowsAffected: 1}, res)
+
+	rows, err := db.Query(ctx, "SELECT name, age FROM users WHERE age > ?", 5)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, []string{"name", "age"}, rows.Columns())
+
+	type user struct {
+		name string
+		age  int
+	}
+	users := []user{}
+	for rows.Next() {
+		var u user
+		assert.NoError(t, rows.Scan(&u.name, &u.age))
+		users = append(users, u)
+	}
+	assert.NoError(t, rows.Err())
+	assert.NoError(t, rows.Close())
+	assert.Equal(t, []user{{"Jane", 8}, {"John", 42}}, users)
+
+	// Conditions are expressions, which may use parameters
+	rows, err = db.Query(ctx, "SELECT name FROM users WHERE age % 2 = 0 AND CASE WHEN age > ? THEN name END IS NOT NULL", 10)
+	if !assert.NoError(t, err) {
+		return
+	}
+	var name string
+	if assert.True(t, rows.Next()) {
+		assert.NoError(t, rows.Scan(&name))
+	}
+	assert.False(t, rows.Next())
+	assert.NoError(t, rows.Close())
+	assert.Equal(t, "John", name)
+
+	_, err = db.Query(ctx, "DELETE FROM users")
+	assert.EqualError(t, err, "DELETE statement does not return rows")
+
+	_, err = db.Exec(ctx, "SELECT FROM users")
+	assert.IsType(t, &ParseError{}, err)
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err = db.Exec(cancelled, "DELETE FROM users")
+	assert.True(t, errors.Is(err, ErrCanceled))
+	assert.True(t, errors.Is(err, context.Canceled))
+
+	assert.NoError(t, db.Close())
+	_, err = db.Query(ctx, "SELECT * FROM users")
+	assert.Equal(t, ErrClosed, err)
+	assert.Equal(t, ErrClosed, db.Close())
+}
+
+func TestOpen_path(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lbadd")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "test.db")
+	ctx := context.Background()
+
+	db, err := Open(path, nil)
+	if !assert.NoError(t, err) {
+		return
+	}
+	_, err = db.Exec(ctx, "CREATE TABLE t (a integer); INSERT INTO t VALUES (1), (2)")
+	assert.NoError(t, err)
+	assert.NoError(t, db.Close())
+
+	// The database is restored from the file it was closed into
+	db, err = Open(path, nil)
+	if !assert.NoError(t, err) {
+		return
+	}
+	rows, err := db.Query(ctx, "SELECT a FROM t WHERE a > 1;")
+	if !assert.NoError(t, err) {
+		return
+	}
+	var a int64
+	assert.True(t, rows.Next())
+	assert.NoError(t, rows.Scan(&a))
+	assert.Equal(t, int64(2), a)
+	assert.False(t, rows.Next())
+	assert.NoError(t, db.Close())
+
+	files, err := ioutil.ReadDir(dir)
+	assert.NoError(t, err)
+	assert.Len(t, files, 1)
+
+	// A file which isn't a dump fails to open
+	assert.NoError(t, ioutil.WriteFile(path, []byte("nonsense"), 0600))
+	_, err = Open(path, nil)
+	assert.Error(t, err)
+}
+
+func TestOpen_nonFiniteFloats(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lbadd")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+	path, csv := filepath.Join(dir, "test.db"), filepath.Join(dir, "scores.csv")
+	ctx := context.Background()
+	assert.NoError(t, ioutil.WriteFile(csv, []byte("5,NaN\n6,+Inf\n"), 0600))
+
+	db, err := Open(path, &Options{FileCopy: true})
+	if !assert.NoError(t, err) {
+		return
+	}
+	_, err = db.Exec(ctx, "CREATE TABLE scores (n integer, score float); COPY scores FROM '"+csv+"'")
+	assert.NoError(t, err)
+	_, err = db.Exec(ctx, "INSERT INTO scores VALUES (?, ?)", 7, math.Inf(-1))
+	assert.NoError(t, err)
+	assert.NoError(t, db.Close())
+
+	// Floats which aren't finite are dumped so that the file opens again
+	db, err = Open(path, nil)
+	if !assert.NoError(t, err) {
+		return
+	}
+	rows, err := db.Query(ctx, "SELECT score FROM scores")
+	if !assert.NoError(t, err) {
+		return
+	}
+	scores := []float64{}
+	for rows.Next() {
+		var score float64
+		assert.NoError(t, rows.Scan(&score))
+		scores = append(scores, score)
+	}
+	if assert.Len(t, scores, 3) {
+		assert.True(t, math.IsNaN(scores[0]))
+		assert.Equal(t, []float64{math.Inf(1), math.Inf(-1)}, scores[1:])
+	}
+	assert.NoError(t, db.Close())
+}
+
+func TestOpen_log(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lbadd")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "test.db")
+	ctx := context.Background()
+
+	selectAll := func(db *DB) []int {
+		rows, err := db.Query(ctx, "SELECT a FROM t")
+		if !assert.NoError(t, err) {
+			return nil
+		}
+		values := []int{}
+		for rows.Next() {
+			var a int
+			assert.NoError(t, rows.Scan(&a))
+			values = append(values, a)
+		}
+		return values
+	}
+
+	db, err := Open(path, nil)
+	if !assert.NoError(t, err) {
+		return
+	}
+	_, err = db.Exec(ctx, "CREATE TABLE t (a integer); INSERT INTO t VALUES (1)")
+	assert.NoError(t, err)
+	assert.NoError(t, db.Close())
+
+	db, err = Open(path, nil)
+	if !assert.NoError(t, err) {
+		return
+	}
+	_, err = db.Exec(ctx, "INSERT INTO t VALUES (2); BEGIN; INSERT INTO t VALUES (3)")
+	assert.NoError(t, err)
+
+	// The transactions committed are recovered from the log if the database
+	// isn't closed
+	assert.NoError(t, db.executor.db.log.close())
+	db, err = Open(path, nil)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, []int{1, 2}, selectAll(db))
+
+	// Closing rolls back the transaction in progress, and removes the log
+	_, err = db.Exec(ctx, "BEGIN; INSERT INTO t VALUES (4)")
+	assert.NoError(t, err)
+	assert.True(t, db.inTransaction())
+	assert.NoError(t, db.Close())
+
+	files, err := ioutil.ReadDir(dir)
+	assert.NoError(t, err)
+	assert.Len(t, files, 1)
+
+	db, err = Open(path, nil)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, []int{1, 2}, selectAll(db))
+	assert.NoError(t, db.Close())
+}
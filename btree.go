@@ -9,8 +9,18 @@
 
 package lbadd
 
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+)
+
 const defaultOrder = 3
 
+// The number of entries scanned between checks of whether the scan has been
+// canceled
+const scanCheckInterval = 64
+
 // storage defines the interface to be implemented by
 // the b-tree
 type storage interface {
@@ -21,6 +31,18 @@ type storage interface {
 	getAbove(k key, limit int) []*entry
 	getBelow(k key, limit int) []*entry
 	getBetween(low, high key, limit int) []*entry
+	scan(ctx context.Context, visit func(*entry) bool) error
+	scanBetween(ctx context.Context, low, high key, visit func(*entry) bool) error
+	update(k key, fn func(v value) value)
+	stats() storageStats
+}
+
+// storageStats describes the size and shape of a storage
+type storageStats struct {
+	entries int // the number of entries stored
+	nodes   int // the number of nodes holding them
+	height  int // the number of levels of nodes
+	order   int // the order of the tree
 }
 
 type (
@@ -29,8 +51,13 @@ type (
 )
 
 // node defines the stuct which contains keys (entries) and
-// the child nodes of a particular node in the b-tree
+// the child nodes of a particular node in the b-tree.
+//
+// Entries in internal nodes which have a nil value are separators
+// created when splitting a node. A separator does not hold any data,
+// the entry with its key lives in the child to the right of it.
 type node struct {
+	latch    sync.RWMutex // held to read or change the entries and children
 	parent   *node
 	entries  []*entry
 	children []*node
@@ -42,14 +69,22 @@ type entry struct {
 	value value
 }
 
-// btree is the main structure.
+// btree is the main structure. It is safe for concurrent use: each
+// node has a latch, held to read or change its entries and children,
+// and operations descend the tree latch coupling, latching a child
+// before releasing its parent. Writers latch only the leaf they change
+// for writing unless it must be split, in which case the nodes on the
+// way down are latched for writing instead, and split ahead of time.
+// Latches are only ever taken from the root down, so operations can't
+// deadlock.
 //
 // "order" invariants:
 // - every node except root must contain at least order-1 keys
 // - every node may contain at most (2*order)-1 keys
 type btree struct {
+	size  int64        // the number of entries, accessed atomically
+	mu    sync.RWMutex // guards root, which is split in place once created
 	root  *node
-	size  int
 	order int
 }
 
@@ -70,97 +105,222 @@ func newBtreeOrder(order int) *btree {
 	}
 }
 
+// rootNode returns the root of the tree, creating an empty one first if
+// there isn't one and create is set.
+func (b *btree) rootNode(create bool) *node {
+	b.mu.RLock()
+	root := b.root
+	b.mu.RUnlock()
+	if root != nil || !create {
+		return root
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.root == nil {
+		b.root = &node{}
+	}
+	return b.root
+}
+
 // get searches for a specific key in the btree,
 // returning a pointer to the resulting entry
 // and a boolean as to whether it exists in the tree
 func (b *btree) get(k key) (result *entry, exists bool) {
-	if b.root == nil || len(b.root.entries) == 0 {
+	root := b.rootNode(false)
+	if root == nil {
 		return nil, false
 	}
 
-	return b.getNode(b.root, k)
+	root.latch.RLock()
+	return b.getNode(root, k)
 }
 
+// getNode searches the subtree of the node, which is latched for
+// reading, releasing the latch.
 func (b *btree) getNode(node *node, k key) (result *entry, exists bool) {
 	i, exists := b.search(node.entries, k)
-	if exists {
-		return node.entries[i], true
+	if exists && !node.isSeparator(i) {
+		e := node.entries[i]
+		node.latch.RUnlock()
+		return e, true
 	}
 
-	if i > len(node.children) {
+	if node.isLeaf() || i >= len(node.children) {
+		node.latch.RUnlock()
 		return nil, false
 	}
 
-	return b.getNode(node.children[i], k)
+	// The entry for a separator's key is to the right of it
+	if exists {
+		i++
+	}
+
+	child := node.children[i]
+	child.latch.RLock()
+	node.latch.RUnlock()
+	return b.getNode(child, k)
 }
 
-// insert takes a key and value, creats a new
-// entry and inserts it in the tree according to the key
-func (b *btree) insert(k key, v value) {
-	if b.root == nil {
-		b.size++
-		b.root = &node{
-			parent:   nil,
-			entries:  []*entry{{k, v}},
-			children: []*node{},
+// lockLeaf returns the leaf the key belongs in latched for writing,
+// latching the nodes above it for reading on the way down. It returns
+// nil if the tree is empty, or the key is held by an internal node.
+func (b *btree) lockLeaf(k key) *node {
+	n := b.rootNode(false)
+	if n == nil {
+		return nil
+	}
+
+	// The root is the only node which stops being a leaf, once split
+	n.latch.RLock()
+	if n.isLeaf() {
+		n.latch.RUnlock()
+		n.latch.Lock()
+		if n.isLeaf() {
+			return n
 		}
-		return
+		n.latch.Unlock()
+		return b.lockLeaf(k)
 	}
 
-	b.insertNode(b.root, &entry{k, v})
+	for {
+		i, exists := b.search(n.entries, k)
+		if exists && !n.isSeparator(i) {
+			n.latch.RUnlock()
+			return nil
+		}
+		if exists {
+			i++
+		}
+
+		// The parent stays latched until the child is, so the child
+		// can't be split in between
+		child := n.children[i]
+		child.latch.RLock()
+		if child.isLeaf() {
+			child.latch.RUnlock()
+			child.latch.Lock()
+			n.latch.RUnlock()
+			return child
+		}
+		n.latch.RUnlock()
+		n = child
+	}
 }
 
-// insertNode takes a node and the entry to insert
-func (b *btree) insertNode(node *node, entry *entry) (inserted bool) {
-	// If the root node is already full, we need to split it
-	if node == b.root && node.isFull(b.order) {
-		b.root = node.split()
+// lockNode returns the node an entry with the key belongs in latched
+// for writing, which either holds the entry or is a leaf with room for
+// it. The tree is only latched for writing on the way down if the leaf
+// must be split.
+func (b *btree) lockNode(k key) *node {
+	if n := b.lockLeaf(k); n != nil {
+		if _, exists := b.search(n.entries, k); exists || !n.isFull(b.order) {
+			return n
+		}
+		n.latch.Unlock()
 	}
 
-	// Search for the key in the node's entries
-	idx, exists := b.search(node.entries, entry.key)
+	root := b.rootNode(true)
+	root.latch.Lock()
+	return b.descend(root, k)
+}
+
+// descend takes a node latched for writing and returns the node in its
+// subtree an entry with the key belongs in latched for writing, which
+// either holds the entry or is a leaf with room for it. Full nodes are
+// split on the way down, so that there is room for the entries moved
+// up into their parents.
+func (b *btree) descend(node *node, k key) *node {
+	for {
+		// If the root node is already full, we need to split it
+		if node == b.root && node.isFull(b.order) {
+			node.split()
+		}
 
-	// The entry already exists, so it should be updated
+		idx, exists := b.search(node.entries, k)
+		if (exists && !node.isSeparator(idx)) || node.isLeaf() {
+			return node
+		}
+
+		// The entry for a separator's key is to the right of it
+		if exists {
+			idx++
+		}
+
+		// If the appropriate child is already full, it is replaced by
+		// its two halves, and the entry may belong in the new right one
+		child := node.children[idx]
+		child.latch.Lock()
+		if child.isFull(b.order) {
+			node.splitChild(idx)
+			child.latch.Unlock()
+
+			if k >= node.entries[idx].key {
+				idx++
+			}
+			child = node.children[idx]
+			child.latch.Lock()
+		}
+
+		node.latch.Unlock()
+		node = child
+	}
+}
+
+// putEntry stores the entry in the node returned by lockNode, replacing
+// the entry with its key if there is one.
+func (b *btree) putEntry(node *node, entry *entry) (inserted bool) {
+	idx, exists := b.search(node.entries, entry.key)
 	if exists {
 		node.entries[idx] = entry
 		return false
 	}
 
-	// If the node is a leaf node, add entry to the entries list
-	// We can guarantee that we have room as it would otherwise have
-	// been split.
-	if node.isLeaf() {
-		node.entries = append(node.entries, nil)
-		copy(node.entries[idx+1:], node.entries[idx:])
-		node.entries[idx] = entry
-		b.size++
-		return true
-	}
+	node.entries = append(node.entries, nil)
+	copy(node.entries[idx+1:], node.entries[idx:])
+	node.entries[idx] = entry
+	atomic.AddInt64(&b.size, 1)
+	return true
+}
 
-	// The node is not a leaf, so we we need to check
-	// if the appropriate child is already full,
-	// and conditionally split it. Otherwise traverse
-	// to that child.
-	if node.children[idx].isFull(b.order) {
-		node.children[idx] = node.children[idx].split()
-	}
+// insert takes a key and value, creats a new
+// entry and inserts it in the tree according to the key
+func (b *btree) insert(k key, v value) {
+	node := b.lockNode(k)
+	b.putEntry(node, &entry{k, v})
+	node.latch.Unlock()
+}
 
-	return b.insertNode(node.children[idx], entry)
+// insertNode inserts the entry into the subtree of the node, which is
+// latched for writing, releasing the latch.
+func (b *btree) insertNode(node *node, entry *entry) (inserted bool) {
+	node = b.descend(node, entry.key)
+	inserted = b.putEntry(node, entry)
+	node.latch.Unlock()
+	return inserted
 }
 
 // remove tries to delete an entry from the tree, and
 // returns true if the entry was removed, and false if
 // the key was not found in the tree
 func (b *btree) remove(k key) (removed bool) {
-	if b.root == nil {
+	// Leaves are never merged, so removing from one only needs it latched
+	if leaf := b.lockLeaf(k); leaf != nil {
+		return b.removeNode(leaf, k)
+	}
+
+	root := b.rootNode(false)
+	if root == nil {
 		return false
 	}
 
-	return b.removeNode(b.root, k)
+	root.latch.Lock()
+	return b.removeNode(root, k)
 }
 
-// removeNode takes a node and key and bool, and recursively deletes
-// k from the node, while maintaining the order invariants
+// removeNode takes a node latched for writing and a key, and
+// recursively deletes k from the node, while maintaining the
+// order invariants. The latch is released.
 func (b *btree) removeNode(node *node, k key) (removed bool) {
 	idx, exists := b.search(node.entries, k)
 
@@ -168,21 +328,31 @@ func (b *btree) removeNode(node *node, k key) (removed bool) {
 	// it outright
 	if node.isLeaf() {
 		if exists {
-			b.size--
+			atomic.AddInt64(&b.size, -1)
 			node.entries = append(node.entries[:idx], node.entries[idx+1:]...)
-			return true
 		}
-		// We've reached the bottom and couldn't find the key
-		return false
+		// Otherwise we've reached the bottom and couldn't find the key
+		node.latch.Unlock()
+		return exists
+	}
+
+	// Separators don't hold an entry, the entry for their key
+	// is to the right of them
+	if exists && node.isSeparator(idx) {
+		idx++
+		exists = false
 	}
 
+	child := node.children[idx]
+	child.latch.Lock()
+
 	// If the key exists in the node, but it is not a leaf
 	if exists {
-		child := node.children[idx]
 		// There are enough entries in left child to take one
 		if child.canSteal(b.order) {
 			stolen := child.entries[len(child.entries)-1]
 			node.entries[idx] = stolen
+			node.latch.Unlock()
 			return b.removeNode(child, stolen.key)
 		}
 
@@ -197,36 +367,219 @@ func (b *btree) removeNode(node *node, k key) (removed bool) {
 		// TODO
 	}
 
-	return b.removeNode(node.children[idx], k)
+	node.latch.Unlock()
+	return b.removeNode(child, k)
 }
 
-//
+// The smallest and largest possible keys
+const (
+	minKey = key(-int(^uint(0)>>1) - 1)
+	maxKey = key(int(^uint(0) >> 1))
+)
+
+// getAll returns up to limit entries of the tree in
+// ascending order of their keys. A negative limit
+// returns every entry.
 func (b *btree) getAll(limit int) []*entry {
-	if b.size == 0 || limit == 0 {
+	return b.getRange(minKey, maxKey, limit)
+}
+
+// getAbove returns up to limit entries with keys greater
+// than k, in ascending order. A negative limit returns
+// every matching entry.
+func (b *btree) getAbove(k key, limit int) []*entry {
+	if k == maxKey {
 		return []*entry{}
 	}
 
-	// TODO unimplemented
+	return b.getRange(k+1, maxKey, limit)
+}
+
+// getBelow returns up to limit entries with keys less
+// than k, in ascending order. A negative limit returns
+// every matching entry.
+func (b *btree) getBelow(k key, limit int) []*entry {
+	if k == minKey {
+		return []*entry{}
+	}
 
-	return nil
+	return b.getRange(minKey, k-1, limit)
 }
 
-//
-func (b *btree) getAbove(k key, limit int) []*entry {
-	// TODO unimplemented
-	return []*entry{}
+// getBetween returns up to limit entries with keys
+// between low and high inclusive, in ascending order.
+// A negative limit returns every matching entry.
+func (b *btree) getBetween(low, high key, limit int) []*entry {
+	return b.getRange(low, high, limit)
 }
 
-//
-func (b *btree) getBelow(k key, limit int) []*entry {
-	// TODO unimplemented
-	return []*entry{}
+// getRange collects the entries with keys between low
+// and high inclusive, stopping once limit entries have
+// been found.
+func (b *btree) getRange(low, high key, limit int) []*entry {
+	entries := []*entry{}
+	if limit == 0 {
+		return entries
+	}
+
+	b.walk(low, high, func(e *entry) bool {
+		entries = append(entries, e)
+		return len(entries) != limit
+	})
+
+	return entries
+}
+
+// scan visits every entry of the tree in ascending order
+// of their keys, until visit returns false, as scanBetween.
+func (b *btree) scan(ctx context.Context, visit func(*entry) bool) error {
+	return b.scanBetween(ctx, minKey, maxKey, visit)
+}
+
+// scanBetween visits the entries with keys between low
+// and high inclusive in ascending order, until visit
+// returns false. The context is checked as the entries
+// are visited, and an error is returned if it is done
+// before the scan is. Each leaf is latched for reading
+// while its entries are visited, so visit must not
+// change the tree.
+func (b *btree) scanBetween(ctx context.Context, low, high key, visit func(*entry) bool) error {
+	if err := checkContext(ctx); err != nil {
+		return err
+	}
+
+	var err error
+	visited := 0
+	b.walk(low, high, func(e *entry) bool {
+		visited++
+		if visited%scanCheckInterval == 0 {
+			if err = checkContext(ctx); err != nil {
+				return false
+			}
+		}
+		return visit(e)
+	})
+
+	return err
 }
 
+// walk visits the entries with keys between low and high
+// inclusive in ascending order, until visit returns false.
 //
-func (b *btree) getBetween(low, high key, limit int) []*entry {
-	// TODO unimplemented
-	return []*entry{}
+// The entries are visited a leaf at a time, with the leaf
+// latched for reading. The next leaf is found from the
+// root again, from the key which separates the two, so
+// writers aren't held up for the whole walk, and a leaf
+// split in between is still walked in order.
+func (b *btree) walk(low, high key, visit func(*entry) bool) {
+	for low <= high {
+		n := b.rootNode(false)
+		if n == nil {
+			return
+		}
+
+		// The leaf's keys are below the separator to the right
+		// of it, the nearest of those on the way down
+		n.latch.RLock()
+		bounded, bound := false, maxKey
+		for !n.isLeaf() {
+			i, exists := b.search(n.entries, low)
+			if exists && !n.isSeparator(i) {
+				break
+			}
+			if exists {
+				i++
+			}
+			if i < len(n.entries) {
+				bounded, bound = true, n.entries[i].key
+			}
+
+			child := n.children[i]
+			child.latch.RLock()
+			n.latch.RUnlock()
+			n = child
+		}
+
+		// An internal node holds the entry with the key itself
+		if !n.isLeaf() {
+			i, _ := b.search(n.entries, low)
+			ok := visit(n.entries[i])
+			n.latch.RUnlock()
+			if !ok || low == maxKey {
+				return
+			}
+			low++
+			continue
+		}
+
+		i, _ := b.search(n.entries, low)
+		for ; i < len(n.entries) && n.entries[i].key <= high; i++ {
+			if !visit(n.entries[i]) {
+				n.latch.RUnlock()
+				return
+			}
+		}
+		n.latch.RUnlock()
+
+		if !bounded {
+			return
+		}
+		low = bound
+	}
+}
+
+// update calls fn with the value of the entry with key k,
+// or nil if there isn't one, and replaces the value with
+// the one fn returns, or removes the entry if it returns
+// nil. The entry's node is latched for writing throughout,
+// so fn may change the value in place, but must not use
+// the tree.
+func (b *btree) update(k key, fn func(v value) value) {
+	node := b.lockNode(k)
+
+	var old value
+	idx, exists := b.search(node.entries, k)
+	if exists {
+		old = node.entries[idx].value
+	}
+
+	v := fn(old)
+	switch {
+	case v == nil && exists:
+		b.removeNode(node, k)
+		return
+	case v != nil && exists:
+		node.entries[idx].value = v
+	case v != nil:
+		b.putEntry(node, &entry{k, v})
+	}
+	node.latch.Unlock()
+}
+
+// stats returns the number of entries and nodes
+// of the tree, and its height
+func (b *btree) stats() storageStats {
+	st := storageStats{entries: int(atomic.LoadInt64(&b.size)), order: b.order}
+
+	var visit func(n *node, depth int)
+	visit = func(n *node, depth int) {
+		st.nodes++
+		if depth > st.height {
+			st.height = depth
+		}
+		for _, c := range n.children {
+			c.latch.RLock()
+			visit(c, depth+1)
+			c.latch.RUnlock()
+		}
+	}
+	if root := b.rootNode(false); root != nil {
+		root.latch.RLock()
+		visit(root, 1)
+		root.latch.RUnlock()
+	}
+
+	return st
 }
 
 // search takes a slice of entries and a key, and returns
@@ -262,6 +615,12 @@ func (n *node) isLeaf() bool {
 	return len(n.children) == 0
 }
 
+// isSeparator returns whether the entry at index i
+// of an internal node is a separator without data
+func (n *node) isSeparator(i int) bool {
+	return !n.isLeaf() && n.entries[i].value == nil
+}
+
 // isFull returns a bool indication whether the node
 // already contains the maximum number of entries
 // allowed for a given order
@@ -284,18 +643,70 @@ func (n *node) split() *node {
 	}
 
 	mid := len(n.entries) / 2
+	left, right, median := n.halves(mid)
 
-	left := &node{
-		parent:  n,
-		entries: append([]*entry{}, n.entries[:mid]...),
-	}
-	right := &node{
-		parent:  n,
-		entries: append([]*entry{}, n.entries[mid:]...),
+	n.entries = []*entry{median}
+	n.children = append(n.children[:0], left, right)
+
+	return n
+}
+
+// splitChild splits the full child at index i into two
+// nodes, moving the median entry up into this node as
+// the separator between them
+func (n *node) splitChild(i int) {
+	child := n.children[i]
+
+	mid := len(child.entries) / 2
+	left, right, median := child.halves(mid)
+	left.parent, right.parent = n, n
+
+	n.entries = append(n.entries, nil)
+	copy(n.entries[i+1:], n.entries[i:])
+	n.entries[i] = median
+
+	n.children = append(n.children, nil)
+	copy(n.children[i+2:], n.children[i+1:])
+	n.children[i], n.children[i+1] = left, right
+}
+
+// halves divides the node's entries and children around
+// the entry at index mid, returning the left and right
+// halves and the median entry which separates them.
+//
+// Leaf entries are copied into the right half, leaving
+// a separator as the median. The median of an internal
+// node is moved out of the halves as is.
+func (n *node) halves(mid int) (left, right *node, median *entry) {
+	if n.isLeaf() {
+		left = &node{
+			parent:  n,
+			entries: append([]*entry{}, n.entries[:mid]...),
+		}
+		right = &node{
+			parent:  n,
+			entries: append([]*entry{}, n.entries[mid:]...),
+		}
+
+		return left, right, &entry{n.entries[mid].key, nil}
 	}
 
-	n.entries = []*entry{{n.entries[mid].key, nil}}
-	n.children = append(n.children, left, right)
+	left = &node{
+		parent:   n,
+		entries:  append([]*entry{}, n.entries[:mid]...),
+		children: append([]*node{}, n.children[:mid+1]...),
+	}
+	right = &node{
+		parent:   n,
+		entries:  append([]*entry{}, n.entries[mid+1:]...),
+		children: append([]*node{}, n.children[mid+1:]...),
+	}
+	for _, c := range left.children {
+		c.parent = left
+	}
+	for _, c := range right.children {
+		c.parent = right
+	}
 
-	return n
+	return left, right, n.entries[mid]
 }
@@ -1,6 +1,8 @@
 package lbadd
 
 import (
+	"context"
+	"sync"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -439,13 +441,14 @@ func TestInsertNode(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			b := &btree{
 				root:  tt.fields.root,
-				size:  tt.fields.size,
+				size:  int64(tt.fields.size),
 				order: 3,
 			}
 
+			tt.args.node.latch.Lock()
 			got := b.insertNode(tt.args.node, tt.args.entry)
 			assert.Equal(t, tt.wantInserted, got)
-			assert.Equal(t, tt.wantSize, b.size)
+			assert.Equal(t, int64(tt.wantSize), b.size)
 		})
 	}
 }
@@ -555,13 +558,13 @@ func TestRemove(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			b := &btree{
 				root:  tt.fields.root,
-				size:  tt.fields.size,
+				size:  int64(tt.fields.size),
 				order: tt.fields.order,
 			}
 
 			gotRemoved := b.remove(tt.args.k)
 			assert.Equal(t, tt.wantRemoved, gotRemoved)
-			assert.Equal(t, tt.wantSize, b.size)
+			assert.Equal(t, int64(tt.wantSize), b.size)
 		})
 	}
 }
@@ -726,14 +729,43 @@ func Test_btree_getAll(t *testing.T) {
 			args:   args{limit: 0},
 			want:   []*entry{},
 		},
-		// TODO add more cases
+		{
+			name:   "returns entries in order up to limit",
+			fields: f,
+			args:   args{limit: 5},
+			want:   []*entry{{0, 0}, {1, 1}, {2, 2}, {4, 4}, {5, 5}},
+		},
+		{
+			name:   "negative limit returns every entry",
+			fields: f,
+			args:   args{limit: -1},
+			want: []*entry{
+				{0, 0}, {1, 1}, {2, 2}, {4, 4}, {5, 5},
+				{7, 7}, {8, 8}, {9, 9}, {11, 11}, {12, 12},
+			},
+		},
+		{
+			name: "separators are not returned",
+			fields: fields{
+				size: 3,
+				root: &node{
+					entries: []*entry{{2, nil}},
+					children: []*node{
+						{entries: []*entry{{1, 1}}},
+						{entries: []*entry{{2, 2}, {3, 3}}},
+					},
+				},
+			},
+			args: args{limit: -1},
+			want: []*entry{{1, 1}, {2, 2}, {3, 3}},
+		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			b := &btree{
 				root:  tt.fields.root,
-				size:  tt.fields.size,
+				size:  int64(tt.fields.size),
 				order: tt.fields.order,
 			}
 
@@ -742,3 +774,267 @@ func Test_btree_getAll(t *testing.T) {
 		})
 	}
 }
+
+func Test_btree_getRanges(t *testing.T) {
+	b := newBtreeOrder(2)
+	for _, k := range []key{5, 1, 9, 3, 7, 2, 8, 4, 6, 0} {
+		b.insert(k, int(k))
+	}
+
+	keys := func(entries []*entry) []key {
+		ks := []key{}
+		for _, e := range entries {
+			ks = append(ks, e.key)
+		}
+		return ks
+	}
+
+	assert.Equal(t, []key{7, 8, 9}, keys(b.getAbove(6, -1)))
+	assert.Equal(t, []key{7}, keys(b.getAbove(6, 1)))
+	assert.Equal(t, []key{}, keys(b.getAbove(9, -1)))
+	assert.Equal(t, []key{0, 1, 2}, keys(b.getBelow(3, -1)))
+	assert.Equal(t, []key{}, keys(b.getBelow(0, -1)))
+	assert.Equal(t, []key{3, 4, 5, 6}, keys(b.getBetween(3, 6, -1)))
+	assert.Equal(t, []key{3, 4}, keys(b.getBetween(3, 6, 2)))
+	assert.Equal(t, []key{}, keys(b.getBetween(6, 3, -1)))
+	assert.Equal(t, []key{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}, keys(b.getAll(-1)))
+}
+
+func Test_btree_manyEntries(t *testing.T) {
+	const n = 1000
+
+	b := newBtreeOrder(3)
+	for i := 0; i < n; i++ {
+		// Insert the keys in a scattered order
+		k := key((i * 7919) % n)
+		b.insert(k, int(k))
+	}
+	assert.Equal(t, int64(n), b.size)
+
+	// Updating an existing key doesn't change the size
+	b.insert(10, "ten")
+	assert.Equal(t, int64(n), b.size)
+
+	for i := 0; i < n; i++ {
+		e, exists := b.get(key(i))
+		if assert.True(t, exists, "key %d", i) && i != 10 {
+			assert.Equal(t, i, e.value)
+		}
+	}
+
+	all := b.getAll(-1)
+	assert.Len(t, all, n)
+	for i, e := range all {
+		assert.Equal(t, key(i), e.key)
+	}
+
+	// The tree stays balanced, so every leaf is at the same depth
+	depths := map[int]bool{}
+	var visit func(n *node, depth int)
+	visit = func(n *node, depth int) {
+		if n.isLeaf() {
+			depths[depth] = true
+		}
+		for _, c := range n.children {
+			visit(c, depth+1)
+		}
+	}
+	visit(b.root, 0)
+	assert.Len(t, depths, 1)
+
+	// Remove every even key, including those used as separators
+	for i := 0; i < n; i += 2 {
+		assert.True(t, b.remove(key(i)), "key %d", i)
+	}
+	assert.Equal(t, int64(n/2), b.size)
+
+	for i := 0; i < n; i++ {
+		_, exists := b.get(key(i))
+		assert.Equal(t, i%2 == 1, exists, "key %d", i)
+	}
+	assert.Len(t, b.getAll(-1), n/2)
+}
+
+func Test_btree_stats(t *testing.T) {
+	b := newBtreeOrder(2)
+	assert.Equal(t, storageStats{order: 2}, b.stats())
+
+	b.insert(1, "a")
+	assert.Equal(t, storageStats{entries: 1, nodes: 1, height: 1, order: 2}, b.stats())
+
+	// A node of order 2 holds up to 3 entries, so the fourth splits the root
+	for k := key(2); k <= 4; k++ {
+		b.insert(k, "a")
+	}
+	assert.Equal(t, storageStats{entries: 4, nodes: 3, height: 2, order: 2}, b.stats())
+}
+
+func Test_btree_scan(t *testing.T) {
+	const n = 4 * scanCheckInterval
+
+	b := newBtreeOrder(3)
+	for i := 0; i < n; i++ {
+		b.insert(key(i), i)
+	}
+
+	visited := []key{}
+	err := b.scan(context.Background(), func(e *entry) bool {
+		visited = append(visited, e.key)
+		return len(visited) != n/2
+	})
+	assert.NoError(t, err)
+	assert.Len(t, visited, n/2)
+	for i, k := range visited {
+		assert.Equal(t, key(i), k)
+	}
+
+	// The scan stops once the context is canceled
+	ctx, cancel := context.WithCancel(context.Background())
+	visited = visited[:0]
+	err = b.scan(ctx, func(e *entry) bool {
+		visited = append(visited, e.key)
+		if len(visited) == n/2 {
+			cancel()
+		}
+		return true
+	})
+	assert.EqualError(t, err, "statement canceled")
+	assert.True(t, len(visited) < n/2+scanCheckInterval)
+
+	err = b.scan(ctx, func(e *entry) bool {
+		t.Error("visited an entry after the context was canceled")
+		return true
+	})
+	assert.Error(t, err)
+}
+
+// sorted reports whether the entries are in ascending order of their keys.
+func sorted(entries []*entry) bool {
+	for i := 1; i < len(entries); i++ {
+		if entries[i-1].key >= entries[i].key {
+			return false
+		}
+	}
+	return true
+}
+
+func Test_btree_concurrent(t *testing.T) {
+	const (
+		workers = 8
+		n       = 2000
+	)
+
+	b := newBtreeOrder(2)
+
+	// run calls fn from each worker with the keys it owns, which are
+	// interleaved so that the workers contend for the same leaves, while
+	// scans check the entries stay in order
+	run := func(fn func(k key)) {
+		var wg, scans sync.WaitGroup
+		done := make(chan struct{})
+		scans.Add(1)
+		go func() {
+			defer scans.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				assert.True(t, sorted(b.getAll(-1)))
+				assert.True(t, sorted(b.getBetween(n/4, n/2, -1)))
+			}
+		}()
+
+		for w := 0; w < workers; w++ {
+			wg.Add(1)
+			go func(w int) {
+				defer wg.Done()
+				for i := w; i < n; i += workers {
+					fn(key(i))
+				}
+			}(w)
+		}
+		wg.Wait()
+		close(done)
+		scans.Wait()
+	}
+
+	run(func(k key) {
+		b.insert(k, int(k))
+		e, exists := b.get(k)
+		if assert.True(t, exists, "key %d", k) {
+			assert.Equal(t, int(k), e.value)
+		}
+	})
+	assert.Equal(t, int64(n), b.size)
+
+	// Remove the even keys and change the odd ones
+	run(func(k key) {
+		if k%2 == 0 {
+			assert.True(t, b.remove(k), "key %d", k)
+			return
+		}
+		b.update(k, func(v value) value {
+			return v.(int) * 2
+		})
+	})
+	assert.Equal(t, int64(n/2), b.size)
+
+	all := b.getAll(-1)
+	if assert.Len(t, all, n/2) {
+		for i, e := range all {
+			assert.Equal(t, key(2*i+1), e.key)
+			assert.Equal(t, 2*(2*i+1), e.value)
+		}
+	}
+
+	// The tree stays balanced, with every node within the order
+	depths := map[int]bool{}
+	var visit func(n *node, depth int)
+	visit = func(n *node, depth int) {
+		assert.False(t, len(n.entries) > 2*b.order-1)
+		if n.isLeaf() {
+			depths[depth] = true
+		}
+		for _, c := range n.children {
+			visit(c, depth+1)
+		}
+	}
+	visit(b.root, 0)
+	assert.Len(t, depths, 1)
+}
+
+func Test_btree_concurrentUpdates(t *testing.T) {
+	const (
+		workers = 8
+		keys    = 4
+		n       = 500
+	)
+
+	b := newBtreeOrder(2)
+
+	// Each update reads and changes the value with its node latched, so
+	// no increment is lost
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < n; i++ {
+				b.update(key(i%keys), func(v value) value {
+					count, _ := v.(int)
+					return count + 1
+				})
+			}
+		}()
+	}
+	wg.Wait()
+
+	for k := key(0); k < keys; k++ {
+		e, exists := b.get(k)
+		if assert.True(t, exists) {
+			assert.Equal(t, workers*n/keys, e.value)
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package lbadd
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrCanceled is matched, with errors.Is, by the error returned when a
+// statement is canceled or times out. The error also matches the error of the
+// context it was executed with, context.Canceled or context.DeadlineExceeded.
+var ErrCanceled = errors.New("statement canceled")
+
+// canceledError is the error returned when the context a statement is
+// executed with is done.
+type canceledError struct {
+	cause error // the error of the context
+}
+
+func (e *canceledError) Error() string {
+	if e.cause == context.DeadlineExceeded {
+		return "statement timed out"
+	}
+
+	return ErrCanceled.Error()
+}
+
+func (e *canceledError) Is(target error) bool {
+	return target == ErrCanceled
+}
+
+func (e *canceledError) Unwrap() error {
+	return e.cause
+}
+
+// checkContext returns an error if the context is done, which is checked
+// periodically by anything which may take a while.
+func checkContext(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return &canceledError{cause: err}
+	}
+
+	return nil
+}
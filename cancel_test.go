@@ -0,0 +1,89 @@
+package lbadd
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_checkContext(t *testing.T) {
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	expired, cancel := context.WithTimeout(context.Background(), -time.Second)
+	defer cancel()
+
+	cases := []struct {
+		name  string
+		ctx   context.Context
+		err   string
+		cause error
+	}{
+		{name: "not done", ctx: context.Background()},
+		{name: "canceled", ctx: canceled, err: "statement canceled", cause: context.Canceled},
+		{name: "timed out", ctx: expired, err: "statement timed out", cause: context.DeadlineExceeded},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := checkContext(tc.ctx)
+			if tc.err == "" {
+				assert.NoError(t, err)
+				return
+			}
+			assert.EqualError(t, err, tc.err)
+			assert.True(t, errors.Is(err, ErrCanceled))
+			assert.True(t, errors.Is(err, tc.cause))
+		})
+	}
+}
+
+// countdownContext is a context which is canceled once its error has been
+// checked a number of times, to cancel statements part way through.
+type countdownContext struct {
+	context.Context
+	checks int
+}
+
+func (c *countdownContext) Err() error {
+	if c.checks == 0 {
+		return context.Canceled
+	}
+	c.checks--
+
+	return nil
+}
+
+func Test_executor_canceled(t *testing.T) {
+	e := newExecutor(exeConfig{order: 3})
+	execSQL(t, e, "CREATE TABLE n (n integer)")
+	for i := 0; i < 4*scanCheckInterval; i++ {
+		execSQL(t, e, "INSERT INTO n VALUES (1)")
+	}
+
+	cases := []string{
+		"SELECT * FROM n",
+		"DELETE FROM n",
+		"UPDATE n SET n = 2",
+	}
+
+	for _, sql := range cases {
+		t.Run(sql, func(t *testing.T) {
+			q, err := parse(sql)
+			if !assert.NoError(t, err) {
+				return
+			}
+
+			// Statements are canceled part way through scanning the rows,
+			// before any of them are changed
+			ctx := &countdownContext{Context: context.Background(), checks: 3}
+			_, err = e.executeQuery(ctx, q)
+			assert.True(t, errors.Is(err, ErrCanceled), "%v", err)
+
+			res := execSQL(t, e, "SELECT * FROM n WHERE n = 1")
+			assert.Len(t, res.rows, 4*scanCheckInterval)
+		})
+	}
+}
@@ -0,0 +1,128 @@
+package lbadd
+
+import (
+	"context"
+	"sort"
+)
+
+// listTables returns a result describing the tables of the database, with
+// their number of columns and rows, in order of their names.
+func (e *executor) listTables() (result, error) {
+	values := [][]interface{}{}
+	err := e.read(func() error {
+		for _, t := range e.visibleTables() {
+			rows := 0
+			err := e.scanMatching(context.Background(), t, nil, func(k key, r row) {
+				rows++
+			})
+			if err != nil {
+				return err
+			}
+			values = append(values, []interface{}{t.name, int64(len(t.columns)), int64(rows)})
+		}
+		return nil
+	})
+	if err != nil {
+		return result{}, err
+	}
+
+	return newResult([]column{
+		{name: "table", dataType: columnTypeString},
+		{name: "columns", dataType: columnTypeInt},
+		{name: "rows", dataType: columnTypeInt},
+	}, values)
+}
+
+// describeTable returns a result describing the columns of the table, with
+// their types, whether they are nullable and whether they are the key of the
+// table's rows. The key, the implicit row id, comes first, followed by the
+// columns in the order of the table. Tables have no other keys or indexes.
+func (e *executor) describeTable(name string) (result, error) {
+	t, err := e.lookupTable(name)
+	if err != nil {
+		return result{}, err
+	}
+
+	values := [][]interface{}{{rowIDColumn.name, rowIDColumn.dataType.String(), rowIDColumn.isNullable, true}}
+	for _, c := range t.columns {
+		values = append(values, []interface{}{c.name, c.dataType.String(), c.isNullable, false})
+	}
+
+	return newResult([]column{
+		{name: "column", dataType: columnTypeString},
+		{name: "type", dataType: columnTypeString},
+		{name: "nullable", dataType: columnTypeBool},
+		{name: "key", dataType: columnTypeBool},
+	}, values)
+}
+
+// storageStats returns a result describing the storage of each table, in
+// order of their names.
+func (e *executor) storageStats() (result, error) {
+	values := [][]interface{}{}
+	_ = e.read(func() error {
+		for _, t := range e.visibleTables() {
+			st := t.store.stats()
+			values = append(values, []interface{}{t.name, int64(st.entries), int64(st.nodes), int64(st.height), int64(st.order)})
+		}
+		return nil
+	})
+
+	return newResult([]column{
+		{name: "table", dataType: columnTypeString},
+		{name: "rows", dataType: columnTypeInt},
+		{name: "nodes", dataType: columnTypeInt},
+		{name: "height", dataType: columnTypeInt},
+		{name: "order", dataType: columnTypeInt},
+	}, values)
+}
+
+// tableNames returns the names of the database's tables in order.
+func (e *executor) tableNames() []string {
+	names := []string{}
+	_ = e.read(func() error {
+		for _, t := range e.visibleTables() {
+			names = append(names, t.name)
+		}
+		return nil
+	})
+
+	return names
+}
+
+// visibleTables returns the tables visible to the transaction in progress,
+// in order of their names.
+func (e *executor) visibleTables() []table {
+	e.db.mu.RLock()
+	defer e.db.mu.RUnlock()
+
+	tables := []table{}
+	for _, head := range e.db.tables {
+		for v := head; v != nil; v = v.older {
+			if e.tx.visible(v.stamp) {
+				tables = append(tables, v.table)
+				break
+			}
+		}
+	}
+	sort.Slice(tables, func(i, j int) bool {
+		return tables[i].name < tables[j].name
+	})
+
+	return tables
+}
+
+// newResult creates a result of the given columns, holding a row for each of
+// the given sets of values.
+func newResult(columns []column, values [][]interface{}) (result, error) {
+	res := result{columns: columns, rows: make([]row, 0, len(values))}
+	for _, v := range values {
+		r, err := encodeRow(table{columns: columns}, v)
+		if err != nil {
+			return result{}, err
+		}
+		res.rows = append(res.rows, r)
+	}
+
+	return res, nil
+}
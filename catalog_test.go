@@ -0,0 +1,56 @@
+package lbadd
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_executor_catalog(t *testing.T) {
+	e := newExecutor(exeConfig{order: 2})
+	for _, instr := range []instruction{
+		{commandCreateTable, "users", []string{"name", "string", "false", "age", "integer", "true"}, nil},
+		{commandCreateTable, "accounts", []string{"balance", "float", "true"}, nil},
+		{commandInsert, "users", []string{"'a'", "1"}, nil},
+		{commandInsert, "users", []string{"'b'", "2"}, nil},
+		{commandInsert, "users", []string{"'c'", "3"}, nil},
+		{commandInsert, "users", []string{"'d'", "4"}, nil},
+	} {
+		_, err := e.execute(context.Background(), instr)
+		if !assert.NoError(t, err) {
+			return
+		}
+	}
+
+	display := func(res result, err error) [][]string {
+		if !assert.NoError(t, err) {
+			return nil
+		}
+		cells, err := displayCells(res.columns, res.rows, "NULL")
+		assert.NoError(t, err)
+		return append([][]string{namesOf(res.columns)}, cells...)
+	}
+
+	assert.Equal(t, [][]string{
+		{"table", "columns", "rows"},
+		{"accounts", "1", "0"},
+		{"users", "2", "4"},
+	}, display(e.listTables()))
+
+	assert.Equal(t, [][]string{
+		{"column", "type", "nullable", "key"},
+		{"rowid", "integer", "false", "true"},
+		{"name", "string", "false", "false"},
+		{"age", "integer", "true", "false"},
+	}, display(e.describeTable("users")))
+
+	assert.Equal(t, [][]string{
+		{"table", "rows", "nodes", "height", "order"},
+		{"accounts", "0", "0", "0", "2"},
+		{"users", "4", "3", "2", "2"},
+	}, display(e.storageStats()))
+
+	_, err := e.describeTable("missing")
+	assert.EqualError(t, err, "table missing does not exist")
+}
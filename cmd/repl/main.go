@@ -1,10 +1,105 @@
 package main
 
 import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
 	"github.com/tomarrell/lbadd"
 )
 
 func main() {
+	os.Exit(run())
+}
+
+// run runs the repl as configured by the command line flags, returning the
+// exit code.
+func run() int {
+	format := flag.String("format", "table", "the format rows are written in: table, csv, json, jsonl or markdown")
+	file := flag.String("f", "", "execute the statements in the file, then exit")
+	command := flag.String("c", "", "execute the statements given, then exit")
+	continueOnError := flag.Bool("continue", false, "continue with the remaining statements after an error")
+	dump := flag.String("dump", "", "write a dump of the database to the file before exiting")
+	timeout := flag.Duration("timeout", 0, "cancel statements which run for longer than this, e.g. 30s")
+	flag.Parse()
+
 	r := lbadd.NewRepl()
-	r.Start()
+	if err := r.SetFormat(*format); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	r.SetContinueOnError(*continueOnError)
+	r.SetTimeout(*timeout)
+
+	if *file != "" && *command != "" {
+		fmt.Fprintln(os.Stderr, "-f and -c can't be used together")
+		return 2
+	}
+
+	code := execute(r, *file, *command)
+	if *dump != "" {
+		if err := dumpTo(r, *dump); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+	}
+
+	return code
+}
+
+// execute runs the statements of the file or command given, or otherwise
+// those read from standard input, returning the exit code.
+func execute(r *lbadd.Repl, file, command string) int {
+	var in io.Reader
+	switch {
+	case command != "":
+		in = strings.NewReader(command)
+	case file != "":
+		f, err := os.Open(file)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		defer f.Close()
+		in = f
+	case isTerminal(os.Stdin):
+		r.Start()
+		return 0
+	default:
+		in = os.Stdin
+	}
+
+	if err := r.Run(in); err != nil {
+		// The error has already been reported by the repl
+		return 1
+	}
+
+	return 0
+}
+
+// dumpTo writes a dump of the repl's database to the file.
+func dumpTo(r *lbadd.Repl, file string) error {
+	f, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	if err := r.Dump(f); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+// isTerminal reports whether the file is a terminal, as opposed to a pipe or
+// a regular file.
+func isTerminal(f *os.File) bool {
+	stat, err := f.Stat()
+	if err != nil {
+		return false
+	}
+
+	return stat.Mode()&os.ModeCharDevice != 0
 }
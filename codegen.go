@@ -1 +1,166 @@
 package lbadd
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// codegen generates the instructions which carry out the query. Most queries
+// translate into a single instruction, inserts generate one instruction for
+// each of the rows being inserted.
+//
+// Values are passed on to the instructions exactly as written in the query,
+// along with the arguments bound to its $n placeholders, so a query must not
+// contain any other parameter placeholders.
+func codegen(q query) ([]instruction, error) {
+	switch q.queryType {
+	case selectQuery:
+		conds, err := codegenWhere(q.where, q.args)
+		if err != nil {
+			return nil, err
+		}
+
+		params := append(append([]string{}, q.fields...), conds...)
+		if len(q.groupBy) > 0 {
+			params = append(append(params, "group"), q.groupBy...)
+		}
+		if len(q.orderBy) > 0 {
+			params = append(params, "order")
+			for _, k := range q.orderBy {
+				params = append(params, k.field)
+				if k.desc {
+					params = append(params, "desc")
+				}
+			}
+		}
+		if q.limit != "" {
+			params = append(params, "limit", q.limit)
+		}
+		if q.offset != "" {
+			params = append(params, "offset", q.offset)
+		}
+		if q.locking != "" {
+			params = append(params, "for", strings.ToLower(q.locking))
+		}
+		return []instruction{{command: commandSelect, table: q.tableName, params: params, args: q.args}}, nil
+
+	case insertQuery:
+		instrs := make([]instruction, 0, len(q.inserts))
+		for _, values := range q.inserts {
+			params := make([]string, 0, len(values))
+			for i, v := range values {
+				if err := checkBound(v, q.args); err != nil {
+					return nil, err
+				}
+
+				if len(q.fields) == 0 {
+					params = append(params, v)
+				} else {
+					params = append(params, q.fields[i]+equal.symbol()+v)
+				}
+			}
+			instrs = append(instrs, instruction{command: commandInsert, table: q.tableName, params: params, args: q.args})
+		}
+		return instrs, nil
+
+	case updateQuery:
+		params := make([]string, 0, len(q.updates))
+		for _, u := range q.updates {
+			if err := checkBound(u.value, q.args); err != nil {
+				return nil, err
+			}
+			params = append(params, u.field+equal.symbol()+u.value)
+		}
+
+		conds, err := codegenWhere(q.where, q.args)
+		if err != nil {
+			return nil, err
+		}
+		if len(conds) > 0 {
+			params = append(append(params, "where"), conds...)
+		}
+		return []instruction{{command: commandUpdate, table: q.tableName, params: params, args: q.args}}, nil
+
+	case deleteQuery:
+		conds, err := codegenWhere(q.where, q.args)
+		if err != nil {
+			return nil, err
+		}
+		return []instruction{{command: commandDelete, table: q.tableName, params: conds, args: q.args}}, nil
+
+	case createTableQuery:
+		params := make([]string, 0, len(q.columns)*3)
+		for _, c := range q.columns {
+			params = append(params, c.name, c.dataType.String(), strconv.FormatBool(c.isNullable))
+		}
+		return []instruction{{command: commandCreateTable, table: q.tableName, params: params}}, nil
+
+	case dropTableQuery:
+		return []instruction{{command: commandDropTable, table: q.tableName, params: []string{}}}, nil
+
+	case copyQuery:
+		direction := "from"
+		if q.copyTo {
+			direction = "to"
+		}
+
+		params := append([]string{direction, q.copyFile}, q.fields...)
+		for _, o := range q.copyOptions {
+			params = append(params, strings.ToLower(o.name)+equal.symbol()+o.value)
+		}
+		return []instruction{{command: commandCopy, table: q.tableName, params: params}}, nil
+
+	case beginQuery:
+		return []instruction{{command: commandBegin, params: []string{}}}, nil
+
+	case commitQuery:
+		return []instruction{{command: commandCommit, params: []string{}}}, nil
+
+	case rollbackQuery:
+		params := []string{}
+		if q.savepoint != "" {
+			params = append(params, q.savepoint)
+		}
+		return []instruction{{command: commandRollback, params: params}}, nil
+
+	case savepointQuery:
+		return []instruction{{command: commandSavepoint, params: []string{q.savepoint}}}, nil
+
+	case releaseQuery:
+		return []instruction{{command: commandRelease, params: []string{q.savepoint}}}, nil
+
+	case setTransactionQuery:
+		return []instruction{{command: commandIsolation, params: []string{q.isolation.param()}}}, nil
+
+	default:
+		return nil, fmt.Errorf("%s is not supported", q.queryType)
+	}
+}
+
+// codegenWhere generates the params for the condition of a WHERE, which is
+// a single param in parentheses, e.g. (age >= 3 AND name IS NOT NULL).
+func codegenWhere(where string, args []interface{}) ([]string, error) {
+	if where == "" {
+		return []string{}, nil
+	}
+
+	tokens, err := lexExpr(where)
+	if err != nil {
+		return nil, err
+	}
+	for _, tok := range tokens {
+		if err := checkBound(tok, args); err != nil {
+			return nil, err
+		}
+	}
+
+	return []string{"(" + where + ")"}, nil
+}
+
+// checkBound returns an error if the value is a parameter placeholder, which
+// has not had a value bound to it.
+func checkBound(value string, args []interface{}) error {
+	_, err := parseValue(value, args)
+	return err
+}
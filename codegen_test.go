@@ -1 +1,168 @@
 package lbadd
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCodegen(t *testing.T) {
+	cases := []struct {
+		name     string
+		sql      string
+		expected []instruction
+		wantErr  bool
+	}{
+		{
+			name:     "select all",
+			sql:      "SELECT * FROM users",
+			expected: []instruction{{commandSelect, "users", []string{"*"}, nil}},
+		},
+		{
+			name: "select with conditions",
+			sql:  "SELECT name, age FROM users WHERE age >= 18 AND name != 'John Smith'",
+			expected: []instruction{
+				{commandSelect, "users", []string{"name", "age", "(age >= 18 AND name != 'John Smith')"}, nil},
+			},
+		},
+		{
+			name: "select with an expression",
+			sql:  "SELECT * FROM users WHERE coalesce(age,0)+1 > 2 OR name is null",
+			expected: []instruction{
+				{commandSelect, "users", []string{"*", "(coalesce(age, 0) + 1 > 2 OR name IS NULL)"}, nil},
+			},
+		},
+		{
+			name: "select grouped, ordered and limited",
+			sql:  "SELECT age, COUNT(*) FROM users WHERE age > 1 GROUP BY age ORDER BY count(*) DESC, age LIMIT 2 OFFSET 1",
+			expected: []instruction{
+				{commandSelect, "users", []string{"age", "count(*)", "(age > 1)", "group", "age", "order", "count(*)", "desc", "age", "limit", "2", "offset", "1"}, nil},
+			},
+		},
+		{
+			name: "select for update",
+			sql:  "SELECT name FROM users WHERE age > 1 FOR UPDATE",
+			expected: []instruction{
+				{commandSelect, "users", []string{"name", "(age > 1)", "for", "update"}, nil},
+			},
+		},
+		{
+			name: "insert without fields",
+			sql:  "INSERT INTO users VALUES ('a', 1), ('b', NULL)",
+			expected: []instruction{
+				{commandInsert, "users", []string{"'a'", "1"}, nil},
+				{commandInsert, "users", []string{"'b'", "NULL"}, nil},
+			},
+		},
+		{
+			name: "insert with fields",
+			sql:  "INSERT INTO users (age, name) VALUES (1, 'it''s')",
+			expected: []instruction{
+				{commandInsert, "users", []string{"age=1", "name='it''s'"}, nil},
+			},
+		},
+		{
+			name: "update with conditions",
+			sql:  "UPDATE users SET age = 2, name = 'x' WHERE age < 2",
+			expected: []instruction{
+				{commandUpdate, "users", []string{"age=2", "name='x'", "where", "(age < 2)"}, nil},
+			},
+		},
+		{
+			name: "update without conditions",
+			sql:  "UPDATE users SET age = 2",
+			expected: []instruction{
+				{commandUpdate, "users", []string{"age=2"}, nil},
+			},
+		},
+		{
+			name:     "delete all",
+			sql:      "DELETE FROM users",
+			expected: []instruction{{commandDelete, "users", []string{}, nil}},
+		},
+		{
+			name:     "delete with condition",
+			sql:      "DELETE FROM users WHERE 3 = age",
+			expected: []instruction{{commandDelete, "users", []string{"(3 = age)"}, nil}},
+		},
+		{
+			name:     "create table",
+			sql:      "CREATE TABLE users (name string NOT NULL, age integer)",
+			expected: []instruction{{commandCreateTable, "users", []string{"name", "string", "false", "age", "integer", "true"}, nil}},
+		},
+		{
+			name:     "drop table",
+			sql:      "DROP TABLE users",
+			expected: []instruction{{commandDropTable, "users", []string{}, nil}},
+		},
+		{
+			name:     "copy from",
+			sql:      "COPY users FROM 'users.csv'",
+			expected: []instruction{{commandCopy, "users", []string{"from", "'users.csv'"}, nil}},
+		},
+		{
+			name: "copy to with columns and options",
+			sql:  "COPY users (name, age) TO 'users.csv' WITH (HEADER, NULL 'NULL')",
+			expected: []instruction{
+				{commandCopy, "users", []string{"to", "'users.csv'", "name", "age", "header=TRUE", "null='NULL'"}, nil},
+			},
+		},
+		{
+			name:    "unbound parameter",
+			sql:     "DELETE FROM users WHERE age = ?",
+			wantErr: true,
+		},
+		{
+			name:     "begin",
+			sql:      "BEGIN TRANSACTION",
+			expected: []instruction{{commandBegin, "", []string{}, nil}},
+		},
+		{
+			name:     "commit",
+			sql:      "COMMIT",
+			expected: []instruction{{commandCommit, "", []string{}, nil}},
+		},
+		{
+			name:     "rollback",
+			sql:      "ROLLBACK",
+			expected: []instruction{{commandRollback, "", []string{}, nil}},
+		},
+		{
+			name:     "savepoint",
+			sql:      "SAVEPOINT before",
+			expected: []instruction{{commandSavepoint, "", []string{"before"}, nil}},
+		},
+		{
+			name:     "rollback to savepoint",
+			sql:      "ROLLBACK TO before",
+			expected: []instruction{{commandRollback, "", []string{"before"}, nil}},
+		},
+		{
+			name:     "release savepoint",
+			sql:      "RELEASE SAVEPOINT before",
+			expected: []instruction{{commandRelease, "", []string{"before"}, nil}},
+		},
+		{
+			name:     "set transaction isolation level",
+			sql:      "SET TRANSACTION ISOLATION LEVEL READ COMMITTED",
+			expected: []instruction{{commandIsolation, "", []string{"read_committed"}, nil}},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			q, err := parse(tc.sql)
+			if !assert.NoError(t, err) {
+				return
+			}
+
+			actual, err := codegen(q)
+			if tc.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, actual)
+		})
+	}
+}
@@ -8,6 +8,15 @@ const (
 	commandSelect
 	commandDelete
 	commandCreateTable
+	commandUpdate
+	commandDropTable
+	commandCopy
+	commandBegin
+	commandCommit
+	commandRollback
+	commandSavepoint
+	commandRelease
+	commandIsolation
 )
 
 func newCommand(cmd string) command {
@@ -20,11 +29,41 @@ func newCommand(cmd string) command {
 		return commandDelete
 	case commandCreateTable.String():
 		return commandCreateTable
+	case commandUpdate.String():
+		return commandUpdate
+	case commandDropTable.String():
+		return commandDropTable
+	case commandCopy.String():
+		return commandCopy
+	case commandBegin.String():
+		return commandBegin
+	case commandCommit.String():
+		return commandCommit
+	case commandRollback.String():
+		return commandRollback
+	case commandSavepoint.String():
+		return commandSavepoint
+	case commandRelease.String():
+		return commandRelease
+	case commandIsolation.String():
+		return commandIsolation
 	default:
 		return commandUnknown
 	}
 }
 
+// isTransactionCommand reports whether the command begins or ends a
+// transaction, marks a savepoint within one, or sets its isolation level,
+// rather than operating on a table.
+func (c command) isTransactionCommand() bool {
+	switch c {
+	case commandBegin, commandCommit, commandRollback, commandSavepoint, commandRelease, commandIsolation:
+		return true
+	default:
+		return false
+	}
+}
+
 func (c command) String() string {
 	switch c {
 	case commandInsert:
@@ -35,6 +74,24 @@ func (c command) String() string {
 		return "DELETE"
 	case commandCreateTable:
 		return "CREATE TABLE"
+	case commandUpdate:
+		return "UPDATE"
+	case commandDropTable:
+		return "DROP TABLE"
+	case commandCopy:
+		return "COPY"
+	case commandBegin:
+		return "BEGIN"
+	case commandCommit:
+		return "COMMIT"
+	case commandRollback:
+		return "ROLLBACK"
+	case commandSavepoint:
+		return "SAVEPOINT"
+	case commandRelease:
+		return "RELEASE"
+	case commandIsolation:
+		return "ISOLATION"
 	default:
 		return "UNKNOWN"
 	}
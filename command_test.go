@@ -36,6 +36,36 @@ func Test_newCommand(t *testing.T) {
 			args: args{cmd: "delete"},
 			want: 3,
 		},
+		{
+			name: "update",
+			args: args{cmd: "update"},
+			want: 5,
+		},
+		{
+			name: "drop table",
+			args: args{cmd: "drop table"},
+			want: 6,
+		},
+		{
+			name: "copy",
+			args: args{cmd: "copy"},
+			want: 7,
+		},
+		{
+			name: "begin",
+			args: args{cmd: "begin"},
+			want: 8,
+		},
+		{
+			name: "commit",
+			args: args{cmd: "commit"},
+			want: 9,
+		},
+		{
+			name: "rollback",
+			args: args{cmd: "rollback"},
+			want: 10,
+		},
 		{
 			name: "mixed casing insert",
 			args: args{cmd: "iNsErT"},
@@ -82,6 +112,41 @@ func Test_command_String(t *testing.T) {
 			c:    4,
 			want: "CREATE TABLE",
 		},
+		{
+			name: "update",
+			c:    5,
+			want: "UPDATE",
+		},
+		{
+			name: "drop table",
+			c:    6,
+			want: "DROP TABLE",
+		},
+		{
+			name: "copy",
+			c:    7,
+			want: "COPY",
+		},
+		{
+			name: "rollback",
+			c:    10,
+			want: "ROLLBACK",
+		},
+		{
+			name: "savepoint",
+			c:    11,
+			want: "SAVEPOINT",
+		},
+		{
+			name: "release",
+			c:    12,
+			want: "RELEASE",
+		},
+		{
+			name: "isolation",
+			c:    13,
+			want: "ISOLATION",
+		},
 	}
 
 	for _, tt := range tests {
@@ -0,0 +1,176 @@
+package lbadd
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// The meta-commands offered for completion
+var metaCommands = []string{
+	`\?`, `\q`, `\sql`, `\ir`, `\e`, `\r`, `\explain`, `\copy`,
+	`\dt`, `\d`, `\stats`, `\dump`, `\restore`, `\format`, `\null`, `\timing`,
+}
+
+// complete returns the candidates completing the word at the end of the
+// text, which is the line entered up to the cursor, and the offset of the
+// start of the word. Candidates depend on the context of the word:
+//
+//   - meta-commands at the start of the line, and their arguments
+//   - table names following FROM, INTO, UPDATE, TABLE or COPY, and in the IR
+//   - keywords and the names of columns otherwise, for the tables named in the
+//     statement, or all tables if none are
+//
+// Keywords are completed in lower case if the word is typed in lower case.
+func (r *Repl) complete(text string) (int, []string) {
+	start := len(text)
+	for start > 0 && isWordChar(rune(text[start-1])) {
+		start--
+	}
+	word := text[start:]
+
+	// Meta-commands and their arguments
+	if fields := strings.Fields(text); strings.HasPrefix(strings.TrimSpace(text), `\`) {
+		if start > 0 && text[start-1] == '\\' && len(fields) == 1 {
+			return start - 1, matching(`\`+word, metaCommands, false)
+		}
+		switch fields[0] {
+		case `\explain`:
+			return r.completeSQL(strings.TrimPrefix(strings.TrimSpace(text), `\explain`), start, word)
+		case `\copy`:
+			return r.completeSQL("COPY "+strings.TrimPrefix(strings.TrimSpace(text), `\copy`), start, word)
+		}
+		if len(fields) > 2 || (len(fields) == 2 && word == "") {
+			return start, nil
+		}
+
+		switch fields[0] {
+		case `\d`:
+			return start, matching(word, r.executor.tableNames(), false)
+		case `\format`:
+			return start, matching(word, outputFormatNames, false)
+		case `\timing`:
+			return start, matching(word, []string{"on", "off"}, false)
+		default:
+			return start, nil
+		}
+	}
+
+	if r.mode == modeIR {
+		return start, r.completeIR(text[:start], word)
+	}
+
+	return r.completeSQL(r.buffer+text, start, word)
+}
+
+// completeSQL returns the candidates completing the word of a SQL statement,
+// given the text of the statement up to the cursor.
+func (r *Repl) completeSQL(statement string, start int, word string) (int, []string) {
+	// Only the statement the cursor is in is of interest
+	if terms := terminators(statement); len(terms) > 0 {
+		statement = statement[terms[len(terms)-1]+1:]
+	}
+	tokens := strings.FieldsFunc(statement, func(c rune) bool { return !isWordChar(c) })
+	if word != "" && len(tokens) > 0 {
+		tokens = tokens[:len(tokens)-1]
+	}
+
+	if len(tokens) > 0 {
+		switch toUp(tokens[len(tokens)-1]) {
+		case "FROM", "INTO", "UPDATE", "TABLE", "COPY":
+			return start, matching(word, r.executor.tableNames(), false)
+		}
+	}
+
+	candidates := sqlKeywords()
+
+	tables := []table{}
+	for _, tok := range tokens {
+		if t, err := r.executor.lookupTable(tok); err == nil {
+			tables = append(tables, t)
+		}
+	}
+	if len(tables) == 0 {
+		for _, name := range r.executor.tableNames() {
+			if t, err := r.executor.lookupTable(name); err == nil {
+				tables = append(tables, t)
+			}
+		}
+	}
+	for _, t := range tables {
+		for _, c := range t.columns {
+			candidates = append(candidates, c.name)
+		}
+	}
+
+	return start, matching(word, candidates, true)
+}
+
+// completeIR returns the candidates completing the word of an instruction,
+// given the text of the instruction before the word.
+func (r *Repl) completeIR(before, word string) []string {
+	tokens := strings.Fields(before)
+
+	// Creating and dropping tables are the only commands made up of two
+	// words
+	if len(tokens) > 0 && (strings.EqualFold(tokens[0], "create") || strings.EqualFold(tokens[0], "drop")) {
+		if len(tokens) == 1 {
+			return matching(word, []string{"table"}, false)
+		}
+		tokens = tokens[1:]
+	}
+
+	switch len(tokens) {
+	case 0:
+		return matching(word, []string{"select", "insert", "update", "delete", "create", "drop", "copy"}, false)
+	case 1:
+		return matching(word, r.executor.tableNames(), false)
+	}
+
+	t, err := r.executor.lookupTable(tokens[1])
+	if err != nil {
+		return nil
+	}
+
+	return matching(word, namesOf(t.columns), false)
+}
+
+// sqlKeywords returns the keywords of SQL and the names of column types.
+func sqlKeywords() []string {
+	keywords := []string{}
+	for _, w := range reservedWords {
+		if identifierPattern.MatchString(w) {
+			keywords = append(keywords, w)
+		}
+	}
+
+	return append(keywords, columnNames[1:]...)
+}
+
+// matching returns the candidates which start with the word, ignoring case,
+// sorted and without duplicates. With keywords set, candidates in upper case
+// are treated as keywords, and completed in lower case if the word is.
+func matching(word string, candidates []string, keywords bool) []string {
+	lower := word != "" && strings.ToLower(word) == word && toUp(word) != word
+
+	seen := map[string]bool{}
+	matches := []string{}
+	for _, c := range candidates {
+		if keywords && lower && toUp(c) == c {
+			c = strings.ToLower(c)
+		}
+		if seen[c] || !strings.HasPrefix(strings.ToLower(c), strings.ToLower(word)) {
+			continue
+		}
+
+		seen[c] = true
+		matches = append(matches, c)
+	}
+	sort.Strings(matches)
+
+	return matches
+}
+
+func isWordChar(c rune) bool {
+	return c == '_' || unicode.IsLetter(c) || unicode.IsDigit(c)
+}
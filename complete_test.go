@@ -0,0 +1,118 @@
+package lbadd
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRepl_complete(t *testing.T) {
+	r := NewRepl()
+	_, err := r.executor.execute(context.Background(), instruction{commandCreateTable, "users", []string{"name", "string", "false", "age", "integer", "true"}, nil})
+	assert.NoError(t, err)
+	_, err = r.executor.execute(context.Background(), instruction{commandCreateTable, "orders", []string{"amount", "float", "false"}, nil})
+	assert.NoError(t, err)
+
+	cases := []struct {
+		name       string
+		mode       replMode
+		buffer     string
+		text       string
+		start      int
+		candidates []string
+	}{
+		{
+			name:       "meta-commands",
+			text:       `\d`,
+			start:      0,
+			candidates: []string{`\d`, `\dt`, `\dump`},
+		},
+		{
+			name:       "tables to describe",
+			text:       `\d u`,
+			start:      3,
+			candidates: []string{"users"},
+		},
+		{
+			name:       "formats",
+			text:       `\format j`,
+			start:      8,
+			candidates: []string{"json", "jsonl"},
+		},
+		{
+			name:       "explained SQL",
+			text:       `\explain SELECT * FROM o`,
+			start:      23,
+			candidates: []string{"orders"},
+		},
+		{
+			name:       "tables after FROM",
+			text:       "SELECT * FROM ",
+			start:      14,
+			candidates: []string{"orders", "users"},
+		},
+		{
+			name:       "columns of all tables",
+			text:       "SELECT A",
+			start:      7,
+			candidates: []string{"AND", "ASC", "age", "amount"},
+		},
+		{
+			name:       "columns of the table in the statement",
+			text:       "SELECT * FROM users WHERE a",
+			start:      26,
+			candidates: []string{"age", "and", "asc"},
+		},
+		{
+			name:       "keywords in lower case",
+			text:       "select * fr",
+			start:      9,
+			candidates: []string{"from"},
+		},
+		{
+			name:       "statements continued from earlier lines",
+			buffer:     "SELECT *\n",
+			text:       "FROM us",
+			start:      5,
+			candidates: []string{"users"},
+		},
+		{
+			name:       "only the current statement",
+			text:       "SELECT * FROM orders; SELECT * FROM users WHERE am",
+			start:      48,
+			candidates: []string{},
+		},
+		{
+			name:       "IR commands",
+			mode:       modeIR,
+			text:       "d",
+			start:      0,
+			candidates: []string{"delete", "drop"},
+		},
+		{
+			name:       "IR tables",
+			mode:       modeIR,
+			text:       "drop table o",
+			start:      11,
+			candidates: []string{"orders"},
+		},
+		{
+			name:       "IR columns",
+			mode:       modeIR,
+			text:       "select users n",
+			start:      13,
+			candidates: []string{"name"},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			r.mode, r.buffer = tc.mode, tc.buffer
+
+			start, candidates := r.complete(tc.text)
+			assert.Equal(t, tc.start, start)
+			assert.Equal(t, tc.candidates, candidates)
+		})
+	}
+}
@@ -0,0 +1,409 @@
+package lbadd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// The options of a copy, in the order they are written
+var copyOptionNames = []string{"HEADER", "DELIMITER", "QUOTE", "NULL"}
+
+func isCopyOption(name string) bool {
+	for _, o := range copyOptionNames {
+		if o == toUp(name) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Whether the file of a copy has a header of the column names
+type copyHeader int
+
+const (
+	copyHeaderDetect copyHeader = iota // read if the first record names columns, always written
+	copyHeaderPresent
+	copyHeaderAbsent
+)
+
+// The options of a copy between a table and a file of comma separated values
+type copyOptions struct {
+	header    copyHeader
+	delimiter rune
+	quote     rune
+	null      string // the text of NULL values, which are never quoted
+}
+
+// The number of rows inserted into the table together when copying from a
+// file
+const copyBatchSize = 500
+
+// A row of the input which couldn't be copied into the table
+type rejection struct {
+	line int // the line the row starts on
+	err  error
+}
+
+func (r rejection) String() string {
+	return fmt.Sprintf("line %d: %v", r.line, r.err)
+}
+
+// Executes the copy instruction, copying rows from a file of comma separated
+// values into the table, or the table's rows into the file. The params are
+// the direction, from or to, the file as a string literal, and optionally the
+// columns to copy followed by the options, e.g.
+//
+//	copy users from 'users.csv' name age delimiter=';' header=true
+//
+// Rows which can't be copied into the table are rejected, and reported in
+// the result, rather than failing the copy. Files are only accessed if the
+// executor is configured to allow it.
+func (e *executor) executeCopy(ctx context.Context, instr instruction) (result, error) {
+	if !e.cfg.fileCopy {
+		return result{}, fmt.Errorf("copy can't access files unless FileCopy is set in the database's options")
+	}
+
+	return e.copyFile(ctx, instr)
+}
+
+// copyFile executes the copy instruction, reading or writing the file it
+// names. It is called by the REPL's \copy, which accesses files on behalf of
+// its user whatever the configuration.
+func (e *executor) copyFile(ctx context.Context, instr instruction) (result, error) {
+	t, err := e.table(instr.table)
+	if err != nil {
+		return result{}, err
+	}
+	if len(instr.params) < 2 {
+		return result{}, fmt.Errorf("copy expects a direction and a file")
+	}
+
+	file, ok := parseLiteral(instr.params[1]).(string)
+	if !ok || !strings.HasPrefix(instr.params[1], "'") {
+		return result{}, fmt.Errorf("invalid file %s, expected a string", instr.params[1])
+	}
+
+	cols, opts, err := parseCopyParams(t, instr.params[2:])
+	if err != nil {
+		return result{}, err
+	}
+
+	switch toUp(instr.params[0]) {
+	case "FROM":
+		f, err := os.Open(file)
+		if err != nil {
+			return result{}, err
+		}
+		defer f.Close()
+
+		return e.copyFrom(ctx, t, cols, opts, f)
+
+	case "TO":
+		f, err := os.Create(file)
+		if err != nil {
+			return result{}, err
+		}
+
+		res, err := e.copyTo(ctx, t, cols, opts, f)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		return res, err
+
+	default:
+		return result{}, fmt.Errorf("invalid direction %s, expected from or to", instr.params[0])
+	}
+}
+
+// parseCopyParams parses the columns and options of a copy instruction. The
+// columns are returned as their indices within the table, or nil if none are
+// given.
+func parseCopyParams(t table, params []string) ([]int, copyOptions, error) {
+	opts := copyOptions{delimiter: ',', quote: '"'}
+
+	var cols []int
+	for _, p := range params {
+		i := strings.IndexByte(p, '=')
+		if i == -1 {
+			col := t.columnIndex(p)
+			if col == -1 {
+				return nil, opts, fmt.Errorf("column %s does not exist in table %s", p, t.name)
+			}
+			for _, c := range cols {
+				if c == col {
+					return nil, opts, fmt.Errorf("column %s is given more than once", p)
+				}
+			}
+			cols = append(cols, col)
+			continue
+		}
+
+		if err := opts.set(p[:i], parseLiteral(p[i+1:])); err != nil {
+			return nil, opts, err
+		}
+	}
+
+	if opts.delimiter == opts.quote {
+		return nil, opts, fmt.Errorf("delimiter and quote must be different")
+	}
+
+	return cols, opts, nil
+}
+
+// set sets the option of the given name to the value.
+func (o *copyOptions) set(name string, value interface{}) error {
+	switch toUp(name) {
+	case "HEADER":
+		header, ok := value.(bool)
+		if !ok {
+			return fmt.Errorf("invalid header %s, expected true or false", formatLiteral(value))
+		}
+		o.header = copyHeaderAbsent
+		if header {
+			o.header = copyHeaderPresent
+		}
+	case "DELIMITER", "QUOTE":
+		s, ok := value.(string)
+		c, size := utf8.DecodeRuneInString(s)
+		if !ok || size == 0 || size != len(s) || c == '\n' || c == '\r' {
+			return fmt.Errorf("invalid %s %s, expected a single character", strings.ToLower(name), formatLiteral(value))
+		}
+		if toUp(name) == "DELIMITER" {
+			o.delimiter = c
+		} else {
+			o.quote = c
+		}
+	case "NULL":
+		s, ok := value.(string)
+		if !ok {
+			return fmt.Errorf("invalid null %s, expected a string", formatLiteral(value))
+		}
+		o.null = s
+	default:
+		return fmt.Errorf("unknown copy option %s", name)
+	}
+
+	return nil
+}
+
+// copyFrom inserts a row into the table for each record read from in, holding
+// values for the given columns, or all of the table's columns in order if nil.
+// Columns not given are NULL. Rows are inserted in batches, those which
+// can't be inserted are rejected.
+//
+// A header is either read from the first record, or detected if every one of
+// its fields names a column of the table. Without columns given, the header
+// determines the columns.
+func (e *executor) copyFrom(ctx context.Context, t table, cols []int, opts copyOptions, in io.Reader) (result, error) {
+	res := result{}
+	batch := make([]row, 0, copyBatchSize)
+	insert := func() error {
+		for _, r := range batch {
+			if err := e.insertRow(ctx, t, r); err != nil {
+				return err
+			}
+		}
+		res.rowsAffected += len(batch)
+		batch = batch[:0]
+		return nil
+	}
+
+	r := newCSVReader(in, opts.delimiter, opts.quote)
+	first := true
+	for {
+		if err := checkContext(ctx); err != nil {
+			return result{}, err
+		}
+
+		fields, line, err := r.read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			res.rejected = append(res.rejected, rejection{line: line, err: err})
+			break
+		}
+
+		if first {
+			first = false
+			if opts.header == copyHeaderPresent && cols != nil {
+				continue
+			}
+
+			header, err := readHeader(t, fields, opts.header)
+			if err != nil {
+				return result{}, fmt.Errorf("line %d: %v", line, err)
+			}
+			if header != nil && cols == nil {
+				cols = header
+			}
+			if header != nil || opts.header == copyHeaderPresent {
+				continue
+			}
+		}
+
+		// Blank lines don't hold any records
+		if len(fields) == 1 && fields[0].text == "" && !fields[0].quoted {
+			continue
+		}
+
+		if cols == nil {
+			cols = make([]int, len(t.columns))
+			for i := range cols {
+				cols[i] = i
+			}
+		}
+
+		encoded, err := parseRecord(t, cols, fields, opts.null)
+		if err != nil {
+			res.rejected = append(res.rejected, rejection{line: line, err: err})
+			continue
+		}
+
+		batch = append(batch, encoded)
+		if len(batch) == copyBatchSize {
+			if err := insert(); err != nil {
+				return result{}, err
+			}
+		}
+	}
+	if err := insert(); err != nil {
+		return result{}, err
+	}
+
+	return res, nil
+}
+
+// readHeader returns the columns named by the fields of the first record, if
+// it is a header, or nil otherwise. A header which is present must only name
+// columns of the table, otherwise it is only detected if it does.
+func readHeader(t table, fields []csvField, header copyHeader) ([]int, error) {
+	if header == copyHeaderAbsent {
+		return nil, nil
+	}
+
+	cols := make([]int, len(fields))
+	for i, f := range fields {
+		cols[i] = t.columnIndex(strings.TrimSpace(f.text))
+		if cols[i] != -1 {
+			continue
+		}
+
+		if header == copyHeaderPresent {
+			return nil, fmt.Errorf("column %s does not exist in table %s", f.text, t.name)
+		}
+		return nil, nil
+	}
+
+	return cols, nil
+}
+
+// parseRecord converts the fields of a record, holding values for the given
+// columns, into a row of the table.
+func parseRecord(t table, cols []int, fields []csvField, null string) (row, error) {
+	if len(fields) != len(cols) {
+		return nil, fmt.Errorf("expected %d fields but found %d", len(cols), len(fields))
+	}
+
+	values := make([]interface{}, len(t.columns))
+	for i, f := range fields {
+		c := t.columns[cols[i]]
+
+		v, err := parseField(f, c.dataType, null)
+		if err != nil {
+			return nil, fmt.Errorf("column %s: %v", c.name, err)
+		}
+		values[cols[i]] = v
+	}
+
+	return encodeRow(t, values)
+}
+
+// parseField converts the text of a field into a value of the column type.
+// A field which isn't quoted is NULL if its text is the null text.
+func parseField(f csvField, typ columnType, null string) (interface{}, error) {
+	if !f.quoted && f.text == null {
+		return nil, nil
+	}
+
+	var v interface{}
+	var err error
+
+	text := f.text
+	if typ != columnTypeString {
+		text = strings.TrimSpace(text)
+	}
+
+	switch typ {
+	case columnTypeInt:
+		v, err = strconv.ParseInt(text, 10, 64)
+	case columnTypeFloat:
+		v, err = strconv.ParseFloat(text, 64)
+	case columnTypeBool:
+		v, err = strconv.ParseBool(text)
+	default:
+		return convertValue(text, typ)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s value: %s", typ, quoteString(f.text))
+	}
+
+	return v, nil
+}
+
+// copyTo writes a record to out for each of the table's rows, holding the
+// values of the given columns, or all of the table's columns if nil. A header
+// of the column names is written unless turned off.
+func (e *executor) copyTo(ctx context.Context, t table, cols []int, opts copyOptions, out io.Writer) (result, error) {
+	if cols == nil {
+		cols = make([]int, len(t.columns))
+		for i := range cols {
+			cols[i] = i
+		}
+	}
+
+	w := newCSVWriter(out, opts.delimiter, opts.quote, opts.null)
+	values := make([]interface{}, len(cols))
+
+	if opts.header != copyHeaderAbsent {
+		for i, c := range cols {
+			values[i] = t.columns[c].name
+		}
+		if err := w.write(values); err != nil {
+			return result{}, err
+		}
+	}
+
+	res := result{}
+	var werr error
+	err := e.scanMatching(ctx, t, nil, func(k key, r row) {
+		if werr != nil {
+			return
+		}
+		for i, c := range cols {
+			if values[i], werr = decodeRecord(r[c], t.columns[c].dataType); werr != nil {
+				return
+			}
+		}
+		if werr = w.write(values); werr == nil {
+			res.rowsAffected++
+		}
+	})
+	if err == nil {
+		err = werr
+	}
+	if err == nil {
+		err = w.flush()
+	}
+	if err != nil {
+		return result{}, err
+	}
+
+	return res, nil
+}
@@ -0,0 +1,256 @@
+package lbadd
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_executor_copyFrom(t *testing.T) {
+	cases := []struct {
+		name     string
+		input    string
+		copy     string
+		expected string
+		rejected []string
+		err      string
+	}{
+		{
+			name:     "detected header",
+			input:    "age,name\n7,Jane\n,John\n",
+			copy:     "COPY users FROM '%s'",
+			expected: "Jane 7|John NULL",
+		},
+		{
+			name:     "no header",
+			input:    "Jane,7\n\nJohn,8\n",
+			copy:     "COPY users FROM '%s'",
+			expected: "Jane 7|John 8",
+		},
+		{
+			name:     "header given with columns",
+			input:    "a,b\n7,Jane\n",
+			copy:     "COPY users (age, name) FROM '%s' WITH (HEADER)",
+			expected: "Jane 7",
+		},
+		{
+			name:     "header turned off",
+			input:    "name\nJane\n",
+			copy:     "COPY users (name) FROM '%s' WITH (HEADER FALSE)",
+			expected: "name NULL|Jane NULL",
+		},
+		{
+			name:  "unknown column in header",
+			input: "name,email\nJane,x\n",
+			copy:  "COPY users FROM '%s' (HEADER TRUE)",
+			err:   "line 1: column email does not exist in table users",
+		},
+		{
+			name:     "options",
+			input:    "name;age\n'a;b';-\n'-';1\n",
+			copy:     "COPY users FROM '%s' WITH (DELIMITER ';', QUOTE '''', NULL '-')",
+			expected: "a;b NULL|- 1",
+		},
+		{
+			name:     "rejected rows",
+			input:    "name,age\nJane,seven\n,7\nJohn\n\"Jim\",\"8\"\n\"Joe,9\n",
+			copy:     "COPY users FROM '%s'",
+			expected: "Jim 8",
+			rejected: []string{
+				"line 2: column age: invalid integer value: 'seven'",
+				"line 3: column name can't be NULL",
+				"line 4: expected 2 fields but found 1",
+				"line 6: unterminated quoted field",
+			},
+		},
+		{
+			name: "invalid option",
+			copy: "COPY users FROM '%s' WITH (DELIMITER ';;')",
+			err:  "invalid delimiter ';;', expected a single character",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "lbadd")
+			if !assert.NoError(t, err) {
+				return
+			}
+			defer os.RemoveAll(dir)
+
+			file := filepath.Join(dir, "users.csv")
+			if !assert.NoError(t, ioutil.WriteFile(file, []byte(tc.input), 0600)) {
+				return
+			}
+
+			e := newExecutor(exeConfig{order: 3, fileCopy: true})
+			execSQL(t, e, "CREATE TABLE users (name string NOT NULL, age integer)")
+
+			res, err := execSQLErr(e, fmt.Sprintf(tc.copy, file))
+			if tc.err != "" {
+				assert.EqualError(t, err, tc.err)
+				return
+			}
+			assert.NoError(t, err)
+
+			rejected := []string{}
+			for _, r := range res.rejected {
+				rejected = append(rejected, r.String())
+			}
+			if tc.rejected == nil {
+				tc.rejected = []string{}
+			}
+			assert.Equal(t, tc.rejected, rejected)
+
+			rows := execSQL(t, e, "SELECT name, age FROM users")
+			assert.Equal(t, len(rows.rows), res.rowsAffected)
+			assert.Equal(t, tc.expected, displayRows(t, rows))
+		})
+	}
+}
+
+func Test_executor_copyFrom_batches(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lbadd")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	n := copyBatchSize*2 + 1
+	lines := make([]string, n)
+	for i := range lines {
+		lines[i] = fmt.Sprint(i)
+	}
+	file := filepath.Join(dir, "n.csv")
+	if !assert.NoError(t, ioutil.WriteFile(file, []byte(strings.Join(lines, "\n")), 0600)) {
+		return
+	}
+
+	e := newExecutor(exeConfig{order: 3, fileCopy: true})
+	execSQL(t, e, "CREATE TABLE n (n integer)")
+	res := execSQL(t, e, fmt.Sprintf("COPY n FROM '%s'", file))
+	assert.Equal(t, n, res.rowsAffected)
+
+	res = execSQL(t, e, "SELECT n FROM n WHERE n >= 999")
+	assert.Equal(t, "999|1000", displayRows(t, res))
+}
+
+func Test_executor_copyTo(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lbadd")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	e := newExecutor(exeConfig{order: 3, fileCopy: true})
+	execSQL(t, e, "CREATE TABLE users (name string NOT NULL, age integer)")
+	execSQL(t, e, `INSERT INTO users VALUES ('Jane', 7), ('Smith, John', NULL), ('', 3)`)
+
+	cases := []struct {
+		name     string
+		copy     string
+		expected string
+	}{
+		{
+			name:     "all columns",
+			copy:     "COPY users TO '%s'",
+			expected: "name,age\nJane,7\n\"Smith, John\",\n\"\",3\n",
+		},
+		{
+			name:     "columns and options",
+			copy:     "COPY users (age, name) TO '%s' WITH (HEADER FALSE, DELIMITER '|', NULL 'NULL')",
+			expected: "7|Jane\nNULL|Smith, John\n3|\n",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			file := filepath.Join(dir, "out.csv")
+			res := execSQL(t, e, fmt.Sprintf(tc.copy, file))
+			assert.Equal(t, 3, res.rowsAffected)
+
+			data, err := ioutil.ReadFile(file)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, string(data))
+		})
+	}
+
+	// What's written reads back into the same rows
+	file := filepath.Join(dir, "users.csv")
+	execSQL(t, e, fmt.Sprintf("COPY users TO '%s'", file))
+	execSQL(t, e, "CREATE TABLE copied (name string NOT NULL, age integer)")
+	execSQL(t, e, fmt.Sprintf("COPY copied FROM '%s'", file))
+	assert.Equal(t,
+		displayRows(t, execSQL(t, e, "SELECT * FROM users")),
+		displayRows(t, execSQL(t, e, "SELECT * FROM copied")),
+	)
+}
+
+func Test_executor_copy_errors(t *testing.T) {
+	e := newExecutor(exeConfig{order: 3, fileCopy: true})
+	execSQL(t, e, "CREATE TABLE users (name string)")
+
+	cases := []struct {
+		instr instruction
+		err   string
+	}{
+		{instruction{commandCopy, "missing", []string{"from", "'x.csv'"}, nil}, "table missing does not exist"},
+		{instruction{commandCopy, "users", []string{"from"}, nil}, "copy expects a direction and a file"},
+		{instruction{commandCopy, "users", []string{"into", "'x.csv'"}, nil}, "invalid direction into, expected from or to"},
+		{instruction{commandCopy, "users", []string{"from", "x.csv"}, nil}, "invalid file x.csv, expected a string"},
+		{instruction{commandCopy, "users", []string{"from", "'x.csv'", "email"}, nil}, "column email does not exist in table users"},
+		{instruction{commandCopy, "users", []string{"from", "'x.csv'", "name", "name"}, nil}, "column name is given more than once"},
+		{instruction{commandCopy, "users", []string{"from", "'x.csv'", "header=1"}, nil}, "invalid header 1, expected true or false"},
+		{instruction{commandCopy, "users", []string{"from", "'x.csv'", "quote=','"}, nil}, "delimiter and quote must be different"},
+		{instruction{commandCopy, "users", []string{"from", "'x.csv'", "format=csv"}, nil}, "unknown copy option format"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.err, func(t *testing.T) {
+			_, err := e.execute(context.Background(), tc.instr)
+			assert.EqualError(t, err, tc.err)
+		})
+	}
+
+	// Files can't be accessed unless the executor allows it
+	e = newExecutor(exeConfig{order: 3})
+	execSQL(t, e, "CREATE TABLE users (name string)")
+	_, err := execSQLErr(e, "COPY users TO 'users.csv'")
+	assert.EqualError(t, err, "copy can't access files unless FileCopy is set in the database's options")
+}
+
+func execSQLErr(e *executor, sql string) (result, error) {
+	q, err := parse(sql)
+	if err != nil {
+		return result{}, err
+	}
+
+	return e.executeQuery(context.Background(), q)
+}
+
+func execSQL(t *testing.T, e *executor, sql string) result {
+	res, err := execSQLErr(e, sql)
+	assert.NoError(t, err, sql)
+
+	return res
+}
+
+// displayRows displays the rows of the result, separated by |, with the
+// values of each row separated by spaces.
+func displayRows(t *testing.T, res result) string {
+	cells, err := displayCells(res.columns, res.rows, "NULL")
+	assert.NoError(t, err)
+
+	rows := make([]string, len(cells))
+	for i, c := range cells {
+		rows[i] = strings.Join(c, " ")
+	}
+
+	return strings.Join(rows, "|")
+}
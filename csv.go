@@ -0,0 +1,162 @@
+package lbadd
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// csvReader reads records of comma separated values, as described by RFC
+// 4180, with a configurable delimiter and quote character. Unlike
+// encoding/csv, it reports whether each field was quoted, so that an empty
+// string can be told apart from a missing value, and the line each record
+// starts on.
+//
+// Characters following the closing quote of a field are kept as part of the
+// field, and carriage returns are only removed before line feeds.
+type csvReader struct {
+	in        *bufio.Reader
+	delimiter rune
+	quote     rune
+	line      int // the number of lines read
+}
+
+// A single field of a record
+type csvField struct {
+	text   string
+	quoted bool
+}
+
+func newCSVReader(in io.Reader, delimiter, quote rune) *csvReader {
+	return &csvReader{
+		in:        bufio.NewReader(in),
+		delimiter: delimiter,
+		quote:     quote,
+	}
+}
+
+// read reads the next record, returning its fields and the line it starts on.
+// io.EOF is returned once there are no more records.
+func (r *csvReader) read() ([]csvField, int, error) {
+	c, _, err := r.in.ReadRune()
+	if err != nil {
+		return nil, 0, err
+	}
+	r.line++
+	start := r.line
+
+	fields := []csvField{}
+	var text strings.Builder
+	quoted := false
+
+	for {
+		switch {
+		case err == io.EOF || (err == nil && c == '\n'):
+			fields = append(fields, csvField{text: text.String(), quoted: quoted})
+			return fields, start, nil
+		case err != nil:
+			return nil, start, err
+		case c == r.delimiter:
+			fields = append(fields, csvField{text: text.String(), quoted: quoted})
+			text.Reset()
+			quoted = false
+		case c == r.quote && text.Len() == 0 && !quoted:
+			quoted = true
+			if err := r.readQuoted(&text); err != nil {
+				return nil, start, err
+			}
+		case c == '\r':
+			if next, err := r.in.Peek(1); err != nil || next[0] != '\n' {
+				text.WriteRune(c)
+			}
+		default:
+			text.WriteRune(c)
+		}
+
+		c, _, err = r.in.ReadRune()
+	}
+}
+
+// readQuoted reads the rest of a quoted field, up to and including its
+// closing quote. A doubled quote stands for a quote within the field.
+func (r *csvReader) readQuoted(text *strings.Builder) error {
+	for {
+		c, _, err := r.in.ReadRune()
+		if err == io.EOF {
+			return fmt.Errorf("unterminated quoted field")
+		}
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case c == r.quote:
+			next, _, err := r.in.ReadRune()
+			if err == nil && next == r.quote {
+				text.WriteRune(c)
+				continue
+			}
+			if err == nil {
+				_ = r.in.UnreadRune()
+			}
+			return nil
+		case c == '\n':
+			r.line++
+		}
+		text.WriteRune(c)
+	}
+}
+
+// csvWriter writes records of comma separated values, with a configurable
+// delimiter and quote character. NULL values are written as the null text,
+// fields are quoted where they would otherwise not read back as the same
+// value.
+type csvWriter struct {
+	out       *bufio.Writer
+	delimiter rune
+	quote     rune
+	null      string
+}
+
+func newCSVWriter(out io.Writer, delimiter, quote rune, null string) *csvWriter {
+	return &csvWriter{
+		out:       bufio.NewWriter(out),
+		delimiter: delimiter,
+		quote:     quote,
+		null:      null,
+	}
+}
+
+// write writes a record of the values, which are decoded values as returned
+// by decodeRecord.
+func (w *csvWriter) write(values []interface{}) error {
+	for i, v := range values {
+		if i > 0 {
+			if _, err := w.out.WriteRune(w.delimiter); err != nil {
+				return err
+			}
+		}
+
+		text := displayValue(v, w.null)
+		if v != nil && w.needsQuotes(text) {
+			q := string(w.quote)
+			text = q + strings.Replace(text, q, q+q, -1) + q
+		}
+		if _, err := w.out.WriteString(text); err != nil {
+			return err
+		}
+	}
+
+	_, err := w.out.WriteString("\n")
+	return err
+}
+
+// flush writes any buffered records to the underlying writer.
+func (w *csvWriter) flush() error {
+	return w.out.Flush()
+}
+
+func (w *csvWriter) needsQuotes(text string) bool {
+	return text == w.null || strings.ContainsAny(text, string([]rune{w.delimiter, w.quote, '\r', '\n'}))
+}
@@ -0,0 +1,105 @@
+package lbadd
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_csvReader(t *testing.T) {
+	type record struct {
+		line   int
+		fields []csvField
+	}
+
+	cases := []struct {
+		name      string
+		input     string
+		delimiter rune
+		quote     rune
+		expected  []record
+		err       string
+	}{
+		{
+			name:  "plain fields",
+			input: "a,b,c\n1,,3\n",
+			expected: []record{
+				{1, []csvField{{"a", false}, {"b", false}, {"c", false}}},
+				{2, []csvField{{"1", false}, {"", false}, {"3", false}}},
+			},
+		},
+		{
+			name:  "no trailing newline and CRLF",
+			input: "a,b\r\nc,d",
+			expected: []record{
+				{1, []csvField{{"a", false}, {"b", false}}},
+				{2, []csvField{{"c", false}, {"d", false}}},
+			},
+		},
+		{
+			name:  "quoted fields",
+			input: "\"a,b\",\"\",\"say \"\"hi\"\"\"\n\"multi\nline\",x\nlast\n",
+			expected: []record{
+				{1, []csvField{{"a,b", true}, {"", true}, {`say "hi"`, true}}},
+				{2, []csvField{{"multi\nline", true}, {"x", false}}},
+				{4, []csvField{{"last", false}}},
+			},
+		},
+		{
+			name:      "custom delimiter and quote",
+			input:     "'a;b';c\n",
+			delimiter: ';',
+			quote:     '\'',
+			expected: []record{
+				{1, []csvField{{"a;b", true}, {"c", false}}},
+			},
+		},
+		{
+			name:     "unterminated quote",
+			input:    "a\n\"b,c\n",
+			expected: []record{{1, []csvField{{"a", false}}}},
+			err:      "unterminated quoted field",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			delimiter, quote := ',', '"'
+			if tc.delimiter != 0 {
+				delimiter, quote = tc.delimiter, tc.quote
+			}
+			r := newCSVReader(strings.NewReader(tc.input), delimiter, quote)
+
+			actual := []record{}
+			for {
+				fields, line, err := r.read()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					assert.EqualError(t, err, tc.err)
+					break
+				}
+				actual = append(actual, record{line, fields})
+			}
+			assert.Equal(t, tc.expected, actual)
+		})
+	}
+}
+
+func Test_csvWriter(t *testing.T) {
+	var out bytes.Buffer
+	w := newCSVWriter(&out, ';', '\'', "")
+
+	assert.NoError(t, w.write([]interface{}{"name", "age"}))
+	assert.NoError(t, w.write([]interface{}{"it's; fine", int64(7)}))
+	assert.NoError(t, w.write([]interface{}{"", nil}))
+	assert.NoError(t, w.write([]interface{}{true, time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)}))
+	assert.NoError(t, w.flush())
+
+	assert.Equal(t, "name;age\n'it''s; fine';7\n'';\ntrue;2020-01-02T03:04:05Z\n", out.String())
+}
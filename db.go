@@ -1,19 +1,78 @@
 package lbadd
 
+import (
+	"sync"
+	"sync/atomic"
+)
+
 type table struct {
 	name    string
 	store   storage
 	columns []column
+	nextKey *int64 // the key given to the next row inserted, shared by copies
+}
+
+func newTable(name string, columns []column, order int) table {
+	return table{
+		name:    name,
+		store:   newBtreeOrder(order),
+		columns: columns,
+		nextKey: new(int64),
+	}
+}
+
+// rowIDColumn describes the key of a table's rows, an implicit row id given
+// to each row as it is inserted.
+var rowIDColumn = column{name: "rowid", dataType: columnTypeInt}
+
+// keyedColumns returns the table's columns followed by the rowid column, so
+// that conditions and fields may refer to the key of each row.
+func (t table) keyedColumns() []column {
+	return append(append([]column{}, t.columns...), rowIDColumn)
+}
+
+// keyRecord encodes the key of a row as the value of its rowid column.
+func keyRecord(k key) record {
+	r, _ := encodeValue(int64(k), columnTypeInt)
+	return r
+}
+
+// newKey returns the key of a row being inserted into the table.
+func (t table) newKey() key {
+	return key(atomic.AddInt64(t.nextKey, 1) - 1)
+}
+
+// useKey makes sure the keys of rows inserted later follow k.
+func (t table) useKey(k key) {
+	for {
+		next := atomic.LoadInt64(t.nextKey)
+		if int64(k) < next || atomic.CompareAndSwapInt64(t.nextKey, next, int64(k)+1) {
+			return
+		}
+	}
 }
 
+// db is the state shared by every session of a database.
 type db struct {
-	tables map[string]table
+	garbage int64 // the versions ended since the last vacuum, accessed atomically
+
+	mu     sync.RWMutex
+	tables map[string]*tableVersion // the newest version of each table by name
+
+	txns     *txnManager
+	locks    *lockManager
+	ssi      *ssiManager
+	commitMu sync.Mutex // held while a transaction with changes commits
+	log      *wal       // where committed changes are logged, nil if they aren't
 }
 
 func newDB() *db {
-	tables := make(map[string]table)
+	tables := make(map[string]*tableVersion)
 
 	return &db{
 		tables: tables,
+		txns:   newTxnManager(),
+		locks:  newLockManager(),
+		ssi:    newSSIManager(),
 	}
 }
@@ -0,0 +1,485 @@
+package lbadd
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The database/sql driver is registered as lbadd, and opened with a data
+// source name of the file the database is kept in, with any options as
+// query parameters, e.g.
+//
+//	db, err := sql.Open("lbadd", "file:data.db?order=64")
+//
+// Without a file, or with :memory:, the database only exists in memory. The
+// options are order, lock_timeout, a duration such as 5s, and file_copy, true
+// or false, see Options. All of the connections of a sql.DB share a database,
+// which is written to its file once they are all closed. Each connection is a
+// session with transactions of its own, which see the database as it was when
+// they began. Reading never waits, but a transaction changing a row or table
+// waits for any other changing it to end. If the other committed, or had
+// committed since the transaction began, the transaction fails with an error
+// matching ErrSerialization, and is rolled back. Transactions waiting for each
+// other are deadlocked, and one fails with ErrDeadlock.
+//
+// Transactions are repeatable read by default, and may be begun at the read
+// committed or serializable isolation levels instead. At read committed, each
+// statement sees the changes committed before it began, and is executed
+// again rather than failing with ErrSerialization. Serializable transactions
+// also fail with ErrSerialization when their reads and writes, and those of
+// concurrent serializable transactions, couldn't have happened in any serial
+// order.
+func init() {
+	sql.Register("lbadd", sqlDriver{})
+}
+
+type sqlDriver struct{}
+
+func (d sqlDriver) Open(name string) (driver.Conn, error) {
+	c, err := d.OpenConnector(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.Connect(context.Background())
+}
+
+func (d sqlDriver) OpenConnector(name string) (driver.Connector, error) {
+	path, opts, err := parseDSN(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return &connector{path: path, opts: opts}, nil
+}
+
+// parseDSN parses a data source name into the path of the database's file
+// and the options it is opened with.
+func parseDSN(dsn string) (string, Options, error) {
+	opts := Options{}
+
+	path := strings.TrimPrefix(dsn, "file:")
+	query := ""
+	if i := strings.IndexByte(path, '?'); i != -1 {
+		path, query = path[:i], path[i+1:]
+	}
+	if path == ":memory:" {
+		path = ""
+	}
+
+	params, err := url.ParseQuery(query)
+	if err != nil {
+		return "", opts, fmt.Errorf("invalid data source name %s: %v", dsn, err)
+	}
+	for name, values := range params {
+		switch name {
+		case "order":
+			if opts.Order, err = strconv.Atoi(values[len(values)-1]); err != nil {
+				return "", opts, fmt.Errorf("invalid order %s", values[len(values)-1])
+			}
+		case "lock_timeout":
+			if opts.LockTimeout, err = time.ParseDuration(values[len(values)-1]); err != nil {
+				return "", opts, fmt.Errorf("invalid lock timeout %s", values[len(values)-1])
+			}
+		case "file_copy":
+			if opts.FileCopy, err = strconv.ParseBool(values[len(values)-1]); err != nil {
+				return "", opts, fmt.Errorf("invalid file copy %s, expected true or false", values[len(values)-1])
+			}
+		default:
+			return "", opts, fmt.Errorf("unknown option %s in data source name %s", name, dsn)
+		}
+	}
+
+	return path, opts, nil
+}
+
+// connector opens connections to a database shared between them, which is
+// opened with the first connection and closed, saving it, once the last is
+// closed. A database kept only in memory stays open instead.
+type connector struct {
+	path string
+	opts Options
+
+	mu    sync.Mutex
+	db    *DB
+	conns int // the number of open connections
+}
+
+func (c *connector) Connect(ctx context.Context) (driver.Conn, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.db == nil {
+		db, err := Open(c.path, &c.opts)
+		if err != nil {
+			return nil, err
+		}
+		c.db = db
+	}
+	c.conns++
+
+	return &conn{connector: c, db: c.db, session: c.db.executor.newSession()}, nil
+}
+
+func (c *connector) Driver() driver.Driver {
+	return sqlDriver{}
+}
+
+// release is called as a connection is closed, closing the database along
+// with its log if it was the last. The next connection opens it again.
+func (c *connector) release() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.conns--
+	if c.conns > 0 || c.path == "" {
+		return nil
+	}
+
+	db := c.db
+	c.db = nil
+	return db.Close()
+}
+
+// conn is a connection to a database. A connection is used by one goroutine
+// at a time, the database itself is safe for concurrent use.
+type conn struct {
+	connector *connector
+	db        *DB
+	session   *executor
+	closed    bool
+}
+
+var (
+	_ driver.ConnPrepareContext = &conn{}
+	_ driver.ConnBeginTx        = &conn{}
+	_ driver.ExecerContext      = &conn{}
+	_ driver.QueryerContext     = &conn{}
+	_ driver.Pinger             = &conn{}
+	_ driver.SessionResetter    = &conn{}
+)
+
+func (c *conn) Prepare(query string) (driver.Stmt, error) {
+	return c.PrepareContext(context.Background(), query)
+}
+
+func (c *conn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	if c.closed {
+		return nil, driver.ErrBadConn
+	}
+	if err := c.check(ctx); err != nil {
+		return nil, err
+	}
+
+	s, err := c.session.prepare(trimStatement(query))
+	if err != nil {
+		return nil, err
+	}
+
+	return &stmt{conn: c, prepared: s}, nil
+}
+
+// Close closes the connection, rolling back its transaction if it has one in
+// progress.
+func (c *conn) Close() error {
+	if c.closed {
+		return nil
+	}
+
+	if c.session.tx != nil {
+		c.session.rollback()
+	}
+	c.closed = true
+
+	return c.connector.release()
+}
+
+// check returns an error if the connection's database can't be used for the
+// context.
+func (c *conn) check(ctx context.Context) error {
+	c.db.mu.Lock()
+	defer c.db.mu.Unlock()
+
+	return c.db.check(ctx)
+}
+
+func (c *conn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+// BeginTx begins a transaction, which holds the statements executed on the
+// connection until it is committed or rolled back. Read uncommitted is
+// treated as read committed, and snapshot as repeatable read, the default.
+func (c *conn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if c.closed {
+		return nil, driver.ErrBadConn
+	}
+	if opts.ReadOnly {
+		return nil, errors.New("read only transactions are not supported")
+	}
+
+	set := ""
+	switch level := sql.IsolationLevel(opts.Isolation); level {
+	case sql.LevelDefault:
+	case sql.LevelReadUncommitted, sql.LevelReadCommitted:
+		set = "SET TRANSACTION ISOLATION LEVEL READ COMMITTED"
+	case sql.LevelRepeatableRead, sql.LevelSnapshot:
+		set = "SET TRANSACTION ISOLATION LEVEL REPEATABLE READ"
+	case sql.LevelSerializable:
+		set = "SET TRANSACTION ISOLATION LEVEL SERIALIZABLE"
+	default:
+		return nil, fmt.Errorf("isolation level %s is not supported", level)
+	}
+
+	if _, err := c.ExecContext(ctx, "BEGIN", nil); err != nil {
+		return nil, err
+	}
+	t := tx{conn: c}
+	if set == "" {
+		return t, nil
+	}
+
+	// Without a Tx returned, nothing else would end the transaction
+	if _, err := c.ExecContext(ctx, set, nil); err != nil {
+		t.Rollback()
+		return nil, err
+	}
+	return t, nil
+}
+
+func (c *conn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	if c.closed {
+		return nil, driver.ErrBadConn
+	}
+	if err := c.check(ctx); err != nil {
+		return nil, err
+	}
+
+	res, err := c.session.exec(ctx, query, namedArgs(args)...)
+	if err != nil {
+		return nil, err
+	}
+
+	return driverResult(res.RowsAffected), nil
+}
+
+func (c *conn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	if c.closed {
+		return nil, driver.ErrBadConn
+	}
+	if err := c.check(ctx); err != nil {
+		return nil, err
+	}
+
+	rows, err := c.session.query(ctx, query, namedArgs(args)...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &driverRows{rows: rows}, nil
+}
+
+func (c *conn) Ping(ctx context.Context) error {
+	if c.closed {
+		return driver.ErrBadConn
+	}
+
+	return c.check(ctx)
+}
+
+// ResetSession is called before the connection is reused, and rolls back a
+// transaction begun with BEGIN which was left in progress.
+func (c *conn) ResetSession(ctx context.Context) error {
+	if c.closed {
+		return driver.ErrBadConn
+	}
+	if c.session.tx != nil {
+		c.session.rollback()
+	}
+
+	return nil
+}
+
+// namedArgs converts the arguments passed to the driver into those bound to
+// a statement's parameters, where named arguments are bound by name.
+func namedArgs(args []driver.NamedValue) []interface{} {
+	converted := make([]interface{}, len(args))
+	for i, a := range args {
+		if a.Name != "" {
+			converted[i] = sql.Named(a.Name, a.Value)
+		} else {
+			converted[i] = a.Value
+		}
+	}
+
+	return converted
+}
+
+// stmt is a prepared statement of a connection.
+type stmt struct {
+	conn     *conn
+	prepared *preparedStmt
+	closed   bool
+}
+
+var (
+	_ driver.StmtExecContext  = &stmt{}
+	_ driver.StmtQueryContext = &stmt{}
+)
+
+func (s *stmt) Close() error {
+	s.closed = true
+	return nil
+}
+
+func (s *stmt) NumInput() int {
+	return len(s.prepared.params)
+}
+
+func (s *stmt) Exec(args []driver.Value) (driver.Result, error) {
+	return s.ExecContext(context.Background(), positionalArgs(args))
+}
+
+func (s *stmt) Query(args []driver.Value) (driver.Rows, error) {
+	return s.QueryContext(context.Background(), positionalArgs(args))
+}
+
+func (s *stmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	res, err := s.execute(ctx, args, (*preparedStmt).exec)
+	if err != nil {
+		return nil, err
+	}
+
+	return driverResult(res.rowsAffected), nil
+}
+
+func (s *stmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	res, err := s.execute(ctx, args, (*preparedStmt).query)
+	if err != nil {
+		return nil, err
+	}
+
+	return &driverRows{rows: &Rows{columns: res.columns, rows: res.rows}}, nil
+}
+
+// execute executes the statement with fn, either exec or query.
+func (s *stmt) execute(ctx context.Context, args []driver.NamedValue, fn func(*preparedStmt, context.Context, ...interface{}) (result, error)) (result, error) {
+	if s.closed {
+		return result{}, errors.New("statement is closed")
+	}
+	if s.conn.closed {
+		return result{}, driver.ErrBadConn
+	}
+	if err := s.conn.check(ctx); err != nil {
+		return result{}, err
+	}
+
+	return fn(s.prepared, ctx, namedArgs(args)...)
+}
+
+func positionalArgs(args []driver.Value) []driver.NamedValue {
+	named := make([]driver.NamedValue, len(args))
+	for i, a := range args {
+		named[i] = driver.NamedValue{Ordinal: i + 1, Value: a}
+	}
+
+	return named
+}
+
+// tx is the transaction in progress on a connection.
+type tx struct {
+	conn *conn
+}
+
+func (t tx) Commit() error {
+	_, err := t.conn.ExecContext(context.Background(), "COMMIT", nil)
+	return err
+}
+
+func (t tx) Rollback() error {
+	_, err := t.conn.ExecContext(context.Background(), "ROLLBACK", nil)
+	return err
+}
+
+// driverResult is the number of rows affected by a statement.
+type driverResult int
+
+func (r driverResult) LastInsertId() (int64, error) {
+	return 0, errors.New("LastInsertId is not supported")
+}
+
+func (r driverResult) RowsAffected() (int64, error) {
+	return int64(r), nil
+}
+
+// driverRows are the rows returned by a query, with values of the Go type
+// for their column's type: int64, float64, bool, string or time.Time.
+type driverRows struct {
+	rows *Rows
+}
+
+var (
+	_ driver.RowsColumnTypeDatabaseTypeName = &driverRows{}
+	_ driver.RowsColumnTypeScanType         = &driverRows{}
+	_ driver.RowsColumnTypeNullable         = &driverRows{}
+)
+
+func (r *driverRows) Columns() []string {
+	return r.rows.Columns()
+}
+
+func (r *driverRows) Close() error {
+	return r.rows.Close()
+}
+
+func (r *driverRows) Next(dest []driver.Value) error {
+	if !r.rows.Next() {
+		return io.EOF
+	}
+
+	row := r.rows.rows[r.rows.pos-1]
+	for i, c := range r.rows.columns {
+		v, err := decodeRecord(row[i], c.dataType)
+		if err != nil {
+			return err
+		}
+		dest[i] = v
+	}
+
+	return nil
+}
+
+func (r *driverRows) ColumnTypeDatabaseTypeName(index int) string {
+	return toUp(r.rows.columns[index].dataType.String())
+}
+
+func (r *driverRows) ColumnTypeScanType(index int) reflect.Type {
+	switch r.rows.columns[index].dataType {
+	case columnTypeInt:
+		return reflect.TypeOf(int64(0))
+	case columnTypeFloat:
+		return reflect.TypeOf(float64(0))
+	case columnTypeBool:
+		return reflect.TypeOf(false)
+	case columnTypeString:
+		return reflect.TypeOf("")
+	case columnTypeDateTime:
+		return reflect.TypeOf(time.Time{})
+	default:
+		return reflect.TypeOf(new(interface{})).Elem()
+	}
+}
+
+func (r *driverRows) ColumnTypeNullable(index int) (nullable, ok bool) {
+	return r.rows.columns[index].isNullable, true
+}
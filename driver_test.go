@@ -0,0 +1,311 @@
+package lbadd
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_parseDSN(t *testing.T) {
+	cases := []struct {
+		dsn  string
+		path string
+		opts Options
+		err  string
+	}{
+		{dsn: "", path: ""},
+		{dsn: ":memory:", path: ""},
+		{dsn: "file::memory:?order=8", path: "", opts: Options{Order: 8}},
+		{dsn: "file:data.db?order=64", path: "data.db", opts: Options{Order: 64}},
+		{dsn: "/var/lib/data.db", path: "/var/lib/data.db"},
+		{dsn: "data.db?lock_timeout=1s", path: "data.db", opts: Options{LockTimeout: time.Second}},
+		{dsn: "data.db?file_copy=true", path: "data.db", opts: Options{FileCopy: true}},
+		{dsn: "data.db?order=x", err: "invalid order x"},
+		{dsn: "data.db?lock_timeout=1", err: "invalid lock timeout 1"},
+		{dsn: "data.db?file_copy=yes", err: "invalid file copy yes, expected true or false"},
+		{dsn: "data.db?cache=shared", err: "unknown option cache in data source name data.db?cache=shared"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.dsn, func(t *testing.T) {
+			path, opts, err := parseDSN(tc.dsn)
+			if tc.err != "" {
+				assert.EqualError(t, err, tc.err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.path, path)
+			assert.Equal(t, tc.opts, opts)
+		})
+	}
+}
+
+func TestDriver(t *testing.T) {
+	ctx := context.Background()
+	db, err := sql.Open("lbadd", ":memory:")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer db.Close()
+
+	_, err = db.Exec("CREATE TABLE users (name string NOT NULL, age integer, score float, admin boolean, born datetime)")
+	assert.NoError(t, err)
+
+	// Prepared statements can be executed repeatedly
+	insert, err := db.Prepare("INSERT INTO users VALUES (?, ?, ?, ?, ?);")
+	if !assert.NoError(t, err) {
+		return
+	}
+	born := time.Date(2000, 1, 2, 0, 0, 0, 0, time.UTC)
+	res, err := insert.Exec("Jane", 7, 1.5, true, born)
+	assert.NoError(t, err)
+	_, err = insert.Exec("John", nil, nil, false, nil)
+	assert.NoError(t, err)
+	assert.NoError(t, insert.Close())
+
+	n, err := res.RowsAffected()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), n)
+	_, err = res.LastInsertId()
+	assert.Error(t, err)
+
+	res, err = db.ExecContext(ctx, "UPDATE users SET age = :age WHERE name = :name", sql.Named("name", "John"), sql.Named("age", 40))
+	assert.NoError(t, err)
+	n, _ = res.RowsAffected()
+	assert.Equal(t, int64(1), n)
+
+	rows, err := db.QueryContext(ctx, "SELECT * FROM users WHERE age > $1", 5)
+	if !assert.NoError(t, err) {
+		return
+	}
+	types, err := rows.ColumnTypes()
+	assert.NoError(t, err)
+	names, scanTypes, nullable := []string{}, []reflect.Type{}, []bool{}
+	for _, ct := range types {
+		names = append(names, ct.DatabaseTypeName())
+		scanTypes = append(scanTypes, ct.ScanType())
+		n, _ := ct.Nullable()
+		nullable = append(nullable, n)
+	}
+	assert.Equal(t, []string{"STRING", "INTEGER", "FLOAT", "BOOLEAN", "DATETIME"}, names)
+	assert.Equal(t, []reflect.Type{
+		reflect.TypeOf(""), reflect.TypeOf(int64(0)), reflect.TypeOf(float64(0)), reflect.TypeOf(false), reflect.TypeOf(time.Time{}),
+	}, scanTypes)
+	assert.Equal(t, []bool{false, true, true, true, true}, nullable)
+
+	type user struct {
+		name  string
+		age   int
+		score sql.NullFloat64
+		admin bool
+		born  sql.NullTime
+	}
+	users := []user{}
+	for rows.Next() {
+		var u user
+		assert.NoError(t, rows.Scan(&u.name, &u.age, &u.score, &u.admin, &u.born))
+		users = append(users, u)
+	}
+	assert.NoError(t, rows.Err())
+	assert.Equal(t, []user{
+		{"Jane", 7, sql.NullFloat64{Float64: 1.5, Valid: true}, true, sql.NullTime{Time: born, Valid: true}},
+		{"John", 40, sql.NullFloat64{}, false, sql.NullTime{}},
+	}, users)
+
+	var count int
+	err = db.QueryRow("SELECT name FROM users WHERE name = ?", "nobody").Scan(&count)
+	assert.Equal(t, sql.ErrNoRows, err)
+
+	_, err = db.Exec("SELECT FROM users")
+	assert.IsType(t, &ParseError{}, err)
+}
+
+func TestDriver_connections(t *testing.T) {
+	ctx := context.Background()
+	db, err := sql.Open("lbadd", "")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer db.Close()
+
+	// Connections of a sql.DB share the database
+	c1, err := db.Conn(ctx)
+	if !assert.NoError(t, err) {
+		return
+	}
+	c2, err := db.Conn(ctx)
+	if !assert.NoError(t, err) {
+		return
+	}
+	_, err = c1.ExecContext(ctx, "CREATE TABLE t (a integer); INSERT INTO t VALUES (1)")
+	assert.NoError(t, err)
+
+	var a int64
+	assert.NoError(t, c2.QueryRowContext(ctx, "SELECT a FROM t").Scan(&a))
+	assert.Equal(t, int64(1), a)
+	assert.NoError(t, c1.Close())
+	assert.NoError(t, c2.Close())
+
+	assert.NoError(t, db.PingContext(ctx))
+}
+
+func TestDriver_tx(t *testing.T) {
+	ctx := context.Background()
+	db, err := sql.Open("lbadd", "?lock_timeout=20ms")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer db.Close()
+
+	_, err = db.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	assert.EqualError(t, err, "read only transactions are not supported")
+	_, err = db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelLinearizable})
+	assert.EqualError(t, err, "isolation level Linearizable is not supported")
+
+	count := func() int {
+		var n int
+		rows, err := db.Query("SELECT * FROM t")
+		if !assert.NoError(t, err) {
+			return 0
+		}
+		defer rows.Close()
+		for rows.Next() {
+			n++
+		}
+		return n
+	}
+
+	_, err = db.Exec("CREATE TABLE t (a integer)")
+	assert.NoError(t, err)
+
+	tx, err := db.Begin()
+	if !assert.NoError(t, err) {
+		return
+	}
+	_, err = tx.Exec("INSERT INTO t VALUES (1), (2)")
+	assert.NoError(t, err)
+	assert.NoError(t, tx.Rollback())
+	assert.Equal(t, 0, count())
+
+	tx, err = db.Begin()
+	if !assert.NoError(t, err) {
+		return
+	}
+	_, err = tx.Exec("INSERT INTO t VALUES (?)", 1)
+	assert.NoError(t, err)
+
+	// Other connections don't wait for the transaction, nor see its changes
+	// until it commits
+	assert.Equal(t, 0, count())
+	assert.NoError(t, tx.Commit())
+	assert.Equal(t, 1, count())
+	assert.Equal(t, sql.ErrTxDone, tx.Rollback())
+
+	// A row changed by a transaction in progress can't be changed by another
+	// until it ends
+	tx, err = db.Begin()
+	if !assert.NoError(t, err) {
+		return
+	}
+	_, err = tx.Exec("UPDATE t SET a = 2")
+	assert.NoError(t, err)
+	_, err = db.Exec("DELETE FROM t")
+	assert.True(t, errors.Is(err, ErrLockTimeout))
+	assert.NoError(t, tx.Commit())
+
+	var a int
+	assert.NoError(t, db.QueryRow("SELECT a FROM t").Scan(&a))
+	assert.Equal(t, 2, a)
+
+	// A transaction is rolled back if its isolation level can't be set once
+	// it has begun
+	c, err := db.Driver().Open("")
+	if !assert.NoError(t, err) {
+		return
+	}
+	_, err = c.(*conn).BeginTx(txCanceledContext{ctx, c.(*conn)}, driver.TxOptions{Isolation: driver.IsolationLevel(sql.LevelSerializable)})
+	assert.True(t, errors.Is(err, ErrCanceled))
+	assert.Nil(t, c.(*conn).session.tx)
+	assert.NoError(t, c.Close())
+
+	// A transaction begun with a statement is rolled back if it's left in
+	// progress once the connection is released
+	conn, err := db.Conn(ctx)
+	if !assert.NoError(t, err) {
+		return
+	}
+	_, err = conn.ExecContext(ctx, "BEGIN; DELETE FROM t")
+	assert.NoError(t, err)
+	assert.NoError(t, conn.Close())
+	assert.Equal(t, 1, count())
+}
+
+// txCanceledContext is a context which is canceled once its connection is in
+// a transaction.
+type txCanceledContext struct {
+	context.Context
+	conn *conn
+}
+
+func (ctx txCanceledContext) Err() error {
+	if ctx.conn.session.tx != nil {
+		return context.Canceled
+	}
+	return nil
+}
+
+func TestDriver_file(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lbadd")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+	dsn := "file:" + filepath.Join(dir, "data.db") + "?order=4"
+
+	db, err := sql.Open("lbadd", dsn)
+	if !assert.NoError(t, err) {
+		return
+	}
+	_, err = db.Exec("CREATE TABLE t (a string); INSERT INTO t VALUES ('kept')")
+	assert.NoError(t, err)
+	assert.NoError(t, db.Close())
+
+	db, err = sql.Open("lbadd", dsn)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer db.Close()
+
+	var a string
+	assert.NoError(t, db.QueryRow("SELECT a FROM t").Scan(&a))
+	assert.Equal(t, "kept", a)
+
+	// Once the last connection is closed, the database is saved and its log
+	// closed and removed, until the next connection opens it again
+	db.SetMaxIdleConns(0)
+	_, err = db.Exec("INSERT INTO t VALUES ('again')")
+	assert.NoError(t, err)
+	_, err = os.Stat(filepath.Join(dir, "data.db-wal"))
+	assert.True(t, os.IsNotExist(err))
+
+	rows, err := db.Query("SELECT a FROM t")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer rows.Close()
+	got := []string{}
+	for rows.Next() {
+		assert.NoError(t, rows.Scan(&a))
+		got = append(got, a)
+	}
+	assert.Equal(t, []string{"kept", "again"}, got)
+}
@@ -0,0 +1,112 @@
+package lbadd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+)
+
+// The number of rows inserted by each INSERT statement of a dump
+const dumpBatchSize = 100
+
+// dump writes the database to w as a script of SQL statements, which
+// recreates it when restored. Each table is created, followed by INSERT
+// statements holding its rows in order. Tables don't depend on one another,
+// so they are written in order of their names.
+func (e *executor) dump(ctx context.Context, w io.Writer) error {
+	if _, err := fmt.Fprintln(w, "-- lbadd database dump"); err != nil {
+		return err
+	}
+
+	return e.read(func() error {
+		return e.dumpTables(ctx, w)
+	})
+}
+
+// dumpTables writes the tables visible to the transaction in progress to w,
+// as part of a dump.
+func (e *executor) dumpTables(ctx context.Context, w io.Writer) error {
+	for _, t := range e.visibleTables() {
+
+		create := query{queryType: createTableQuery, tableName: t.name, columns: t.columns}
+		if _, err := fmt.Fprintf(w, "\n%s;\n", format(create)); err != nil {
+			return err
+		}
+
+		insert := query{queryType: insertQuery, tableName: t.name}
+		write := func() error {
+			if len(insert.inserts) == 0 {
+				return nil
+			}
+
+			_, err := fmt.Fprintf(w, "%s;\n", format(insert))
+			insert.inserts = insert.inserts[:0]
+			return err
+		}
+
+		var err error
+		serr := e.scanMatching(ctx, t, nil, func(k key, r row) {
+			if err != nil {
+				return
+			}
+
+			values := make([]string, len(r))
+			for i, rec := range r {
+				var v interface{}
+				if v, err = decodeRecord(rec, t.columns[i].dataType); err != nil {
+					return
+				}
+				values[i] = formatLiteral(v)
+			}
+
+			insert.inserts = append(insert.inserts, values)
+			if len(insert.inserts) == dumpBatchSize {
+				err = write()
+			}
+		})
+		if serr != nil {
+			return serr
+		}
+		if err == nil {
+			err = write()
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// restore executes the script of SQL statements read from in, as written by
+// dump, returning the combined result of the statements. The whole script is
+// parsed before any of it is executed, and execution stops at the first
+// statement which fails, or once the context is done.
+func (e *executor) restore(ctx context.Context, in io.Reader) (result, error) {
+	data, err := ioutil.ReadAll(in)
+	if err != nil {
+		return result{}, err
+	}
+	sql := string(data)
+
+	stmts, err := parseScript(sql)
+	if err != nil {
+		return result{}, err
+	}
+
+	res := result{}
+	for _, s := range stmts {
+		r, err := e.executeQuery(ctx, s.query)
+		if err != nil {
+			line := strings.Count(sql[:s.span.start], "\n") + 1
+			return res, fmt.Errorf("statement on line %d: %w", line, err)
+		}
+
+		res.rowsAffected += r.rowsAffected
+		res.created += r.created
+	}
+
+	return res, nil
+}
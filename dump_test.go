@@ -0,0 +1,101 @@
+package lbadd
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_executor_dump(t *testing.T) {
+	e := newExecutor(exeConfig{order: 3})
+	execSQL(t, e, `CREATE TABLE users (name string NOT NULL, age integer, born datetime, score float, "to" boolean)`)
+	execSQL(t, e, `INSERT INTO users VALUES ('it''s; fine', 7, '2020-01-02', 1, TRUE), ('x', NULL, NULL, 2.5, NULL), ('y', NULL, NULL, 'NaN', NULL), ('z', NULL, NULL, '-infinity', NULL)`)
+	execSQL(t, e, "CREATE TABLE empty (a boolean)")
+
+	var out bytes.Buffer
+	assert.NoError(t, e.dump(context.Background(), &out))
+	assert.Equal(t, `-- lbadd database dump
+
+CREATE TABLE empty (
+  a boolean
+);
+
+CREATE TABLE users (
+  name string NOT NULL,
+  age integer,
+  born datetime,
+  score float,
+  "to" boolean
+);
+INSERT INTO users
+VALUES
+  ('it''s; fine', 7, '2020-01-02T00:00:00Z', 1.0, TRUE),
+  ('x', NULL, NULL, 2.5, NULL),
+  ('y', NULL, NULL, 'NaN', NULL),
+  ('z', NULL, NULL, '-Infinity', NULL);
+`, out.String())
+
+	// The dump restores into the same database
+	restored := newExecutor(exeConfig{order: 3})
+	res, err := restored.restore(context.Background(), bytes.NewReader(out.Bytes()))
+	assert.NoError(t, err)
+	assert.Equal(t, 2, res.created)
+	assert.Equal(t, 4, res.rowsAffected)
+
+	var again bytes.Buffer
+	assert.NoError(t, restored.dump(context.Background(), &again))
+	assert.Equal(t, out.String(), again.String())
+}
+
+func Test_executor_dump_batches(t *testing.T) {
+	e := newExecutor(exeConfig{order: 3})
+	execSQL(t, e, "CREATE TABLE n (n integer)")
+	for i := 0; i <= dumpBatchSize; i++ {
+		execSQL(t, e, fmt.Sprintf("INSERT INTO n VALUES (%d)", i))
+	}
+
+	var out bytes.Buffer
+	assert.NoError(t, e.dump(context.Background(), &out))
+	assert.Equal(t, 2, strings.Count(out.String(), "INSERT INTO"))
+	assert.True(t, strings.HasSuffix(out.String(), fmt.Sprintf("INSERT INTO n\nVALUES\n  (%d);\n", dumpBatchSize)))
+
+	restored := newExecutor(exeConfig{order: 3})
+	res, err := restored.restore(context.Background(), &out)
+	assert.NoError(t, err)
+	assert.Equal(t, dumpBatchSize+1, res.rowsAffected)
+}
+
+func Test_executor_restore_errors(t *testing.T) {
+	cases := []struct {
+		name   string
+		script string
+		err    string
+		tables []string
+	}{
+		{
+			name:   "parse error",
+			script: "CREATE TABLE a (a integer);\nINSERT a VALUES (1);",
+			err:    "line 2, column 8: at INSERT: unexpected a, expected INTO",
+			tables: []string{},
+		},
+		{
+			name:   "execution error",
+			script: "CREATE TABLE a (a integer);\n\n  INSERT INTO a VALUES ('x');\nCREATE TABLE b (b integer);",
+			err:    "statement on line 3: column a: invalid integer value: 'x'",
+			tables: []string{"a"},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			e := newExecutor(exeConfig{order: 3})
+			_, err := e.restore(context.Background(), strings.NewReader(tc.script))
+			assert.EqualError(t, err, tc.err)
+			assert.Equal(t, tc.tables, e.tableNames())
+		})
+	}
+}
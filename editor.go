@@ -0,0 +1,500 @@
+package lbadd
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"unicode"
+)
+
+// lineEditor reads lines of input from a terminal, with line editing, a
+// history of the lines entered which can be searched, and tab completion.
+//
+// The editor assumes every character takes up a single column, and that
+// lines fit on the terminal.
+type lineEditor struct {
+	in       *bufio.Reader
+	out      io.Writer
+	raw      func() (restore func() error, err error) // puts the terminal into raw mode, nil if it already is
+	complete completer
+
+	history     []string
+	historyFile string // the file history is kept in, empty to not keep it
+}
+
+// completer returns the candidates completing the word which ends the text,
+// and the offset of the start of that word within the text.
+type completer func(text string) (start int, candidates []string)
+
+// The number of lines of history kept
+const maxHistory = 1000
+
+// errInterrupted is returned by readLine when the line is abandoned with
+// Ctrl-C.
+var errInterrupted = errors.New("interrupted")
+
+// Keys read from the terminal
+const (
+	keyCtrlA     = 1
+	keyCtrlB     = 2
+	keyCtrlC     = 3
+	keyCtrlD     = 4
+	keyCtrlE     = 5
+	keyCtrlF     = 6
+	keyCtrlG     = 7
+	keyCtrlH     = 8
+	keyTab       = 9
+	keyLineFeed  = 10
+	keyCtrlK     = 11
+	keyCtrlL     = 12
+	keyEnter     = 13
+	keyCtrlN     = 14
+	keyCtrlP     = 16
+	keyCtrlR     = 18
+	keyCtrlU     = 21
+	keyCtrlW     = 23
+	keyEscape    = 27
+	keyBackspace = 127
+)
+
+// Keys sent as escape sequences, which are mapped to runes of the Unicode
+// private use area, so they can't be confused with typed characters
+const (
+	keyUp rune = 0xe000 + iota
+	keyDown
+	keyRight
+	keyLeft
+	keyHome
+	keyEnd
+	keyDelete
+	keyUnknown
+)
+
+// newLineEditor creates an editor reading keys from in and writing to out.
+// The history is loaded from the history file, if given.
+func newLineEditor(in io.Reader, out io.Writer, historyFile string, complete completer) *lineEditor {
+	e := &lineEditor{
+		in:          bufio.NewReader(in),
+		out:         out,
+		complete:    complete,
+		historyFile: historyFile,
+	}
+	e.loadHistory()
+
+	return e
+}
+
+// readLine reads a line, showing the prompt before it. io.EOF is returned if
+// the input ends, or Ctrl-D is pressed on an empty line, and errInterrupted
+// if Ctrl-C is pressed.
+func (e *lineEditor) readLine(prompt string) (string, error) {
+	if e.raw != nil {
+		restore, err := e.raw()
+		if err != nil {
+			return "", err
+		}
+		defer restore()
+	}
+
+	s := &editState{editor: e, prompt: prompt, historyIndex: len(e.history)}
+	s.refresh()
+
+	for {
+		key, err := e.readKey()
+		if err != nil {
+			return "", err
+		}
+
+		switch key {
+		case keyEnter, keyLineFeed:
+			return s.submit(), nil
+		case keyCtrlC:
+			fmt.Fprint(e.out, "^C\r\n")
+			return "", errInterrupted
+		case keyCtrlD:
+			if len(s.line) == 0 {
+				fmt.Fprint(e.out, "\r\n")
+				return "", io.EOF
+			}
+			s.delete(s.pos, s.pos+1)
+		case keyCtrlA, keyHome:
+			s.move(0)
+		case keyCtrlE, keyEnd:
+			s.move(len(s.line))
+		case keyCtrlB, keyLeft:
+			s.move(s.pos - 1)
+		case keyCtrlF, keyRight:
+			s.move(s.pos + 1)
+		case keyBackspace, keyCtrlH:
+			s.delete(s.pos-1, s.pos)
+		case keyDelete:
+			s.delete(s.pos, s.pos+1)
+		case keyCtrlK:
+			s.delete(s.pos, len(s.line))
+		case keyCtrlU:
+			s.delete(0, s.pos)
+		case keyCtrlW:
+			s.delete(s.wordStart(), s.pos)
+		case keyCtrlL:
+			fmt.Fprint(e.out, "\x1b[H\x1b[2J")
+			s.refresh()
+		case keyCtrlP, keyUp:
+			s.recall(s.historyIndex - 1)
+		case keyCtrlN, keyDown:
+			s.recall(s.historyIndex + 1)
+		case keyCtrlR:
+			submit, err := s.search()
+			if err != nil {
+				return "", err
+			}
+			if submit {
+				return s.submit(), nil
+			}
+		case keyTab:
+			s.completeWord()
+		default:
+			if unicode.IsPrint(key) {
+				s.insert(key)
+			}
+		}
+	}
+}
+
+// readKey reads the next key, decoding escape sequences.
+func (e *lineEditor) readKey() (rune, error) {
+	key, _, err := e.in.ReadRune()
+	if err != nil || key != keyEscape {
+		return key, err
+	}
+
+	// Escape sequences are ESC [ or ESC O, followed by any parameters
+	// and a final character
+	intro, _, err := e.in.ReadRune()
+	if err != nil {
+		return 0, err
+	}
+	if intro != '[' && intro != 'O' {
+		return keyUnknown, nil
+	}
+
+	params := ""
+	for {
+		c, _, err := e.in.ReadRune()
+		if err != nil {
+			return 0, err
+		}
+		if c >= 0x40 && c <= 0x7e {
+			return escapeKey(params, c), nil
+		}
+		params += string(c)
+	}
+}
+
+// escapeKey returns the key sent as the escape sequence with the given
+// parameters and final character.
+func escapeKey(params string, final rune) rune {
+	switch final {
+	case 'A':
+		return keyUp
+	case 'B':
+		return keyDown
+	case 'C':
+		return keyRight
+	case 'D':
+		return keyLeft
+	case 'H':
+		return keyHome
+	case 'F':
+		return keyEnd
+	case '~':
+		switch params {
+		case "1", "7":
+			return keyHome
+		case "4", "8":
+			return keyEnd
+		case "3":
+			return keyDelete
+		}
+	}
+
+	return keyUnknown
+}
+
+// addHistory adds the line to the history, unless it is blank or the same as
+// the line before it. The line is appended to the history file, if there is
+// one. History is kept on a best effort basis, so failing to write it
+// doesn't fail the line.
+func (e *lineEditor) addHistory(line string) {
+	if strings.TrimSpace(line) == "" {
+		return
+	}
+	if len(e.history) > 0 && e.history[len(e.history)-1] == line {
+		return
+	}
+
+	e.history = append(e.history, line)
+	if len(e.history) > maxHistory {
+		e.history = e.history[len(e.history)-maxHistory:]
+	}
+
+	if e.historyFile == "" {
+		return
+	}
+	f, err := os.OpenFile(e.historyFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	_, _ = f.WriteString(line + "\n")
+}
+
+// loadHistory loads the most recent lines of the history file.
+func (e *lineEditor) loadHistory() {
+	if e.historyFile == "" {
+		return
+	}
+
+	data, err := ioutil.ReadFile(e.historyFile)
+	if err != nil {
+		return
+	}
+
+	for _, line := range strings.Split(string(data), "\n") {
+		if line != "" {
+			e.history = append(e.history, line)
+		}
+	}
+	if len(e.history) > maxHistory {
+		e.history = e.history[len(e.history)-maxHistory:]
+	}
+}
+
+// editState is the state of the line being edited.
+type editState struct {
+	editor *lineEditor
+	prompt string
+	line   []rune
+	pos    int // the position of the cursor within the line
+
+	historyIndex int    // the line of history shown, len(history) for a new line
+	saved        []rune // the new line, while history is shown
+}
+
+// refresh redraws the prompt and line, and places the cursor.
+func (s *editState) refresh() {
+	fmt.Fprintf(s.editor.out, "\r%s%s\x1b[K", s.prompt, string(s.line))
+	if back := len(s.line) - s.pos; back > 0 {
+		fmt.Fprintf(s.editor.out, "\x1b[%dD", back)
+	}
+}
+
+// submit ends editing of the line, adding it to the history.
+func (s *editState) submit() string {
+	s.pos = len(s.line)
+	s.refresh()
+	fmt.Fprint(s.editor.out, "\r\n")
+
+	line := string(s.line)
+	s.editor.addHistory(line)
+
+	return line
+}
+
+func (s *editState) insert(r ...rune) {
+	s.line = append(s.line[:s.pos], append(r, s.line[s.pos:]...)...)
+	s.pos += len(r)
+	s.refresh()
+}
+
+// delete deletes the characters from start up to end, as far as they are
+// within the line.
+func (s *editState) delete(start, end int) {
+	if start < 0 {
+		start = 0
+	}
+	if end > len(s.line) {
+		end = len(s.line)
+	}
+	if start >= end {
+		return
+	}
+
+	s.line = append(s.line[:start], s.line[end:]...)
+	s.pos = start
+	s.refresh()
+}
+
+func (s *editState) move(pos int) {
+	if pos < 0 || pos > len(s.line) {
+		return
+	}
+
+	s.pos = pos
+	s.refresh()
+}
+
+// wordStart returns the start of the word before the cursor, including any
+// spaces between it and the cursor.
+func (s *editState) wordStart() int {
+	i := s.pos
+	for i > 0 && unicode.IsSpace(s.line[i-1]) {
+		i--
+	}
+	for i > 0 && !unicode.IsSpace(s.line[i-1]) {
+		i--
+	}
+
+	return i
+}
+
+// recall shows the line of history at index i, where len(history) is the new
+// line being entered.
+func (s *editState) recall(i int) {
+	history := s.editor.history
+	if i < 0 || i > len(history) || i == s.historyIndex {
+		return
+	}
+
+	if s.historyIndex == len(history) {
+		s.saved = s.line
+	}
+	s.historyIndex = i
+
+	if i == len(history) {
+		s.line = s.saved
+	} else {
+		s.line = []rune(history[i])
+	}
+	s.pos = len(s.line)
+	s.refresh()
+}
+
+// search searches the history backwards for lines containing the text typed,
+// showing the most recent match. Ctrl-R moves on to the next older match,
+// Ctrl-G cancels the search, and Enter submits the match. Any other key ends
+// the search with the match to be edited.
+func (s *editState) search() (submit bool, err error) {
+	history := s.editor.history
+	query := []rune{}
+	match := len(history)
+	failed := false
+
+	// find finds the most recent match at or before index from
+	find := func(from int) {
+		if from >= len(history) {
+			from = len(history) - 1
+		}
+		for i := from; i >= 0; i-- {
+			if strings.Contains(history[i], string(query)) {
+				match, failed = i, false
+				return
+			}
+		}
+		failed = true
+	}
+	show := func() {
+		text, prefix := "", ""
+		if match < len(history) {
+			text = history[match]
+		}
+		if failed {
+			prefix = "failing "
+		}
+		fmt.Fprintf(s.editor.out, "\r(%sreverse-i-search)`%s': %s\x1b[K", prefix, string(query), text)
+	}
+
+	show()
+	for {
+		key, err := s.editor.readKey()
+		if err != nil {
+			return false, err
+		}
+
+		switch key {
+		case keyCtrlR:
+			find(match - 1)
+		case keyBackspace, keyCtrlH:
+			if len(query) > 0 {
+				query = query[:len(query)-1]
+				match = len(history)
+				find(len(history) - 1)
+			}
+		case keyCtrlG, keyCtrlC:
+			s.refresh()
+			return false, nil
+		default:
+			if unicode.IsPrint(key) {
+				query = append(query, key)
+				find(match)
+				break
+			}
+
+			if match < len(history) {
+				s.line = []rune(history[match])
+				s.pos = len(s.line)
+				s.historyIndex = match
+			}
+			if key == keyEnter || key == keyLineFeed {
+				return true, nil
+			}
+			s.refresh()
+			return false, nil
+		}
+
+		show()
+	}
+}
+
+// completeWord completes the word before the cursor. A single candidate
+// replaces the word, otherwise the word is extended to the longest prefix
+// shared by all candidates, or if that doesn't extend it, the candidates are
+// listed.
+func (s *editState) completeWord() {
+	if s.editor.complete == nil {
+		return
+	}
+
+	text := string(s.line[:s.pos])
+	start, candidates := s.editor.complete(text)
+	if len(candidates) == 0 {
+		fmt.Fprint(s.editor.out, "\a")
+		return
+	}
+	start = len([]rune(text[:start]))
+
+	replacement := []rune(candidates[0])
+	if len(candidates) == 1 {
+		replacement = append(replacement, ' ')
+	} else {
+		replacement = commonPrefix(candidates)
+	}
+
+	if len(candidates) > 1 && len(replacement) <= s.pos-start {
+		fmt.Fprintf(s.editor.out, "\r\n%s\r\n", strings.Join(candidates, "  "))
+		s.refresh()
+		return
+	}
+
+	s.line = append(append(append([]rune{}, s.line[:start]...), replacement...), s.line[s.pos:]...)
+	s.pos = start + len(replacement)
+	s.refresh()
+}
+
+// commonPrefix returns the longest prefix shared by all of the strings.
+func commonPrefix(strs []string) []rune {
+	prefix := []rune(strs[0])
+	for _, str := range strs[1:] {
+		r := []rune(str)
+		n := 0
+		for n < len(prefix) && n < len(r) && prefix[n] == r[n] {
+			n++
+		}
+		prefix = prefix[:n]
+	}
+
+	return prefix
+}
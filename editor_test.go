@@ -0,0 +1,186 @@
+package lbadd
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_lineEditor_readLine(t *testing.T) {
+	complete := func(text string) (int, []string) {
+		start := strings.LastIndex(text, " ") + 1
+		return start, matching(text[start:], []string{"users", "user_roles", "orders"}, false)
+	}
+
+	cases := []struct {
+		name     string
+		history  []string
+		input    string
+		expected string
+		err      error
+	}{
+		{
+			name:     "typing",
+			input:    "SELECT *\r",
+			expected: "SELECT *",
+		},
+		{
+			name:     "line feed",
+			input:    "abc\n",
+			expected: "abc",
+		},
+		{
+			name:     "moving and inserting",
+			input:    "ac\x1b[Db\x1b[C\x1b[Cd\x01>\x05<\r",
+			expected: ">abcd<",
+		},
+		{
+			name:     "backspace and delete",
+			input:    "abcd\x7f\x1b[D\x1b[D\x1b[3~\r",
+			expected: "ac",
+		},
+		{
+			name:     "killing",
+			input:    "one two  three\x17\x02\x0b\x01\x06\x0b\r",
+			expected: "o",
+		},
+		{
+			name:     "killing to the start",
+			input:    "one two\x1b[D\x1b[D\x15\r",
+			expected: "wo",
+		},
+		{
+			name:     "home and end sequences",
+			input:    "b\x1b[Ha\x1bOFc\x1b[1~<\x1b[4~>\r",
+			expected: "<abc>",
+		},
+		{
+			name:     "recalling history",
+			history:  []string{"first", "second"},
+			input:    "new\x1b[A\x1b[A\x1b[A\x1b[B!\r",
+			expected: "second!",
+		},
+		{
+			name:     "returning to the new line",
+			history:  []string{"first"},
+			input:    "new\x10\x0e\r",
+			expected: "new",
+		},
+		{
+			name:     "searching history",
+			history:  []string{"SELECT a FROM t", "INSERT INTO t", "SELECT b FROM t"},
+			input:    "\x12SEL\x12\r",
+			expected: "SELECT a FROM t",
+		},
+		{
+			name:     "editing a match",
+			history:  []string{"SELECT a FROM t", "INSERT INTO t"},
+			input:    "\x12INS\x05;\r",
+			expected: "INSERT INTO t;",
+		},
+		{
+			name:     "cancelling a search",
+			history:  []string{"SELECT a FROM t"},
+			input:    "x\x12SEL\x07\r",
+			expected: "x",
+		},
+		{
+			name:     "completing a single candidate",
+			input:    "FROM o\t\r",
+			expected: "FROM orders ",
+		},
+		{
+			name:     "completing a common prefix",
+			input:    "FROM u\t\t\r",
+			expected: "FROM user",
+		},
+		{
+			name:     "completing without candidates",
+			input:    "FROM x\t\r",
+			expected: "FROM x",
+		},
+		{
+			name:  "interrupting",
+			input: "abc\x03",
+			err:   errInterrupted,
+		},
+		{
+			name:  "ending the input",
+			input: "\x04",
+			err:   io.EOF,
+		},
+		{
+			name:     "deleting rather than ending",
+			input:    "ab\x02\x04\r",
+			expected: "a",
+		},
+		{
+			name:  "input ending mid line",
+			input: "abc",
+			err:   io.EOF,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			e := newLineEditor(strings.NewReader(tc.input), ioutil.Discard, "", complete)
+			e.history = tc.history
+
+			line, err := e.readLine("> ")
+			assert.Equal(t, tc.err, err)
+			assert.Equal(t, tc.expected, line)
+		})
+	}
+}
+
+func Test_lineEditor_output(t *testing.T) {
+	var out bytes.Buffer
+	e := newLineEditor(strings.NewReader("ab\x1b[D\r"), &out, "", nil)
+
+	line, err := e.readLine("> ")
+	assert.NoError(t, err)
+	assert.Equal(t, "ab", line)
+	assert.Equal(t, ""+
+		"\r> \x1b[K"+
+		"\r> a\x1b[K"+
+		"\r> ab\x1b[K"+
+		"\r> ab\x1b[K\x1b[1D"+
+		"\r> ab\x1b[K\r\n", out.String())
+}
+
+func Test_lineEditor_history(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lbadd")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "history")
+
+	e := newLineEditor(strings.NewReader("one\r\r  \rtwo\rtwo\r"), ioutil.Discard, file, nil)
+	for i := 0; i < 5; i++ {
+		_, err := e.readLine("> ")
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, []string{"one", "two"}, e.history)
+
+	data, err := ioutil.ReadFile(file)
+	assert.NoError(t, err)
+	assert.Equal(t, "one\ntwo\n", string(data))
+
+	// History is loaded by the next editor
+	e = newLineEditor(strings.NewReader("\x1b[A\x1b[A\r"), ioutil.Discard, file, nil)
+	line, err := e.readLine("> ")
+	assert.NoError(t, err)
+	assert.Equal(t, "one", line)
+}
+
+func Test_commonPrefix(t *testing.T) {
+	assert.Equal(t, "user", string(commonPrefix([]string{"users", "user_roles", "user"})))
+	assert.Equal(t, "", string(commonPrefix([]string{"users", "orders"})))
+}
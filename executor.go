@@ -1,8 +1,10 @@
 package lbadd
 
 import (
+	"context"
 	"fmt"
 	"regexp"
+	"time"
 )
 
 // Contains a command and associated information required to execute such command
@@ -10,6 +12,7 @@ type instruction struct {
 	command command
 	table   string
 	params  []string
+	args    []interface{} // the values bound to the $n placeholders of the params, by position
 }
 
 // A single column on a single row
@@ -20,20 +23,28 @@ type row []record
 
 // A response from the executor
 type result struct {
-	columns      []column // columns in order of stored rows
-	rows         []row    // the set of rows
-	rowsAffected int      // the number of rows affected by execution
-	created      int      // the number of resources created
+	columns      []column    // columns in order of stored rows
+	rows         []row       // the set of rows
+	rowsAffected int         // the number of rows affected by execution
+	created      int         // the number of resources created
+	rejected     []rejection // the rows of input which couldn't be copied
+	status       string      // the outcome of a transaction command, e.g. COMMIT
 }
 
 type exeConfig struct {
-	order int
+	order       int
+	lockTimeout time.Duration // how long a statement waits for a lock, forever if zero
+	fileCopy    bool          // whether copy instructions may read and write files
 }
 
-// Execute executes an instruction against the database
+// Execute executes an instruction against the database. An executor is a
+// session of the database, which has its own transactions, and is used by one
+// goroutine at a time. Sessions of the same database may be used
+// concurrently.
 type executor struct {
 	db  *db
 	cfg exeConfig
+	tx  *transaction // the transaction in progress, nil if there isn't one
 }
 
 func newExecutor(cfg exeConfig) *executor {
@@ -43,56 +54,471 @@ func newExecutor(cfg exeConfig) *executor {
 	}
 }
 
+// newSession returns another session of the executor's database.
+func (e *executor) newSession() *executor {
+	return &executor{db: e.db, cfg: e.cfg}
+}
+
 // The executor takes an instruction, and coordinates the operations which are
 // required to fulfill the instruction, executing these against the DB. It
-// also returns the result of the instruction.
-func (e *executor) execute(instr instruction) (result, error) {
+// also returns the result of the instruction. Once the context is done, the
+// instruction stops early with an error matching ErrCanceled.
+//
+// Instructions which aren't transaction commands are executed within the
+// transaction in progress, or outside of one, in a transaction of their own.
+func (e *executor) execute(ctx context.Context, instr instruction) (result, error) {
+	if err := checkContext(ctx); err != nil {
+		return result{}, err
+	}
+
+	switch instr.command {
+	case commandBegin:
+		return e.executeBegin()
+	case commandCommit:
+		return e.executeCommit()
+	case commandRollback:
+		return e.executeRollback(instr)
+	case commandSavepoint:
+		return e.executeSavepoint(instr)
+	case commandRelease:
+		return e.executeRelease(instr)
+	case commandIsolation:
+		return e.executeIsolation(instr)
+	}
+
+	return e.autocommit(func() (result, error) {
+		return e.executeChange(ctx, instr)
+	})
+}
+
+// executeChange executes an instruction which reads or changes the tables.
+func (e *executor) executeChange(ctx context.Context, instr instruction) (result, error) {
 	switch instr.command {
 	case commandInsert:
-		return result{}, fmt.Errorf("unimplemented")
+		return e.executeInsert(ctx, instr)
 	case commandSelect:
-		return e.executeSelect(instr)
+		return e.executeSelect(ctx, instr)
 	case commandDelete:
-		return result{}, fmt.Errorf("unimplemented")
+		return e.executeDelete(ctx, instr)
+	case commandUpdate:
+		return e.executeUpdate(ctx, instr)
 	case commandCreateTable:
-		return e.executeCreateTable(instr)
+		return e.executeCreateTable(ctx, instr)
+	case commandDropTable:
+		return e.executeDropTable(ctx, instr)
+	case commandCopy:
+		return e.executeCopy(ctx, instr)
 
 	default:
 		return result{}, fmt.Errorf("invalid executor command")
 	}
 }
 
+// executeQuery generates the instructions for a parsed query, and executes
+// them in order. The results of the instructions are combined into one. The
+// instructions of a query take effect together, or not at all if any fails.
+func (e *executor) executeQuery(ctx context.Context, q query) (result, error) {
+	instrs, err := codegen(q)
+	if err != nil {
+		return result{}, err
+	}
+	if len(instrs) == 1 {
+		return e.execute(ctx, instrs[0])
+	}
+
+	return e.autocommit(func() (result, error) {
+		res := result{}
+		for _, instr := range instrs {
+			r, err := e.execute(ctx, instr)
+			if err != nil {
+				return result{}, err
+			}
+
+			res.columns = r.columns
+			res.rows = r.rows
+			res.rowsAffected += r.rowsAffected
+			res.created += r.created
+			res.rejected = append(res.rejected, r.rejected...)
+		}
+		return res, nil
+	})
+}
+
 // Executes the select query instruction, returning the structure of the table
 // (columns) and the rows specified in the query.
-func (e *executor) executeSelect(instr instruction) (result, error) {
-	_, exists := e.db.tables[instr.table]
-	if !exists {
-		return result{}, fmt.Errorf("table %s does not exist", instr.table)
+func (e *executor) executeSelect(ctx context.Context, instr instruction) (result, error) {
+	t, err := e.table(instr.table)
+	if err != nil {
+		return result{}, err
+	}
+
+	// The rows of a locking read are locked before they are read, so that
+	// what's returned is the latest version of each
+	sel, err := parseSelectParams(instr.params)
+	if err != nil {
+		return result{}, err
+	}
+	if sel.lock != 0 {
+		preds, err := parsePredicates(t.keyedColumns(), sel.conds, instr.args)
+		if err != nil {
+			return result{}, err
+		}
+		if err := e.lockMatching(ctx, t, preds, sel.lock); err != nil {
+			return result{}, err
+		}
+	}
+
+	plan, err := e.planSelect(t, instr.params, instr.args)
+	if err != nil {
+		return result{}, err
+	}
+
+	rows, err := collect(ctx, plan)
+	if err != nil {
+		return result{}, err
+	}
+
+	return result{columns: plan.columns(), rows: rows}, nil
+}
+
+// planSelect returns the plan of a select instruction's params, and the
+// arguments bound to their placeholders. It scans the
+// table, filters the rows by the conditions, groups them and computes the
+// aggregates if there are any, sorts them, limits them and finally projects
+// the fields. The scan only reads the range of keys the conditions on the
+// rowid column allow, which is an index scan if they narrow it.
+func (e *executor) planSelect(t table, params []string, args []interface{}) (operator, error) {
+	sel, err := parseSelectParams(params)
+	if err != nil {
+		return nil, err
+	}
+	cols := t.keyedColumns()
+	preds, err := parsePredicates(cols, sel.conds, args)
+	if err != nil {
+		return nil, err
+	}
+
+	fields := []string{}
+	for _, f := range sel.fields {
+		if f == "*" {
+			fields = append(fields, namesOf(t.columns)...)
+		} else {
+			fields = append(fields, f)
+		}
+	}
+	if len(sel.fields) == 0 {
+		fields = namesOf(t.columns)
+	}
+
+	groupBy := make([]int, 0, len(sel.groupBy))
+	for _, g := range sel.groupBy {
+		i := columnIndex(cols, g)
+		if i == -1 {
+			return nil, fmt.Errorf("column %s does not exist in table %s", g, t.name)
+		}
+		groupBy = append(groupBy, i)
+	}
+
+	grouped := len(groupBy) > 0
+	for _, f := range fields {
+		grouped = grouped || isAggregate(f)
+	}
+	for _, k := range sel.orderBy {
+		grouped = grouped || isAggregate(k.field)
+	}
+
+	// Fields are resolved to the columns of the table, or once the rows are
+	// grouped, to the group by columns and the aggregates which follow them
+	aggs := []aggregate{}
+	resolve := func(field string) (int, error) {
+		fn, arg, isAgg := parseAggregate(field)
+		if !isAgg {
+			arg = field
+		}
+
+		i := -1
+		if !isAgg || arg != "*" {
+			if i = columnIndex(cols, arg); i == -1 {
+				return 0, fmt.Errorf("column %s does not exist in table %s", arg, t.name)
+			}
+		}
+		if !grouped {
+			return i, nil
+		}
+
+		if !isAgg {
+			for n, g := range groupBy {
+				if g == i {
+					return n, nil
+				}
+			}
+			return 0, fmt.Errorf("column %s must be grouped by or aggregated", field)
+		}
+		agg := aggregate{fn: fn, column: i}
+		for n, a := range aggs {
+			if a == agg {
+				return len(groupBy) + n, nil
+			}
+		}
+		aggs = append(aggs, agg)
+		return len(groupBy) + len(aggs) - 1, nil
+	}
+
+	projected := make([]int, 0, len(fields))
+	for _, f := range fields {
+		i, err := resolve(f)
+		if err != nil {
+			return nil, err
+		}
+		projected = append(projected, i)
+	}
+	keys := make([]sortKey, 0, len(sel.orderBy))
+	for _, k := range sel.orderBy {
+		i, err := resolve(k.field)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, sortKey{column: i, desc: k.desc})
+	}
+
+	low, high := keyRange(preds, len(t.columns))
+	scan := e.newIndexScanOp(t, low, high)
+	scan.keyed = true
+
+	var plan operator = scan
+	if len(preds) > 0 {
+		plan = newFilterOp(plan, preds)
+	}
+	if grouped {
+		plan = newAggregateOp(plan, groupBy, aggs)
+	}
+	if len(keys) > 0 {
+		plan = newSortOp(plan, keys)
+	}
+	if sel.limit >= 0 || sel.offset > 0 {
+		plan = newLimitOp(plan, sel.limit, sel.offset)
+	}
+	return newProjectOp(plan, projected), nil
+}
+
+// Executes the insert instruction, inserting a single row into the table. The
+// values are either given in the order of the table's columns, or as
+// assignments to the columns by name, in which case any columns not assigned
+// to are NULL.
+func (e *executor) executeInsert(ctx context.Context, instr instruction) (result, error) {
+	t, err := e.table(instr.table)
+	if err != nil {
+		return result{}, err
 	}
 
-	// TODO check if columns all exist in table
-	// TODO btree get all method
+	values := make([]interface{}, len(t.columns))
 
-	return result{}, fmt.Errorf("unimplemented")
+	if len(instr.params) > 0 && isAssignment(instr.params[0]) {
+		assigns, err := parseAssignments(t, instr.params, instr.args)
+		if err != nil {
+			return result{}, err
+		}
+		for _, a := range assigns {
+			values[a.column] = a.value
+		}
+	} else {
+		if len(instr.params) != len(t.columns) {
+			return result{}, fmt.Errorf("table %s has %d columns but %d values were given", t.name, len(t.columns), len(instr.params))
+		}
+		for i, p := range instr.params {
+			if values[i], err = parseValue(p, instr.args); err != nil {
+				return result{}, err
+			}
+		}
+	}
+
+	r, err := encodeRow(t, values)
+	if err != nil {
+		return result{}, err
+	}
+
+	if err := e.insertRow(ctx, t, r); err != nil {
+		return result{}, err
+	}
+
+	return result{rowsAffected: 1}, nil
+}
+
+// Executes the delete instruction, removing every row of the table which
+// matches all of the conditions given as params.
+func (e *executor) executeDelete(ctx context.Context, instr instruction) (result, error) {
+	t, err := e.table(instr.table)
+	if err != nil {
+		return result{}, err
+	}
+
+	preds, err := parsePredicates(t.keyedColumns(), instr.params, instr.args)
+	if err != nil {
+		return result{}, err
+	}
+
+	keys := []key{}
+	err = e.scanMatching(ctx, t, preds, func(k key, r row) {
+		keys = append(keys, k)
+	})
+	if err != nil {
+		return result{}, err
+	}
+
+	for _, k := range keys {
+		if err := e.removeRow(ctx, t, k); err != nil {
+			return result{}, err
+		}
+	}
+
+	return result{rowsAffected: len(keys)}, nil
+}
+
+// Executes the update instruction, assigning new values to the columns of
+// every row which matches the conditions. The params are the assignments,
+// optionally followed by the where keyword and the conditions.
+func (e *executor) executeUpdate(ctx context.Context, instr instruction) (result, error) {
+	t, err := e.table(instr.table)
+	if err != nil {
+		return result{}, err
+	}
+
+	params, conds := instr.params, []string{}
+	for i, p := range params {
+		if toUp(p) == "WHERE" {
+			params, conds = params[:i], params[i+1:]
+			break
+		}
+	}
+
+	assigns, err := parseAssignments(t, params, instr.args)
+	if err != nil {
+		return result{}, err
+	}
+	if len(assigns) == 0 {
+		return result{}, fmt.Errorf("no columns to update")
+	}
+
+	recs := make([]record, len(assigns))
+	for i, a := range assigns {
+		if recs[i], err = encodeValue(a.value, t.columns[a.column].dataType); err != nil {
+			return result{}, err
+		}
+	}
+
+	preds, err := parsePredicates(t.keyedColumns(), conds, instr.args)
+	if err != nil {
+		return result{}, err
+	}
+
+	updated := map[key]row{}
+	err = e.scanMatching(ctx, t, preds, func(k key, r row) {
+		updated[k] = r
+	})
+	if err != nil {
+		return result{}, err
+	}
+
+	for k, old := range updated {
+		r := append(row{}, old...)
+		for i, a := range assigns {
+			r[a.column] = recs[i]
+		}
+		if err := e.putRow(ctx, t, k, r); err != nil {
+			return result{}, err
+		}
+	}
+
+	return result{rowsAffected: len(updated)}, nil
 }
 
 // Executes the create table instruction, parses the columns given as arguments
 // and adds a new table record to the storage map.
-func (e *executor) executeCreateTable(instr instruction) (result, error) {
+func (e *executor) executeCreateTable(ctx context.Context, instr instruction) (result, error) {
 	cols, err := parseInsertColumns(instr.params)
 	if err != nil {
 		return result{}, fmt.Errorf("failed to parse column params: %v", err)
 	}
 
-	e.db.tables[instr.table] = table{
-		name:    instr.table,
-		store:   newBtreeOrder(e.cfg.order),
-		columns: cols,
+	if err := e.createTable(ctx, newTable(instr.table, cols, e.cfg.order)); err != nil {
+		return result{}, err
 	}
 
 	return result{created: 1}, nil
 }
 
+// Executes the drop table instruction, removing the table and all of its rows.
+func (e *executor) executeDropTable(ctx context.Context, instr instruction) (result, error) {
+	t, err := e.table(instr.table)
+	if err != nil {
+		return result{}, err
+	}
+	if len(instr.params) > 0 {
+		return result{}, fmt.Errorf("drop table takes no params")
+	}
+
+	if err := e.dropTable(ctx, t); err != nil {
+		return result{}, err
+	}
+
+	return result{}, nil
+}
+
+// scanMatching calls fn with the key and row of every row in the table visible
+// to the transaction in progress which satisfies all of the predicates, in
+// order of their keys. The predicates are checked against the table's keyed
+// columns, and only the range of keys they allow is scanned. The scan stops
+// with an error if the context is done before it finishes. The storage holding
+// the row is latched for reading while fn is called, so fn must not change the
+// table.
+func (e *executor) scanMatching(ctx context.Context, t table, preds []expr, fn func(k key, r row)) error {
+	if err := e.readTable(t); err != nil {
+		return err
+	}
+
+	low, high := keyRange(preds, len(t.columns))
+	var err error
+	serr := t.store.scanBetween(ctx, low, high, func(en *entry) bool {
+		r, verr := e.visibleEntry(t, en)
+		if verr != nil {
+			err = verr
+			return false
+		}
+		if r == nil {
+			return true
+		}
+
+		if len(preds) > 0 {
+			matches, merr := matchAll(preds, append(r[:len(r):len(r)], keyRecord(en.key)))
+			if merr != nil {
+				err = merr
+				return false
+			}
+			if !matches {
+				return true
+			}
+		}
+		fn(en.key, r)
+		return true
+	})
+	if err != nil {
+		return err
+	}
+
+	return serr
+}
+
+// visibleEntry returns the row of the table's entry visible to the
+// transaction in progress, or nil if none is.
+func (e *executor) visibleEntry(t table, en *entry) (row, error) {
+	v, ok := en.value.(*version)
+	if !ok {
+		return nil, fmt.Errorf("invalid row with key %d in table %s", en.key, t.name)
+	}
+
+	return e.tx.visibleRow(v), nil
+}
+
 func parseInsertColumns(params []string) ([]column, error) {
 	// If there are no tables to be created, return early
 	if len(params) == 0 {
@@ -1,6 +1,8 @@
 package lbadd
 
 import (
+	"context"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -30,7 +32,7 @@ func Test_executor_execute(t *testing.T) {
 				db: tt.fields.db,
 			}
 
-			got, err := e.execute(tt.args.instr)
+			got, err := e.execute(context.Background(), tt.args.instr)
 			if err != nil {
 				assert.Error(t, err)
 			}
@@ -55,26 +57,22 @@ func Test_executor_executeCreateTable(t *testing.T) {
 		fields     fields
 		args       args
 		want       result
-		wantTables map[string]table
+		wantTables map[string][]column
 		wantErr    bool
 	}{
 		{
 			name:    "creates a new empty table",
-			fields:  fields{db: &db{tables: map[string]table{}}, cfg: exeConfig{order: order}},
+			fields:  fields{db: newDB(), cfg: exeConfig{order: order}},
 			args:    args{instr: instruction{command: commandCreateTable, table: "users"}},
 			want:    result{created: 1},
 			wantErr: false,
-			wantTables: map[string]table{
-				"users": {
-					name:    "users",
-					store:   newBtreeOrder(order),
-					columns: []column{},
-				},
+			wantTables: map[string][]column{
+				"users": []column{},
 			},
 		},
 		{
 			name:   "creates a new table with single column",
-			fields: fields{db: &db{tables: map[string]table{}}, cfg: exeConfig{order: order}},
+			fields: fields{db: newDB(), cfg: exeConfig{order: order}},
 			args: args{instr: instruction{
 				command: commandCreateTable,
 				table:   "users",
@@ -82,23 +80,19 @@ func Test_executor_executeCreateTable(t *testing.T) {
 			}},
 			want:    result{created: 1},
 			wantErr: false,
-			wantTables: map[string]table{
-				"users": {
-					name:  "users",
-					store: newBtreeOrder(order),
-					columns: []column{
-						{
-							dataType:   columnTypeString,
-							name:       "name",
-							isNullable: false,
-						},
+			wantTables: map[string][]column{
+				"users": []column{
+					{
+						dataType:   columnTypeString,
+						name:       "name",
+						isNullable: false,
 					},
 				},
 			},
 		},
 		{
 			name:   "creates a new table with multiple columns",
-			fields: fields{db: &db{tables: map[string]table{}}, cfg: exeConfig{order: order}},
+			fields: fields{db: newDB(), cfg: exeConfig{order: order}},
 			args: args{instr: instruction{
 				command: commandCreateTable,
 				table:   "users",
@@ -106,28 +100,24 @@ func Test_executor_executeCreateTable(t *testing.T) {
 			}},
 			want:    result{created: 1},
 			wantErr: false,
-			wantTables: map[string]table{
-				"users": {
-					name:  "users",
-					store: newBtreeOrder(order),
-					columns: []column{
-						{
-							dataType:   columnTypeString,
-							name:       "name",
-							isNullable: false,
-						},
-						{
-							dataType:   columnTypeInt,
-							name:       "age",
-							isNullable: true,
-						},
+			wantTables: map[string][]column{
+				"users": []column{
+					{
+						dataType:   columnTypeString,
+						name:       "name",
+						isNullable: false,
+					},
+					{
+						dataType:   columnTypeInt,
+						name:       "age",
+						isNullable: true,
 					},
 				},
 			},
 		},
 		{
 			name:   "fails to create if datatype is unknown",
-			fields: fields{db: &db{tables: map[string]table{}}, cfg: exeConfig{order: order}},
+			fields: fields{db: newDB(), cfg: exeConfig{order: order}},
 			args: args{instr: instruction{
 				command: commandCreateTable,
 				table:   "users",
@@ -135,7 +125,7 @@ func Test_executor_executeCreateTable(t *testing.T) {
 			}},
 			want:       result{created: 0},
 			wantErr:    true,
-			wantTables: map[string]table{},
+			wantTables: map[string][]column{},
 		},
 	}
 
@@ -146,14 +136,23 @@ func Test_executor_executeCreateTable(t *testing.T) {
 				cfg: tt.fields.cfg,
 			}
 
-			got, err := e.executeCreateTable(tt.args.instr)
+			got, err := e.autocommit(func() (result, error) {
+				return e.executeCreateTable(context.Background(), tt.args.instr)
+			})
 			if tt.wantErr {
 				assert.Error(t, err)
 			} else {
 				assert.NoError(t, err)
 			}
 			assert.Equal(t, tt.want, got)
-			assert.Equal(t, tt.wantTables, tt.fields.db.tables)
+
+			tables := map[string][]column{}
+			for _, name := range e.tableNames() {
+				tbl, err := e.lookupTable(name)
+				assert.NoError(t, err)
+				tables[name] = tbl.columns
+			}
+			assert.Equal(t, tt.wantTables, tables)
 		})
 	}
 }
@@ -197,7 +196,7 @@ func Test_executor_executeSelect(t *testing.T) {
 			name: "error when table does not exist",
 			fields: fields{
 				db: &db{
-					tables: map[string]table{mockTable.name: mockTable},
+					tables: map[string]*tableVersion{mockTable.name: {table: mockTable}},
 				},
 				cfg: exeConfig{order: order},
 			},
@@ -220,7 +219,7 @@ func Test_executor_executeSelect(t *testing.T) {
 				cfg: tt.fields.cfg,
 			}
 
-			got, err := e.executeSelect(tt.args.instr)
+			got, err := e.executeSelect(context.Background(), tt.args.instr)
 			if tt.wantErr {
 				assert.Error(t, err)
 			} else {
@@ -231,3 +230,439 @@ func Test_executor_executeSelect(t *testing.T) {
 		})
 	}
 }
+
+func Test_executor_dataManipulation(t *testing.T) {
+	e := newExecutor(exeConfig{order: 3})
+
+	str := func(s string) record { r, _ := encodeValue(s, columnTypeString); return r }
+	num := func(i int64) record { r, _ := encodeValue(i, columnTypeInt); return r }
+
+	nameCol := column{dataType: columnTypeString, name: "name", isNullable: false}
+	ageCol := column{dataType: columnTypeInt, name: "age", isNullable: true}
+
+	steps := []struct {
+		name    string
+		instr   instruction
+		want    result
+		wantErr bool
+	}{
+		{
+			name:  "create table",
+			instr: instruction{commandCreateTable, "users", []string{"name", "string", "false", "age", "integer", "true"}, nil},
+			want:  result{created: 1},
+		},
+		{
+			name:    "create existing table",
+			instr:   instruction{commandCreateTable, "users", []string{"name", "string", "false"}, nil},
+			wantErr: true,
+		},
+		{
+			name:  "insert positional values",
+			instr: instruction{commandInsert, "users", []string{"'John Smith'", "42"}, nil},
+			want:  result{rowsAffected: 1},
+		},
+		{
+			name:  "insert assignments in any order",
+			instr: instruction{commandInsert, "users", []string{"age=7", "name='Jane'"}, nil},
+			want:  result{rowsAffected: 1},
+		},
+		{
+			name:  "insert leaving a nullable column out",
+			instr: instruction{commandInsert, "users", []string{"name='Nobody'"}, nil},
+			want:  result{rowsAffected: 1},
+		},
+		{
+			name:    "insert NULL into a non-nullable column",
+			instr:   instruction{commandInsert, "users", []string{"NULL", "1"}, nil},
+			wantErr: true,
+		},
+		{
+			name:    "insert value of the wrong type",
+			instr:   instruction{commandInsert, "users", []string{"'x'", "'old'"}, nil},
+			wantErr: true,
+		},
+		{
+			name:    "insert a placeholder without a value bound to it",
+			instr:   instruction{commandInsert, "users", []string{"'x'", "$1"}, nil},
+			wantErr: true,
+		},
+		{
+			name:    "insert wrong number of values",
+			instr:   instruction{commandInsert, "users", []string{"'x'"}, nil},
+			wantErr: true,
+		},
+		{
+			name:  "select all",
+			instr: instruction{commandSelect, "users", []string{}, nil},
+			want: result{
+				columns: []column{nameCol, ageCol},
+				rows: []row{
+					{str("John Smith"), num(42)},
+					{str("Jane"), num(7)},
+					{str("Nobody"), nil},
+				},
+			},
+		},
+		{
+			name:  "select fields with conditions",
+			instr: instruction{commandSelect, "users", []string{"name", "age>5", "age<=42"}, nil},
+			want: result{
+				columns: []column{nameCol},
+				rows:    []row{{str("John Smith")}, {str("Jane")}},
+			},
+		},
+		{
+			name:  "select with string condition",
+			instr: instruction{commandSelect, "users", []string{"age", "name='John Smith'"}, nil},
+			want: result{
+				columns: []column{ageCol},
+				rows:    []row{{num(42)}},
+			},
+		},
+		{
+			name:  "select with an expression condition, where NULL doesn't satisfy it",
+			instr: instruction{commandSelect, "users", []string{"name", "(age % 2 = 0 OR age IS NULL) AND NOT name = 'Nobody'"}, nil},
+			want: result{
+				columns: []column{nameCol},
+				rows:    []row{{str("John Smith")}},
+			},
+		},
+		{
+			name:    "select with a condition which isn't a boolean",
+			instr:   instruction{commandSelect, "users", []string{"name", "coalesce(age,0)+1"}, nil},
+			wantErr: true,
+		},
+		{
+			name:    "select with an ill-typed condition",
+			instr:   instruction{commandSelect, "users", []string{"name", "age>'old'"}, nil},
+			wantErr: true,
+		},
+		{
+			name:    "select missing column",
+			instr:   instruction{commandSelect, "users", []string{"email"}, nil},
+			wantErr: true,
+		},
+		{
+			name:  "update matching rows",
+			instr: instruction{commandUpdate, "users", []string{"age=8", "where", "name='Jane'"}, nil},
+			want:  result{rowsAffected: 1},
+		},
+		{
+			name:    "update non-nullable column to NULL",
+			instr:   instruction{commandUpdate, "users", []string{"name=NULL"}, nil},
+			wantErr: true,
+		},
+		{
+			name:  "delete matching rows",
+			instr: instruction{commandDelete, "users", []string{"age<10"}, nil},
+			want:  result{rowsAffected: 1},
+		},
+		{
+			name:  "remaining rows",
+			instr: instruction{commandSelect, "users", []string{"*"}, nil},
+			want: result{
+				columns: []column{nameCol, ageCol},
+				rows: []row{
+					{str("John Smith"), num(42)},
+					{str("Nobody"), nil},
+				},
+			},
+		},
+		{
+			name:  "delete all rows",
+			instr: instruction{commandDelete, "users", []string{}, nil},
+			want:  result{rowsAffected: 2},
+		},
+		{
+			name:  "no rows remain",
+			instr: instruction{commandSelect, "users", []string{"name"}, nil},
+			want:  result{columns: []column{nameCol}},
+		},
+		{
+			name:  "drop table",
+			instr: instruction{commandDropTable, "users", []string{}, nil},
+			want:  result{},
+		},
+		{
+			name:    "select from dropped table",
+			instr:   instruction{commandSelect, "users", []string{}, nil},
+			wantErr: true,
+		},
+		{
+			name:    "drop missing table",
+			instr:   instruction{commandDropTable, "users", []string{}, nil},
+			wantErr: true,
+		},
+	}
+
+	for _, s := range steps {
+		got, err := e.execute(context.Background(), s.instr)
+		if s.wantErr {
+			assert.Error(t, err, s.name)
+			continue
+		}
+		assert.NoError(t, err, s.name)
+		assert.Equal(t, s.want, got, s.name)
+	}
+}
+
+func Test_executor_selectClauses(t *testing.T) {
+	e := newExecutor(exeConfig{order: 3})
+	execSQL(t, e, "CREATE TABLE users (name string NOT NULL, age integer, score float)")
+	execSQL(t, e, "INSERT INTO users VALUES ('Jane', 7, 1.5), ('John', 42, NULL), ('Ann', 7, 3), ('Bob', NULL, 1.5)")
+
+	cases := []struct {
+		sql   string
+		names []string // the names of the columns returned, if checked
+		want  string
+		err   string
+	}{
+		{
+			sql:  "SELECT name FROM users ORDER BY age DESC, name",
+			want: "John|Ann|Jane|Bob",
+		},
+		{
+			sql:  "SELECT name, age FROM users WHERE score > 1 ORDER BY score LIMIT 2",
+			want: "Jane 7|Bob NULL",
+		},
+		{
+			sql:  "SELECT * FROM users LIMIT 2 OFFSET 1",
+			want: "John 42 NULL|Ann 7 3",
+		},
+		{
+			sql:  "SELECT name FROM users OFFSET 3",
+			want: "Bob",
+		},
+		{
+			sql:   "SELECT COUNT(*), count(age), sum(age), avg(score), min(name), max(age) FROM users",
+			names: []string{"count(*)", "count(age)", "sum(age)", "avg(score)", "min(name)", "max(age)"},
+			want:  "4 3 56 2 Ann 42",
+		},
+		{
+			sql:  "SELECT count(*), sum(score) FROM users WHERE age > 100",
+			want: "0 NULL",
+		},
+		{
+			sql:   "SELECT age, count(*), max(name) FROM users GROUP BY age ORDER BY count(*) DESC, age",
+			names: []string{"age", "count(*)", "max(name)"},
+			want:  "7 2 Jane|NULL 1 Bob|42 1 John",
+		},
+		{
+			sql:  "SELECT score FROM users GROUP BY score ORDER BY max(age) DESC LIMIT 1",
+			want: "NULL",
+		},
+		{
+			sql: "SELECT name FROM users WHERE nmae = 'Jane'",
+			err: "invalid condition (nmae = 'Jane'): column nmae does not exist",
+		},
+		{
+			sql: "SELECT name FROM users WHERE name = Jane",
+			err: "invalid condition (name = Jane): column Jane does not exist",
+		},
+		{
+			sql:  "SELECT name FROM users WHERE age = +7 AND score >= .5",
+			want: "Jane|Ann",
+		},
+		{
+			sql: "SELECT name, count(*) FROM users",
+			err: "column name must be grouped by or aggregated",
+		},
+		{
+			sql:   "SELECT rowid, name FROM users WHERE rowid >= 1 AND rowid < 3",
+			names: []string{"rowid", "name"},
+			want:  "1 John|2 Ann",
+		},
+		{
+			sql:  "SELECT name FROM users WHERE 2 < rowid OR rowid = 0 ORDER BY rowid DESC",
+			want: "Bob|Jane",
+		},
+		{
+			sql:  "SELECT * FROM users WHERE rowid > 1 AND rowid <= 1",
+			want: "",
+		},
+		{
+			sql: "SELECT * FROM users GROUP BY name",
+			err: "column age must be grouped by or aggregated",
+		},
+		{
+			sql: "SELECT name FROM users ORDER BY email",
+			err: "column email does not exist in table users",
+		},
+		{
+			sql: "SELECT max(email) FROM users",
+			err: "column email does not exist in table users",
+		},
+		{
+			sql: "SELECT sum(name) FROM users",
+			err: "can't compute the sum of string column name",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.sql, func(t *testing.T) {
+			res, err := execSQLErr(e, tc.sql)
+			if tc.err != "" {
+				assert.EqualError(t, err, tc.err)
+				return
+			}
+			if !assert.NoError(t, err) {
+				return
+			}
+			if tc.names != nil {
+				assert.Equal(t, tc.names, namesOf(res.columns))
+			}
+			assert.Equal(t, tc.want, displayRows(t, res))
+		})
+	}
+}
+
+func Test_executor_whereExpressions(t *testing.T) {
+	e := newExecutor(exeConfig{order: 3})
+	execSQL(t, e, "CREATE TABLE users (name string NOT NULL, age integer, score float)")
+	execSQL(t, e, "INSERT INTO users VALUES ('Jane', 7, 1.5), ('John', 42, NULL), ('Ann', 7, 3), ('Bob', NULL, 1.5)")
+
+	cases := []struct {
+		where string
+		want  string
+		err   string
+	}{
+		// Arithmetic
+		{where: "age + 1 > 8", want: "John"},
+		{where: "age % 2 = 1", want: "Jane|Ann"},
+		{where: "-age < -10", want: "John"},
+
+		// Three-valued logic
+		{where: "age IS NULL", want: "Bob"},
+		{where: "NOT age = 7", want: "John"},
+		{where: "score > 2 OR age > 40", want: "John|Ann"},
+		{where: "NOT (score > 2 AND age > 40)", want: "Jane|Ann|Bob"},
+		{where: "(score > 2) IS NULL", want: "John"},
+
+		// CASE
+		{where: "CASE WHEN age < 18 THEN 'child' WHEN age IS NULL THEN 'unknown' ELSE 'adult' END = 'child'", want: "Jane|Ann"},
+		{where: "case age when 42 then true end", want: "John"},
+
+		// COALESCE and NULLIF
+		{where: "coalesce(age, 0) = 0", want: "Bob"},
+		{where: "COALESCE(score, age) > 40", want: "John"},
+		{where: "nullif(age, 7) IS NOT NULL", want: "John"},
+
+		// CAST
+		{where: "CAST(score AS integer) = 1", want: "Jane|Bob"},
+		{where: "cast(age as string) = '42'", want: "John"},
+
+		{where: "age + name > 1", err: "invalid condition (age + name > 1): can't apply + to integer and string"},
+		{where: "foo(age) = 1", err: "line 1, column 30: at SELECT: unexpected foo"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.where, func(t *testing.T) {
+			res, err := execSQLErr(e, "SELECT name FROM users WHERE "+tc.where)
+			if tc.err != "" {
+				assert.EqualError(t, err, tc.err)
+				return
+			}
+			if assert.NoError(t, err) {
+				assert.Equal(t, tc.want, displayRows(t, res))
+			}
+		})
+	}
+
+	res := execSQL(t, e, "UPDATE users SET score = 0 WHERE score IS NULL OR coalesce(age, 0) = 0")
+	assert.Equal(t, 2, res.rowsAffected)
+	res = execSQL(t, e, "DELETE FROM users WHERE CASE WHEN score = 0 THEN true ELSE false END")
+	assert.Equal(t, 2, res.rowsAffected)
+	assert.Equal(t, "Jane|Ann", displayRows(t, execSQL(t, e, "SELECT name FROM users")))
+}
+
+func Test_executor_selectParams(t *testing.T) {
+	e := newExecutor(exeConfig{order: 3})
+	execSQL(t, e, "CREATE TABLE users (name string NOT NULL, age integer)")
+	execSQL(t, e, "INSERT INTO users VALUES ('Jane', 7), ('John', 42), ('Ann', 7)")
+
+	cases := []struct {
+		params []string
+		want   string
+		err    string
+	}{
+		{params: []string{"name", "age<40", "ORDER", "name", "DESC"}, want: "Jane|Ann"},
+		{params: []string{"age", "count(*)", "group", "age", "order", "age", "asc", "limit", "1"}, want: "7 2"},
+		{params: []string{"name", "order", "name", "offset", "2"}, want: "John"},
+		{params: []string{"name", "group"}, err: "group expects fields"},
+		{params: []string{"name", "order", "limit", "1"}, err: "order expects fields"},
+		{params: []string{"name", "limit"}, err: "limit expects a number of rows"},
+		{params: []string{"name", "limit", "-1"}, err: "limit expects a number of rows"},
+		{params: []string{"name", "limit", "1", "name"}, err: "unexpected param name after limit"},
+		{params: []string{"name", "offset", "1", "limit", "1"}, err: "unexpected param limit after offset"},
+		{params: []string{"name", "for"}, err: "for expects share or update"},
+		{params: []string{"name", "for", "all"}, err: "for expects share or update"},
+		{params: []string{"name", "for", "share", "limit", "1"}, err: "unexpected param limit after for"},
+	}
+
+	for _, tc := range cases {
+		t.Run(strings.Join(tc.params, " "), func(t *testing.T) {
+			res, err := e.execute(context.Background(), instruction{commandSelect, "users", tc.params, nil})
+			if tc.err != "" {
+				assert.EqualError(t, err, tc.err)
+				return
+			}
+			if assert.NoError(t, err) {
+				assert.Equal(t, tc.want, displayRows(t, res))
+			}
+		})
+	}
+}
+
+func Test_executor_selectQuotedValues(t *testing.T) {
+	e := newExecutor(exeConfig{order: 3})
+	execSQL(t, e, "CREATE TABLE users (name string NOT NULL, email string, created datetime)")
+	execSQL(t, e, "INSERT INTO users VALUES ('Jane', 'a@b.com', '2021-03-04'), ('John', 'c@d.com', '2019-05-06')")
+
+	cases := []struct {
+		input string
+		want  string
+		err   string
+	}{
+		{input: "select users name created>'2020-01-01'", want: "Jane"},
+		{input: "select users name '2020-01-01'>created", want: "John"},
+		{input: "select users name email='a@b.com'", want: "Jane"},
+		{input: "select users name email!='a@b.com'", want: "John"},
+
+		// Values which aren't numbers must be quoted, rather than being
+		// taken as strings when they aren't valid expressions
+		{input: "select users name created>2020-01-01", err: "invalid condition created>2020-01-01: can't compare datetime with integer"},
+		{input: "select users name email=a@b.com", err: "invalid condition email=a@b.com: unexpected character '@' at column 8"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.input, func(t *testing.T) {
+			instr, err := parseInstruction(tc.input)
+			if !assert.NoError(t, err) {
+				return
+			}
+			res, err := e.execute(context.Background(), instr)
+			if tc.err != "" {
+				assert.EqualError(t, err, tc.err)
+				return
+			}
+			if assert.NoError(t, err) {
+				assert.Equal(t, tc.want, displayRows(t, res))
+			}
+		})
+	}
+}
+
+func Test_executor_insertIntegerRange(t *testing.T) {
+	e := newExecutor(exeConfig{order: 3})
+	execSQL(t, e, "CREATE TABLE numbers (n integer)")
+
+	execSQL(t, e, "INSERT INTO numbers VALUES (9223372036854775807), (-9223372036854775808)")
+	assert.Equal(t, "9223372036854775807|-9223372036854775808", displayRows(t, execSQL(t, e, "SELECT n FROM numbers")))
+
+	// Integers beyond int64 are read as floats, which can't be stored as
+	// integers
+	_, err := execSQLErr(e, "INSERT INTO numbers VALUES (9223372036854775808)")
+	assert.EqualError(t, err, "column n: integer value out of range: 9.223372036854776e+18")
+	_, err = execSQLErr(e, "INSERT INTO numbers VALUES (1e19)")
+	assert.EqualError(t, err, "column n: integer value out of range: 1e+19")
+}
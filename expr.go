@@ -0,0 +1,712 @@
+package lbadd
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// expr is an expression evaluated against a row, such as a condition rows
+// must satisfy.
+//
+// An expression is checked against the columns of the rows before it's
+// evaluated, which resolves the columns it refers to and finds its type, so
+// that an ill-typed expression fails when it's planned, before any rows are
+// read. Values are represented as described in record.go. Boolean
+// expressions follow SQL's three-valued logic, in which NULL is unknown.
+type expr interface {
+	// check resolves the expression against the columns, returning the
+	// type of its values.
+	check(cols []column) (columnType, error)
+	// eval returns the value of the expression for the row.
+	eval(r row) (interface{}, error)
+}
+
+// typeNull is the type of NULL, which may take the place of a value of any
+// type.
+const typeNull = columnTypeInvalid
+
+// errDivisionByZero is returned when evaluating a division by zero.
+var errDivisionByZero = errors.New("division by zero")
+
+// typeName returns the name of the type, for errors.
+func typeName(t columnType) string {
+	if t == typeNull {
+		return "NULL"
+	}
+
+	return t.String()
+}
+
+func numericType(t columnType) bool {
+	return t == columnTypeInt || t == columnTypeFloat
+}
+
+// comparableTypes reports whether values of the types can be compared with
+// each other.
+func comparableTypes(a, b columnType) bool {
+	return a == b || a == typeNull || b == typeNull || (numericType(a) && numericType(b))
+}
+
+// unify returns the type values of both types can be converted to, the wider
+// of the two numeric types, or false if there isn't one.
+func unify(a, b columnType) (columnType, bool) {
+	switch {
+	case a == typeNull || a == b:
+		return b, true
+	case b == typeNull:
+		return a, true
+	case numericType(a) && numericType(b):
+		return columnTypeFloat, true
+	default:
+		return typeNull, false
+	}
+}
+
+// typeOfValue returns the type of the value.
+func typeOfValue(v interface{}) columnType {
+	switch v.(type) {
+	case int64:
+		return columnTypeInt
+	case float64:
+		return columnTypeFloat
+	case bool:
+		return columnTypeBool
+	case string:
+		return columnTypeString
+	case time.Time:
+		return columnTypeDateTime
+	default:
+		return typeNull
+	}
+}
+
+// literalExpr is a value written in the expression.
+type literalExpr struct {
+	value interface{}
+}
+
+func (l *literalExpr) check(cols []column) (columnType, error) {
+	return typeOfValue(l.value), nil
+}
+
+func (l *literalExpr) eval(r row) (interface{}, error) {
+	return l.value, nil
+}
+
+// columnExpr refers to a column by name.
+type columnExpr struct {
+	name     string
+	index    int
+	dataType columnType
+}
+
+func (c *columnExpr) check(cols []column) (columnType, error) {
+	i, err := resolveColumn(cols, c.name)
+	if err != nil {
+		return typeNull, err
+	}
+
+	c.index = i
+	c.dataType = cols[c.index].dataType
+	return c.dataType, nil
+}
+
+func (c *columnExpr) eval(r row) (interface{}, error) {
+	return decodeRecord(r[c.index], c.dataType)
+}
+
+// paramExpr is a parameter placeholder of a prepared statement, e.g. ? or
+// :name, which takes the type of what it's compared with, unless that's a
+// literal, whose type may be converted, or another placeholder. It is only
+// checked to infer the types of the statement's parameters, and is replaced
+// by the value bound to it before the statement is executed.
+type paramExpr struct {
+	placeholder string
+	typ         columnType
+}
+
+func (p *paramExpr) check(cols []column) (columnType, error) {
+	return p.typ, nil
+}
+
+func (p *paramExpr) eval(r row) (interface{}, error) {
+	return nil, fmt.Errorf("no value bound to parameter %s", p.placeholder)
+}
+
+// inferParam gives the expression the type of the other side of a comparison
+// if it is a parameter compared with an expression other than a literal.
+func inferParam(x, other expr, t columnType) {
+	p, ok := x.(*paramExpr)
+	if _, isLiteral := other.(*literalExpr); ok && !isLiteral && t != typeNull {
+		p.typ = t
+	}
+}
+
+// literal returns the value of the expression if it is a literal.
+func literal(x expr) (interface{}, bool) {
+	if l, ok := x.(*literalExpr); ok {
+		return l.value, true
+	}
+
+	return nil, false
+}
+
+// coerce converts a literal compared with a value of another type to that
+// type up front, e.g. a string compared with a datetime column, returning the
+// types of both sides once converted.
+func coerce(l, r *expr, lt, rt columnType) (columnType, columnType, error) {
+	inferParam(*l, *r, rt)
+	inferParam(*r, *l, lt)
+	if comparableTypes(lt, rt) {
+		return lt, rt, nil
+	}
+
+	lv, lok := literal(*l)
+	rv, rok := literal(*r)
+	switch {
+	case rok && !lok:
+		v, err := convertValue(rv, lt)
+		if err != nil {
+			return lt, rt, err
+		}
+		*r = &literalExpr{v}
+		return lt, lt, nil
+	case lok && !rok:
+		v, err := convertValue(lv, rt)
+		if err != nil {
+			return lt, rt, err
+		}
+		*l = &literalExpr{v}
+		return rt, rt, nil
+	default:
+		return lt, rt, nil
+	}
+}
+
+// negExpr negates a number.
+type negExpr struct {
+	x expr
+}
+
+func (n *negExpr) check(cols []column) (columnType, error) {
+	t, err := n.x.check(cols)
+	if err == nil && !numericType(t) && t != typeNull {
+		err = fmt.Errorf("can't negate %s", typeName(t))
+	}
+	return t, err
+}
+
+func (n *negExpr) eval(r row) (interface{}, error) {
+	v, err := n.x.eval(r)
+	switch v := v.(type) {
+	case int64:
+		return -v, err
+	case float64:
+		return -v, err
+	default:
+		return nil, err
+	}
+}
+
+// arithExpr applies one of the arithmetic operators + - * / or % to two
+// numbers. The result is an integer if both are, and a float otherwise.
+// Integer division truncates towards zero.
+type arithExpr struct {
+	op   byte
+	l, r expr
+	typ  columnType
+}
+
+func (a *arithExpr) check(cols []column) (columnType, error) {
+	lt, err := a.l.check(cols)
+	if err != nil {
+		return typeNull, err
+	}
+	rt, err := a.r.check(cols)
+	if err != nil {
+		return typeNull, err
+	}
+
+	for _, t := range []columnType{lt, rt} {
+		if !numericType(t) && t != typeNull {
+			return typeNull, fmt.Errorf("can't apply %c to %s and %s", a.op, typeName(lt), typeName(rt))
+		}
+	}
+
+	a.typ, _ = unify(lt, rt)
+	return a.typ, nil
+}
+
+func (a *arithExpr) eval(r row) (interface{}, error) {
+	lv, err := a.l.eval(r)
+	if err != nil || lv == nil {
+		return nil, err
+	}
+	rv, err := a.r.eval(r)
+	if err != nil || rv == nil {
+		return nil, err
+	}
+
+	if a.typ == columnTypeInt {
+		x, y := lv.(int64), rv.(int64)
+		switch a.op {
+		case '+':
+			return x + y, nil
+		case '-':
+			return x - y, nil
+		case '*':
+			return x * y, nil
+		}
+		if y == 0 {
+			return nil, errDivisionByZero
+		}
+		if a.op == '/' {
+			return x / y, nil
+		}
+		return x % y, nil
+	}
+
+	xv, _ := convertValue(lv, columnTypeFloat)
+	yv, _ := convertValue(rv, columnTypeFloat)
+	x, y := xv.(float64), yv.(float64)
+	switch a.op {
+	case '+':
+		return x + y, nil
+	case '-':
+		return x - y, nil
+	case '*':
+		return x * y, nil
+	}
+	if y == 0 {
+		return nil, errDivisionByZero
+	}
+	if a.op == '/' {
+		return x / y, nil
+	}
+	return math.Mod(x, y), nil
+}
+
+// compareExpr compares two values with one of the comparison operators. It
+// is NULL if either value is.
+type compareExpr struct {
+	op   operatorType
+	l, r expr
+}
+
+func (c *compareExpr) check(cols []column) (columnType, error) {
+	lt, err := c.l.check(cols)
+	if err != nil {
+		return typeNull, err
+	}
+	rt, err := c.r.check(cols)
+	if err != nil {
+		return typeNull, err
+	}
+
+	if lt, rt, err = coerce(&c.l, &c.r, lt, rt); err != nil {
+		return typeNull, err
+	}
+	if !comparableTypes(lt, rt) {
+		return typeNull, fmt.Errorf("can't compare %s with %s", typeName(lt), typeName(rt))
+	}
+	return columnTypeBool, nil
+}
+
+func (c *compareExpr) eval(r row) (interface{}, error) {
+	lv, err := c.l.eval(r)
+	if err != nil || lv == nil {
+		return nil, err
+	}
+	rv, err := c.r.eval(r)
+	if err != nil || rv == nil {
+		return nil, err
+	}
+
+	cmp, err := compareValues(lv, rv)
+	if err != nil {
+		return nil, err
+	}
+	return c.op.holds(cmp), nil
+}
+
+// checkBool checks the operand of a boolean operator.
+func checkBool(x expr, cols []column, op string) error {
+	t, err := x.check(cols)
+	if err == nil && t != columnTypeBool && t != typeNull {
+		err = fmt.Errorf("%s expects booleans, not %s", op, typeName(t))
+	}
+	return err
+}
+
+// logicExpr is the conjunction of two booleans with AND, or the disjunction
+// with OR. An AND is false if either is false, an OR is true if either is
+// true, and otherwise they are NULL if either is.
+type logicExpr struct {
+	and  bool
+	l, r expr
+}
+
+func (lg *logicExpr) check(cols []column) (columnType, error) {
+	op := "OR"
+	if lg.and {
+		op = "AND"
+	}
+
+	if err := checkBool(lg.l, cols, op); err != nil {
+		return typeNull, err
+	}
+	if err := checkBool(lg.r, cols, op); err != nil {
+		return typeNull, err
+	}
+	return columnTypeBool, nil
+}
+
+func (lg *logicExpr) eval(r row) (interface{}, error) {
+	// The value of either side which decides the result
+	decisive := !lg.and
+
+	lv, err := lg.l.eval(r)
+	if err != nil || lv == decisive {
+		return lv, err
+	}
+	rv, err := lg.r.eval(r)
+	if err != nil || rv == decisive {
+		return rv, err
+	}
+
+	if lv == nil || rv == nil {
+		return nil, nil
+	}
+	return !decisive, nil
+}
+
+// notExpr negates a boolean. NOT NULL is NULL.
+type notExpr struct {
+	x expr
+}
+
+func (n *notExpr) check(cols []column) (columnType, error) {
+	return columnTypeBool, checkBool(n.x, cols, "NOT")
+}
+
+func (n *notExpr) eval(r row) (interface{}, error) {
+	v, err := n.x.eval(r)
+	if b, ok := v.(bool); ok {
+		return !b, err
+	}
+	return nil, err
+}
+
+// isNullExpr tests whether a value is NULL, or not NULL if not is set. It is
+// never NULL itself.
+type isNullExpr struct {
+	x   expr
+	not bool
+}
+
+func (n *isNullExpr) check(cols []column) (columnType, error) {
+	_, err := n.x.check(cols)
+	return columnTypeBool, err
+}
+
+func (n *isNullExpr) eval(r row) (interface{}, error) {
+	v, err := n.x.eval(r)
+	return (v == nil) != n.not, err
+}
+
+// when is a condition of a CASE and its result.
+type when struct {
+	cond, result expr
+}
+
+// caseExpr is the result of the first condition which is true, or of the
+// ELSE if none is, or NULL if there's no ELSE. A CASE with an operand
+// compares it with the values of the WHENs instead, the first equal to it
+// being chosen. The results are of one type.
+type caseExpr struct {
+	operand expr // nil unless the CASE compares a value
+	whens   []when
+	els     expr // nil if there is no ELSE
+	typ     columnType
+}
+
+func (c *caseExpr) check(cols []column) (columnType, error) {
+	var ot columnType
+	if c.operand != nil {
+		var err error
+		if ot, err = c.operand.check(cols); err != nil {
+			return typeNull, err
+		}
+	}
+
+	c.typ = typeNull
+	results := []*expr{}
+	for i := range c.whens {
+		w := &c.whens[i]
+		if c.operand == nil {
+			if err := checkBool(w.cond, cols, "WHEN"); err != nil {
+				return typeNull, err
+			}
+		} else {
+			wt, err := w.cond.check(cols)
+			if err != nil {
+				return typeNull, err
+			}
+			if _, wt, err = coerce(&c.operand, &w.cond, ot, wt); err != nil {
+				return typeNull, err
+			}
+			if !comparableTypes(ot, wt) {
+				return typeNull, fmt.Errorf("can't compare %s with %s", typeName(ot), typeName(wt))
+			}
+		}
+		results = append(results, &w.result)
+	}
+	if c.els != nil {
+		results = append(results, &c.els)
+	}
+
+	for _, res := range results {
+		rt, err := (*res).check(cols)
+		if err != nil {
+			return typeNull, err
+		}
+
+		t, ok := unify(c.typ, rt)
+		if !ok {
+			return typeNull, fmt.Errorf("CASE results of types %s and %s don't match", typeName(c.typ), typeName(rt))
+		}
+		c.typ = t
+	}
+
+	return c.typ, nil
+}
+
+func (c *caseExpr) eval(r row) (interface{}, error) {
+	var ov interface{}
+	if c.operand != nil {
+		var err error
+		if ov, err = c.operand.eval(r); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, w := range c.whens {
+		v, err := w.cond.eval(r)
+		if err != nil {
+			return nil, err
+		}
+
+		chosen := v == true
+		if c.operand != nil {
+			// NULL is equal to nothing, not even NULL
+			chosen = false
+			if ov != nil && v != nil {
+				cmp, err := compareValues(ov, v)
+				if err != nil {
+					return nil, err
+				}
+				chosen = cmp == 0
+			}
+		}
+		if chosen {
+			return evalAs(w.result, r, c.typ)
+		}
+	}
+
+	if c.els == nil {
+		return nil, nil
+	}
+	return evalAs(c.els, r, c.typ)
+}
+
+// evalAs evaluates the expression, converting its value to the type, which
+// it has been unified with.
+func evalAs(x expr, r row, t columnType) (interface{}, error) {
+	v, err := x.eval(r)
+	if err != nil {
+		return nil, err
+	}
+
+	return convertValue(v, t)
+}
+
+// coalesceExpr is the first of its arguments which isn't NULL, or NULL if
+// they all are. The arguments are of one type.
+type coalesceExpr struct {
+	args []expr
+	typ  columnType
+}
+
+func (c *coalesceExpr) check(cols []column) (columnType, error) {
+	c.typ = typeNull
+	for _, a := range c.args {
+		at, err := a.check(cols)
+		if err != nil {
+			return typeNull, err
+		}
+
+		t, ok := unify(c.typ, at)
+		if !ok {
+			return typeNull, fmt.Errorf("COALESCE arguments of types %s and %s don't match", typeName(c.typ), typeName(at))
+		}
+		c.typ = t
+	}
+
+	return c.typ, nil
+}
+
+func (c *coalesceExpr) eval(r row) (interface{}, error) {
+	for _, a := range c.args {
+		v, err := evalAs(a, r, c.typ)
+		if err != nil || v != nil {
+			return v, err
+		}
+	}
+
+	return nil, nil
+}
+
+// nullifExpr is NULL if its arguments are equal, and the first otherwise.
+type nullifExpr struct {
+	l, r expr
+}
+
+func (n *nullifExpr) check(cols []column) (columnType, error) {
+	lt, err := n.l.check(cols)
+	if err != nil {
+		return typeNull, err
+	}
+	rt, err := n.r.check(cols)
+	if err != nil {
+		return typeNull, err
+	}
+
+	if lt, rt, err = coerce(&n.l, &n.r, lt, rt); err != nil {
+		return typeNull, err
+	}
+	if !comparableTypes(lt, rt) {
+		return typeNull, fmt.Errorf("can't compare %s with %s", typeName(lt), typeName(rt))
+	}
+	return lt, nil
+}
+
+func (n *nullifExpr) eval(r row) (interface{}, error) {
+	lv, err := n.l.eval(r)
+	if err != nil || lv == nil {
+		return nil, err
+	}
+	rv, err := n.r.eval(r)
+	if err != nil || rv == nil {
+		return lv, err
+	}
+
+	cmp, err := compareValues(lv, rv)
+	if err != nil || cmp == 0 {
+		return nil, err
+	}
+	return lv, nil
+}
+
+// castExpr converts a value to another type, failing if the value can't be
+// represented by it. Booleans are the numbers 1 and 0, and datetimes are
+// numbers of seconds since the Unix epoch.
+type castExpr struct {
+	x  expr
+	to columnType
+}
+
+func (c *castExpr) check(cols []column) (columnType, error) {
+	_, err := c.x.check(cols)
+	return c.to, err
+}
+
+func (c *castExpr) eval(r row) (interface{}, error) {
+	v, err := c.x.eval(r)
+	if err != nil || v == nil {
+		return nil, err
+	}
+
+	if cv, ok := castValue(v, c.to); ok {
+		return cv, nil
+	}
+	return nil, fmt.Errorf("can't cast %s to %s", formatLiteral(v), c.to)
+}
+
+// castValue converts a non-NULL value to the type, reporting whether it
+// could be.
+func castValue(v interface{}, t columnType) (interface{}, bool) {
+	if cv, err := convertValue(v, t); err == nil {
+		return cv, true
+	}
+
+	switch t {
+	case columnTypeInt:
+		switch v := v.(type) {
+		case float64:
+			if v >= math.MinInt64 && v < math.MaxInt64 {
+				return int64(v), true
+			}
+		case bool:
+			if v {
+				return int64(1), true
+			}
+			return int64(0), true
+		case string:
+			if i, err := strconv.ParseInt(strings.TrimSpace(v), 10, 64); err == nil {
+				return i, true
+			}
+		case time.Time:
+			return v.Unix(), true
+		}
+	case columnTypeFloat:
+		switch v := v.(type) {
+		case bool:
+			if v {
+				return 1.0, true
+			}
+			return 0.0, true
+		case string:
+			if f, err := strconv.ParseFloat(strings.TrimSpace(v), 64); err == nil {
+				return f, true
+			}
+		case time.Time:
+			return float64(v.UnixNano()) / float64(time.Second), true
+		}
+	case columnTypeBool:
+		switch v := v.(type) {
+		case int64:
+			return v != 0, true
+		case float64:
+			return v != 0, true
+		case string:
+			if b, err := strconv.ParseBool(strings.TrimSpace(v)); err == nil {
+				return b, true
+			}
+		}
+	case columnTypeString:
+		switch v := v.(type) {
+		case int64:
+			return strconv.FormatInt(v, 10), true
+		case float64:
+			return strconv.FormatFloat(v, 'g', -1, 64), true
+		case bool:
+			return strconv.FormatBool(v), true
+		case time.Time:
+			return v.Format(time.RFC3339Nano), true
+		}
+	case columnTypeDateTime:
+		switch v := v.(type) {
+		case int64:
+			return time.Unix(v, 0).UTC(), true
+		case float64:
+			sec, frac := math.Modf(v)
+			return time.Unix(int64(sec), int64(frac*float64(time.Second))).UTC(), true
+		}
+	}
+
+	return nil, false
+}
@@ -0,0 +1,225 @@
+package lbadd
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_expr(t *testing.T) {
+	cols := []column{
+		{dataType: columnTypeString, name: "name"},
+		{dataType: columnTypeInt, name: "age", isNullable: true},
+		{dataType: columnTypeFloat, name: "score", isNullable: true},
+		{dataType: columnTypeBool, name: "active"},
+		{dataType: columnTypeDateTime, name: "born"},
+	}
+	values := []interface{}{"Jane", int64(7), nil, true, time.Date(2020, 1, 2, 0, 0, 0, 0, time.UTC)}
+	r := make(row, len(values))
+	for i, v := range values {
+		rec, err := encodeValue(v, cols[i].dataType)
+		if !assert.NoError(t, err) {
+			return
+		}
+		r[i] = rec
+	}
+
+	cases := []struct {
+		text string
+		want interface{}
+		err  string
+	}{
+		// Arithmetic
+		{text: "1 + 2 * 3", want: int64(7)},
+		{text: "(1 + 2) * 3", want: int64(9)},
+		{text: "age * 2 - 1", want: int64(13)},
+		{text: "-age", want: int64(-7)},
+		{text: "- -2", want: int64(2)},
+		{text: "7 / 2", want: int64(3)},
+		{text: "-7 % 3", want: int64(-1)},
+		{text: "7 / 2.0", want: 3.5},
+		{text: "7.5 % 2", want: 1.5},
+		{text: "1e1 + 1", want: 11.0},
+		{text: "score + 1", want: nil},
+		{text: "1 / 0", err: "division by zero"},
+		{text: "age % 0.0", err: "division by zero"},
+
+		// Comparisons, with literals converted to the type of the other side
+		{text: "age = 7.0", want: true},
+		{text: "age + 1 > 7", want: true},
+		{text: "name = 'Jane'", want: true},
+		{text: "name <> 'John'", want: true},
+		{text: "score < 1", want: nil},
+		{text: "born > '2019-12-31'", want: true},
+		{text: "'2020-01-02 00:00:00' = born", want: true},
+
+		// Three-valued logic
+		{text: "age > 5 AND name = 'Jane'", want: true},
+		{text: "score > 1 AND false", want: false},
+		{text: "false AND score > 1", want: false},
+		{text: "score > 1 AND true", want: nil},
+		{text: "score > 1 OR true", want: true},
+		{text: "score > 1 OR false", want: nil},
+		{text: "NULL OR NULL", want: nil},
+		{text: "true OR 1 / 0 = 1", want: true},
+		{text: "NOT score > 1", want: nil},
+		{text: "NOT active", want: false},
+		{text: "not age = 1 or age = 1 and false", want: true},
+		{text: "score IS NULL", want: true},
+		{text: "age IS NOT NULL", want: true},
+		{text: "(score > 1) IS NULL", want: true},
+
+		// CASE
+		{text: "CASE WHEN age < 5 THEN 'young' WHEN age < 50 THEN 'adult' END", want: "adult"},
+		{text: "CASE WHEN score > 1 THEN 1 END", want: nil},
+		{text: "CASE WHEN score > 1 THEN 1 ELSE 2 END", want: int64(2)},
+		{text: "CASE age WHEN 6 THEN 1 WHEN 7 THEN 2.5 ELSE 0 END", want: 2.5},
+		{text: "CASE age WHEN 7 THEN 1 ELSE 2.5 END", want: 1.0},
+		{text: "CASE score WHEN NULL THEN 1 ELSE 0 END", want: int64(0)},
+		{text: "case born when '2020-01-02' then true end", want: true},
+
+		// COALESCE and NULLIF
+		{text: "coalesce(score, age, 0)", want: 7.0},
+		{text: "COALESCE(NULL, name)", want: "Jane"},
+		{text: "coalesce(score)", want: nil},
+		{text: "nullif(age, 7)", want: nil},
+		{text: "nullif(age, 8)", want: int64(7)},
+		{text: "nullif(born, '2020-01-02') IS NULL", want: true},
+		{text: "nullif(score, 1)", want: nil},
+
+		// CAST
+		{text: "CAST(age AS float)", want: 7.0},
+		{text: "cast(2.9 as integer)", want: int64(2)},
+		{text: "CAST(active AS integer)", want: int64(1)},
+		{text: "CAST(false AS float)", want: 0.0},
+		{text: "CAST(born AS integer)", want: int64(1577923200)},
+		{text: "CAST(' 12 ' AS integer)", want: int64(12)},
+		{text: "CAST('1.5' AS float)", want: 1.5},
+		{text: "CAST(0 AS boolean)", want: false},
+		{text: "CAST('true' AS boolean)", want: true},
+		{text: "CAST(age AS string)", want: "7"},
+		{text: "CAST(1.5 AS string)", want: "1.5"},
+		{text: "CAST(active AS string)", want: "true"},
+		{text: "CAST(born AS string)", want: "2020-01-02T00:00:00Z"},
+		{text: "CAST(1577923200 AS datetime) = born", want: true},
+		{text: "CAST('2020-01-02' AS datetime) = born", want: true},
+		{text: "CAST(score AS string)", want: nil},
+		{text: "CAST('x' AS integer)", err: "can't cast 'x' to integer"},
+		{text: "CAST(active AS datetime)", err: "can't cast TRUE to datetime"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.text, func(t *testing.T) {
+			x, err := parseExpr(tc.text)
+			if !assert.NoError(t, err) {
+				return
+			}
+			_, err = x.check(cols)
+			if !assert.NoError(t, err) {
+				return
+			}
+
+			got, err := x.eval(r)
+			if tc.err != "" {
+				assert.EqualError(t, err, tc.err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func Test_expr_check(t *testing.T) {
+	cols := []column{
+		{dataType: columnTypeString, name: "name"},
+		{dataType: columnTypeInt, name: "age", isNullable: true},
+		{dataType: columnTypeBool, name: "active"},
+	}
+
+	cases := []struct {
+		text string
+		typ  columnType
+		err  string
+	}{
+		{text: "age + 1.5", typ: columnTypeFloat},
+		{text: "age / 2", typ: columnTypeInt},
+		{text: "NULL + 1", typ: columnTypeInt},
+		{text: "age > 1 OR NULL", typ: columnTypeBool},
+		{text: "CASE WHEN active THEN NULL ELSE 'x' END", typ: columnTypeString},
+		{text: "coalesce(NULL, NULL)", typ: typeNull},
+		{text: "CAST(name AS datetime)", typ: columnTypeDateTime},
+		{text: "name + 1", err: "can't apply + to string and integer"},
+		{text: "active * age", err: "can't apply * to boolean and integer"},
+		{text: "-name", err: "can't negate string"},
+		{text: "age > active", err: "can't compare integer with boolean"},
+		{text: "age = 'old'", err: "invalid integer value: 'old'"},
+		{text: "name = Jane", err: "column Jane does not exist"},
+		{text: "nmae = 'bob'", err: "column nmae does not exist"},
+		{text: "age AND true", err: "AND expects booleans, not integer"},
+		{text: "active OR name", err: "OR expects booleans, not string"},
+		{text: "NOT name", err: "NOT expects booleans, not string"},
+		{text: "CASE WHEN age THEN 1 END", err: "WHEN expects booleans, not integer"},
+		{text: "CASE age WHEN active THEN 1 END", err: "can't compare integer with boolean"},
+		{text: "CASE WHEN active THEN 1 ELSE 'x' END", err: "CASE results of types integer and string don't match"},
+		{text: "coalesce(age, name)", err: "COALESCE arguments of types integer and string don't match"},
+		{text: "nullif(name, active)", err: "can't compare string with boolean"},
+		{text: "CAST(age + name AS string)", err: "can't apply + to integer and string"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.text, func(t *testing.T) {
+			x, err := parseExpr(tc.text)
+			if !assert.NoError(t, err) {
+				return
+			}
+
+			typ, err := x.check(cols)
+			if tc.err != "" {
+				assert.EqualError(t, err, tc.err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.typ, typ)
+		})
+	}
+
+	// Conditions must be booleans
+	_, err := parsePredicates(cols, []string{"age>1", "age+1"}, nil)
+	assert.EqualError(t, err, "invalid condition age+1: expected a boolean, not integer")
+}
+
+func Test_parseExpr_errors(t *testing.T) {
+	cases := []struct {
+		text string
+		err  string
+	}{
+		{"", "unexpected end of expression"},
+		{"age >", "unexpected end of expression"},
+		{"age 1", "unexpected 1"},
+		{"(age > 1", "expected ) but found end of expression"},
+		{"age > 1)", "unexpected )"},
+		{"AND age", "unexpected AND"},
+		{"1.2.3", "invalid number 1.2.3"},
+		{"'abc", "unterminated string starting at column 1"},
+		{"age # 1", "unexpected character '#' at column 5"},
+		{"age IS 1", "expected NULL but found 1"},
+		{"foo(1)", "unknown function foo"},
+		{"coalesce(1 2)", "expected , or ) but found 2"},
+		{"nullif(1)", "NULLIF takes 2 arguments but 1 were given"},
+		{"cast(1 integer)", "expected AS but found integer"},
+		{"cast(1 as money)", "expected type but found money"},
+		{"CASE END", "unexpected END"},
+		{"CASE age END", "expected WHEN but found END"},
+		{"CASE WHEN true 1 END", "expected THEN but found 1"},
+		{"CASE WHEN true THEN 1", "expected END but found end of expression"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.text, func(t *testing.T) {
+			_, err := parseExpr(tc.text)
+			assert.EqualError(t, err, tc.err)
+		})
+	}
+}
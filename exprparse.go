@@ -0,0 +1,562 @@
+package lbadd
+
+import (
+	"fmt"
+	"strings"
+)
+
+// parseExpr parses an expression as written in the conditions of the IR,
+// e.g. coalesce(age, 0) + 1 > 7 OR name IS NULL. Keywords and the names of
+// functions and types are case insensitive.
+//
+//	expr ::= expr OR expr | expr AND expr | NOT expr
+//	  | expr operator expr | expr IS [NOT] NULL
+//	  | expr ("+" | "-" | "*" | "/" | "%") expr | "-" expr
+//	  | CASE [expr] WHEN expr THEN expr ... [ELSE expr] END
+//	  | COALESCE(expr, ...) | NULLIF(expr, expr) | CAST(expr AS <type>)
+//	  | "(" expr ")" | <value> | <column_name>
+//
+// Operators bind in the usual order, from OR the loosest, to AND, NOT, the
+// comparisons, addition and subtraction, and multiplication, division and
+// remainder the tightest.
+func parseExpr(text string) (expr, error) {
+	tokens, err := lexExpr(text)
+	if err != nil {
+		return nil, err
+	}
+
+	return (&exprParser{tokens: tokens}).parse()
+}
+
+// lexExpr splits the text of an expression into its tokens, which are words,
+// numbers, quoted strings and names, placeholders and symbols.
+func lexExpr(text string) ([]string, error) {
+	tokens := []string{}
+
+	for i := 0; i < len(text); {
+		if isSpace(text[i]) {
+			i++
+			continue
+		}
+
+		end, err := scanExprToken(text, i)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, text[i:end])
+		i = end
+	}
+
+	return tokens, nil
+}
+
+// scanExprToken returns the end of the token of an expression starting at i.
+func scanExprToken(text string, i int) (int, error) {
+	c := text[i]
+	switch {
+	case c == '\'' || c == '"':
+		end, terminated := scanQuoted(text, i)
+		if !terminated {
+			return 0, fmt.Errorf("unterminated %s starting at column %d", quotedKind(c), i+1)
+		}
+		return end, nil
+	case c == '?':
+		return i + 1, nil
+	case c == '$' && i+1 < len(text) && isDigit(text[i+1]):
+		for i++; i < len(text) && isDigit(text[i]); i++ {
+		}
+		return i, nil
+	case c == ':' && i+1 < len(text) && (text[i+1] == '_' || isLetter(text[i+1])):
+		for i++; i < len(text) && (text[i] == '_' || isLetter(text[i]) || isDigit(text[i])); i++ {
+		}
+		return i, nil
+	case isDigit(c) || (c == '.' && i+1 < len(text) && isDigit(text[i+1])):
+		for i < len(text) && (isDigit(text[i]) || text[i] == '.') {
+			i++
+		}
+		// An exponent, e.g. 1e-3
+		if i < len(text) && (text[i] == 'e' || text[i] == 'E') {
+			j := i + 1
+			if j < len(text) && (text[j] == '+' || text[j] == '-') {
+				j++
+			}
+			if j < len(text) && isDigit(text[j]) {
+				for i = j; i < len(text) && isDigit(text[i]); i++ {
+				}
+			}
+		}
+		return i, nil
+	case c == '_' || isLetter(c):
+		// A word, which may be qualified by the name of its table, e.g.
+		// users.name
+		for i < len(text) && (text[i] == '_' || isLetter(text[i]) || isDigit(text[i]) ||
+			(text[i] == '.' && i+1 < len(text) && (text[i+1] == '_' || isLetter(text[i+1])))) {
+			i++
+		}
+		return i, nil
+	case i+1 < len(text) && newOperator(text[i:i+2]) != unknownOperator:
+		return i + 2, nil
+	case strings.IndexByte("=<>+-*/%(),", c) != -1:
+		return i + 1, nil
+	default:
+		return 0, fmt.Errorf("unexpected character %q at column %d", c, i+1)
+	}
+}
+
+// quotedKind returns what is quoted by the quote, a string or a name.
+func quotedKind(quote byte) string {
+	if quote == '"' {
+		return "name"
+	}
+
+	return "string"
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isLetter(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+// The words with a meaning of their own in expressions, which can't be used
+// to refer to columns
+var exprKeywords = []string{
+	"AND", "OR", "NOT", "IS", "NULL", "TRUE", "FALSE",
+	"CASE", "WHEN", "THEN", "ELSE", "END", "AS",
+}
+
+// isExprKeyword reports whether the word is one of the keywords of
+// expressions, ignoring case.
+func isExprKeyword(word string) bool {
+	for _, k := range exprKeywords {
+		if toUp(word) == k {
+			return true
+		}
+	}
+
+	return false
+}
+
+// joinExpr joins the tokens of an expression into its text, separated by
+// spaces other than within parentheses, before commas, after signs and
+// between a function and its arguments, e.g. -age > coalesce(score, 0).
+func joinExpr(tokens []string) string {
+	var b strings.Builder
+	for i, tok := range tokens {
+		if i > 0 && spaceBefore(tokens, i) {
+			b.WriteByte(' ')
+		}
+		b.WriteString(tok)
+	}
+
+	return b.String()
+}
+
+// spaceBefore reports whether joinExpr separates the token at i from the one
+// before it.
+func spaceBefore(tokens []string, i int) bool {
+	prev, tok := tokens[i-1], tokens[i]
+	switch {
+	case tok == ")" || tok == "," || prev == "(":
+		return false
+	case prev == "-" || prev == "+":
+		// A sign, rather than an addition or subtraction
+		return i > 1 && endsOperand(tokens[i-2])
+	case tok == "(":
+		// A function call, rather than a keyword followed by parentheses
+		return !(prev[0] == '_' || isLetter(prev[0])) || isExprKeyword(prev)
+	default:
+		return true
+	}
+}
+
+// endsOperand reports whether the token may be the last of an operand, so
+// that a sign following it is an addition or subtraction.
+func endsOperand(tok string) bool {
+	switch toUp(tok) {
+	case ")", "NULL", "TRUE", "FALSE", "END":
+		return true
+	}
+
+	return !isExprKeyword(tok) && strings.IndexByte("=<>!+-*/%(,", tok[0]) == -1
+}
+
+// exprParser is a recursive descent parser of expressions, with a method for
+// each level of precedence.
+type exprParser struct {
+	tokens []string
+	pos    int
+
+	// args are the values bound to the $n placeholders, by position. Other
+	// placeholders are only accepted if placeholders is set, as those of a
+	// prepared statement, each of which is added to params.
+	args         []interface{}
+	placeholders bool
+	params       []*paramExpr
+}
+
+// exprError is an error in an expression, found at one of its tokens.
+type exprError struct {
+	pos      int      // the index of the token the error was found at
+	expected []string // the tokens which would have been accepted there, if any
+	msg      string
+}
+
+func (e *exprError) Error() string {
+	return e.msg
+}
+
+// parse parses the tokens as a whole expression.
+func (p *exprParser) parse() (expr, error) {
+	x, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.peek() != "" {
+		return nil, p.unexpected()
+	}
+
+	return x, nil
+}
+
+// peek returns the token at the cursor, or an empty string at the end.
+func (p *exprParser) peek() string {
+	if p.pos == len(p.tokens) {
+		return ""
+	}
+
+	return p.tokens[p.pos]
+}
+
+// accept pops the token at the cursor if it is the given token, ignoring
+// case, reporting whether it was.
+func (p *exprParser) accept(tok string) bool {
+	if toUp(p.peek()) != tok {
+		return false
+	}
+
+	p.pos++
+	return true
+}
+
+// expect pops the given token, returning an error if it isn't at the cursor.
+func (p *exprParser) expect(tok string) error {
+	if !p.accept(tok) {
+		return p.unexpected(tok)
+	}
+
+	return nil
+}
+
+// unexpected returns an error for the token at the cursor, given the tokens
+// which would have been accepted instead, if any.
+func (p *exprParser) unexpected(expected ...string) error {
+	found := "end of expression"
+	if p.peek() != "" {
+		found = p.peek()
+	}
+	if len(expected) == 0 {
+		return p.errorAt(p.pos, "unexpected %s", found)
+	}
+
+	err := p.errorAt(p.pos, "expected %s but found %s", joinAlternatives(expected), found)
+	err.expected = expected
+	return err
+}
+
+// errorAt returns an error found at the token at the given position.
+func (p *exprParser) errorAt(pos int, format string, args ...interface{}) *exprError {
+	return &exprError{pos: pos, msg: fmt.Sprintf(format, args...)}
+}
+
+func (p *exprParser) parseOr() (expr, error) {
+	x, err := p.parseAnd()
+	for err == nil && p.accept("OR") {
+		var r expr
+		if r, err = p.parseAnd(); err == nil {
+			x = &logicExpr{l: x, r: r}
+		}
+	}
+
+	return x, err
+}
+
+func (p *exprParser) parseAnd() (expr, error) {
+	x, err := p.parseNot()
+	for err == nil && p.accept("AND") {
+		var r expr
+		if r, err = p.parseNot(); err == nil {
+			x = &logicExpr{and: true, l: x, r: r}
+		}
+	}
+
+	return x, err
+}
+
+func (p *exprParser) parseNot() (expr, error) {
+	if p.accept("NOT") {
+		x, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return &notExpr{x}, nil
+	}
+
+	return p.parseComparison()
+}
+
+func (p *exprParser) parseComparison() (expr, error) {
+	x, err := p.parseSum()
+	if err != nil {
+		return nil, err
+	}
+
+	if p.accept("IS") {
+		not := p.accept("NOT")
+		if err := p.expect("NULL"); err != nil {
+			return nil, err
+		}
+		return &isNullExpr{x: x, not: not}, nil
+	}
+
+	op := newOperator(p.peek())
+	if op == unknownOperator {
+		return x, nil
+	}
+	p.pos++
+
+	r, err := p.parseSum()
+	if err != nil {
+		return nil, err
+	}
+	return &compareExpr{op: op, l: x, r: r}, nil
+}
+
+func (p *exprParser) parseSum() (expr, error) {
+	x, err := p.parseProduct()
+	for err == nil && (p.peek() == "+" || p.peek() == "-") {
+		op := p.tokens[p.pos][0]
+		p.pos++
+
+		var r expr
+		if r, err = p.parseProduct(); err == nil {
+			x = &arithExpr{op: op, l: x, r: r}
+		}
+	}
+
+	return x, err
+}
+
+func (p *exprParser) parseProduct() (expr, error) {
+	x, err := p.parseUnary()
+	for err == nil && (p.peek() == "*" || p.peek() == "/" || p.peek() == "%") {
+		op := p.tokens[p.pos][0]
+		p.pos++
+
+		var r expr
+		if r, err = p.parseUnary(); err == nil {
+			x = &arithExpr{op: op, l: x, r: r}
+		}
+	}
+
+	return x, err
+}
+
+// parseUnary parses a value with any signs before it. The signs of numbers
+// are part of their literals, as they are elsewhere in the IR.
+func (p *exprParser) parseUnary() (expr, error) {
+	switch p.peek() {
+	case "-", "+":
+		neg := p.tokens[p.pos] == "-"
+		p.pos++
+
+		x, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		if l, ok := x.(*literalExpr); ok {
+			switch v := l.value.(type) {
+			case int64:
+				if neg {
+					v = -v
+				}
+				return &literalExpr{v}, nil
+			case float64:
+				if neg {
+					v = -v
+				}
+				return &literalExpr{v}, nil
+			}
+		}
+		if neg {
+			return &negExpr{x}, nil
+		}
+		return x, nil
+	}
+
+	return p.parsePrimary()
+}
+
+func (p *exprParser) parsePrimary() (expr, error) {
+	tok := p.peek()
+	switch {
+	case tok == "":
+		return nil, p.unexpected()
+	case tok == "(":
+		p.pos++
+		x, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		return x, p.expect(")")
+	case tok[0] == '\'' || isDigit(tok[0]) || tok[0] == '.':
+		p.pos++
+		v := parseLiteral(tok)
+		if s, ok := v.(string); ok && tok[0] != '\'' {
+			return nil, p.errorAt(p.pos-1, "invalid number %s", s)
+		}
+		return &literalExpr{v}, nil
+	}
+
+	switch toUp(tok) {
+	case "NULL", "TRUE", "FALSE":
+		p.pos++
+		return &literalExpr{parseLiteral(tok)}, nil
+	case "CASE":
+		p.pos++
+		return p.parseCase()
+	}
+
+	switch {
+	case tok[0] == '"':
+		p.pos++
+		return &columnExpr{name: strings.Replace(tok[1:len(tok)-1], `""`, `"`, -1)}, nil
+	case isPlaceholder(tok):
+		if v, ok := boundValue(tok, p.args); ok {
+			p.pos++
+			return &literalExpr{v}, nil
+		}
+		if !p.placeholders {
+			return nil, p.errorAt(p.pos, "no value bound to parameter %s", tok)
+		}
+		p.pos++
+		param := &paramExpr{placeholder: tok}
+		p.params = append(p.params, param)
+		return param, nil
+	}
+
+	if !(tok[0] == '_' || isLetter(tok[0])) || isExprKeyword(tok) {
+		return nil, p.unexpected()
+	}
+
+	// A word followed by parentheses calls a function, otherwise it refers
+	// to a column
+	start := p.pos
+	p.pos++
+	if !p.accept("(") {
+		return &columnExpr{name: tok}, nil
+	}
+
+	switch toUp(tok) {
+	case "COALESCE":
+		args, err := p.parseArgs()
+		if err != nil {
+			return nil, err
+		}
+		return &coalesceExpr{args: args}, nil
+	case "NULLIF":
+		args, err := p.parseArgs()
+		if err != nil {
+			return nil, err
+		}
+		if len(args) != 2 {
+			return nil, p.errorAt(start, "NULLIF takes 2 arguments but %d were given", len(args))
+		}
+		return &nullifExpr{l: args[0], r: args[1]}, nil
+	case "CAST":
+		return p.parseCast()
+	default:
+		return nil, p.errorAt(start, "unknown function %s", tok)
+	}
+}
+
+// parseArgs parses the arguments of a function call, following its opening
+// parenthesis.
+func (p *exprParser) parseArgs() ([]expr, error) {
+	args := []expr{}
+	for {
+		x, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, x)
+
+		if p.accept(")") {
+			return args, nil
+		}
+		if !p.accept(",") {
+			return nil, p.unexpected(",", ")")
+		}
+	}
+}
+
+// parseCast parses the rest of a CAST, following its opening parenthesis.
+func (p *exprParser) parseCast() (expr, error) {
+	x, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect("AS"); err != nil {
+		return nil, err
+	}
+
+	to := parseColumnType(strings.ToLower(p.peek()))
+	if to == columnTypeInvalid {
+		return nil, p.unexpected("type")
+	}
+	p.pos++
+
+	return &castExpr{x: x, to: to}, p.expect(")")
+}
+
+// parseCase parses the rest of a CASE, following the CASE keyword.
+func (p *exprParser) parseCase() (expr, error) {
+	c := &caseExpr{}
+	if toUp(p.peek()) != "WHEN" {
+		operand, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		c.operand = operand
+	}
+
+	for p.accept("WHEN") {
+		cond, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect("THEN"); err != nil {
+			return nil, err
+		}
+		result, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		c.whens = append(c.whens, when{cond: cond, result: result})
+	}
+	if len(c.whens) == 0 {
+		return nil, p.unexpected("WHEN")
+	}
+
+	if p.accept("ELSE") {
+		els, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		c.els = els
+	}
+
+	return c, p.expect("END")
+}
@@ -0,0 +1,217 @@
+package lbadd
+
+import "strings"
+
+// format renders the query as canonical SQL, which parses back into the same
+// query. Keywords are written in upper case, each clause starts on a new line,
+// and identifiers are only quoted where they would otherwise not parse as
+// identifiers, e.g.
+//
+//	SELECT name, "from"
+//	FROM users
+//	WHERE age >= 18
+//	  AND name != 'John'
+func format(q query) string {
+	return formatQuery(q, true)
+}
+
+// formatLine renders the query as canonical SQL like format, but on a single
+// line, which is better suited for logs and histories.
+func formatLine(q query) string {
+	return formatQuery(q, false)
+}
+
+// formatQuery renders the query, either with every clause and every item of
+// lists which may grow long, such as conditions and inserted rows, on a line
+// of its own, or all on a single line.
+func formatQuery(q query, multiline bool) string {
+	clauseSep, itemSep := " ", " "
+	if multiline {
+		clauseSep, itemSep = "\n", "\n  "
+	}
+
+	clauses := []string{}
+
+	switch q.queryType {
+	case selectQuery:
+		fields := make([]string, len(q.fields))
+		for i, f := range q.fields {
+			fields[i] = formatField(f)
+		}
+		clauses = append(clauses,
+			"SELECT "+strings.Join(fields, ", "),
+			"FROM "+formatIdentifier(q.tableName),
+		)
+
+	case insertQuery:
+		into := "INSERT INTO " + formatIdentifier(q.tableName)
+		if len(q.fields) > 0 {
+			into += " (" + formatIdentifiers(q.fields) + ")"
+		}
+
+		rows := make([]string, len(q.inserts))
+		for i, values := range q.inserts {
+			rows[i] = "(" + strings.Join(values, ", ") + ")"
+		}
+		clauses = append(clauses, into, "VALUES"+itemSep+strings.Join(rows, ","+itemSep))
+
+	case updateQuery:
+		sets := make([]string, len(q.updates))
+		for i, u := range q.updates {
+			sets[i] = formatIdentifier(u.field) + " = " + u.value
+		}
+		clauses = append(clauses,
+			"UPDATE "+formatIdentifier(q.tableName),
+			"SET "+strings.Join(sets, ", "),
+		)
+
+	case deleteQuery:
+		clauses = append(clauses, "DELETE FROM "+formatIdentifier(q.tableName))
+
+	case createTableQuery:
+		cols := make([]string, len(q.columns))
+		for i, c := range q.columns {
+			cols[i] = formatIdentifier(c.name) + " " + c.dataType.String()
+			if !c.isNullable {
+				cols[i] += " NOT NULL"
+			}
+		}
+
+		if multiline {
+			return "CREATE TABLE " + formatIdentifier(q.tableName) + " (" + itemSep + strings.Join(cols, ","+itemSep) + "\n)"
+		}
+		return "CREATE TABLE " + formatIdentifier(q.tableName) + " (" + strings.Join(cols, ", ") + ")"
+
+	case dropTableQuery:
+		return "DROP TABLE " + formatIdentifier(q.tableName)
+
+	case copyQuery:
+		stmt := "COPY " + formatIdentifier(q.tableName)
+		if len(q.fields) > 0 {
+			stmt += " (" + formatIdentifiers(q.fields) + ")"
+		}
+		if q.copyTo {
+			stmt += " TO " + q.copyFile
+		} else {
+			stmt += " FROM " + q.copyFile
+		}
+
+		if len(q.copyOptions) > 0 {
+			opts := make([]string, len(q.copyOptions))
+			for i, o := range q.copyOptions {
+				opts[i] = o.name + " " + o.value
+			}
+			stmt += " WITH (" + strings.Join(opts, ", ") + ")"
+		}
+		return stmt
+
+	case savepointQuery:
+		return "SAVEPOINT " + formatIdentifier(q.savepoint)
+
+	case releaseQuery:
+		return "RELEASE SAVEPOINT " + formatIdentifier(q.savepoint)
+
+	case rollbackQuery:
+		if q.savepoint != "" {
+			return "ROLLBACK TO SAVEPOINT " + formatIdentifier(q.savepoint)
+		}
+		return q.queryType.String()
+
+	case setTransactionQuery:
+		return "SET TRANSACTION ISOLATION LEVEL " + q.isolation.String()
+
+	default:
+		return q.queryType.String()
+	}
+
+	if q.where != "" {
+		clauses = append(clauses, "WHERE "+strings.Join(conjuncts(q.where), itemSep+"AND "))
+	}
+
+	if len(q.groupBy) > 0 {
+		clauses = append(clauses, "GROUP BY "+formatIdentifiers(q.groupBy))
+	}
+	if len(q.orderBy) > 0 {
+		keys := make([]string, len(q.orderBy))
+		for i, k := range q.orderBy {
+			keys[i] = formatField(k.field)
+			if k.desc {
+				keys[i] += " DESC"
+			}
+		}
+		clauses = append(clauses, "ORDER BY "+strings.Join(keys, ", "))
+	}
+	if q.limit != "" {
+		clauses = append(clauses, "LIMIT "+q.limit)
+	}
+	if q.offset != "" {
+		clauses = append(clauses, "OFFSET "+q.offset)
+	}
+	if q.locking != "" {
+		clauses = append(clauses, "FOR "+q.locking)
+	}
+
+	return strings.Join(clauses, clauseSep)
+}
+
+// conjuncts splits the condition of a WHERE into the conditions it joins with
+// AND, other than within parentheses or a CASE.
+func conjuncts(where string) []string {
+	tokens, err := lexExpr(where)
+	if err != nil {
+		return []string{where}
+	}
+
+	conds := []string{}
+	depth, start := 0, 0
+	for i, tok := range tokens {
+		switch toUp(tok) {
+		case "(", "CASE":
+			depth++
+		case ")", "END":
+			depth--
+		case "AND":
+			if depth == 0 {
+				conds = append(conds, joinExpr(tokens[start:i]))
+				start = i + 1
+			}
+		}
+	}
+
+	return append(conds, joinExpr(tokens[start:]))
+}
+
+// formatField renders a field of a SELECT, which is *, an identifier or an
+// aggregate of one, e.g. MAX(age).
+func formatField(field string) string {
+	if field == "*" {
+		return field
+	}
+	if fn, arg, ok := parseAggregate(field); ok {
+		if arg != "*" {
+			arg = formatIdentifier(arg)
+		}
+		return toUp(fn.String()) + "(" + arg + ")"
+	}
+
+	return formatIdentifier(field)
+}
+
+// formatIdentifier renders the name as an identifier, quoting it if it isn't
+// a plain word or is a reserved word.
+func formatIdentifier(name string) string {
+	if identifierPattern.MatchString(name) && !isReserved(toUp(name)) {
+		return name
+	}
+
+	return `"` + strings.Replace(name, `"`, `""`, -1) + `"`
+}
+
+func formatIdentifiers(names []string) string {
+	formatted := make([]string, len(names))
+	for i, n := range names {
+		formatted[i] = formatIdentifier(n)
+	}
+
+	return strings.Join(formatted, ", ")
+}
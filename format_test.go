@@ -0,0 +1,166 @@
+package lbadd
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFormat(t *testing.T) {
+	cases := []struct {
+		name string
+		sql  string
+		full string
+		line string
+	}{
+		{
+			name: "select",
+			sql:  "select a,b from z",
+			full: "SELECT a, b\nFROM z",
+			line: "SELECT a, b FROM z",
+		},
+		{
+			name: "select star with conditions",
+			sql:  "select * from z where a>=1 and 'x'<>b and c = ? ",
+			full: "SELECT *\nFROM z\nWHERE a >= 1\n  AND 'x' != b\n  AND c = ?",
+			line: "SELECT * FROM z WHERE a >= 1 AND 'x' != b AND c = ?",
+		},
+		{
+			name: "select quoted identifiers",
+			sql:  `SELECT "from", "a b", "x" FROM "my ""table"""`,
+			full: "SELECT \"from\", \"a b\", x\nFROM \"my \"\"table\"\"\"",
+			line: `SELECT "from", "a b", x FROM "my ""table"""`,
+		},
+		{
+			name: "select grouped, ordered and limited",
+			sql:  `select age, count(*), max("from") from z group by age order by count(*) desc, age asc limit 2 offset 1`,
+			full: "SELECT age, COUNT(*), MAX(\"from\")\nFROM z\nGROUP BY age\nORDER BY COUNT(*) DESC, age\nLIMIT 2\nOFFSET 1",
+			line: `SELECT age, COUNT(*), MAX("from") FROM z GROUP BY age ORDER BY COUNT(*) DESC, age LIMIT 2 OFFSET 1`,
+		},
+		{
+			name: "select for share",
+			sql:  "select a from z where a = 1 for share",
+			full: "SELECT a\nFROM z\nWHERE a = 1\nFOR SHARE",
+			line: "SELECT a FROM z WHERE a = 1 FOR SHARE",
+		},
+		{
+			name: "insert",
+			sql:  "insert into z values (1, 'it''s', null), (2,true,$1)",
+			full: "INSERT INTO z\nVALUES\n  (1, 'it''s', NULL),\n  (2, TRUE, $1)",
+			line: "INSERT INTO z VALUES (1, 'it''s', NULL), (2, TRUE, $1)",
+		},
+		{
+			name: "insert with fields",
+			sql:  "INSERT INTO z (a, \"values\") VALUES (1.5, :v)",
+			full: "INSERT INTO z (a, \"values\")\nVALUES\n  (1.5, :v)",
+			line: "INSERT INTO z (a, \"values\") VALUES (1.5, :v)",
+		},
+		{
+			name: "update",
+			sql:  "update z set a = 1, b = 'x' where c < 3",
+			full: "UPDATE z\nSET a = 1, b = 'x'\nWHERE c < 3",
+			line: "UPDATE z SET a = 1, b = 'x' WHERE c < 3",
+		},
+		{
+			name: "delete",
+			sql:  "delete from z where a = b",
+			full: "DELETE FROM z\nWHERE a = b",
+			line: "DELETE FROM z WHERE a = b",
+		},
+		{
+			name: "delete all",
+			sql:  "DELETE FROM z",
+			full: "DELETE FROM z",
+			line: "DELETE FROM z",
+		},
+		{
+			name: "create table",
+			sql:  "create table users (name string not null, \"from\" DATETIME)",
+			full: "CREATE TABLE users (\n  name string NOT NULL,\n  \"from\" datetime\n)",
+			line: "CREATE TABLE users (name string NOT NULL, \"from\" datetime)",
+		},
+		{
+			name: "drop table",
+			sql:  "drop table users",
+			full: "DROP TABLE users",
+			line: "DROP TABLE users",
+		},
+		{
+			name: "copy",
+			sql:  "copy users(name) to 'users.csv' (header false, quote '''')",
+			full: "COPY users (name) TO 'users.csv' WITH (HEADER FALSE, QUOTE '''')",
+			line: "COPY users (name) TO 'users.csv' WITH (HEADER FALSE, QUOTE '''')",
+		},
+		{
+			name: "begin",
+			sql:  "begin transaction",
+			full: "BEGIN",
+			line: "BEGIN",
+		},
+		{
+			name: "rollback",
+			sql:  "ROLLBACK",
+			full: "ROLLBACK",
+			line: "ROLLBACK",
+		},
+		{
+			name: "rollback to savepoint",
+			sql:  "rollback transaction to savepoint a",
+			full: "ROLLBACK TO SAVEPOINT a",
+			line: "ROLLBACK TO SAVEPOINT a",
+		},
+		{
+			name: "set transaction",
+			sql:  "set transaction isolation level snapshot",
+			full: "SET TRANSACTION ISOLATION LEVEL REPEATABLE READ",
+			line: "SET TRANSACTION ISOLATION LEVEL REPEATABLE READ",
+		},
+		{
+			name: "release",
+			sql:  "RELEASE a",
+			full: "RELEASE SAVEPOINT a",
+			line: "RELEASE SAVEPOINT a",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			q, err := parse(tc.sql)
+			assert.NoError(t, err)
+
+			assert.Equal(t, tc.full, format(q))
+			assert.Equal(t, tc.line, formatLine(q))
+
+			// The formatted SQL parses back into the same query, and
+			// formatting it again yields the same SQL.
+			for _, fn := range []func(query) string{format, formatLine} {
+				formatted := fn(q)
+				reparsed, err := parse(formatted)
+				assert.NoError(t, err)
+				assert.Equal(t, q, reparsed)
+				assert.Equal(t, formatted, fn(reparsed))
+			}
+		})
+	}
+}
+
+func TestFormatIdentifier(t *testing.T) {
+	cases := []struct {
+		name     string
+		expected string
+	}{
+		{"a", "a"},
+		{"user_id", "user_id"},
+		{"select", `"select"`},
+		{"From", `"From"`},
+		{"a b", `"a b"`},
+		{`say "hi"`, `"say ""hi"""`},
+		{"1a", `"1a"`},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, formatIdentifier(tc.name))
+		})
+	}
+}
@@ -0,0 +1,128 @@
+package lbadd
+
+import (
+	"fmt"
+	"strings"
+)
+
+// parseInstruction parses an instruction written in the textual intermediary
+// representation, as described in doc/intermediary-rep.md. An instruction is
+// its command, the name of the table and its params, separated by any amount
+// of whitespace, e.g.
+//
+//	insert users 'John Smith' 42
+//
+// Single quoted strings may contain whitespace, and are kept quoted in the
+// params.
+func parseInstruction(input string) (instruction, error) {
+	tokens, err := lexInstruction(input)
+	if err != nil {
+		return instruction{}, err
+	}
+	if len(tokens) == 0 {
+		return instruction{}, fmt.Errorf("empty instruction")
+	}
+
+	name := tokens[0]
+	tokens = tokens[1:]
+
+	// Creating and dropping tables are the only commands made up of two
+	// words.
+	switch toUp(name) {
+	case "CREATE", "DROP":
+		if len(tokens) == 0 || toUp(tokens[0]) != "TABLE" {
+			return instruction{}, fmt.Errorf("expected TABLE after %s", toUp(name))
+		}
+		name += " " + tokens[0]
+		tokens = tokens[1:]
+	}
+
+	cmd := newCommand(name)
+	if cmd == commandUnknown {
+		return instruction{}, fmt.Errorf("unknown command %s", name)
+	}
+
+	// Transaction commands don't operate on a table. A savepoint is named by
+	// the only param, which is optional for a rollback, and an isolation
+	// level is the only param of isolation.
+	if cmd == commandIsolation {
+		if len(tokens) != 1 {
+			return instruction{}, fmt.Errorf("%s expects an isolation level", cmd)
+		}
+		if _, err := parseIsolationParam(tokens[0]); err != nil {
+			return instruction{}, err
+		}
+		return instruction{command: cmd, params: tokens}, nil
+	}
+	if cmd.isTransactionCommand() {
+		named := cmd == commandSavepoint || cmd == commandRelease || (cmd == commandRollback && len(tokens) > 0)
+		switch {
+		case !named && len(tokens) > 0:
+			return instruction{}, fmt.Errorf("%s takes no table or params", cmd)
+		case named && len(tokens) != 1:
+			return instruction{}, fmt.Errorf("%s expects a savepoint name", cmd)
+		case named && !identifierPattern.MatchString(tokens[0]):
+			return instruction{}, fmt.Errorf("invalid savepoint name %s", tokens[0])
+		}
+		return instruction{command: cmd, params: tokens}, nil
+	}
+
+	if len(tokens) == 0 {
+		return instruction{}, fmt.Errorf("%s expects a table name", cmd)
+	}
+	if !identifierPattern.MatchString(tokens[0]) {
+		return instruction{}, fmt.Errorf("invalid table name %s", tokens[0])
+	}
+
+	return instruction{command: cmd, table: tokens[0], params: tokens[1:]}, nil
+}
+
+// lexInstruction splits the input into its tokens, returning an error if a
+// quoted string isn't terminated. Whitespace within quotes or parentheses
+// doesn't separate tokens, so an expression in parentheses is a single token.
+func lexInstruction(input string) ([]string, error) {
+	tokens := []string{}
+
+	for i := 0; i < len(input); {
+		if isSpace(input[i]) {
+			i++
+			continue
+		}
+
+		start, depth := i, 0
+		for i < len(input) && (depth > 0 || !isSpace(input[i])) {
+			switch input[i] {
+			case '(':
+				depth++
+			case ')':
+				if depth > 0 {
+					depth--
+				}
+			}
+			if input[i] != '\'' {
+				i++
+				continue
+			}
+
+			end, terminated := scanQuoted(input, i)
+			if !terminated {
+				return nil, fmt.Errorf("unterminated string starting at column %d", i+1)
+			}
+			i = end
+		}
+		tokens = append(tokens, input[start:i])
+	}
+
+	return tokens, nil
+}
+
+// String returns the instruction in the textual intermediary representation,
+// which parses back into the same instruction.
+func (instr instruction) String() string {
+	tokens := []string{strings.ToLower(instr.command.String())}
+	if instr.table != "" {
+		tokens = append(tokens, instr.table)
+	}
+
+	return strings.Join(append(tokens, instr.params...), " ")
+}
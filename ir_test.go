@@ -0,0 +1,194 @@
+package lbadd
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_parseInstruction(t *testing.T) {
+	cases := []struct {
+		name     string
+		input    string
+		expected instruction
+		err      string
+	}{
+		{
+			name:     "select",
+			input:    "select users name age>=3",
+			expected: instruction{commandSelect, "users", []string{"name", "age>=3"}, nil},
+		},
+		{
+			name:     "select with an expression in parentheses",
+			input:    "select users name (age + 1 > 3 OR name = 'a b)')",
+			expected: instruction{commandSelect, "users", []string{"name", "(age + 1 > 3 OR name = 'a b)')"}, nil},
+		},
+		{
+			name:     "select without params",
+			input:    "SELECT users",
+			expected: instruction{commandSelect, "users", []string{}, nil},
+		},
+		{
+			name:     "insert quoted values",
+			input:    "insert users 'John Smith' 'it''s' NULL",
+			expected: instruction{commandInsert, "users", []string{"'John Smith'", "'it''s'", "NULL"}, nil},
+		},
+		{
+			name:     "update with quoted assignment",
+			input:    "update users name='Jane Doe' where age<3",
+			expected: instruction{commandUpdate, "users", []string{"name='Jane Doe'", "where", "age<3"}, nil},
+		},
+		{
+			name:     "delete",
+			input:    "delete users\tage>6  b=1",
+			expected: instruction{commandDelete, "users", []string{"age>6", "b=1"}, nil},
+		},
+		{
+			name:     "create table",
+			input:    "Create Table users name string false age integer true",
+			expected: instruction{commandCreateTable, "users", []string{"name", "string", "false", "age", "integer", "true"}, nil},
+		},
+		{
+			name:     "drop table",
+			input:    "drop table users",
+			expected: instruction{commandDropTable, "users", []string{}, nil},
+		},
+		{
+			name:     "copy",
+			input:    "copy users from 'my users.csv' name delimiter=' '",
+			expected: instruction{commandCopy, "users", []string{"from", "'my users.csv'", "name", "delimiter=' '"}, nil},
+		},
+		{
+			name:     "begin",
+			input:    "begin",
+			expected: instruction{commandBegin, "", []string{}, nil},
+		},
+		{
+			name:     "rollback",
+			input:    " ROLLBACK ",
+			expected: instruction{commandRollback, "", []string{}, nil},
+		},
+		{
+			name:     "rollback to a savepoint",
+			input:    "rollback before",
+			expected: instruction{commandRollback, "", []string{"before"}, nil},
+		},
+		{
+			name:     "savepoint",
+			input:    "SAVEPOINT before",
+			expected: instruction{commandSavepoint, "", []string{"before"}, nil},
+		},
+		{
+			name:     "isolation",
+			input:    "isolation SERIALIZABLE",
+			expected: instruction{commandIsolation, "", []string{"SERIALIZABLE"}, nil},
+		},
+		{
+			name:  "isolation without a level",
+			input: "isolation",
+			err:   "ISOLATION expects an isolation level",
+		},
+		{
+			name:  "invalid isolation level",
+			input: "isolation sometimes",
+			err:   "invalid isolation level sometimes",
+		},
+		{
+			name:  "commit with a table",
+			input: "commit users",
+			err:   "COMMIT takes no table or params",
+		},
+		{
+			name:  "release without a savepoint",
+			input: "release",
+			err:   "RELEASE expects a savepoint name",
+		},
+		{
+			name:  "invalid savepoint name",
+			input: "savepoint 'a'",
+			err:   "invalid savepoint name 'a'",
+		},
+		{
+			name:  "empty",
+			input: "   ",
+			err:   "empty instruction",
+		},
+		{
+			name:  "unknown command",
+			input: "truncate users",
+			err:   "unknown command truncate",
+		},
+		{
+			name:  "create without table",
+			input: "create users",
+			err:   "expected TABLE after CREATE",
+		},
+		{
+			name:  "missing table name",
+			input: "select",
+			err:   "SELECT expects a table name",
+		},
+		{
+			name:  "invalid table name",
+			input: "select 'users'",
+			err:   "invalid table name 'users'",
+		},
+		{
+			name:  "unterminated string",
+			input: "insert users 'John",
+			err:   "unterminated string starting at column 14",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			instr, err := parseInstruction(tc.input)
+			if tc.err != "" {
+				assert.EqualError(t, err, tc.err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, instr)
+
+			// The printed instruction parses back into the same instruction
+			reparsed, err := parseInstruction(instr.String())
+			assert.NoError(t, err)
+			assert.Equal(t, instr, reparsed)
+		})
+	}
+}
+
+func Test_instruction_String(t *testing.T) {
+	cases := []struct {
+		name     string
+		instr    instruction
+		expected string
+	}{
+		{
+			name:     "select",
+			instr:    instruction{commandSelect, "users", []string{"name", "age>=3"}, nil},
+			expected: "select users name age>=3",
+		},
+		{
+			name:     "create table",
+			instr:    instruction{commandCreateTable, "users", []string{"name", "string", "false"}, nil},
+			expected: "create table users name string false",
+		},
+		{
+			name:     "drop table without params",
+			instr:    instruction{command: commandDropTable, table: "users"},
+			expected: "drop table users",
+		},
+		{
+			name:     "commit",
+			instr:    instruction{command: commandCommit},
+			expected: "commit",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, tc.instr.String())
+		})
+	}
+}
@@ -0,0 +1,263 @@
+package lbadd
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// isolationLevel determines which changes of concurrent transactions a
+// transaction sees, and which anomalies it is protected from.
+type isolationLevel int
+
+const (
+	// levelRepeatableRead sees the database as it was when the transaction
+	// began, the default. Also known as snapshot isolation.
+	levelRepeatableRead isolationLevel = iota
+	// levelReadCommitted sees the database as it was when each statement
+	// began, and rows changed since by a committed transaction are read
+	// again rather than causing a serialization error.
+	levelReadCommitted
+	// levelSerializable is a snapshot, which also fails with a serialization
+	// error where the transactions couldn't have been executed one after the
+	// other.
+	levelSerializable
+)
+
+var isolationLevels = []isolationLevel{levelReadCommitted, levelRepeatableRead, levelSerializable}
+
+func (l isolationLevel) String() string {
+	switch l {
+	case levelReadCommitted:
+		return "READ COMMITTED"
+	case levelRepeatableRead:
+		return "REPEATABLE READ"
+	case levelSerializable:
+		return "SERIALIZABLE"
+	default:
+		return "UNKNOWN"
+	}
+}
+
+// param returns the level as the param of an isolation instruction, e.g.
+// read_committed.
+func (l isolationLevel) param() string {
+	return strings.ToLower(strings.Replace(l.String(), " ", "_", -1))
+}
+
+// parseIsolationParam returns the level of an isolation instruction's param.
+func parseIsolationParam(p string) (isolationLevel, error) {
+	for _, l := range isolationLevels {
+		if l.param() == strings.ToLower(p) {
+			return l, nil
+		}
+	}
+
+	return 0, fmt.Errorf("invalid isolation level %s", p)
+}
+
+// sxact is a serializable transaction, tracked for as long as a concurrent
+// transaction may conflict with it.
+//
+// Two concurrent transactions have a read-write conflict, or
+// rw-antidependency, when one reads a table the other writes, so the reader
+// doesn't see the writer's changes and must come first in any serial order.
+// A transaction with conflicts both in and out may be a pivot of a cycle of
+// such orders, in which case the transactions can't be serialized, so it or
+// a transaction it conflicts with is aborted.
+type sxact struct {
+	id        txid
+	snap      snapshot
+	reads     map[string]bool // the tables read
+	writes    map[string]bool // the tables written
+	in        map[*sxact]bool // readers of tables this one wrote
+	out       map[*sxact]bool // writers of tables this one read
+	committed bool            // whether the transaction has committed
+	doomed    bool            // whether the transaction must abort
+}
+
+// concurrent reports whether neither transaction saw the other commit.
+func (sx *sxact) concurrent(o *sxact) bool {
+	return sx != o && !sx.snap.sees(o.id) && !o.snap.sees(sx.id)
+}
+
+// pivot reports whether the transaction has conflicts both in and out.
+func (sx *sxact) pivot() bool {
+	return len(sx.in) > 0 && len(sx.out) > 0
+}
+
+// ssiManager detects read-write conflicts among serializable transactions,
+// following serializable snapshot isolation. Reads are tracked by table, as
+// every read scans the table, and so conflicts with any write to it.
+type ssiManager struct {
+	mu    sync.Mutex
+	xacts map[txid]*sxact
+}
+
+func newSSIManager() *ssiManager {
+	return &ssiManager{xacts: make(map[txid]*sxact)}
+}
+
+// register starts tracking the serializable transaction.
+func (m *ssiManager) register(id txid, snap snapshot) *sxact {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	sx := &sxact{
+		id:     id,
+		snap:   snap,
+		reads:  make(map[string]bool),
+		writes: make(map[string]bool),
+		in:     make(map[*sxact]bool),
+		out:    make(map[*sxact]bool),
+	}
+	m.xacts[id] = sx
+	return sx
+}
+
+// read records the transaction reading the table, returning a serialization
+// error if it must abort.
+func (m *ssiManager) read(sx *sxact, table string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if sx.doomed {
+		return ErrSerialization
+	}
+	if sx.reads[table] {
+		return nil
+	}
+
+	sx.reads[table] = true
+	for _, o := range m.xacts {
+		if o.writes[table] && sx.concurrent(o) {
+			if err := m.conflict(sx, sx, o); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// write records the transaction writing the table, returning a
+// serialization error if it must abort.
+func (m *ssiManager) write(sx *sxact, table string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if sx.doomed {
+		return ErrSerialization
+	}
+	if sx.writes[table] {
+		return nil
+	}
+
+	sx.writes[table] = true
+	for _, o := range m.xacts {
+		if o.reads[table] && sx.concurrent(o) {
+			if err := m.conflict(sx, o, sx); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// conflict records the reader's conflict with the writer, found by the
+// current transaction, which is one of the two. If the current transaction
+// is now a pivot, it fails, as it does if the other is a pivot which has
+// committed. Otherwise the other is doomed if it is a pivot.
+func (m *ssiManager) conflict(current, reader, writer *sxact) error {
+	reader.out[writer] = true
+	writer.in[reader] = true
+
+	other := reader
+	if other == current {
+		other = writer
+	}
+	switch {
+	case current.pivot(), other.pivot() && other.committed:
+		return ErrSerialization
+	case other.pivot():
+		other.doomed = true
+	}
+	return nil
+}
+
+// commit marks the transaction committed, returning a serialization error
+// instead if it must abort.
+func (m *ssiManager) commit(sx *sxact) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if sx.doomed || sx.pivot() {
+		return ErrSerialization
+	}
+
+	sx.committed = true
+	m.prune()
+	return nil
+}
+
+// abort stops tracking the transaction once it has rolled back, along with
+// its conflicts.
+func (m *ssiManager) abort(sx *sxact) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.remove(sx)
+	m.prune()
+}
+
+// prune stops tracking committed transactions which are no longer
+// concurrent with any in progress, which are the only ones they could
+// conflict with.
+func (m *ssiManager) prune() {
+	for _, sx := range m.xacts {
+		if !sx.committed {
+			continue
+		}
+
+		concurrent := false
+		for _, o := range m.xacts {
+			if !o.committed && o.concurrent(sx) {
+				concurrent = true
+				break
+			}
+		}
+		if !concurrent {
+			m.remove(sx)
+		}
+	}
+}
+
+// remove stops tracking the transaction.
+func (m *ssiManager) remove(sx *sxact) {
+	delete(m.xacts, sx.id)
+	for o := range sx.in {
+		delete(o.out, sx)
+	}
+	for o := range sx.out {
+		delete(o.in, sx)
+	}
+}
+
+// readTable records the transaction in progress reading the table, if it is
+// serializable.
+func (e *executor) readTable(t table) error {
+	if e.tx.sx == nil {
+		return nil
+	}
+
+	return e.db.ssi.read(e.tx.sx, t.name)
+}
+
+// writeTable records the transaction in progress writing the table, if it
+// is serializable.
+func (e *executor) writeTable(t table) error {
+	if e.tx.sx == nil {
+		return nil
+	}
+
+	return e.db.ssi.write(e.tx.sx, t.name)
+}
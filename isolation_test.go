@@ -0,0 +1,182 @@
+package lbadd
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// beginAt begins a transaction in the session at the isolation level.
+func beginAt(t *testing.T, s *executor, level isolationLevel) {
+	execSQL(t, s, "BEGIN")
+	execSQL(t, s, "SET TRANSACTION ISOLATION LEVEL "+level.String())
+}
+
+func Test_executor_isolationLevels(t *testing.T) {
+	anomalies := []struct {
+		name    string
+		allowed []isolationLevel // the levels at which the anomaly occurs
+		// occurs runs the anomaly's transactions in two sessions, at the
+		// level, reporting whether it occurred
+		occurs func(t *testing.T, a, b *executor, level isolationLevel) bool
+	}{
+		{
+			name: "dirty read",
+			occurs: func(t *testing.T, a, b *executor, level isolationLevel) bool {
+				beginAt(t, a, level)
+				execSQL(t, a, "UPDATE users SET age = 1 WHERE name = 'Jane'")
+				beginAt(t, b, level)
+				seen := displayRows(t, execSQL(t, b, "SELECT age FROM users WHERE name = 'Jane'"))
+				execSQL(t, a, "ROLLBACK")
+				execSQL(t, b, "COMMIT")
+				return seen == "1"
+			},
+		},
+		{
+			name:    "non-repeatable read",
+			allowed: []isolationLevel{levelReadCommitted},
+			occurs: func(t *testing.T, a, b *executor, level isolationLevel) bool {
+				beginAt(t, b, level)
+				first := displayRows(t, execSQL(t, b, "SELECT age FROM users WHERE name = 'Jane'"))
+				execSQL(t, a, "UPDATE users SET age = 8 WHERE name = 'Jane'")
+				second := displayRows(t, execSQL(t, b, "SELECT age FROM users WHERE name = 'Jane'"))
+				execSQL(t, b, "COMMIT")
+				return first != second
+			},
+		},
+		{
+			name:    "lost update",
+			allowed: []isolationLevel{levelReadCommitted},
+			occurs: func(t *testing.T, a, b *executor, level isolationLevel) bool {
+				// Both transactions increment the age they read
+				age := func(s *executor) int64 {
+					v, err := decodeRecord(execSQL(t, s, "SELECT age FROM users WHERE name = 'Jane'").rows[0][0], columnTypeInt)
+					assert.NoError(t, err)
+					return v.(int64)
+				}
+
+				beginAt(t, a, level)
+				beginAt(t, b, level)
+				ageA, ageB := age(a), age(b)
+				execSQL(t, a, fmt.Sprintf("UPDATE users SET age = %d WHERE name = 'Jane'", ageA+1))
+				execSQL(t, a, "COMMIT")
+				if _, err := execSQLErr(b, fmt.Sprintf("UPDATE users SET age = %d WHERE name = 'Jane'", ageB+1)); err != nil {
+					assert.True(t, errors.Is(err, ErrSerialization))
+					return false
+				}
+				execSQL(t, b, "COMMIT")
+				return displayRows(t, execSQL(t, a, "SELECT age FROM users WHERE name = 'Jane'")) != "9"
+			},
+		},
+		{
+			name:    "write skew",
+			allowed: []isolationLevel{levelReadCommitted, levelRepeatableRead},
+			occurs: func(t *testing.T, a, b *executor, level isolationLevel) bool {
+				// At least one user must be an adult, each transaction checks
+				// that the other is before making one a child
+				beginAt(t, a, level)
+				beginAt(t, b, level)
+				assert.Equal(t, "Jane|John", displayRows(t, execSQL(t, a, "SELECT name FROM users WHERE age >= 7")))
+				assert.Equal(t, "Jane|John", displayRows(t, execSQL(t, b, "SELECT name FROM users WHERE age >= 7")))
+				execSQL(t, a, "UPDATE users SET age = 1 WHERE name = 'Jane'")
+				if _, err := execSQLErr(b, "UPDATE users SET age = 1 WHERE name = 'John'"); err != nil {
+					assert.True(t, errors.Is(err, ErrSerialization))
+					execSQL(t, a, "COMMIT")
+					return false
+				}
+				execSQL(t, a, "COMMIT")
+				if _, err := execSQLErr(b, "COMMIT"); err != nil {
+					assert.True(t, errors.Is(err, ErrSerialization))
+					return false
+				}
+				return displayRows(t, execSQL(t, a, "SELECT name FROM users WHERE age >= 7")) == ""
+			},
+		},
+	}
+
+	for _, an := range anomalies {
+		for _, level := range isolationLevels {
+			t.Run(an.name+" at "+level.String(), func(t *testing.T) {
+				a := newExecutor(exeConfig{order: 3})
+				b := a.newSession()
+				execSQL(t, a, "CREATE TABLE users (name string NOT NULL, age integer)")
+				execSQL(t, a, "INSERT INTO users VALUES ('Jane', 7), ('John', 42)")
+
+				allowed := false
+				for _, l := range an.allowed {
+					allowed = allowed || l == level
+				}
+				assert.Equal(t, allowed, an.occurs(t, a, b, level))
+
+				// Serializable transactions are no longer tracked once none
+				// in progress could conflict with them
+				assert.False(t, a.inTransaction() || b.inTransaction())
+				assert.Empty(t, a.db.ssi.xacts)
+			})
+		}
+	}
+}
+
+func Test_executor_readCommitted(t *testing.T) {
+	a := newExecutor(exeConfig{order: 3})
+	b := a.newSession()
+	execSQL(t, a, "CREATE TABLE users (name string NOT NULL, age integer)")
+	execSQL(t, a, "INSERT INTO users VALUES ('Jane', 7), ('John', 42)")
+
+	// A statement waiting for a row changed by a transaction which commits
+	// is executed again, seeing the change
+	execSQL(t, a, "BEGIN")
+	execSQL(t, a, "UPDATE users SET age = 8 WHERE name = 'Jane'")
+	execSQL(t, a, "DELETE FROM users WHERE name = 'John'")
+	beginAt(t, b, levelReadCommitted)
+	done := make(chan result, 1)
+	go func() {
+		res, err := execSQLErr(b, "UPDATE users SET age = 0 WHERE age > 7")
+		assert.NoError(t, err)
+		done <- res
+	}()
+	waitForLock(a.db.locks, b.tx.id)
+	execSQL(t, a, "COMMIT")
+	assert.Equal(t, 1, (<-done).rowsAffected)
+	execSQL(t, b, "COMMIT")
+	assert.Equal(t, "Jane 0", displayRows(t, execSQL(t, a, "SELECT * FROM users")))
+
+	// A statement failing again and again is given up on, rolling the
+	// transaction back
+	beginAt(t, b, levelReadCommitted)
+	calls := 0
+	_, err := b.autocommit(func() (result, error) {
+		calls++
+		return result{}, ErrSerialization
+	})
+	assert.Equal(t, ErrSerialization, err)
+	assert.Equal(t, maxStatementRetries+1, calls)
+	assert.False(t, b.inTransaction())
+}
+
+func Test_executor_executeIsolation(t *testing.T) {
+	e := newExecutor(exeConfig{order: 3})
+	execSQL(t, e, "CREATE TABLE users (name string NOT NULL, age integer)")
+
+	_, err := execSQLErr(e, "SET TRANSACTION ISOLATION LEVEL SERIALIZABLE")
+	assert.Equal(t, errNoTransaction, err)
+
+	execSQL(t, e, "BEGIN")
+	execSQL(t, e, "SET TRANSACTION ISOLATION LEVEL SERIALIZABLE")
+	execSQL(t, e, "SET TRANSACTION ISOLATION LEVEL READ COMMITTED")
+	assert.Equal(t, levelReadCommitted, e.tx.level)
+	assert.Nil(t, e.tx.sx)
+	assert.Empty(t, e.db.ssi.xacts)
+
+	execSQL(t, e, "SELECT * FROM users")
+	_, err = execSQLErr(e, "SET TRANSACTION ISOLATION LEVEL SERIALIZABLE")
+	assert.EqualError(t, err, "the isolation level must be set before any statement is executed")
+	execSQL(t, e, "COMMIT")
+
+	// Each transaction is repeatable read unless set otherwise
+	execSQL(t, e, "BEGIN")
+	assert.Equal(t, levelRepeatableRead, e.tx.level)
+	execSQL(t, e, "ROLLBACK")
+}
@@ -0,0 +1,350 @@
+package lbadd
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrDeadlock is returned to a transaction chosen to break a deadlock, when
+// transactions wait for each other's locks. The transaction is rolled back,
+// and may be retried.
+var ErrDeadlock = errors.New("deadlock detected")
+
+// ErrLockTimeout is returned when a statement waits for a lock for longer
+// than the lock timeout. The statement has no effect.
+var ErrLockTimeout = errors.New("lock timeout exceeded")
+
+// lockMode is the mode a lock is held in. A table is locked in one of the
+// intention modes before any of its rows are locked, so that locks on the
+// table as a whole conflict with those on its rows. Rows are locked shared by
+// SELECT ... FOR SHARE, and exclusively by the statements changing them and
+// SELECT ... FOR UPDATE.
+type lockMode int
+
+const (
+	lockIntentShared    lockMode = iota + 1 // rows of the table will be locked shared
+	lockIntentExclusive                     // rows of the table will be locked exclusive
+	lockShared                              // read by several transactions at once
+	lockExclusive                           // changed by a single transaction
+)
+
+func (m lockMode) String() string {
+	switch m {
+	case lockIntentShared:
+		return "IS"
+	case lockIntentExclusive:
+		return "IX"
+	case lockShared:
+		return "S"
+	case lockExclusive:
+		return "X"
+	default:
+		return "unknown"
+	}
+}
+
+// compatible reports whether locks of the two modes may be held at once by
+// different transactions.
+func (m lockMode) compatible(o lockMode) bool {
+	switch m {
+	case lockIntentShared:
+		return o != lockExclusive
+	case lockIntentExclusive:
+		return o == lockIntentShared || o == lockIntentExclusive
+	case lockShared:
+		return o == lockIntentShared || o == lockShared
+	default:
+		return false
+	}
+}
+
+// covers reports whether holding a lock in the mode allows everything a
+// lock in the other mode does.
+func (m lockMode) covers(o lockMode) bool {
+	switch m {
+	case lockExclusive:
+		return true
+	case lockShared, lockIntentExclusive:
+		return o == m || o == lockIntentShared
+	default:
+		return o == m
+	}
+}
+
+// join returns the weakest mode covering both modes, which a lock is
+// upgraded to.
+func (m lockMode) join(o lockMode) lockMode {
+	switch {
+	case m.covers(o):
+		return m
+	case o.covers(m):
+		return o
+	default:
+		return lockExclusive
+	}
+}
+
+// lockTarget is a table, or a row of a table, which may be locked.
+type lockTarget struct {
+	table string
+	key   key
+	row   bool // whether a row with the key is locked, rather than the table
+}
+
+func tableTarget(name string) lockTarget {
+	return lockTarget{table: name}
+}
+
+func rowTarget(name string, k key) lockTarget {
+	return lockTarget{table: name, key: k, row: true}
+}
+
+// lockRequest is a transaction waiting for a lock.
+type lockRequest struct {
+	tx   txid
+	mode lockMode
+	done chan error // receives nil once the lock is granted, or why it wasn't
+}
+
+// lockState holds the locks granted on a target, and the requests waiting
+// for it in the order they will be granted.
+type lockState struct {
+	granted map[txid]lockMode
+	queue   []*lockRequest
+}
+
+// grantable reports whether the transaction may be granted a lock in the
+// mode alongside those already granted to others.
+func (st *lockState) grantable(tx txid, mode lockMode) bool {
+	for other, m := range st.granted {
+		if other != tx && !m.compatible(mode) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// lockManager grants locks on tables and rows to transactions, which hold
+// them until they end. Requests which conflict with the locks granted wait
+// in turn, and a deadlock among waiting transactions is broken by aborting
+// the youngest.
+type lockManager struct {
+	mu      sync.Mutex
+	locks   map[lockTarget]*lockState
+	held    map[txid][]lockTarget  // the targets each transaction holds locks on
+	waiting map[txid]waitingOnLock // the request each waiting transaction made
+}
+
+type waitingOnLock struct {
+	target lockTarget
+	req    *lockRequest
+}
+
+func newLockManager() *lockManager {
+	return &lockManager{
+		locks:   make(map[lockTarget]*lockState),
+		held:    make(map[txid][]lockTarget),
+		waiting: make(map[txid]waitingOnLock),
+	}
+}
+
+// acquire locks the target in the mode for the transaction, upgrading any
+// lock it already holds, and waiting for conflicting locks to be released.
+// The wait ends with an error if the context is done, if the timeout passes,
+// when it is positive, or if the transaction is aborted to break a deadlock.
+func (m *lockManager) acquire(ctx context.Context, tx txid, target lockTarget, mode lockMode, timeout time.Duration) error {
+	m.mu.Lock()
+
+	st := m.locks[target]
+	if st == nil {
+		st = &lockState{granted: make(map[txid]lockMode)}
+		m.locks[target] = st
+	}
+
+	held, holds := st.granted[tx]
+	if holds && held.covers(mode) {
+		m.mu.Unlock()
+		return nil
+	}
+	if holds {
+		mode = held.join(mode)
+	}
+
+	// Upgrades are granted ahead of the requests waiting, other requests
+	// wait their turn
+	if st.grantable(tx, mode) && (holds || len(st.queue) == 0) {
+		m.grant(st, target, tx, mode)
+		m.mu.Unlock()
+		return nil
+	}
+
+	req := &lockRequest{tx: tx, mode: mode, done: make(chan error, 1)}
+	if holds {
+		st.queue = append([]*lockRequest{req}, st.queue...)
+	} else {
+		st.queue = append(st.queue, req)
+	}
+	m.waiting[tx] = waitingOnLock{target: target, req: req}
+	if victim := m.findDeadlock(tx); victim != 0 {
+		m.abort(victim, ErrDeadlock)
+	}
+	m.mu.Unlock()
+
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+
+	select {
+	case err := <-req.done:
+		return err
+	case <-ctx.Done():
+		return m.cancel(req, checkContext(ctx))
+	case <-expired:
+		return m.cancel(req, ErrLockTimeout)
+	}
+}
+
+// grant grants the lock in the mode to the transaction.
+func (m *lockManager) grant(st *lockState, target lockTarget, tx txid, mode lockMode) {
+	if _, holds := st.granted[tx]; !holds {
+		m.held[tx] = append(m.held[tx], target)
+	}
+	st.granted[tx] = mode
+}
+
+// cancel stops the request from waiting, returning err, unless it has been
+// granted or aborted in the meantime.
+func (m *lockManager) cancel(req *lockRequest, err error) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if w, waiting := m.waiting[req.tx]; waiting && w.req == req {
+		m.dequeue(w)
+		return err
+	}
+
+	return <-req.done
+}
+
+// abort stops the transaction from waiting, its request failing with err.
+func (m *lockManager) abort(tx txid, err error) {
+	w := m.waiting[tx]
+	m.dequeue(w)
+	w.req.done <- err
+}
+
+// dequeue removes the request from those waiting, granting any it was
+// holding up.
+func (m *lockManager) dequeue(w waitingOnLock) {
+	delete(m.waiting, w.req.tx)
+
+	st := m.locks[w.target]
+	for i, r := range st.queue {
+		if r == w.req {
+			st.queue = append(st.queue[:i], st.queue[i+1:]...)
+			break
+		}
+	}
+	m.wake(w.target)
+}
+
+// wake grants the requests waiting for the target in turn, until one can't
+// be granted.
+func (m *lockManager) wake(target lockTarget) {
+	st := m.locks[target]
+	for len(st.queue) > 0 {
+		req := st.queue[0]
+		if !st.grantable(req.tx, req.mode) {
+			return
+		}
+
+		st.queue = st.queue[1:]
+		delete(m.waiting, req.tx)
+		m.grant(st, target, req.tx, req.mode)
+		req.done <- nil
+	}
+
+	if len(st.granted) == 0 {
+		delete(m.locks, target)
+	}
+}
+
+// releaseAll releases every lock the transaction holds, once it has ended.
+func (m *lockManager) releaseAll(tx txid) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, target := range m.held[tx] {
+		delete(m.locks[target].granted, tx)
+		m.wake(target)
+	}
+	delete(m.held, tx)
+}
+
+// waitsFor returns the transactions the waiting transaction waits for: those
+// holding conflicting locks, and those whose requests are ahead of its own.
+func (m *lockManager) waitsFor(tx txid) []txid {
+	w, waiting := m.waiting[tx]
+	if !waiting {
+		return nil
+	}
+
+	st := m.locks[w.target]
+	blockers := []txid{}
+	for other, mode := range st.granted {
+		if other != tx && !mode.compatible(w.req.mode) {
+			blockers = append(blockers, other)
+		}
+	}
+	for _, r := range st.queue {
+		if r == w.req {
+			break
+		}
+		blockers = append(blockers, r.tx)
+	}
+
+	return blockers
+}
+
+// findDeadlock searches the graph of which transactions wait for which for a
+// cycle through the transaction, returning the youngest transaction of the
+// cycle, or zero if there isn't one.
+func (m *lockManager) findDeadlock(start txid) txid {
+	path := []txid{}
+	visited := map[txid]bool{start: true}
+
+	var visit func(tx txid) bool
+	visit = func(tx txid) bool {
+		path = append(path, tx)
+		for _, next := range m.waitsFor(tx) {
+			if next == start {
+				return true
+			}
+			if !visited[next] {
+				visited[next] = true
+				if visit(next) {
+					return true
+				}
+			}
+		}
+		path = path[:len(path)-1]
+		return false
+	}
+	if !visit(start) {
+		return 0
+	}
+
+	victim := start
+	for _, tx := range path {
+		if tx > victim {
+			victim = tx
+		}
+	}
+	return victim
+}
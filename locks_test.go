@@ -0,0 +1,276 @@
+package lbadd
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_lockMode(t *testing.T) {
+	modes := []lockMode{lockIntentShared, lockIntentExclusive, lockShared, lockExclusive}
+
+	// Rows are the mode held, columns the mode requested
+	compatible := [][]bool{
+		{true, true, true, false},
+		{true, true, false, false},
+		{true, false, true, false},
+		{false, false, false, false},
+	}
+	joined := [][]lockMode{
+		{lockIntentShared, lockIntentExclusive, lockShared, lockExclusive},
+		{lockIntentExclusive, lockIntentExclusive, lockExclusive, lockExclusive},
+		{lockShared, lockExclusive, lockShared, lockExclusive},
+		{lockExclusive, lockExclusive, lockExclusive, lockExclusive},
+	}
+
+	for i, m := range modes {
+		for j, o := range modes {
+			t.Run(m.String()+" "+o.String(), func(t *testing.T) {
+				assert.Equal(t, compatible[i][j], m.compatible(o))
+				assert.Equal(t, joined[i][j], m.join(o))
+				assert.Equal(t, joined[i][j] == m, m.covers(o))
+			})
+		}
+	}
+}
+
+// acquireAsync acquires the lock in a goroutine, returning a channel which
+// receives the result.
+func acquireAsync(m *lockManager, tx txid, target lockTarget, mode lockMode) <-chan error {
+	done := make(chan error, 1)
+	go func() {
+		done <- m.acquire(context.Background(), tx, target, mode, 0)
+	}()
+	return done
+}
+
+// waitForLock waits until the transaction is waiting for a lock.
+func waitForLock(m *lockManager, tx txid) {
+	for {
+		m.mu.Lock()
+		_, waiting := m.waiting[tx]
+		m.mu.Unlock()
+		if waiting {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func Test_lockManager(t *testing.T) {
+	ctx := context.Background()
+	m := newLockManager()
+	users := tableTarget("users")
+
+	// Shared locks are held together, and upgraded once the others are
+	// released
+	assert.NoError(t, m.acquire(ctx, 1, users, lockShared, 0))
+	assert.NoError(t, m.acquire(ctx, 2, users, lockIntentShared, 0))
+	assert.NoError(t, m.acquire(ctx, 2, users, lockShared, 0))
+	upgraded := acquireAsync(m, 1, users, lockExclusive)
+	waitForLock(m, 1)
+
+	// Requests wait in turn, behind the upgrade
+	queued := acquireAsync(m, 3, users, lockIntentShared)
+	waitForLock(m, 3)
+	m.releaseAll(2)
+	assert.NoError(t, <-upgraded)
+	m.mu.Lock()
+	assert.Equal(t, map[txid]lockMode{1: lockExclusive}, m.locks[users].granted)
+	m.mu.Unlock()
+
+	m.releaseAll(1)
+	assert.NoError(t, <-queued)
+	m.releaseAll(3)
+	assert.Empty(t, m.locks)
+	assert.Empty(t, m.held)
+}
+
+func Test_lockManager_deadlock(t *testing.T) {
+	ctx := context.Background()
+	m := newLockManager()
+	first, second := rowTarget("users", 1), rowTarget("users", 2)
+
+	assert.NoError(t, m.acquire(ctx, 1, first, lockExclusive, 0))
+	assert.NoError(t, m.acquire(ctx, 2, second, lockExclusive, 0))
+	older := acquireAsync(m, 1, second, lockExclusive)
+	waitForLock(m, 1)
+
+	// The younger transaction is chosen to break the deadlock, and the older
+	// is granted the lock once it has rolled back
+	assert.Equal(t, ErrDeadlock, m.acquire(ctx, 2, first, lockExclusive, 0))
+	m.releaseAll(2)
+	assert.NoError(t, <-older)
+
+	// When the older transaction closes the cycle, the younger is aborted
+	// while waiting
+	assert.NoError(t, m.acquire(ctx, 3, rowTarget("users", 3), lockExclusive, 0))
+	younger := acquireAsync(m, 3, second, lockExclusive)
+	waitForLock(m, 3)
+	granted := acquireAsync(m, 1, rowTarget("users", 3), lockExclusive)
+	assert.Equal(t, ErrDeadlock, <-younger)
+	m.releaseAll(3)
+	assert.NoError(t, <-granted)
+}
+
+func Test_lockManager_timeout(t *testing.T) {
+	m := newLockManager()
+	users := tableTarget("users")
+	assert.NoError(t, m.acquire(context.Background(), 1, users, lockIntentExclusive, 0))
+
+	err := m.acquire(context.Background(), 2, users, lockShared, 10*time.Millisecond)
+	assert.Equal(t, ErrLockTimeout, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = m.acquire(ctx, 2, users, lockShared, 0)
+	assert.True(t, errors.Is(err, ErrCanceled))
+
+	// Requests which gave up waiting no longer hold up others
+	m.mu.Lock()
+	assert.Empty(t, m.waiting)
+	assert.Empty(t, m.locks[users].queue)
+	m.mu.Unlock()
+	assert.NoError(t, m.acquire(context.Background(), 3, users, lockIntentShared, 0))
+}
+
+func Test_executor_locks(t *testing.T) {
+	a := newExecutor(exeConfig{order: 3})
+	b := a.newSession()
+	execSQL(t, a, "CREATE TABLE users (name string NOT NULL, age integer)")
+	execSQL(t, a, "INSERT INTO users VALUES ('Jane', 7), ('John', 42)")
+
+	update := func(s *executor, sql string) <-chan error {
+		done := make(chan error, 1)
+		go func() {
+			_, err := execSQLErr(s, sql)
+			done <- err
+		}()
+		return done
+	}
+
+	// A row being changed by a transaction in progress is changed by
+	// another once it rolls back
+	execSQL(t, a, "BEGIN")
+	execSQL(t, a, "UPDATE users SET age = 1 WHERE name = 'Jane'")
+	execSQL(t, b, "BEGIN")
+	updated := update(b, "UPDATE users SET age = 2 WHERE name = 'Jane'")
+	waitForLock(a.db.locks, b.tx.id)
+	execSQL(t, a, "ROLLBACK")
+	assert.NoError(t, <-updated)
+	execSQL(t, b, "COMMIT")
+
+	// Or fails once it commits
+	execSQL(t, a, "BEGIN")
+	execSQL(t, a, "UPDATE users SET age = 3 WHERE name = 'Jane'")
+	execSQL(t, b, "BEGIN")
+	updated = update(b, "UPDATE users SET age = 4 WHERE name = 'Jane'")
+	waitForLock(a.db.locks, b.tx.id)
+	execSQL(t, a, "COMMIT")
+	assert.True(t, errors.Is(<-updated, ErrSerialization))
+	assert.False(t, b.inTransaction())
+	assert.Equal(t, "Jane 3|John 42", displayRows(t, execSQL(t, b, "SELECT * FROM users")))
+
+	// Transactions changing each other's rows are deadlocked, and the
+	// younger is rolled back
+	execSQL(t, a, "BEGIN")
+	execSQL(t, b, "BEGIN")
+	execSQL(t, a, "UPDATE users SET age = 5 WHERE name = 'Jane'")
+	execSQL(t, b, "UPDATE users SET age = 6 WHERE name = 'John'")
+	updated = update(a, "UPDATE users SET age = 7 WHERE name = 'John'")
+	waitForLock(a.db.locks, a.tx.id)
+	_, err := execSQLErr(b, "UPDATE users SET age = 8 WHERE name = 'Jane'")
+	assert.True(t, errors.Is(err, ErrDeadlock))
+	assert.False(t, b.inTransaction())
+	assert.NoError(t, <-updated)
+	execSQL(t, a, "COMMIT")
+	assert.Equal(t, "Jane 5|John 7", displayRows(t, execSQL(t, b, "SELECT * FROM users")))
+
+	// A statement waiting too long for a lock has no effect, but the
+	// transaction stays in progress
+	b.cfg.lockTimeout = 10 * time.Millisecond
+	execSQL(t, a, "BEGIN")
+	execSQL(t, a, "DELETE FROM users WHERE name = 'John'")
+	execSQL(t, b, "BEGIN")
+	execSQL(t, b, "INSERT INTO users VALUES ('Ann', 3)")
+	_, err = execSQLErr(b, "UPDATE users SET age = 0")
+	assert.True(t, errors.Is(err, ErrLockTimeout))
+	assert.True(t, b.inTransaction())
+	assert.Equal(t, "Jane 5|John 7|Ann 3", displayRows(t, execSQL(t, b, "SELECT * FROM users")))
+	execSQL(t, a, "ROLLBACK")
+	execSQL(t, b, "COMMIT")
+}
+
+func Test_executor_lockingReads(t *testing.T) {
+	a := newExecutor(exeConfig{order: 3})
+	b := a.newSession()
+	execSQL(t, a, "CREATE TABLE users (name string NOT NULL, age integer)")
+	execSQL(t, a, "INSERT INTO users VALUES ('Jane', 7), ('John', 42)")
+
+	run := func(s *executor, sql string) <-chan error {
+		done := make(chan error, 1)
+		go func() {
+			_, err := execSQLErr(s, sql)
+			done <- err
+		}()
+		return done
+	}
+
+	// Rows read for share are shared, and a transaction changing one of
+	// them upgrades its lock once the others reading it end
+	execSQL(t, a, "BEGIN")
+	execSQL(t, b, "BEGIN")
+	assert.Equal(t, "Jane 7", displayRows(t, execSQL(t, a, "SELECT * FROM users WHERE name = 'Jane' FOR SHARE")))
+	assert.Equal(t, "Jane 7", displayRows(t, execSQL(t, b, "SELECT * FROM users WHERE name = 'Jane' FOR SHARE")))
+	updated := run(a, "UPDATE users SET age = 8 WHERE name = 'Jane'")
+	waitForLock(a.db.locks, a.tx.id)
+	execSQL(t, b, "COMMIT")
+	assert.NoError(t, <-updated)
+	execSQL(t, a, "COMMIT")
+
+	// Transactions both upgrading a lock they share are deadlocked, and the
+	// younger is rolled back
+	execSQL(t, a, "BEGIN")
+	execSQL(t, b, "BEGIN")
+	execSQL(t, a, "SELECT * FROM users WHERE name = 'John' FOR SHARE")
+	execSQL(t, b, "SELECT * FROM users WHERE name = 'John' FOR SHARE")
+	updated = run(a, "UPDATE users SET age = 43 WHERE name = 'John'")
+	waitForLock(a.db.locks, a.tx.id)
+	_, err := execSQLErr(b, "DELETE FROM users WHERE name = 'John'")
+	assert.True(t, errors.Is(err, ErrDeadlock))
+	assert.False(t, b.inTransaction())
+	assert.NoError(t, <-updated)
+	execSQL(t, a, "COMMIT")
+
+	// Rows read for update can't be read for share until the transaction
+	// ends. If it changed them, a snapshot can't read them for share
+	execSQL(t, a, "BEGIN")
+	execSQL(t, b, "BEGIN")
+	assert.Equal(t, "Jane 8", displayRows(t, execSQL(t, a, "SELECT * FROM users WHERE age < 10 FOR UPDATE")))
+	execSQL(t, a, "UPDATE users SET age = 9 WHERE name = 'Jane'")
+	read := run(b, "SELECT * FROM users WHERE name = 'Jane' FOR SHARE")
+	waitForLock(a.db.locks, b.tx.id)
+	execSQL(t, a, "COMMIT")
+	assert.True(t, errors.Is(<-read, ErrSerialization))
+	assert.False(t, b.inTransaction())
+
+	// But a statement at read committed reads them as it left them
+	execSQL(t, a, "BEGIN")
+	beginAt(t, b, levelReadCommitted)
+	execSQL(t, a, "UPDATE users SET age = 10 WHERE name = 'Jane'")
+	read = run(b, "SELECT * FROM users WHERE name = 'Jane' FOR SHARE")
+	waitForLock(a.db.locks, b.tx.id)
+	execSQL(t, a, "COMMIT")
+	assert.NoError(t, <-read)
+	assert.Equal(t, "Jane 10", displayRows(t, execSQL(t, b, "SELECT * FROM users WHERE name = 'Jane' FOR SHARE")))
+	execSQL(t, b, "COMMIT")
+
+	// Plain reads take no locks
+	execSQL(t, a, "BEGIN")
+	execSQL(t, a, "SELECT * FROM users FOR UPDATE")
+	assert.Equal(t, "Jane 10|John 43", displayRows(t, execSQL(t, b, "SELECT * FROM users")))
+	execSQL(t, a, "COMMIT")
+}
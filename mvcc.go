@@ -0,0 +1,257 @@
+package lbadd
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+)
+
+// Concurrency is controlled by keeping multiple versions of rows and tables.
+// Each version is stamped with the transaction which created it, and the one
+// which ended it by deleting, updating or dropping it. A transaction takes a
+// snapshot of the transactions in progress as it begins, and only sees the
+// versions created by those committed before then, which weren't also ended
+// by one of them. Readers therefore see a consistent snapshot of the database
+// without blocking writers, or being blocked by them.
+//
+// Changes are made in place as they happen, so a transaction which is rolled
+// back undoes its changes before it ends, and only the versions of committed
+// transactions, or those in progress, are ever seen.
+
+// ErrSerialization is returned when a transaction changes a row or table
+// which a concurrent transaction has already changed. The transaction is
+// rolled back, and may be retried.
+var ErrSerialization = errors.New("could not serialize access due to a concurrent update")
+
+// txid identifies a transaction, in the order transactions began. The zero
+// txid isn't a transaction.
+type txid uint64
+
+// stamp records the transactions which created and ended a version.
+type stamp struct {
+	xmin txid // the transaction which created the version
+	xmax txid // the transaction which ended it, zero if none has
+}
+
+// deadBefore reports whether the version was ended by a transaction before
+// the horizon, which every transaction in progress sees as committed.
+func (s stamp) deadBefore(horizon txid) bool {
+	return s.xmax != 0 && s.xmax < horizon
+}
+
+// version of a row, held as the value of the row's entry in its table's
+// storage, followed by the older versions it replaced.
+type version struct {
+	stamp
+	row   row
+	older *version
+}
+
+// tableVersion is a version of a table in the catalog, followed by the
+// older versions of the table with the same name.
+type tableVersion struct {
+	stamp
+	table
+	older *tableVersion
+}
+
+// snapshot of the transactions committed as a transaction began.
+type snapshot struct {
+	xmin   txid          // every transaction before xmin had ended
+	xmax   txid          // no transaction from xmax on had begun
+	active map[txid]bool // the transactions in progress in between
+}
+
+// sees reports whether the transaction had been committed as the snapshot
+// was taken.
+func (s snapshot) sees(id txid) bool {
+	return id < s.xmin || (id < s.xmax && !s.active[id])
+}
+
+// sees reports whether the transaction sees the changes of the transaction
+// with the id: its own, and those committed before it began.
+func (tx *transaction) sees(id txid) bool {
+	return id == tx.id || tx.snap.sees(id)
+}
+
+// visible reports whether the version with the stamp is visible to the
+// transaction.
+func (tx *transaction) visible(s stamp) bool {
+	return tx.sees(s.xmin) && (s.xmax == 0 || !tx.sees(s.xmax))
+}
+
+// gone reports whether the version with the stamp has been ended, as far as
+// the transaction can see, so that its row or table no longer exists.
+func (tx *transaction) gone(s stamp) bool {
+	return tx.sees(s.xmin) && s.xmax != 0 && tx.sees(s.xmax)
+}
+
+// visibleRow returns the row of the version visible to the transaction,
+// among the versions starting from v, or nil if none is.
+func (tx *transaction) visibleRow(v *version) row {
+	for ; v != nil; v = v.older {
+		if tx.visible(v.stamp) {
+			return v.row
+		}
+	}
+
+	return nil
+}
+
+// txnManager hands out the ids of transactions, and keeps track of those in
+// progress.
+type txnManager struct {
+	mu     sync.Mutex
+	next   txid          // the id of the next transaction to begin
+	active map[txid]txid // the transactions in progress, to their snapshots' xmin
+}
+
+func newTxnManager() *txnManager {
+	return &txnManager{next: 1, active: make(map[txid]txid)}
+}
+
+// begin registers a new transaction, returning its id and snapshot.
+func (m *txnManager) begin() (txid, snapshot) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	snap := m.snapshot()
+	id := m.next
+	m.next++
+	m.active[id] = snap.xmin
+
+	return id, snap
+}
+
+// refresh returns a new snapshot for the transaction in progress, seeing the
+// transactions committed since it began.
+func (m *txnManager) refresh(id txid) snapshot {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	snap := m.snapshot()
+	m.active[id] = snap.xmin
+	return snap
+}
+
+// snapshot returns a snapshot seeing the transactions committed so far. The
+// caller must hold m.mu.
+func (m *txnManager) snapshot() snapshot {
+	snap := snapshot{xmin: m.next, xmax: m.next, active: make(map[txid]bool, len(m.active))}
+	for a := range m.active {
+		snap.active[a] = true
+		if a < snap.xmin {
+			snap.xmin = a
+		}
+	}
+
+	return snap
+}
+
+// end removes the transaction from those in progress, once it has been
+// committed, or rolled back and its changes undone.
+func (m *txnManager) end(id txid) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.active, id)
+}
+
+// horizon returns the oldest transaction any transaction in progress may not
+// see as committed. Versions ended before the horizon are seen by none.
+func (m *txnManager) horizon() txid {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	h := m.next
+	for _, xmin := range m.active {
+		if xmin < h {
+			h = xmin
+		}
+	}
+
+	return h
+}
+
+// The number of versions ended by committed transactions after which the
+// database is vacuumed
+const vacuumThreshold = 1000
+
+// collect counts the versions a committed transaction ended towards the
+// next vacuum, vacuuming the database once there are enough.
+func (d *db) collect(ended int) {
+	if ended == 0 || atomic.AddInt64(&d.garbage, int64(ended)) < vacuumThreshold {
+		return
+	}
+	if atomic.SwapInt64(&d.garbage, 0) >= vacuumThreshold {
+		d.vacuum()
+	}
+}
+
+// vacuum removes the versions of tables and rows which are no longer visible
+// to any transaction, along with rows which have been deleted.
+func (d *db) vacuum() {
+	horizon := d.txns.horizon()
+
+	d.mu.Lock()
+	tables := []table{}
+	for name, head := range d.tables {
+		if head.deadBefore(horizon) {
+			delete(d.tables, name)
+			continue
+		}
+		for v := head; v != nil; v = v.older {
+			tables = append(tables, v.table)
+			if v.older != nil && v.older.deadBefore(horizon) {
+				v.older = nil
+			}
+		}
+	}
+	d.mu.Unlock()
+
+	for _, t := range tables {
+		vacuumTable(t, horizon)
+	}
+}
+
+// vacuumTable removes the versions of the table's rows ended before the
+// horizon. The rows with such versions are found first, and then pruned one
+// at a time, so that the table isn't locked for long.
+func vacuumTable(t table, horizon txid) {
+	keys := []key{}
+	_ = t.store.scan(context.Background(), func(e *entry) bool {
+		for v, _ := e.value.(*version); v != nil; v = v.older {
+			if v.deadBefore(horizon) {
+				keys = append(keys, e.key)
+				break
+			}
+		}
+		return true
+	})
+
+	for _, k := range keys {
+		t.store.update(k, func(v value) value {
+			head, _ := v.(*version)
+			return prune(head, horizon)
+		})
+	}
+}
+
+// prune removes the versions ended before the horizon from those starting
+// from head, returning the versions left, or nil if there are none. Versions
+// are ended in order, so once one has been pruned, so are all older ones.
+func prune(head *version, horizon txid) value {
+	if head == nil || head.deadBefore(horizon) {
+		return nil
+	}
+
+	for v := head; v.older != nil; v = v.older {
+		if v.older.deadBefore(horizon) {
+			v.older = nil
+			break
+		}
+	}
+
+	return head
+}
@@ -0,0 +1,220 @@
+package lbadd
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_snapshot_sees(t *testing.T) {
+	m := newTxnManager()
+	first, _ := m.begin()
+	second, _ := m.begin()
+	m.end(first)
+	third, snap := m.begin()
+
+	tests := []struct {
+		name string
+		id   txid
+		want bool
+	}{
+		{name: "committed before", id: first, want: true},
+		{name: "in progress", id: second, want: false},
+		{name: "itself", id: third, want: false},
+		{name: "begun after", id: third + 1, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, snap.sees(tt.id))
+		})
+	}
+
+	// The second transaction began while the first was in progress, so
+	// versions ended by the first must be kept for it
+	assert.Equal(t, first, m.horizon())
+	m.end(second)
+	m.end(third)
+	assert.Equal(t, third+1, m.horizon())
+}
+
+func Test_executor_snapshotIsolation(t *testing.T) {
+	a := newExecutor(exeConfig{order: 3})
+	b := a.newSession()
+	execSQL(t, a, "CREATE TABLE users (name string NOT NULL, age integer)")
+	execSQL(t, a, "INSERT INTO users VALUES ('Jane', 7), ('John', 42)")
+
+	execSQL(t, a, "BEGIN")
+	assert.Equal(t, "Jane 7|John 42", displayRows(t, execSQL(t, a, "SELECT * FROM users")))
+
+	// Changes committed after the transaction began aren't seen by it
+	execSQL(t, b, "INSERT INTO users VALUES ('Ann', 3)")
+	execSQL(t, b, "UPDATE users SET age = 8 WHERE name = 'Jane'")
+	execSQL(t, b, "DELETE FROM users WHERE name = 'John'")
+	execSQL(t, b, "CREATE TABLE new (a integer)")
+	assert.Equal(t, "Jane 7|John 42", displayRows(t, execSQL(t, a, "SELECT * FROM users")))
+	assert.Equal(t, []string{"users"}, a.tableNames())
+
+	// Changes which haven't been committed are only seen by their own
+	// transaction
+	execSQL(t, b, "BEGIN")
+	execSQL(t, b, "INSERT INTO new VALUES (1)")
+	assert.Equal(t, "1", displayRows(t, execSQL(t, b, "SELECT * FROM new")))
+	execSQL(t, a, "COMMIT")
+	assert.Equal(t, "", displayRows(t, execSQL(t, a, "SELECT * FROM new")))
+	assert.Equal(t, "Jane 8|Ann 3", displayRows(t, execSQL(t, a, "SELECT * FROM users")))
+
+	// A table dropped after a transaction began can still be read by it
+	execSQL(t, b, "ROLLBACK")
+	execSQL(t, a, "BEGIN")
+	execSQL(t, b, "DROP TABLE users")
+	assert.Equal(t, "Jane 8|Ann 3", displayRows(t, execSQL(t, a, "SELECT * FROM users")))
+	execSQL(t, a, "COMMIT")
+	_, err := execSQLErr(a, "SELECT * FROM users")
+	assert.EqualError(t, err, "table users does not exist")
+}
+
+func Test_executor_serializationErrors(t *testing.T) {
+	cases := []struct {
+		name   string
+		first  string // executed in a transaction which commits
+		second string // executed in a concurrent transaction begun before
+	}{
+		{
+			name:   "update updated row",
+			first:  "UPDATE users SET age = 1 WHERE name = 'Jane'",
+			second: "UPDATE users SET age = 2",
+		},
+		{
+			name:   "delete updated row",
+			first:  "UPDATE users SET age = 1 WHERE name = 'Jane'",
+			second: "DELETE FROM users WHERE name = 'Jane'",
+		},
+		{
+			name:   "update deleted row",
+			first:  "DELETE FROM users WHERE name = 'Jane'",
+			second: "UPDATE users SET age = 2 WHERE name = 'Jane'",
+		},
+		{
+			name:   "create created table",
+			first:  "CREATE TABLE new (a integer)",
+			second: "CREATE TABLE new (b integer)",
+		},
+		{
+			name:   "drop dropped table",
+			first:  "DROP TABLE users",
+			second: "DROP TABLE users",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			a := newExecutor(exeConfig{order: 3})
+			b := a.newSession()
+			execSQL(t, a, "CREATE TABLE users (name string NOT NULL, age integer)")
+			execSQL(t, a, "INSERT INTO users VALUES ('Jane', 7), ('John', 42)")
+			execSQL(t, a, "CREATE TABLE log (a integer)")
+
+			execSQL(t, b, "BEGIN")
+			execSQL(t, b, "INSERT INTO log VALUES (1)")
+			execSQL(t, a, "BEGIN")
+			execSQL(t, a, tc.first)
+			execSQL(t, a, "COMMIT")
+
+			// The second transaction is rolled back as a whole
+			_, err := execSQLErr(b, tc.second)
+			assert.True(t, errors.Is(err, ErrSerialization))
+			assert.False(t, b.inTransaction())
+			assert.Equal(t, "", displayRows(t, execSQL(t, b, "SELECT * FROM log")))
+		})
+	}
+}
+
+func Test_db_vacuum(t *testing.T) {
+	a := newExecutor(exeConfig{order: 3})
+	b := a.newSession()
+	execSQL(t, a, "CREATE TABLE users (name string NOT NULL, age integer)")
+	execSQL(t, a, "INSERT INTO users VALUES ('Jane', 7), ('John', 42), ('Ann', 3)")
+	execSQL(t, a, "CREATE TABLE old (a integer)")
+	users, err := a.lookupTable("users")
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	versions := func() int {
+		n := 0
+		for _, en := range users.store.getAll(-1) {
+			for v := en.value.(*version); v != nil; v = v.older {
+				n++
+			}
+		}
+		return n
+	}
+
+	execSQL(t, b, "BEGIN")
+	execSQL(t, b, "SELECT * FROM users")
+	execSQL(t, a, "UPDATE users SET age = 1 WHERE name = 'Jane'")
+	execSQL(t, a, "DELETE FROM users WHERE name = 'John'")
+	execSQL(t, a, "DROP TABLE old")
+
+	// The versions seen by a transaction in progress are kept
+	a.db.vacuum()
+	assert.Equal(t, 4, versions())
+	assert.Equal(t, []string{"old", "users"}, b.tableNames())
+	assert.Equal(t, "Jane 7|John 42|Ann 3", displayRows(t, execSQL(t, b, "SELECT * FROM users")))
+
+	// Once no transaction can see them, they are removed
+	execSQL(t, b, "COMMIT")
+	a.db.vacuum()
+	assert.Equal(t, 2, versions())
+	assert.Equal(t, 2, users.store.stats().entries)
+	assert.Equal(t, 1, len(a.db.tables))
+	assert.Equal(t, "Jane 1|Ann 3", displayRows(t, execSQL(t, a, "SELECT * FROM users")))
+
+	// The database is vacuumed as versions are ended
+	for i := 0; i < vacuumThreshold; i++ {
+		execSQL(t, a, fmt.Sprintf("UPDATE users SET age = %d WHERE name = 'Ann'", i))
+	}
+	assert.True(t, versions() < vacuumThreshold)
+}
+
+func Test_executor_concurrentSessions(t *testing.T) {
+	e := newExecutor(exeConfig{order: 3})
+	execSQL(t, e, "CREATE TABLE counters (n integer)")
+	execSQL(t, e, "INSERT INTO counters VALUES (0)")
+
+	// Each session increments the counter, retrying increments which
+	// conflict, so that none are lost
+	const sessions, increments = 8, 20
+	var wg sync.WaitGroup
+	for i := 0; i < sessions; i++ {
+		s := e.newSession()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for done := 0; done < increments; {
+				execSQL(t, s, "BEGIN")
+				n, err := decodeRecord(execSQL(t, s, "SELECT n FROM counters").rows[0][0], columnTypeInt)
+				if !assert.NoError(t, err) {
+					return
+				}
+
+				_, err = execSQLErr(s, fmt.Sprintf("UPDATE counters SET n = %d WHERE n = %d", n.(int64)+1, n))
+				if errors.Is(err, ErrSerialization) {
+					continue
+				}
+				if !assert.NoError(t, err) {
+					return
+				}
+				execSQL(t, s, "COMMIT")
+				done++
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, fmt.Sprint(sessions*increments), displayRows(t, execSQL(t, e, "SELECT * FROM counters")))
+}
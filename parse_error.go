@@ -0,0 +1,102 @@
+package lbadd
+
+import (
+	"fmt"
+	"strings"
+)
+
+// endOfStatement is used in the expected token set of a ParseError when the
+// statement could have ended at the offending token.
+const endOfStatement = "end of statement"
+
+// ParseError is returned when sql fails to parse. It describes the position
+// of the offending token in the input, and the set of tokens which would
+// have been accepted in its place.
+type ParseError struct {
+	Line     int      // 1-based line of the offending token
+	Column   int      // 1-based column of the offending token, in bytes
+	Token    string   // the offending token, empty at the end of the input
+	Expected []string // the tokens which would have been accepted instead
+	Context  string   // the kind of statement being parsed, if known
+
+	sql    string // the input which failed to parse
+	offset int    // byte offset of the offending token within sql
+}
+
+func newParseError(sql string, offset int, token, context string, expected []string) *ParseError {
+	line, lineStart := 1, 0
+	for i := 0; i < offset && i < len(sql); i++ {
+		if sql[i] == '\n' {
+			line++
+			lineStart = i + 1
+		}
+	}
+
+	return &ParseError{
+		Line:     line,
+		Column:   offset - lineStart + 1,
+		Token:    token,
+		Expected: expected,
+		Context:  context,
+		sql:      sql,
+		offset:   offset,
+	}
+}
+
+func (e *ParseError) Error() string {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "line %d, column %d: ", e.Line, e.Column)
+	if e.Context != "" {
+		fmt.Fprintf(&b, "at %s: ", e.Context)
+	}
+
+	if e.Token == "" {
+		b.WriteString("unexpected end of input")
+	} else {
+		fmt.Fprintf(&b, "unexpected %s", e.Token)
+	}
+
+	if len(e.Expected) > 0 {
+		b.WriteString(", expected ")
+		b.WriteString(joinAlternatives(e.Expected))
+	}
+
+	return b.String()
+}
+
+// Caret renders the line of input holding the offending token, followed by a
+// line with a caret pointing at the token, e.g.
+//
+//	SELECT a, b, c, FROM z
+//	                ^
+func (e *ParseError) Caret() string {
+	start := strings.LastIndexByte(e.sql[:e.offset], '\n') + 1
+	end := strings.IndexByte(e.sql[e.offset:], '\n')
+	if end == -1 {
+		end = len(e.sql)
+	} else {
+		end += e.offset
+	}
+
+	// Keep tabs in the padding so that the caret lines up with the token
+	// regardless of the tab width used to display it.
+	pad := []byte(e.sql[start:e.offset])
+	for i, c := range pad {
+		if c != '\t' {
+			pad[i] = ' '
+		}
+	}
+
+	return e.sql[start:end] + "\n" + string(pad) + "^"
+}
+
+// joinAlternatives joins the expected tokens into a readable list, e.g.
+// "a, b or c".
+func joinAlternatives(alts []string) string {
+	if len(alts) == 1 {
+		return alts[0]
+	}
+
+	return strings.Join(alts[:len(alts)-1], ", ") + " or " + alts[len(alts)-1]
+}
@@ -1,21 +1,26 @@
 package lbadd
 
 import (
-	"fmt"
-	"log"
+	"regexp"
 	"strings"
 )
 
 func parse(sql string) (query, error) {
+	return parseSpan(sql, span{start: 0, end: len(sql)})
+}
+
+// parseSpan parses the single statement located at s within sql. Positions
+// reported by parse errors are relative to the whole of sql.
+func parseSpan(sql string, s span) (query, error) {
 	p := parser{
-		cursor: 0,
-		sql:    sql,
+		cursor: s.start,
+		sql:    sql[:s.end],
 		step:   stepInit,
 		query:  query{},
 		err:    nil,
 	}
 
-	return p.parse()
+	return p.doParse()
 }
 
 type parser struct {
@@ -26,25 +31,8 @@ type parser struct {
 	err    error
 }
 
-func (p *parser) parse() (query, error) {
-	q, err := p.doParse()
-	if err != nil {
-		log.Printf("Err: failed to parse sql: %v", err)
-		return q, err
-	}
-
-	return q, nil
-}
-
 func (p *parser) doParse() (query, error) {
 	for {
-		// Check if we've hit the end of the query
-		if p.cursor >= len(p.sql) {
-			return p.query, p.err
-		}
-
-		fmt.Println("\nstep:", p.step.String())
-		fmt.Println("remaining:", p.sql[p.cursor:])
 		switch p.step {
 		case stepInit:
 			switch toUp(p.peek()) {
@@ -52,16 +40,103 @@ func (p *parser) doParse() (query, error) {
 				p.query.queryType = selectQuery
 				p.step = stepSelectField
 				p.pop()
+			case insertQuery.String():
+				p.query.queryType = insertQuery
+				p.pop()
+				if toUp(p.peek()) != "INTO" {
+					return p.query, p.unexpected("INTO")
+				}
+				p.pop()
+				p.step = stepInsertTable
+			case updateQuery.String():
+				p.query.queryType = updateQuery
+				p.step = stepUpdateTable
+				p.pop()
+			case deleteQuery.String():
+				p.query.queryType = deleteQuery
+				p.pop()
+				if toUp(p.peek()) != "FROM" {
+					return p.query, p.unexpected("FROM")
+				}
+				p.pop()
+				p.step = stepDeleteFromTable
+			case beginQuery.String():
+				p.query.queryType = beginQuery
+				p.step = stepTransaction
+				p.pop()
+			case commitQuery.String():
+				p.query.queryType = commitQuery
+				p.step = stepTransaction
+				p.pop()
+			case rollbackQuery.String():
+				p.query.queryType = rollbackQuery
+				p.step = stepTransaction
+				p.pop()
+			case savepointQuery.String():
+				p.query.queryType = savepointQuery
+				p.step = stepSavepointName
+				p.pop()
+			case releaseQuery.String():
+				p.query.queryType = releaseQuery
+				p.step = stepSavepointName
+				p.pop()
+			case "SET":
+				p.query.queryType = setTransactionQuery
+				p.pop()
+				if toUp(p.peek()) != "TRANSACTION" {
+					return p.query, p.unexpected("TRANSACTION")
+				}
+				p.pop()
+				p.step = stepIsolationLevel
+			case "CREATE":
+				p.query.queryType = createTableQuery
+				p.pop()
+				if toUp(p.peek()) != "TABLE" {
+					return p.query, p.unexpected("TABLE")
+				}
+				p.pop()
+				p.step = stepCreateTableName
+			case "DROP":
+				p.query.queryType = dropTableQuery
+				p.pop()
+				if toUp(p.peek()) != "TABLE" {
+					return p.query, p.unexpected("TABLE")
+				}
+				p.pop()
+				p.step = stepDropTableName
+			case copyQuery.String():
+				p.query.queryType = copyQuery
+				p.step = stepCopyTable
+				p.pop()
 			default:
-				return p.query, fmt.Errorf("unrecognised query type")
+				return p.query, p.unexpected(
+					selectQuery.String(),
+					insertQuery.String(),
+					updateQuery.String(),
+					deleteQuery.String(),
+					beginQuery.String(),
+					commitQuery.String(),
+					rollbackQuery.String(),
+					savepointQuery.String(),
+					releaseQuery.String(),
+					"SET",
+					"CREATE",
+					"DROP",
+					copyQuery.String(),
+				)
 			}
 
+		// SELECT
 		case stepSelectField:
-			field, _ := p.pop()
-			if toUp(field) == "FROM" {
-				return p.query, fmt.Errorf("at SELECT: unexpected FROM after comma")
+			field := "*"
+			if p.peek() == "*" {
+				p.pop()
+			} else {
+				var err error
+				if field, err = p.popField(); err != nil {
+					return p.query, err
+				}
 			}
-
 			p.query.fields = append(p.query.fields, field)
 
 			maybeFrom := toUp(p.peek())
@@ -73,36 +148,750 @@ func (p *parser) doParse() (query, error) {
 			p.step = stepSelectComma
 
 		case stepSelectComma:
-			maybeComma := p.sql[p.cursor]
-			if maybeComma != ',' {
-				return p.query, fmt.Errorf("at SELECT: expected comma or FROM")
+			if p.peek() != "," {
+				return p.query, p.unexpected(",", "FROM")
 			}
-			p.cursor++
+			p.pop()
 			p.step = stepSelectField
 
 		case stepSelectFrom:
-			val, _ := p.pop()
-			if strings.ToLower(val) == "from" {
-				p.step = stepSelectTable
-				break
+			if toUp(p.peek()) != "FROM" {
+				return p.query, p.unexpected("FROM")
 			}
-			return p.query, fmt.Errorf("at SELECT: expected FROM")
+			p.pop()
+			p.step = stepSelectTable
 
 		case stepSelectTable:
-			val, _ := p.pop()
-			p.query.tableName = val
+			name, ok := p.popIdentifier()
+			if !ok {
+				return p.query, p.unexpected("table name")
+			}
+			p.query.tableName = name
+			p.step = stepWhere
+
+		case stepSelectClause:
+			// The clauses following the condition are each optional, but
+			// must be given in order
+			expected := []string{}
+			switch {
+			case p.query.locking != "":
+			case p.query.offset != "":
+				expected = []string{"FOR"}
+			case p.query.limit != "":
+				expected = []string{"OFFSET", "FOR"}
+			case len(p.query.orderBy) > 0:
+				expected = []string{"LIMIT", "OFFSET", "FOR"}
+			case len(p.query.groupBy) > 0:
+				expected = []string{"ORDER", "LIMIT", "OFFSET", "FOR"}
+			case p.query.where != "":
+				expected = []string{"GROUP", "ORDER", "LIMIT", "OFFSET", "FOR"}
+			default:
+				expected = []string{"WHERE", "GROUP", "ORDER", "LIMIT", "OFFSET", "FOR"}
+			}
+
+			clause := toUp(p.peek())
+			if clause == "" {
+				return p.query, nil
+			}
+			allowed := false
+			for _, e := range expected {
+				allowed = allowed || e == clause
+			}
+			if !allowed {
+				return p.query, p.unexpected(append(expected, endOfStatement)...)
+			}
+			p.pop()
+
+			switch clause {
+			case "GROUP", "ORDER":
+				if toUp(p.peek()) != "BY" {
+					return p.query, p.unexpected("BY")
+				}
+				p.pop()
+				p.step = stepSelectGroupBy
+				if clause == "ORDER" {
+					p.step = stepSelectOrderBy
+				}
+			case "LIMIT":
+				p.step = stepSelectLimit
+			case "OFFSET":
+				p.step = stepSelectOffset
+			case "FOR":
+				p.step = stepSelectFor
+			}
+
+		case stepSelectGroupBy:
+			field, ok := p.popIdentifier()
+			if !ok {
+				return p.query, p.unexpected("field")
+			}
+			p.query.groupBy = append(p.query.groupBy, field)
+
+			if p.peek() == "," {
+				p.pop()
+				continue
+			}
+			p.step = stepSelectClause
+
+		case stepSelectOrderBy:
+			field, err := p.popField()
+			if err != nil {
+				return p.query, err
+			}
+			key := orderKey{field: field}
+			switch toUp(p.peek()) {
+			case "DESC":
+				key.desc = true
+				p.pop()
+			case "ASC":
+				p.pop()
+			}
+			p.query.orderBy = append(p.query.orderBy, key)
+
+			if p.peek() == "," {
+				p.pop()
+				continue
+			}
+			p.step = stepSelectClause
+
+		case stepSelectLimit, stepSelectOffset:
+			n := p.peek()
+			if !countPattern.MatchString(n) {
+				return p.query, p.unexpected("number of rows")
+			}
+			p.pop()
+			if p.step == stepSelectLimit {
+				p.query.limit = n
+			} else {
+				p.query.offset = n
+			}
+			p.step = stepSelectClause
+
+		case stepSelectFor:
+			locking := toUp(p.peek())
+			if locking != "SHARE" && locking != "UPDATE" {
+				return p.query, p.unexpected("SHARE", "UPDATE")
+			}
+			p.pop()
+			p.query.locking = locking
+			p.step = stepSelectClause
+
+		// INSERT
+		case stepInsertTable:
+			name, ok := p.popIdentifier()
+			if !ok {
+				return p.query, p.unexpected("table name")
+			}
+			p.query.tableName = name
+			p.step = stepInsertFieldsOpeningParens
+
+		case stepInsertFieldsOpeningParens:
+			// The list of fields is optional, in which case the values are
+			// given in the order of the table's columns
+			if toUp(p.peek()) == "VALUES" {
+				p.step = stepInsertValuesRWord
+				continue
+			}
+			if p.peek() != "(" {
+				return p.query, p.unexpected("(", "VALUES")
+			}
+			p.pop()
+			p.step = stepInsertFields
+
+		case stepInsertFields:
+			field, ok := p.popIdentifier()
+			if !ok {
+				return p.query, p.unexpected("field")
+			}
+			p.query.fields = append(p.query.fields, field)
+			p.step = stepInsertFieldsCommaOrClosingParens
+
+		case stepInsertFieldsCommaOrClosingParens:
+			switch p.peek() {
+			case ",":
+				p.step = stepInsertFields
+			case ")":
+				p.step = stepInsertValuesRWord
+			default:
+				return p.query, p.unexpected(",", ")")
+			}
+			p.pop()
+
+		case stepInsertValuesRWord:
+			if toUp(p.peek()) != "VALUES" {
+				return p.query, p.unexpected("VALUES")
+			}
+			p.pop()
+			p.step = stepInsertValuesOpeningParens
+
+		case stepInsertValuesOpeningParens:
+			if p.peek() != "(" {
+				return p.query, p.unexpected("(")
+			}
+			p.pop()
+			p.query.inserts = append(p.query.inserts, []string{})
+			p.step = stepInsertValues
+
+		case stepInsertValues:
+			val, ok := p.popValue()
+			if !ok {
+				return p.query, p.unexpected("value")
+			}
+			last := len(p.query.inserts) - 1
+			p.query.inserts[last] = append(p.query.inserts[last], val)
+			p.step = stepInsertValuesCommaOrClosingParens
+
+		case stepInsertValuesCommaOrClosingParens:
+			// Every row needs a value for each of the fields, or as many
+			// values as the first row if no fields were given
+			want := len(p.query.fields)
+			if want == 0 && len(p.query.inserts) > 1 {
+				want = len(p.query.inserts[0])
+			}
+			have := len(p.query.inserts[len(p.query.inserts)-1])
+
+			switch {
+			case want == 0:
+				if p.peek() != "," && p.peek() != ")" {
+					return p.query, p.unexpected(",", ")")
+				}
+			case have < want:
+				if p.peek() != "," {
+					return p.query, p.unexpected(",")
+				}
+			default:
+				if p.peek() != ")" {
+					return p.query, p.unexpected(")")
+				}
+			}
+
+			if val, _ := p.pop(); val == "," {
+				p.step = stepInsertValues
+				continue
+			}
+			p.step = stepInsertValuesCommaBeforeOpeningParens
+
+		case stepInsertValuesCommaBeforeOpeningParens:
+			if p.peek() == "" {
+				return p.query, nil
+			}
+			if p.peek() != "," {
+				return p.query, p.unexpected(",", endOfStatement)
+			}
+			p.pop()
+			p.step = stepInsertValuesOpeningParens
+
+		// UPDATE
+		case stepUpdateTable:
+			name, ok := p.popIdentifier()
+			if !ok {
+				return p.query, p.unexpected("table name")
+			}
+			p.query.tableName = name
+			p.step = stepUpdateSet
+
+		case stepUpdateSet:
+			if toUp(p.peek()) != "SET" {
+				return p.query, p.unexpected("SET")
+			}
+			p.pop()
+			p.step = stepUpdateField
+
+		case stepUpdateField:
+			field, ok := p.popIdentifier()
+			if !ok {
+				return p.query, p.unexpected("field")
+			}
+			p.query.updates = append(p.query.updates, update{field: field})
+			p.step = stepUpdateEquals
+
+		case stepUpdateEquals:
+			if p.peek() != "=" {
+				return p.query, p.unexpected("=")
+			}
+			p.pop()
+			p.step = stepUpdateValue
+
+		case stepUpdateValue:
+			val, ok := p.popValue()
+			if !ok {
+				return p.query, p.unexpected("value")
+			}
+			p.query.updates[len(p.query.updates)-1].value = val
+			p.step = stepUpdateComma
+
+		case stepUpdateComma:
+			if p.peek() != "," {
+				p.step = stepWhere
+				continue
+			}
+			p.pop()
+			p.step = stepUpdateField
+
+		// DELETE
+		case stepDeleteFromTable:
+			name, ok := p.popIdentifier()
+			if !ok {
+				return p.query, p.unexpected("table name")
+			}
+			p.query.tableName = name
+			p.step = stepWhere
+
+		// WHERE
+		case stepWhere:
+			if p.peek() == "" {
+				return p.query, nil
+			}
+			if p.query.queryType == selectQuery && toUp(p.peek()) != "WHERE" {
+				p.step = stepSelectClause
+				continue
+			}
+			if toUp(p.peek()) != "WHERE" {
+				return p.query, p.unexpected("WHERE", endOfStatement)
+			}
+			p.pop()
+			p.step = stepWhereExpr
+
+		case stepWhereExpr:
+			where, err := p.popExpr()
+			if err != nil {
+				return p.query, err
+			}
+			p.query.where = where
+			if p.query.queryType == selectQuery {
+				p.step = stepSelectClause
+				continue
+			}
+			return p.query, p.expectEnd()
+
+		// BEGIN, COMMIT, ROLLBACK
+		case stepTransaction:
+			// The TRANSACTION keyword is optional after BEGIN, COMMIT and
+			// ROLLBACK. Only a ROLLBACK may be followed by anything else, TO
+			// and the savepoint to roll back to.
+			expected := []string{"TRANSACTION"}
+			if toUp(p.peek()) == "TRANSACTION" {
+				p.pop()
+				expected = nil
+			}
+			if p.query.queryType == rollbackQuery {
+				if toUp(p.peek()) == "TO" {
+					p.pop()
+					p.step = stepSavepointName
+					continue
+				}
+				expected = append(expected, "TO")
+			}
+			if p.peek() != "" {
+				return p.query, p.unexpected(append(expected, endOfStatement)...)
+			}
 			return p.query, nil
 
+		case stepSavepointName:
+			// The SAVEPOINT keyword is optional after RELEASE and ROLLBACK TO
+			if p.query.queryType != savepointQuery && toUp(p.peek()) == "SAVEPOINT" {
+				p.pop()
+			}
+			name, ok := p.popIdentifier()
+			if !ok {
+				return p.query, p.unexpected("savepoint name")
+			}
+			p.query.savepoint = name
+			return p.query, p.expectEnd()
+
+		// SET TRANSACTION
+		case stepIsolationLevel:
+			for _, keyword := range []string{"ISOLATION", "LEVEL"} {
+				if toUp(p.peek()) != keyword {
+					return p.query, p.unexpected(keyword)
+				}
+				p.pop()
+			}
+
+			// READ UNCOMMITTED is read committed, as uncommitted changes
+			// are never seen, and SNAPSHOT is repeatable read
+			switch toUp(p.peek()) {
+			case "READ":
+				p.pop()
+				if w := toUp(p.peek()); w != "COMMITTED" && w != "UNCOMMITTED" {
+					return p.query, p.unexpected("COMMITTED", "UNCOMMITTED")
+				}
+				p.query.isolation = levelReadCommitted
+			case "REPEATABLE":
+				p.pop()
+				if toUp(p.peek()) != "READ" {
+					return p.query, p.unexpected("READ")
+				}
+				p.query.isolation = levelRepeatableRead
+			case "SNAPSHOT":
+				p.query.isolation = levelRepeatableRead
+			case "SERIALIZABLE":
+				p.query.isolation = levelSerializable
+			default:
+				return p.query, p.unexpected("READ", "REPEATABLE", "SNAPSHOT", "SERIALIZABLE")
+			}
+			p.pop()
+			return p.query, p.expectEnd()
+
+		// CREATE TABLE
+		case stepCreateTableName:
+			name, ok := p.popIdentifier()
+			if !ok {
+				return p.query, p.unexpected("table name")
+			}
+			p.query.tableName = name
+			p.step = stepCreateTableOpeningParens
+
+		case stepCreateTableOpeningParens:
+			if p.peek() != "(" {
+				return p.query, p.unexpected("(")
+			}
+			p.pop()
+			p.step = stepCreateTableColumn
+
+		case stepCreateTableColumn:
+			name, ok := p.popIdentifier()
+			if !ok {
+				return p.query, p.unexpected("column name")
+			}
+			p.query.columns = append(p.query.columns, column{name: name, isNullable: true})
+			p.step = stepCreateTableColumnType
+
+		case stepCreateTableColumnType:
+			typ := parseColumnType(strings.ToLower(p.peek()))
+			if typ == columnTypeInvalid {
+				return p.query, p.unexpected(columnNames[1:]...)
+			}
+			p.pop()
+			p.query.columns[len(p.query.columns)-1].dataType = typ
+			p.step = stepCreateTableNotNull
+
+		case stepCreateTableNotNull:
+			// Columns are nullable unless declared NOT NULL
+			switch toUp(p.peek()) {
+			case "NOT":
+				p.pop()
+				if toUp(p.peek()) != "NULL" {
+					return p.query, p.unexpected("NULL")
+				}
+				p.pop()
+				p.query.columns[len(p.query.columns)-1].isNullable = false
+			case ",", ")":
+			default:
+				return p.query, p.unexpected("NOT", ",", ")")
+			}
+			p.step = stepCreateTableCommaOrClosingParens
+
+		case stepCreateTableCommaOrClosingParens:
+			switch p.peek() {
+			case ",":
+				p.pop()
+				p.step = stepCreateTableColumn
+			case ")":
+				p.pop()
+				return p.query, p.expectEnd()
+			default:
+				return p.query, p.unexpected(",", ")")
+			}
+
+		// DROP TABLE
+		case stepDropTableName:
+			name, ok := p.popIdentifier()
+			if !ok {
+				return p.query, p.unexpected("table name")
+			}
+			p.query.tableName = name
+			return p.query, p.expectEnd()
+
+		// COPY
+		case stepCopyTable:
+			name, ok := p.popIdentifier()
+			if !ok {
+				return p.query, p.unexpected("table name")
+			}
+			p.query.tableName = name
+			p.step = stepCopyColumnsOpeningParens
+
+		case stepCopyColumnsOpeningParens:
+			// The list of columns is optional, in which case all of the
+			// table's columns are copied
+			if p.peek() != "(" {
+				p.step = stepCopyDirection
+				continue
+			}
+			p.pop()
+			p.step = stepCopyColumn
+
+		case stepCopyColumn:
+			field, ok := p.popIdentifier()
+			if !ok {
+				return p.query, p.unexpected("column name")
+			}
+			p.query.fields = append(p.query.fields, field)
+			p.step = stepCopyColumnCommaOrClosingParens
+
+		case stepCopyColumnCommaOrClosingParens:
+			switch p.peek() {
+			case ",":
+				p.step = stepCopyColumn
+			case ")":
+				p.step = stepCopyDirection
+			default:
+				return p.query, p.unexpected(",", ")")
+			}
+			p.pop()
+
+		case stepCopyDirection:
+			switch toUp(p.peek()) {
+			case "FROM":
+			case "TO":
+				p.query.copyTo = true
+			default:
+				return p.query, p.unexpected("(", "FROM", "TO")
+			}
+			p.pop()
+			p.step = stepCopyFile
+
+		case stepCopyFile:
+			file := p.peek()
+			if !strings.HasPrefix(file, "'") || !isValue(file) {
+				return p.query, p.unexpected("file name")
+			}
+			p.pop()
+			p.query.copyFile = file
+			p.step = stepCopyWith
+
+		case stepCopyWith:
+			if p.peek() == "" {
+				return p.query, nil
+			}
+			if toUp(p.peek()) == "WITH" {
+				p.pop()
+			}
+			p.step = stepCopyOptionsOpeningParens
+
+		case stepCopyOptionsOpeningParens:
+			if p.peek() != "(" {
+				return p.query, p.unexpected("WITH", "(", endOfStatement)
+			}
+			p.pop()
+			p.step = stepCopyOption
+
+		case stepCopyOption:
+			name := toUp(p.peek())
+			if !isCopyOption(name) {
+				return p.query, p.unexpected(copyOptionNames...)
+			}
+			p.pop()
+			p.query.copyOptions = append(p.query.copyOptions, copyOption{name: name})
+			p.step = stepCopyOptionValue
+
+		case stepCopyOptionValue:
+			// HEADER on its own turns the header on
+			opt := &p.query.copyOptions[len(p.query.copyOptions)-1]
+			if opt.name == "HEADER" && (p.peek() == "," || p.peek() == ")") {
+				opt.value = "TRUE"
+				p.step = stepCopyOptionCommaOrClosingParens
+				continue
+			}
+
+			val := p.peek()
+			if isPlaceholder(val) {
+				return p.query, p.unexpected("value")
+			}
+			val, ok := p.popValue()
+			if !ok {
+				return p.query, p.unexpected("value")
+			}
+			opt.value = val
+			p.step = stepCopyOptionCommaOrClosingParens
+
+		case stepCopyOptionCommaOrClosingParens:
+			switch p.peek() {
+			case ",":
+				p.pop()
+				p.step = stepCopyOption
+			case ")":
+				p.pop()
+				return p.query, p.expectEnd()
+			default:
+				return p.query, p.unexpected(",", ")")
+			}
+
 		default:
 			return p.query, nil
 		}
 	}
 }
 
+// popIdentifier pops the next token if it is an identifier, returning the
+// name it refers to with any quotes removed.
+func (p *parser) popIdentifier() (string, bool) {
+	tok := p.peek()
+	if !isIdentifier(tok) {
+		return "", false
+	}
+	p.pop()
+
+	if tok[0] == '"' {
+		return strings.Replace(tok[1:len(tok)-1], `""`, `"`, -1), true
+	}
+
+	return tok, true
+}
+
+// popField pops the next token if it is a field, or the tokens of an
+// aggregate of a field, as in MAX(age) or COUNT(*). An aggregate is returned
+// in the form it takes in the IR, e.g. max(age).
+func (p *parser) popField() (string, error) {
+	start := p.cursor
+	if fn, ok := parseAggregateFunc(p.peek()); ok {
+		p.pop()
+		if p.peek() == "(" {
+			p.pop()
+			arg, ok := p.popIdentifier()
+			if !ok && fn == aggregateCount && p.peek() == "*" {
+				arg, _ = p.pop()
+			} else if !ok {
+				return "", p.unexpected("field")
+			}
+			if p.peek() != ")" {
+				return "", p.unexpected(")")
+			}
+			p.pop()
+			return fn.String() + "(" + arg + ")", nil
+		}
+		p.cursor = start
+	}
+
+	field, ok := p.popIdentifier()
+	if !ok {
+		return "", p.unexpected("field")
+	}
+	return field, nil
+}
+
+// popExpr pops the tokens of an expression, up to the end of the statement or
+// the clauses of a SELECT which may follow it, and returns it as parseExpr
+// takes it, with its keywords in upper case and <> written as !=, e.g. coalesce(age, 0) > 7 AND
+// name IS NOT NULL. Placeholders are kept as they are.
+func (p *parser) popExpr() (string, error) {
+	tokens, offsets := []string{}, []int{}
+	for depth := 0; ; {
+		p.popWhitespace()
+		if p.cursor == len(p.sql) || p.sql[p.cursor] == ';' {
+			break
+		}
+
+		end, err := scanExprToken(p.sql, p.cursor)
+		if err != nil {
+			tok, _ := p.peekWithCount()
+			return "", p.errorAt(p.cursor, tok)
+		}
+
+		tok := p.sql[p.cursor:end]
+		switch {
+		case tok == "(":
+			depth++
+		case tok == ")":
+			depth--
+		case depth == 0 && isSelectClause(tok):
+			return p.finishExpr(tokens, offsets)
+		}
+
+		if isExprKeyword(tok) {
+			tok = toUp(tok)
+		}
+		if op := newOperator(tok); op != unknownOperator {
+			tok = op.symbol()
+		}
+		tokens, offsets = append(tokens, tok), append(offsets, p.cursor)
+		p.cursor = end
+	}
+
+	return p.finishExpr(tokens, offsets)
+}
+
+// finishExpr parses the tokens of an expression popped by popExpr, found at
+// the given offsets, returning its text.
+func (p *parser) finishExpr(tokens []string, offsets []int) (string, error) {
+	x := &exprParser{tokens: tokens, placeholders: true}
+	if _, err := x.parse(); err != nil {
+		e := err.(*exprError)
+		if e.pos == len(tokens) {
+			return "", p.errorAt(p.cursor, "", e.expected...)
+		}
+		return "", p.errorAt(offsets[e.pos], tokens[e.pos], e.expected...)
+	}
+
+	return joinExpr(tokens), nil
+}
+
+// isSelectClause reports whether the word starts a clause of a SELECT which
+// follows its WHERE clause.
+func isSelectClause(word string) bool {
+	switch toUp(word) {
+	case "GROUP", "ORDER", "LIMIT", "OFFSET", "FOR":
+		return true
+	default:
+		return false
+	}
+}
+
+// popValue pops the next token if it is a literal value or a parameter
+// placeholder. Keyword literals are normalised to upper case, all other
+// values are returned exactly as written.
+func (p *parser) popValue() (string, bool) {
+	val := p.peek()
+	if !isValue(val) {
+		return "", false
+	}
+	p.pop()
+
+	switch toUp(val) {
+	case "NULL", "TRUE", "FALSE":
+		return toUp(val), true
+	default:
+		return val, true
+	}
+}
+
+// expectEnd returns an error if there is anything left to parse other than
+// whitespace and comments.
+func (p *parser) expectEnd() error {
+	if p.peek() != "" {
+		return p.unexpected(endOfStatement)
+	}
+
+	return nil
+}
+
+// unexpected creates a parse error for the token at the cursor, given the set
+// of tokens which would have been accepted there instead.
+func (p *parser) unexpected(expected ...string) error {
+	return p.errorAt(p.cursor, p.peek(), expected...)
+}
+
+// errorAt creates a parse error for the token at the given offset.
+func (p *parser) errorAt(offset int, token string, expected ...string) error {
+	ctx := ""
+	if p.query.queryType != queryUnknownType {
+		ctx = p.query.queryType.String()
+	}
+
+	return newParseError(p.sql, offset, token, ctx, expected)
+}
+
 var reservedWords = []string{
-	"(", ")", ">=", "<=", "!=", ",", "=", ">", "<",
-	"SELECT", "INSERT INTO", "VALUES", "UPDATE",
-	"DELETE FROM", "WHERE", "FROM", "SET",
+	"(", ")", ">=", "<=", "!=", "<>", ",", "=", ">", "<", ";",
+	"SELECT", "INSERT", "INTO", "VALUES", "UPDATE",
+	"DELETE", "WHERE", "FROM", "SET", "AND",
+	"BEGIN", "COMMIT", "ROLLBACK", "TRANSACTION",
+	"SAVEPOINT", "RELEASE",
+	"NULL", "TRUE", "FALSE", "NOT",
+	"CREATE", "DROP", "TABLE",
+	"COPY", "TO", "WITH",
+	"GROUP", "ORDER", "BY", "ASC", "DESC", "LIMIT", "OFFSET", "FOR",
 }
 
 func (p *parser) peek() string {
@@ -119,40 +908,40 @@ func (p *parser) pop() (string, int) {
 	return val, adv
 }
 
+// peekWithCount returns the token at the cursor, and its length. String
+// literals and quoted identifiers are returned as a single token including
+// their quotes.
 func (p *parser) peekWithCount() (string, int) {
 	if p.cursor >= len(p.sql) {
 		return "", 0
 	}
 
-	// Advance the cursor until we reach the end of the
-	// input, or the desired character
-	buf := ""
-	i := p.cursor
-	for {
-		// Reached the end
-		if i == len(p.sql) {
-			fmt.Println("returning buf: ", buf)
-			return buf, i - p.cursor
-		}
+	if p.sql[p.cursor] == '\'' || p.sql[p.cursor] == '"' {
+		end, _ := scanQuoted(p.sql, p.cursor)
+		return p.sql[p.cursor:end], end - p.cursor
+	}
 
-		// Reached our desired character
-		if p.sql[i] == ' ' || isReserved(string(p.sql[i])) {
-			fmt.Println("returning buf: ", buf)
-			return buf, i - p.cursor
-		}
+	// Reserved characters are tokens of their own, with the exception of
+	// operators made up of two characters
+	if p.cursor+1 < len(p.sql) && isReserved(p.sql[p.cursor:p.cursor+2]) {
+		return p.sql[p.cursor : p.cursor+2], 2
+	}
+	if isReserved(string(p.sql[p.cursor])) {
+		return p.sql[p.cursor : p.cursor+1], 1
+	}
 
-		buf += string(p.sql[i])
+	// Advance until we reach the end of the input, or the desired character
+	i := p.cursor
+	for i < len(p.sql) && !isSpace(p.sql[i]) && !isOperatorChar(p.sql[i]) {
 		i++
 	}
+
+	return p.sql[p.cursor:i], i - p.cursor
 }
 
+// popWhitespace advances the cursor past any whitespace and comments.
 func (p *parser) popWhitespace() {
-	for {
-		if p.sql[p.cursor] != ' ' {
-			break
-		}
-		p.cursor++
-	}
+	p.cursor = skipSpaceAndComments(p.sql, p.cursor)
 }
 
 func isReserved(token string) bool {
@@ -165,6 +954,106 @@ func isReserved(token string) bool {
 	return false
 }
 
+// isOperatorChar reports whether the character starts a reserved token which
+// is not a word, and thus ends any token before it.
+func isOperatorChar(c byte) bool {
+	return c == '!' || isReserved(string(c))
+}
+
+var (
+	identifierPattern  = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+	numberPattern      = regexp.MustCompile(`^[+-]?(\d+(\.\d*)?|\.\d+)([eE][+-]?\d+)?$`)
+	placeholderPattern = regexp.MustCompile(`^(\?|\$[1-9][0-9]*|:[a-zA-Z_][a-zA-Z0-9_]*)$`)
+	countPattern       = regexp.MustCompile(`^[0-9]+$`)
+)
+
+// isIdentifier reports whether the token can be used as the name of a field or
+// table, that is it is a word which is not reserved, ignoring case, or any
+// non-empty name in double quotes.
+func isIdentifier(token string) bool {
+	if strings.HasPrefix(token, `"`) {
+		end, terminated := scanQuoted(token, 0)
+		return terminated && end == len(token) && len(token) > 2
+	}
+
+	return identifierPattern.MatchString(token) && !isReserved(toUp(token))
+}
+
+// isValue reports whether the token is a literal value, being a string,
+// number, boolean or NULL, or a placeholder for a parameter.
+func isValue(token string) bool {
+	switch {
+	case token == "":
+		return false
+	case token[0] == '\'':
+		end, terminated := scanQuoted(token, 0)
+		return terminated && end == len(token)
+	case numberPattern.MatchString(token), isPlaceholder(token):
+		return true
+	}
+
+	switch toUp(token) {
+	case "NULL", "TRUE", "FALSE":
+		return true
+	default:
+		return false
+	}
+}
+
+// isPlaceholder reports whether the token is a parameter placeholder, either
+// positional (? or $1) or named (:name).
+func isPlaceholder(token string) bool {
+	return placeholderPattern.MatchString(token)
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r'
+}
+
+// scanComment returns the offset just past the comment starting at offset i
+// of sql. If there is no comment at i, i is returned unchanged. Both line
+// comments (-- ...) and block comments (/* ... */) are recognised, an
+// unterminated block comment extends to the end of the input.
+func scanComment(sql string, i int) int {
+	switch {
+	case strings.HasPrefix(sql[i:], "--"):
+		end := strings.IndexByte(sql[i:], '\n')
+		if end == -1 {
+			return len(sql)
+		}
+		return i + end + 1
+	case strings.HasPrefix(sql[i:], "/*"):
+		end := strings.Index(sql[i+2:], "*/")
+		if end == -1 {
+			return len(sql)
+		}
+		return i + 2 + end + 2
+	default:
+		return i
+	}
+}
+
+// scanQuoted returns the offset just past the quoted string or identifier
+// starting at offset i of sql, which must hold the opening quote. A doubled
+// quote character is an escaped quote and does not terminate the string. An
+// unterminated string extends to the end of the input, in which case
+// terminated is false.
+func scanQuoted(sql string, i int) (end int, terminated bool) {
+	quote := sql[i]
+	for j := i + 1; j < len(sql); j++ {
+		if sql[j] != quote {
+			continue
+		}
+		if j+1 < len(sql) && sql[j+1] == quote {
+			j++
+			continue
+		}
+		return j + 1, true
+	}
+
+	return len(sql), false
+}
+
 func toUp(str string) string {
 	return strings.ToUpper(str)
 }
@@ -1,7 +1,6 @@
 package lbadd
 
 import (
-	"fmt"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -29,7 +28,48 @@ func TestParser(t *testing.T) {
 			name:     "select with field and trailing comma error",
 			sql:      "SELECT a, b, c, FROM z",
 			expected: query{queryType: selectQuery, fields: []string{"a", "b", "c"}},
-			err:      fmt.Errorf("at SELECT: unexpected FROM after comma"),
+			err:      &ParseError{Line: 1, Column: 17, Token: "FROM", Expected: []string{"field"}, Context: "SELECT"},
+		},
+		{
+			name:     "select without FROM",
+			sql:      "SELECT a, b",
+			expected: query{queryType: selectQuery, fields: []string{"a", "b"}},
+			err:      &ParseError{Line: 1, Column: 12, Expected: []string{",", "FROM"}, Context: "SELECT"},
+		},
+		{
+			name:     "select without table",
+			sql:      "SELECT a FROM ",
+			expected: query{queryType: selectQuery, fields: []string{"a"}},
+			err:      &ParseError{Line: 1, Column: 15, Expected: []string{"table name"}, Context: "SELECT"},
+		},
+		{
+			name:     "select with trailing tokens",
+			sql:      "SELECT a FROM z y",
+			expected: query{queryType: selectQuery, fields: []string{"a"}, tableName: "z"},
+			err:      &ParseError{Line: 1, Column: 17, Token: "y", Expected: []string{"WHERE", "GROUP", "ORDER", "LIMIT", "OFFSET", "FOR", "end of statement"}, Context: "SELECT"},
+		},
+		{
+			name:     "select across lines with comments",
+			sql:      "SELECT a, -- first\n\tb /* second */\nFROM z",
+			expected: query{queryType: selectQuery, fields: []string{"a", "b"}, tableName: "z"},
+		},
+		{
+			name:     "select error on later line",
+			sql:      "SELECT a\n  b FROM z",
+			expected: query{queryType: selectQuery, fields: []string{"a"}},
+			err:      &ParseError{Line: 2, Column: 3, Token: "b", Expected: []string{",", "FROM"}, Context: "SELECT"},
+		},
+		{
+			name:     "unrecognised query type",
+			sql:      "EXPLODE z",
+			expected: query{},
+			err:      &ParseError{Line: 1, Column: 1, Token: "EXPLODE", Expected: []string{"SELECT", "INSERT", "UPDATE", "DELETE", "BEGIN", "COMMIT", "ROLLBACK", "SAVEPOINT", "RELEASE", "SET", "CREATE", "DROP", "COPY"}},
+		},
+		{
+			name:     "empty query",
+			sql:      "  ",
+			expected: query{},
+			err:      &ParseError{Line: 1, Column: 3, Expected: []string{"SELECT", "INSERT", "UPDATE", "DELETE", "BEGIN", "COMMIT", "ROLLBACK", "SAVEPOINT", "RELEASE", "SET", "CREATE", "DROP", "COPY"}},
 		},
 		{
 			name:     "select all (*) fields from table",
@@ -37,7 +77,432 @@ func TestParser(t *testing.T) {
 			expected: query{queryType: selectQuery, fields: []string{"*"}, tableName: "z"},
 		},
 
+		{
+			name:     "select with where",
+			sql:      "SELECT a FROM z WHERE b = 'it''s' AND c >= 1.5 AND 3 != d",
+			expected: query{queryType: selectQuery, fields: []string{"a"}, tableName: "z", where: "b = 'it''s' AND c >= 1.5 AND 3 != d"},
+		},
+		{
+			name:     "select with where comparing fields without spaces",
+			sql:      "SELECT a FROM z WHERE b<c AND d<>null",
+			expected: query{queryType: selectQuery, fields: []string{"a"}, tableName: "z", where: "b < c AND d != NULL"},
+		},
+		{
+			name:     "select with where expressions",
+			sql:      "SELECT a FROM z WHERE a+1 > 2 OR (b is not null AND not c % 2 = 1) OR -a < - 1",
+			expected: query{queryType: selectQuery, fields: []string{"a"}, tableName: "z", where: "a + 1 > 2 OR (b IS NOT NULL AND NOT c % 2 = 1) OR -a < -1"},
+		},
+		{
+			name:     "select with where functions",
+			sql:      "SELECT a FROM z WHERE case when a > 1 then 'x' else b end = coalesce ( c , 'y' ) AND nullif(a, 0) IS NULL AND CAST(b AS integer) = 1",
+			expected: query{queryType: selectQuery, fields: []string{"a"}, tableName: "z", where: "CASE WHEN a > 1 THEN 'x' ELSE b END = coalesce(c, 'y') AND nullif(a, 0) IS NULL AND CAST(b AS integer) = 1"},
+		},
+		{
+			name:     "select with incomplete where",
+			sql:      "SELECT a FROM z WHERE b =",
+			expected: query{queryType: selectQuery, fields: []string{"a"}, tableName: "z"},
+			err:      &ParseError{Line: 1, Column: 26, Context: "SELECT"},
+		},
+		{
+			name:     "select with an invalid where",
+			sql:      "SELECT a FROM z WHERE coalesce(a 1) = 1",
+			expected: query{queryType: selectQuery, fields: []string{"a"}, tableName: "z"},
+			err:      &ParseError{Line: 1, Column: 34, Token: "1", Expected: []string{",", ")"}, Context: "SELECT"},
+		},
+		{
+			name:     "select with unterminated string",
+			sql:      "SELECT a FROM z WHERE b = 'oops",
+			expected: query{queryType: selectQuery, fields: []string{"a"}, tableName: "z"},
+			err:      &ParseError{Line: 1, Column: 27, Token: "'oops", Context: "SELECT"},
+		},
+		{
+			name: "select with aggregates, grouped and ordered",
+			sql:  "SELECT age, COUNT(*), max(\"a b\") FROM z WHERE age > 1 GROUP BY age, b ORDER BY count(*) DESC, age ASC LIMIT 10 OFFSET 5",
+			expected: query{
+				queryType: selectQuery,
+				fields:    []string{"age", "count(*)", "max(a b)"},
+				tableName: "z",
+				where:     "age > 1",
+				groupBy:   []string{"age", "b"},
+				orderBy:   []orderKey{{field: "count(*)", desc: true}, {field: "age"}},
+				limit:     "10",
+				offset:    "5",
+			},
+		},
+		{
+			name:     "select a field named as an aggregate",
+			sql:      "SELECT count FROM z ORDER BY count",
+			expected: query{queryType: selectQuery, fields: []string{"count"}, tableName: "z", orderBy: []orderKey{{field: "count"}}},
+		},
+		{
+			name:     "select with offset only",
+			sql:      "SELECT a FROM z OFFSET 2",
+			expected: query{queryType: selectQuery, fields: []string{"a"}, tableName: "z", offset: "2"},
+		},
+		{
+			name:     "select for share",
+			sql:      "SELECT a FROM z WHERE a = 1 LIMIT 1 FOR SHARE",
+			expected: query{queryType: selectQuery, fields: []string{"a"}, tableName: "z", where: "a = 1", limit: "1", locking: "SHARE"},
+		},
+		{
+			name:     "select for update",
+			sql:      "SELECT a FROM z for update",
+			expected: query{queryType: selectQuery, fields: []string{"a"}, tableName: "z", locking: "UPDATE"},
+		},
+		{
+			name:     "select for neither share nor update",
+			sql:      "SELECT a FROM z FOR a",
+			expected: query{queryType: selectQuery, fields: []string{"a"}, tableName: "z"},
+			err:      &ParseError{Line: 1, Column: 21, Token: "a", Expected: []string{"SHARE", "UPDATE"}, Context: "SELECT"},
+		},
+		{
+			name:     "select with a clause after for",
+			sql:      "SELECT a FROM z FOR SHARE LIMIT 1",
+			expected: query{queryType: selectQuery, fields: []string{"a"}, tableName: "z", locking: "SHARE"},
+			err:      &ParseError{Line: 1, Column: 27, Token: "LIMIT", Expected: []string{"end of statement"}, Context: "SELECT"},
+		},
+		{
+			name:     "select with clauses out of order",
+			sql:      "SELECT a FROM z LIMIT 1 ORDER BY a",
+			expected: query{queryType: selectQuery, fields: []string{"a"}, tableName: "z", limit: "1"},
+			err:      &ParseError{Line: 1, Column: 25, Token: "ORDER", Expected: []string{"OFFSET", "FOR", "end of statement"}, Context: "SELECT"},
+		},
+		{
+			name:     "select with trailing tokens after conditions",
+			sql:      "SELECT a FROM z WHERE a = 1 b",
+			expected: query{queryType: selectQuery, fields: []string{"a"}, tableName: "z"},
+			err:      &ParseError{Line: 1, Column: 29, Token: "b", Context: "SELECT"},
+		},
+		{
+			name:     "select with a limit which isn't a number",
+			sql:      "SELECT a FROM z LIMIT -1",
+			expected: query{queryType: selectQuery, fields: []string{"a"}, tableName: "z"},
+			err:      &ParseError{Line: 1, Column: 23, Token: "-1", Expected: []string{"number of rows"}, Context: "SELECT"},
+		},
+		{
+			name:     "select with ORDER missing BY",
+			sql:      "SELECT a FROM z ORDER a",
+			expected: query{queryType: selectQuery, fields: []string{"a"}, tableName: "z"},
+			err:      &ParseError{Line: 1, Column: 23, Token: "a", Expected: []string{"BY"}, Context: "SELECT"},
+		},
+		{
+			name:     "select with an unclosed aggregate",
+			sql:      "SELECT sum(a FROM z",
+			expected: query{queryType: selectQuery},
+			err:      &ParseError{Line: 1, Column: 14, Token: "FROM", Expected: []string{")"}, Context: "SELECT"},
+		},
+		{
+			name:     "select the sum of every column",
+			sql:      "SELECT sum(*) FROM z",
+			expected: query{queryType: selectQuery},
+			err:      &ParseError{Line: 1, Column: 12, Token: "*", Expected: []string{"field"}, Context: "SELECT"},
+		},
+
 		// INSERT
+		{
+			name: "insert with fields",
+			sql:  "INSERT INTO z (a, b) VALUES ('x', 1), (NULL, -2)",
+			expected: query{
+				queryType: insertQuery,
+				tableName: "z",
+				fields:    []string{"a", "b"},
+				inserts:   [][]string{{"'x'", "1"}, {"NULL", "-2"}},
+			},
+		},
+		{
+			name: "insert without fields",
+			sql:  "insert into z values (true, 'a b'),(false, '')",
+			expected: query{
+				queryType: insertQuery,
+				tableName: "z",
+				inserts:   [][]string{{"TRUE", "'a b'"}, {"FALSE", "''"}},
+			},
+		},
+		{
+			name: "insert with too few values",
+			sql:  "INSERT INTO z (a, b) VALUES (1)",
+			expected: query{
+				queryType: insertQuery,
+				tableName: "z",
+				fields:    []string{"a", "b"},
+				inserts:   [][]string{{"1"}},
+			},
+			err: &ParseError{Line: 1, Column: 31, Token: ")", Expected: []string{","}, Context: "INSERT"},
+		},
+		{
+			name: "insert rows of different lengths",
+			sql:  "INSERT INTO z VALUES (1, 2), (3, 4, 5)",
+			expected: query{
+				queryType: insertQuery,
+				tableName: "z",
+				inserts:   [][]string{{"1", "2"}, {"3", "4"}},
+			},
+			err: &ParseError{Line: 1, Column: 35, Token: ",", Expected: []string{")"}, Context: "INSERT"},
+		},
+		{
+			name:     "insert without into",
+			sql:      "INSERT z VALUES (1)",
+			expected: query{queryType: insertQuery},
+			err:      &ParseError{Line: 1, Column: 8, Token: "z", Expected: []string{"INTO"}, Context: "INSERT"},
+		},
+
+		// UPDATE
+		{
+			name: "update with where",
+			sql:  "UPDATE z SET b = 'x', a = 2 WHERE c = 3",
+			expected: query{
+				queryType: updateQuery,
+				tableName: "z",
+				updates:   []update{{field: "b", value: "'x'"}, {field: "a", value: "2"}},
+				where:     "c = 3",
+			},
+		},
+		{
+			name:     "update with trailing tokens",
+			sql:      "UPDATE z SET a = 2 WHERE c = 3 LIMIT 1",
+			expected: query{queryType: updateQuery, tableName: "z", updates: []update{{field: "a", value: "2"}}, where: "c = 3"},
+			err:      &ParseError{Line: 1, Column: 32, Token: "LIMIT", Expected: []string{"end of statement"}, Context: "UPDATE"},
+		},
+		{
+			name:     "update without set",
+			sql:      "UPDATE z b = 1",
+			expected: query{queryType: updateQuery, tableName: "z"},
+			err:      &ParseError{Line: 1, Column: 10, Token: "b", Expected: []string{"SET"}, Context: "UPDATE"},
+		},
+
+		// DELETE
+		{
+			name:     "delete all",
+			sql:      "DELETE FROM z",
+			expected: query{queryType: deleteQuery, tableName: "z"},
+		},
+		{
+			name:     "delete with where",
+			sql:      "DELETE FROM z WHERE a < 10",
+			expected: query{queryType: deleteQuery, tableName: "z", where: "a < 10"},
+		},
+
+		// Placeholders
+		{
+			name:     "select with placeholders",
+			sql:      "SELECT a FROM z WHERE b = ? AND c > $2 AND :name <= d",
+			expected: query{queryType: selectQuery, fields: []string{"a"}, tableName: "z", where: "b = ? AND c > $2 AND :name <= d"},
+		},
+		{
+			name: "insert with placeholders",
+			sql:  "INSERT INTO z (a, b) VALUES (?, :b)",
+			expected: query{
+				queryType: insertQuery,
+				tableName: "z",
+				fields:    []string{"a", "b"},
+				inserts:   [][]string{{"?", ":b"}},
+			},
+		},
+		{
+			name: "update with placeholders",
+			sql:  "UPDATE z SET a = $1 WHERE b = $2",
+			expected: query{
+				queryType: updateQuery,
+				tableName: "z",
+				updates:   []update{{field: "a", value: "$1"}},
+				where:     "b = $2",
+			},
+		},
+		{
+			name:     "invalid placeholder",
+			sql:      "DELETE FROM z WHERE a = $0",
+			expected: query{queryType: deleteQuery, tableName: "z"},
+			err:      &ParseError{Line: 1, Column: 25, Token: "$0", Context: "DELETE"},
+		},
+
+		// Transactions
+		{
+			name:     "begin",
+			sql:      "BEGIN",
+			expected: query{queryType: beginQuery},
+		},
+		{
+			name:     "begin transaction",
+			sql:      "begin transaction",
+			expected: query{queryType: beginQuery},
+		},
+		{
+			name:     "commit",
+			sql:      "COMMIT",
+			expected: query{queryType: commitQuery},
+		},
+		{
+			name:     "rollback transaction with trailing whitespace",
+			sql:      "ROLLBACK TRANSACTION ",
+			expected: query{queryType: rollbackQuery},
+		},
+		{
+			name:     "savepoint",
+			sql:      "SAVEPOINT before",
+			expected: query{queryType: savepointQuery, savepoint: "before"},
+		},
+		{
+			name:     "release savepoint",
+			sql:      "release savepoint before",
+			expected: query{queryType: releaseQuery, savepoint: "before"},
+		},
+		{
+			name:     "rollback to savepoint",
+			sql:      "ROLLBACK TRANSACTION TO SAVEPOINT before",
+			expected: query{queryType: rollbackQuery, savepoint: "before"},
+		},
+		{
+			name:     "rollback to without a savepoint",
+			sql:      "ROLLBACK TO",
+			expected: query{queryType: rollbackQuery},
+			err:      &ParseError{Line: 1, Column: 12, Expected: []string{"savepoint name"}, Context: "ROLLBACK"},
+		},
+		{
+			name:     "rollback followed by garbage",
+			sql:      "ROLLBACK now",
+			expected: query{queryType: rollbackQuery},
+			err:      &ParseError{Line: 1, Column: 10, Token: "now", Expected: []string{"TRANSACTION", "TO", "end of statement"}, Context: "ROLLBACK"},
+		},
+		{
+			name:     "set transaction isolation level",
+			sql:      "SET TRANSACTION ISOLATION LEVEL SERIALIZABLE",
+			expected: query{queryType: setTransactionQuery, isolation: levelSerializable},
+		},
+		{
+			name:     "set transaction read committed",
+			sql:      "set transaction isolation level read committed",
+			expected: query{queryType: setTransactionQuery, isolation: levelReadCommitted},
+		},
+		{
+			name:     "set transaction read uncommitted",
+			sql:      "SET TRANSACTION ISOLATION LEVEL READ UNCOMMITTED",
+			expected: query{queryType: setTransactionQuery, isolation: levelReadCommitted},
+		},
+		{
+			name:     "set transaction repeatable read",
+			sql:      "SET TRANSACTION ISOLATION LEVEL REPEATABLE READ",
+			expected: query{queryType: setTransactionQuery, isolation: levelRepeatableRead},
+		},
+		{
+			name:     "set transaction snapshot",
+			sql:      "SET TRANSACTION ISOLATION LEVEL SNAPSHOT",
+			expected: query{queryType: setTransactionQuery, isolation: levelRepeatableRead},
+		},
+		{
+			name:     "set transaction without a level",
+			sql:      "SET TRANSACTION ISOLATION LEVEL",
+			expected: query{queryType: setTransactionQuery},
+			err:      &ParseError{Line: 1, Column: 32, Expected: []string{"READ", "REPEATABLE", "SNAPSHOT", "SERIALIZABLE"}, Context: "SET TRANSACTION"},
+		},
+		{
+			name:     "set transaction with an unknown level",
+			sql:      "SET TRANSACTION ISOLATION LEVEL READ SOMETIMES",
+			expected: query{queryType: setTransactionQuery},
+			err:      &ParseError{Line: 1, Column: 38, Token: "SOMETIMES", Expected: []string{"COMMITTED", "UNCOMMITTED"}, Context: "SET TRANSACTION"},
+		},
+		{
+			name:     "set without transaction",
+			sql:      "SET a = 1",
+			expected: query{queryType: setTransactionQuery},
+			err:      &ParseError{Line: 1, Column: 5, Token: "a", Expected: []string{"TRANSACTION"}, Context: "SET TRANSACTION"},
+		},
+		{
+			name:     "commit followed by garbage",
+			sql:      "COMMIT now",
+			expected: query{queryType: commitQuery},
+			err:      &ParseError{Line: 1, Column: 8, Token: "now", Expected: []string{"TRANSACTION", "end of statement"}, Context: "COMMIT"},
+		},
+		// CREATE TABLE
+		{
+			name:     "create table",
+			sql:      "CREATE TABLE users (name string NOT NULL, age INTEGER, joined datetime not null)",
+			expected: query{queryType: createTableQuery, tableName: "users", columns: []column{{name: "name", dataType: columnTypeString}, {name: "age", dataType: columnTypeInt, isNullable: true}, {name: "joined", dataType: columnTypeDateTime}}},
+		},
+		{
+			name:     "create table with unknown type",
+			sql:      "create table users (name text)",
+			expected: query{queryType: createTableQuery, tableName: "users", columns: []column{{name: "name", isNullable: true}}},
+			err:      &ParseError{Line: 1, Column: 26, Token: "text", Expected: []string{"integer", "float", "boolean", "string", "datetime"}, Context: "CREATE TABLE"},
+		},
+		{
+			name:     "create table with NOT but no NULL",
+			sql:      "create table users (name string not, age integer)",
+			expected: query{queryType: createTableQuery, tableName: "users", columns: []column{{name: "name", dataType: columnTypeString, isNullable: true}}},
+			err:      &ParseError{Line: 1, Column: 36, Token: ",", Expected: []string{"NULL"}, Context: "CREATE TABLE"},
+		},
+		{
+			name:     "create table without closing parens",
+			sql:      "create table users (name string",
+			expected: query{queryType: createTableQuery, tableName: "users", columns: []column{{name: "name", dataType: columnTypeString, isNullable: true}}},
+			err:      &ParseError{Line: 1, Column: 32, Expected: []string{"NOT", ",", ")"}, Context: "CREATE TABLE"},
+		},
+		{
+			name:     "create without table",
+			sql:      "CREATE users",
+			expected: query{queryType: createTableQuery},
+			err:      &ParseError{Line: 1, Column: 8, Token: "users", Expected: []string{"TABLE"}, Context: "CREATE TABLE"},
+		},
+		// DROP TABLE
+		{
+			name:     "drop table",
+			sql:      "drop table users",
+			expected: query{queryType: dropTableQuery, tableName: "users"},
+		},
+		{
+			name:     "drop table followed by garbage",
+			sql:      "DROP TABLE users now",
+			expected: query{queryType: dropTableQuery, tableName: "users"},
+			err:      &ParseError{Line: 1, Column: 18, Token: "now", Expected: []string{"end of statement"}, Context: "DROP TABLE"},
+		},
+		// COPY
+		{
+			name:     "copy from",
+			sql:      "COPY users FROM 'users.csv'",
+			expected: query{queryType: copyQuery, tableName: "users", copyFile: "'users.csv'"},
+		},
+		{
+			name: "copy columns to with options",
+			sql:  `copy users (name, age) to 'out.csv' with (header, delimiter ';', NULL 'n/a')`,
+			expected: query{queryType: copyQuery, tableName: "users", fields: []string{"name", "age"}, copyTo: true, copyFile: "'out.csv'", copyOptions: []copyOption{
+				{name: "HEADER", value: "TRUE"},
+				{name: "DELIMITER", value: "';'"},
+				{name: "NULL", value: "'n/a'"},
+			}},
+		},
+		{
+			name:     "copy options without with",
+			sql:      "COPY users FROM 'users.csv' (HEADER false)",
+			expected: query{queryType: copyQuery, tableName: "users", copyFile: "'users.csv'", copyOptions: []copyOption{{name: "HEADER", value: "FALSE"}}},
+		},
+		{
+			name:     "copy without a direction",
+			sql:      "COPY users 'users.csv'",
+			expected: query{queryType: copyQuery, tableName: "users"},
+			err:      &ParseError{Line: 1, Column: 12, Token: "'users.csv'", Expected: []string{"(", "FROM", "TO"}, Context: "COPY"},
+		},
+		{
+			name:     "copy from an unquoted file",
+			sql:      "COPY users FROM users.csv",
+			expected: query{queryType: copyQuery, tableName: "users"},
+			err:      &ParseError{Line: 1, Column: 17, Token: "users.csv", Expected: []string{"file name"}, Context: "COPY"},
+		},
+		{
+			name:     "copy with an unknown option",
+			sql:      "COPY users TO 'users.csv' WITH (FORMAT csv)",
+			expected: query{queryType: copyQuery, tableName: "users", copyTo: true, copyFile: "'users.csv'"},
+			err:      &ParseError{Line: 1, Column: 33, Token: "FORMAT", Expected: []string{"HEADER", "DELIMITER", "QUOTE", "NULL"}, Context: "COPY"},
+		},
+		// Quoted identifiers
+		{
+			name:     "select quoted identifiers",
+			sql:      `SELECT "from", "a b" FROM "my ""table""" WHERE "from" = 'x'`,
+			expected: query{queryType: selectQuery, fields: []string{"from", "a b"}, tableName: `my "table"`, where: `"from" = 'x'`},
+		},
+		{
+			name:     "update quoted identifier",
+			sql:      `UPDATE z SET "set" = 1`,
+			expected: query{queryType: updateQuery, tableName: "z", updates: []update{{field: "set", value: "1"}}},
+		},
 	}
 
 	for _, tc := range cases {
@@ -52,3 +517,43 @@ func TestParser(t *testing.T) {
 		})
 	}
 }
+
+func TestParseError(t *testing.T) {
+	cases := []struct {
+		name    string
+		sql     string
+		message string
+		caret   string
+	}{
+		{
+			name:    "unexpected token",
+			sql:     "SELECT a, b, c, FROM z",
+			message: "line 1, column 17: at SELECT: unexpected FROM, expected field",
+			caret:   "SELECT a, b, c, FROM z\n                ^",
+		},
+		{
+			name:    "unexpected end of input",
+			sql:     "SELECT a",
+			message: "line 1, column 9: at SELECT: unexpected end of input, expected , or FROM",
+			caret:   "SELECT a\n        ^",
+		},
+		{
+			name:    "only the offending line is rendered",
+			sql:     "SELECT a,\n\tb,\n\tFROM z\n",
+			message: "line 3, column 2: at SELECT: unexpected FROM, expected field",
+			caret:   "\tFROM z\n\t^",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := parse(tc.sql)
+			perr, ok := err.(*ParseError)
+			if !assert.True(t, ok, "expected a *ParseError, got %T", err) {
+				return
+			}
+			assert.Equal(t, tc.message, perr.Error())
+			assert.Equal(t, tc.caret, perr.Caret())
+		})
+	}
+}
@@ -0,0 +1,805 @@
+package lbadd
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// operator is a physical operator of a query plan, which produces rows one at
+// a time. Operators are composed into a tree, each pulling the rows of its
+// inputs with next, so that rows flow up through the plan as they're needed
+// rather than being materialised between each step.
+//
+// An operator is opened before its rows are read, and closed once they have
+// been, which opens and closes its inputs in turn.
+type operator interface {
+	// columns returns the columns of the rows produced.
+	columns() []column
+	// open prepares the operator to produce rows. The context is checked
+	// as the rows are produced.
+	open(ctx context.Context) error
+	// next returns the next row, or nil once there are no more.
+	next() (row, error)
+	// close releases anything held to produce the rows.
+	close()
+}
+
+// collect runs the plan, returning every row it produces.
+func collect(ctx context.Context, op operator) ([]row, error) {
+	if err := op.open(ctx); err != nil {
+		return nil, err
+	}
+	defer op.close()
+
+	var rows []row
+	for {
+		r, err := op.next()
+		if err != nil || r == nil {
+			return rows, err
+		}
+		rows = append(rows, r)
+	}
+}
+
+// The number of rows a scan reads from the table's storage at once. The
+// storage is latched while they're read, so a scan doesn't hold up writers
+// for longer, nor wait for the operators above it.
+const scanBatchSize = 64
+
+// scanOp produces the rows of a table visible to the transaction in progress
+// with keys between low and high inclusive, in order of their keys. A scan of
+// every key is a table scan, otherwise it's an index scan of the storage,
+// which is indexed by key.
+type scanOp struct {
+	e         *executor
+	t         table
+	low, high key
+	keyed     bool // whether each row is followed by its key, as its rowid
+
+	ctx   context.Context
+	from  key   // the key the next batch is read from
+	done  bool  // whether the last batch has been read
+	batch []row // the rows read but not yet produced
+}
+
+// newScanOp returns a table scan of the table.
+func (e *executor) newScanOp(t table) *scanOp {
+	return e.newIndexScanOp(t, minKey, maxKey)
+}
+
+// newIndexScanOp returns a scan of the rows of the table with keys between
+// low and high inclusive.
+func (e *executor) newIndexScanOp(t table, low, high key) *scanOp {
+	return &scanOp{e: e, t: t, low: low, high: high}
+}
+
+func (s *scanOp) columns() []column {
+	if s.keyed {
+		return s.t.keyedColumns()
+	}
+	return s.t.columns
+}
+
+func (s *scanOp) open(ctx context.Context) error {
+	if err := s.e.readTable(s.t); err != nil {
+		return err
+	}
+
+	s.ctx, s.from, s.done, s.batch = ctx, s.low, s.low > s.high, nil
+	return nil
+}
+
+func (s *scanOp) next() (row, error) {
+	for len(s.batch) == 0 {
+		if s.done {
+			return nil, nil
+		}
+		if err := s.read(); err != nil {
+			return nil, err
+		}
+	}
+
+	r := s.batch[0]
+	s.batch = s.batch[1:]
+	return r, nil
+}
+
+// read reads the next batch of rows from the storage.
+func (s *scanOp) read() error {
+	var err error
+	s.done = true
+	serr := s.t.store.scanBetween(s.ctx, s.from, s.high, func(en *entry) bool {
+		r, verr := s.e.visibleEntry(s.t, en)
+		if verr != nil {
+			err = verr
+			return false
+		}
+		if r != nil && s.keyed {
+			r = append(r[:len(r):len(r)], keyRecord(en.key))
+		}
+		if r != nil {
+			s.batch = append(s.batch, r)
+		}
+
+		if len(s.batch) == scanBatchSize && en.key < s.high {
+			s.from, s.done = en.key+1, false
+			return false
+		}
+		return true
+	})
+	if err != nil {
+		return err
+	}
+
+	return serr
+}
+
+func (s *scanOp) close() {
+	s.batch = nil
+}
+
+// keyRange returns the range of keys of the rows which may satisfy all of
+// the predicates, narrowed by those comparing the rowid column, at the given
+// index, with an integer. Scanning only the range saves reading rows which
+// can't match, but the predicates must still be checked.
+func keyRange(preds []expr, rowid int) (low, high key) {
+	low, high = minKey, maxKey
+
+	var narrow func(x expr)
+	narrow = func(x expr) {
+		switch x := x.(type) {
+		case *logicExpr:
+			if x.and {
+				narrow(x.l)
+				narrow(x.r)
+			}
+		case *compareExpr:
+			op, k, ok := keyBound(x, rowid)
+			if !ok {
+				return
+			}
+
+			switch op {
+			case equal:
+				low, high = maxOf(low, k), minOf(high, k)
+			case greaterOrEqual:
+				low = maxOf(low, k)
+			case lesserOrEqual:
+				high = minOf(high, k)
+			case greater:
+				if k == maxKey {
+					low, high = maxKey, minKey
+				} else {
+					low = maxOf(low, k+1)
+				}
+			case lesser:
+				if k == minKey {
+					low, high = maxKey, minKey
+				} else {
+					high = minOf(high, k-1)
+				}
+			}
+		}
+	}
+	for _, p := range preds {
+		narrow(p)
+	}
+
+	return low, high
+}
+
+// keyBound returns the comparison as the rowid column, at the given index,
+// compared with a key, such that rowid op k holds. ok is false if it isn't a
+// comparison of the rowid column with an integer.
+func keyBound(c *compareExpr, rowid int) (op operatorType, k key, ok bool) {
+	isRowID := func(x expr) bool {
+		col, isCol := x.(*columnExpr)
+		return isCol && col.index == rowid
+	}
+
+	op, l, r := c.op, c.l, c.r
+	if !isRowID(l) {
+		// k op rowid is rowid op' k with the operator mirrored
+		l, r = r, l
+		switch op {
+		case greater:
+			op = lesser
+		case lesser:
+			op = greater
+		case greaterOrEqual:
+			op = lesserOrEqual
+		case lesserOrEqual:
+			op = greaterOrEqual
+		}
+	}
+	if !isRowID(l) {
+		return unknownOperator, 0, false
+	}
+
+	v, isLiteral := literal(r)
+	n, isInt := v.(int64)
+	if !isLiteral || !isInt {
+		return unknownOperator, 0, false
+	}
+
+	return op, key(n), true
+}
+
+func minOf(a, b key) key {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxOf(a, b key) key {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// filterOp produces the rows of its input which satisfy all of the
+// predicates.
+type filterOp struct {
+	input operator
+	preds []expr
+}
+
+func newFilterOp(input operator, preds []expr) *filterOp {
+	return &filterOp{input: input, preds: preds}
+}
+
+func (f *filterOp) columns() []column {
+	return f.input.columns()
+}
+
+func (f *filterOp) open(ctx context.Context) error {
+	return f.input.open(ctx)
+}
+
+func (f *filterOp) next() (row, error) {
+	for {
+		r, err := f.input.next()
+		if err != nil || r == nil {
+			return nil, err
+		}
+
+		matches, err := matchAll(f.preds, r)
+		if err != nil {
+			return nil, err
+		}
+		if matches {
+			return r, nil
+		}
+	}
+}
+
+func (f *filterOp) close() {
+	f.input.close()
+}
+
+// projectOp produces the given columns of the rows of its input, in the
+// order given.
+type projectOp struct {
+	input  operator
+	fields []int // the indices of the input's columns to produce
+}
+
+func newProjectOp(input operator, fields []int) *projectOp {
+	return &projectOp{input: input, fields: fields}
+}
+
+func (p *projectOp) columns() []column {
+	cols := make([]column, 0, len(p.fields))
+	for _, f := range p.fields {
+		cols = append(cols, p.input.columns()[f])
+	}
+	return cols
+}
+
+func (p *projectOp) open(ctx context.Context) error {
+	return p.input.open(ctx)
+}
+
+func (p *projectOp) next() (row, error) {
+	r, err := p.input.next()
+	if err != nil || r == nil {
+		return nil, err
+	}
+
+	projected := make(row, 0, len(p.fields))
+	for _, f := range p.fields {
+		projected = append(projected, r[f])
+	}
+	return projected, nil
+}
+
+func (p *projectOp) close() {
+	p.input.close()
+}
+
+// sortKey orders rows by one of their columns, ascending unless desc is set.
+// NULL is ordered before any other value.
+type sortKey struct {
+	column int
+	desc   bool
+}
+
+// sortOp produces the rows of its input ordered by the sort keys, each
+// ordering the rows equal by the ones before it. Rows equal by every key are
+// kept in the order of the input.
+type sortOp struct {
+	input operator
+	keys  []sortKey
+
+	rows []row
+}
+
+func newSortOp(input operator, keys []sortKey) *sortOp {
+	return &sortOp{input: input, keys: keys}
+}
+
+func (s *sortOp) columns() []column {
+	return s.input.columns()
+}
+
+// open reads every row of the input, which are sorted before the first is
+// produced.
+func (s *sortOp) open(ctx context.Context) error {
+	rows, err := collect(ctx, s.input)
+	if err != nil {
+		return err
+	}
+
+	// The keys' values are decoded once up front, rather than on every
+	// comparison
+	cols := s.input.columns()
+	type sortRow struct {
+		r      row
+		values []interface{}
+	}
+	sorted := make([]sortRow, 0, len(rows))
+	for _, r := range rows {
+		sr := sortRow{r: r, values: make([]interface{}, len(s.keys))}
+		for i, k := range s.keys {
+			if sr.values[i], err = decodeRecord(r[k.column], cols[k.column].dataType); err != nil {
+				return err
+			}
+		}
+		sorted = append(sorted, sr)
+	}
+
+	sort.SliceStable(sorted, func(i, j int) bool {
+		a, b := sorted[i].values, sorted[j].values
+		for n, k := range s.keys {
+			var cmp int
+			switch {
+			case a[n] == nil && b[n] == nil:
+			case a[n] == nil:
+				cmp = -1
+			case b[n] == nil:
+				cmp = 1
+			default:
+				c, cerr := compareValues(a[n], b[n])
+				if cerr != nil && err == nil {
+					err = cerr
+				}
+				cmp = c
+			}
+
+			if k.desc {
+				cmp = -cmp
+			}
+			if cmp != 0 {
+				return cmp < 0
+			}
+		}
+		return false
+	})
+	if err != nil {
+		return err
+	}
+
+	s.rows = make([]row, 0, len(sorted))
+	for _, sr := range sorted {
+		s.rows = append(s.rows, sr.r)
+	}
+	return nil
+}
+
+func (s *sortOp) next() (row, error) {
+	if len(s.rows) == 0 {
+		return nil, nil
+	}
+
+	r := s.rows[0]
+	s.rows = s.rows[1:]
+	return r, nil
+}
+
+func (s *sortOp) close() {
+	s.rows = nil
+}
+
+// limitOp produces up to limit rows of its input, after skipping the first
+// offset rows. A negative limit produces every row after the offset.
+type limitOp struct {
+	input         operator
+	limit, offset int
+
+	produced int
+}
+
+func newLimitOp(input operator, limit, offset int) *limitOp {
+	return &limitOp{input: input, limit: limit, offset: offset}
+}
+
+func (l *limitOp) columns() []column {
+	return l.input.columns()
+}
+
+func (l *limitOp) open(ctx context.Context) error {
+	l.produced = 0
+	return l.input.open(ctx)
+}
+
+func (l *limitOp) next() (row, error) {
+	for ; l.produced < l.offset; l.produced++ {
+		if r, err := l.input.next(); err != nil || r == nil {
+			return nil, err
+		}
+	}
+	if l.limit >= 0 && l.produced-l.offset >= l.limit {
+		return nil, nil
+	}
+
+	r, err := l.input.next()
+	if r != nil {
+		l.produced++
+	}
+	return r, err
+}
+
+func (l *limitOp) close() {
+	l.input.close()
+}
+
+// aggregateFunc is a function computing a single value from the values of a
+// column in a group of rows.
+type aggregateFunc int
+
+const (
+	aggregateCount aggregateFunc = iota
+	aggregateSum
+	aggregateAvg
+	aggregateMin
+	aggregateMax
+)
+
+var aggregateFuncs = []aggregateFunc{aggregateCount, aggregateSum, aggregateAvg, aggregateMin, aggregateMax}
+
+func (f aggregateFunc) String() string {
+	switch f {
+	case aggregateCount:
+		return "count"
+	case aggregateSum:
+		return "sum"
+	case aggregateAvg:
+		return "avg"
+	case aggregateMin:
+		return "min"
+	case aggregateMax:
+		return "max"
+	default:
+		return "unknown"
+	}
+}
+
+// parseAggregateFunc returns the aggregate function with the name, which is
+// case insensitive.
+func parseAggregateFunc(name string) (aggregateFunc, bool) {
+	for _, f := range aggregateFuncs {
+		if strings.EqualFold(f.String(), name) {
+			return f, true
+		}
+	}
+
+	return 0, false
+}
+
+// aggregate applies an aggregate function to a column. NULL values are
+// ignored, and every function but count is NULL for a group without other
+// values. A count of column -1 counts every row, as COUNT(*).
+type aggregate struct {
+	fn     aggregateFunc
+	column int
+}
+
+// aggregateState is the state of an aggregate over the rows of a group seen
+// so far.
+type aggregateState struct {
+	count int64       // the number of values, or rows for COUNT(*)
+	sum   interface{} // the sum of the values, an int64 or float64
+	best  record      // the least or greatest value, for min and max
+	value interface{} // the decoded value of best
+}
+
+// aggregateOp produces a row for each group of the rows of its input with
+// equal values of the group by columns, holding those values followed by the
+// aggregates over the group's rows. Groups are produced in order of their
+// first row. Without group by columns every row is in one group, which is
+// produced even if there are no rows.
+type aggregateOp struct {
+	input   operator
+	groupBy []int
+	aggs    []aggregate
+
+	rows []row
+}
+
+func newAggregateOp(input operator, groupBy []int, aggs []aggregate) *aggregateOp {
+	return &aggregateOp{input: input, groupBy: groupBy, aggs: aggs}
+}
+
+func (a *aggregateOp) columns() []column {
+	in := a.input.columns()
+
+	cols := make([]column, 0, len(a.groupBy)+len(a.aggs))
+	for _, g := range a.groupBy {
+		cols = append(cols, in[g])
+	}
+	for _, agg := range a.aggs {
+		col := column{name: agg.fn.String() + "(*)", dataType: columnTypeInt}
+		if agg.column != -1 {
+			col.name = fmt.Sprintf("%s(%s)", agg.fn, in[agg.column].name)
+			col.isNullable = agg.fn != aggregateCount
+			switch agg.fn {
+			case aggregateAvg:
+				col.dataType = columnTypeFloat
+			case aggregateSum, aggregateMin, aggregateMax:
+				col.dataType = in[agg.column].dataType
+			}
+		}
+		cols = append(cols, col)
+	}
+	return cols
+}
+
+// open reads every row of the input, computing the aggregates of each group
+// before the first is produced.
+func (a *aggregateOp) open(ctx context.Context) error {
+	in := a.input.columns()
+	for _, agg := range a.aggs {
+		if agg.column == -1 {
+			if agg.fn != aggregateCount {
+				return fmt.Errorf("%s requires a column", agg.fn)
+			}
+			continue
+		}
+
+		t := in[agg.column].dataType
+		if (agg.fn == aggregateSum || agg.fn == aggregateAvg) && t != columnTypeInt && t != columnTypeFloat {
+			return fmt.Errorf("can't compute the %s of %s column %s", agg.fn, t, in[agg.column].name)
+		}
+	}
+
+	if err := a.input.open(ctx); err != nil {
+		return err
+	}
+	defer a.input.close()
+
+	// Groups are found by the records of their group by columns
+	type group struct {
+		values row
+		states []aggregateState
+	}
+	groups := map[string]*group{}
+	order := []*group{}
+	find := func(r row) *group {
+		values := make(row, 0, len(a.groupBy))
+		var id strings.Builder
+		for _, c := range a.groupBy {
+			values = append(values, r[c])
+			fmt.Fprintf(&id, "%t%d:%s", r[c] == nil, len(r[c]), r[c])
+		}
+
+		g, ok := groups[id.String()]
+		if !ok {
+			g = &group{values: values, states: make([]aggregateState, len(a.aggs))}
+			groups[id.String()] = g
+			order = append(order, g)
+		}
+		return g
+	}
+	if len(a.groupBy) == 0 {
+		find(nil)
+	}
+
+	for {
+		r, err := a.input.next()
+		if err != nil {
+			return err
+		}
+		if r == nil {
+			break
+		}
+
+		g := find(r)
+		for i, agg := range a.aggs {
+			if err := a.accumulate(agg, &g.states[i], r); err != nil {
+				return err
+			}
+		}
+	}
+
+	cols := a.columns()
+	a.rows = make([]row, 0, len(order))
+	for _, g := range order {
+		r := append(row{}, g.values...)
+		for i, agg := range a.aggs {
+			rec, err := a.result(agg, g.states[i], cols[len(a.groupBy)+i].dataType)
+			if err != nil {
+				return err
+			}
+			r = append(r, rec)
+		}
+		a.rows = append(a.rows, r)
+	}
+	return nil
+}
+
+// accumulate adds the row's value to the state of the aggregate.
+func (a *aggregateOp) accumulate(agg aggregate, st *aggregateState, r row) error {
+	if agg.column == -1 {
+		st.count++
+		return nil
+	}
+	if r[agg.column] == nil {
+		return nil
+	}
+
+	v, err := decodeRecord(r[agg.column], a.input.columns()[agg.column].dataType)
+	if err != nil {
+		return err
+	}
+	st.count++
+
+	switch agg.fn {
+	case aggregateSum, aggregateAvg:
+		switch sum := st.sum.(type) {
+		case nil:
+			st.sum = v
+		case int64:
+			st.sum = sum + v.(int64)
+		case float64:
+			st.sum = sum + v.(float64)
+		}
+	case aggregateMin, aggregateMax:
+		if st.value != nil {
+			cmp, err := compareValues(v, st.value)
+			if err != nil {
+				return err
+			}
+			if (agg.fn == aggregateMin && cmp >= 0) || (agg.fn == aggregateMax && cmp <= 0) {
+				return nil
+			}
+		}
+		st.best, st.value = r[agg.column], v
+	}
+	return nil
+}
+
+// result returns the record of the aggregate's value for a group, of the
+// aggregate column's type.
+func (a *aggregateOp) result(agg aggregate, st aggregateState, t columnType) (record, error) {
+	switch {
+	case agg.fn == aggregateCount:
+		return encodeValue(st.count, t)
+	case st.count == 0:
+		return nil, nil
+	case agg.fn == aggregateAvg:
+		sum, err := convertValue(st.sum, columnTypeFloat)
+		if err != nil {
+			return nil, err
+		}
+		return encodeValue(sum.(float64)/float64(st.count), t)
+	case agg.fn == aggregateSum:
+		return encodeValue(st.sum, t)
+	default:
+		return st.best, nil
+	}
+}
+
+func (a *aggregateOp) next() (row, error) {
+	if len(a.rows) == 0 {
+		return nil, nil
+	}
+
+	r := a.rows[0]
+	a.rows = a.rows[1:]
+	return r, nil
+}
+
+func (a *aggregateOp) close() {
+	a.rows = nil
+}
+
+// joinOp produces every combination of a row of the left input followed by a
+// row of the right input which satisfies all of the predicates, checked
+// against the columns of both. Each input's columns are qualified by its
+// name, if it has one, e.g. l.name, so that the inputs may share column
+// names, as when a table is joined with itself. It is a nested loop join,
+// with the right input's rows read up front and kept in memory.
+type joinOp struct {
+	left, right         operator
+	leftName, rightName string
+	preds               []expr
+
+	rights []row
+	cur    row // the left row being joined
+	pos    int // the position of the next right row to join it with
+}
+
+func newJoinOp(left operator, leftName string, right operator, rightName string, preds []expr) *joinOp {
+	return &joinOp{left: left, right: right, leftName: leftName, rightName: rightName, preds: preds}
+}
+
+func (j *joinOp) columns() []column {
+	return append(qualify(j.left.columns(), j.leftName), qualify(j.right.columns(), j.rightName)...)
+}
+
+// qualify returns a copy of the columns with their names qualified by the
+// given name, or as they are if it is empty.
+func qualify(cols []column, name string) []column {
+	qualified := append([]column{}, cols...)
+	if name == "" {
+		return qualified
+	}
+
+	for i := range qualified {
+		qualified[i].name = name + "." + qualified[i].name
+	}
+	return qualified
+}
+
+func (j *joinOp) open(ctx context.Context) error {
+	rights, err := collect(ctx, j.right)
+	if err != nil {
+		return err
+	}
+
+	j.rights, j.cur, j.pos = rights, nil, 0
+	return j.left.open(ctx)
+}
+
+func (j *joinOp) next() (row, error) {
+	for {
+		if j.cur == nil || j.pos == len(j.rights) {
+			l, err := j.left.next()
+			if err != nil || l == nil {
+				return nil, err
+			}
+			j.cur, j.pos = l, 0
+			continue
+		}
+
+		r := append(append(row{}, j.cur...), j.rights[j.pos]...)
+		j.pos++
+
+		matches, err := matchAll(j.preds, r)
+		if err != nil {
+			return nil, err
+		}
+		if matches {
+			return r, nil
+		}
+	}
+}
+
+func (j *joinOp) close() {
+	j.left.close()
+	j.rights, j.cur = nil, nil
+}
@@ -0,0 +1,310 @@
+package lbadd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// runPlan runs the plan in a transaction of the session, returning its rows
+// displayed as by displayRows.
+func runPlan(t *testing.T, e *executor, plan operator) string {
+	var rows []row
+	_, err := e.autocommit(func() (result, error) {
+		var err error
+		rows, err = collect(context.Background(), plan)
+		return result{}, err
+	})
+	assert.NoError(t, err)
+
+	return displayRows(t, result{columns: plan.columns(), rows: rows})
+}
+
+func Test_plan_operators(t *testing.T) {
+	e := newExecutor(exeConfig{order: 3})
+	execSQL(t, e, "CREATE TABLE users (name string NOT NULL, age integer, score float)")
+	execSQL(t, e, "INSERT INTO users VALUES ('Jane', 7, 1.5), ('John', 42, NULL), ('Ann', 7, 3), ('Bob', NULL, 1.5)")
+	execSQL(t, e, "CREATE TABLE groups (years integer, label string)")
+	execSQL(t, e, "INSERT INTO groups VALUES (7, 'child'), (42, 'adult')")
+	users, err := e.lookupTable("users")
+	if !assert.NoError(t, err) {
+		return
+	}
+	groups, err := e.lookupTable("groups")
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	where := func(cols []column, conds ...string) []expr {
+		preds, err := parsePredicates(cols, conds, nil)
+		assert.NoError(t, err)
+		return preds
+	}
+
+	cases := []struct {
+		name  string
+		plan  func() operator
+		names []string // the names of the plan's columns, if checked
+		want  string
+	}{
+		{
+			name:  "table scan",
+			plan:  func() operator { return e.newScanOp(users) },
+			names: []string{"name", "age", "score"},
+			want:  "Jane 7 1.5|John 42 NULL|Ann 7 3|Bob NULL 1.5",
+		},
+		{
+			name: "index scan",
+			plan: func() operator { return e.newIndexScanOp(users, 1, 2) },
+			want: "John 42 NULL|Ann 7 3",
+		},
+		{
+			name: "empty index scan",
+			plan: func() operator { return e.newIndexScanOp(users, 2, 1) },
+			want: "",
+		},
+		{
+			name: "filter",
+			plan: func() operator { return newFilterOp(e.newScanOp(users), where(users.columns, "age=7", "score>2")) },
+			want: "Ann 7 3",
+		},
+		{
+			name:  "project",
+			plan:  func() operator { return newProjectOp(e.newScanOp(users), []int{1, 0}) },
+			names: []string{"age", "name"},
+			want:  "7 Jane|42 John|7 Ann|NULL Bob",
+		},
+		{
+			name: "sort with NULL first and equal rows kept in order",
+			plan: func() operator { return newSortOp(e.newScanOp(users), []sortKey{{column: 1}}) },
+			want: "Bob NULL 1.5|Jane 7 1.5|Ann 7 3|John 42 NULL",
+		},
+		{
+			name: "sort by several keys",
+			plan: func() operator {
+				return newSortOp(e.newScanOp(users), []sortKey{{column: 2, desc: true}, {column: 0}})
+			},
+			want: "Ann 7 3|Bob NULL 1.5|Jane 7 1.5|John 42 NULL",
+		},
+		{
+			name: "limit",
+			plan: func() operator { return newLimitOp(e.newScanOp(users), 2, 1) },
+			want: "John 42 NULL|Ann 7 3",
+		},
+		{
+			name: "offset without limit",
+			plan: func() operator { return newLimitOp(e.newScanOp(users), -1, 3) },
+			want: "Bob NULL 1.5",
+		},
+		{
+			name: "aggregate",
+			plan: func() operator {
+				return newAggregateOp(e.newScanOp(users), nil, []aggregate{
+					{aggregateCount, -1},
+					{aggregateCount, 1},
+					{aggregateSum, 1},
+					{aggregateAvg, 2},
+					{aggregateMin, 0},
+					{aggregateMax, 1},
+				})
+			},
+			names: []string{"count(*)", "count(age)", "sum(age)", "avg(score)", "min(name)", "max(age)"},
+			want:  "4 3 56 2 Ann 42",
+		},
+		{
+			name: "aggregate without rows",
+			plan: func() operator {
+				return newAggregateOp(newFilterOp(e.newScanOp(users), where(users.columns, "age>100")), nil, []aggregate{
+					{aggregateCount, -1},
+					{aggregateSum, 2},
+				})
+			},
+			want: "0 NULL",
+		},
+		{
+			name: "aggregate groups",
+			plan: func() operator {
+				return newAggregateOp(e.newScanOp(users), []int{1}, []aggregate{
+					{aggregateCount, -1},
+					{aggregateMax, 0},
+				})
+			},
+			names: []string{"age", "count(*)", "max(name)"},
+			want:  "7 2 Jane|42 1 John|NULL 1 Bob",
+		},
+		{
+			name: "join",
+			plan: func() operator {
+				// Users of the same age, which is never NULL
+				join := newJoinOp(e.newScanOp(users), "l", e.newScanOp(users), "r", nil)
+				join.preds = where(join.columns(), "l.age=r.age")
+				return newProjectOp(join, []int{0, 3})
+			},
+			names: []string{"l.name", "r.name"},
+			want:  "Jane Jane|Jane Ann|John John|Ann Jane|Ann Ann",
+		},
+		{
+			name: "join by unqualified names",
+			plan: func() operator {
+				// Users with the group of their age
+				join := newJoinOp(e.newScanOp(users), "users", e.newScanOp(groups), "groups", nil)
+				join.preds = where(join.columns(), "age=groups.years")
+				return newProjectOp(join, []int{0, 4})
+			},
+			want: "Jane child|John adult|Ann child",
+		},
+		{
+			name: "composed",
+			plan: func() operator {
+				// The names of the two oldest users with a score, by age and name
+				scored := newFilterOp(e.newScanOp(users), where(users.columns, "score>0"))
+				oldest := newLimitOp(newSortOp(scored, []sortKey{{column: 1, desc: true}}), 2, 0)
+				return newProjectOp(newSortOp(oldest, []sortKey{{column: 1}, {column: 0}}), []int{0})
+			},
+			want: "Ann|Jane",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			plan := tc.plan()
+			if tc.names != nil {
+				assert.Equal(t, tc.names, namesOf(plan.columns()))
+			}
+			assert.Equal(t, tc.want, runPlan(t, e, plan))
+
+			// Plans may be run again
+			assert.Equal(t, tc.want, runPlan(t, e, plan))
+		})
+	}
+}
+
+func Test_plan_errors(t *testing.T) {
+	e := newExecutor(exeConfig{order: 3})
+	execSQL(t, e, "CREATE TABLE users (name string NOT NULL, age integer)")
+	users, err := e.lookupTable("users")
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	cases := []struct {
+		aggs []aggregate
+		err  string
+	}{
+		{[]aggregate{{aggregateSum, 0}}, "can't compute the sum of string column name"},
+		{[]aggregate{{aggregateMax, -1}}, "max requires a column"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.err, func(t *testing.T) {
+			err := newAggregateOp(e.newScanOp(users), nil, tc.aggs).open(context.Background())
+			assert.EqualError(t, err, tc.err)
+		})
+	}
+	// A name shared by the columns of both sides of a join must be qualified
+	join := newJoinOp(e.newScanOp(users), "l", e.newScanOp(users), "r", nil)
+	_, err = parsePredicates(join.columns(), []string{"age=1"}, nil)
+	assert.EqualError(t, err, "invalid condition age=1: column age is ambiguous")
+	_, err = parsePredicates(join.columns(), []string{"x.age=1"}, nil)
+	assert.EqualError(t, err, "invalid condition x.age=1: column x.age does not exist")
+}
+
+func Test_keyRange(t *testing.T) {
+	e := newExecutor(exeConfig{order: 3})
+	execSQL(t, e, "CREATE TABLE users (name string NOT NULL, age integer)")
+	execSQL(t, e, "INSERT INTO users VALUES ('Jane', 7), ('John', 42), ('Ann', 7), ('Bob', NULL)")
+	users, err := e.lookupTable("users")
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	cases := []struct {
+		conds     []string
+		low, high key
+	}{
+		{[]string{"age = 7"}, minKey, maxKey},
+		{[]string{"rowid = 2"}, 2, 2},
+		{[]string{"rowid >= 1", "age = 7"}, 1, maxKey},
+		{[]string{"rowid > 1 AND 3 >= rowid"}, 2, 3},
+		{[]string{"rowid < 3 AND (rowid > 0 OR age = 7)"}, minKey, 2},
+		{[]string{"rowid != 1", "rowid <= 1.5"}, minKey, maxKey},
+		{[]string{"rowid > 9223372036854775807"}, maxKey, minKey},
+		{[]string{"rowid = 1", "rowid = 2"}, 2, 1},
+	}
+
+	for _, tc := range cases {
+		t.Run(strings.Join(tc.conds, " "), func(t *testing.T) {
+			preds, err := parsePredicates(users.keyedColumns(), tc.conds, nil)
+			if !assert.NoError(t, err) {
+				return
+			}
+
+			low, high := keyRange(preds, len(users.columns))
+			assert.Equal(t, tc.low, low)
+			assert.Equal(t, tc.high, high)
+		})
+	}
+
+	// A select only scans the range of keys its conditions allow
+	plan, err := e.planSelect(users, []string{"name", "(rowid >= 1 AND rowid < 3)"}, nil)
+	if assert.NoError(t, err) {
+		scan := plan.(*projectOp).input.(*filterOp).input.(*scanOp)
+		assert.Equal(t, []key{1, 2}, []key{scan.low, scan.high})
+		assert.Equal(t, "John|Ann", runPlan(t, e, plan))
+	}
+
+	// As do updates and deletes
+	execSQL(t, e, "UPDATE users SET age = 8 WHERE rowid = 0")
+	execSQL(t, e, "DELETE FROM users WHERE rowid > 2")
+	assert.Equal(t, "Jane 8|John 42|Ann 7", displayRows(t, execSQL(t, e, "SELECT * FROM users")))
+}
+
+func Test_scanOp(t *testing.T) {
+	const n = 3*scanBatchSize + 1
+
+	a := newExecutor(exeConfig{order: 3})
+	b := a.newSession()
+	execSQL(t, a, "CREATE TABLE t (a integer)")
+	for i := 0; i < n; i++ {
+		execSQL(t, a, fmt.Sprintf("INSERT INTO t VALUES (%d)", i))
+	}
+	tbl, err := a.lookupTable("t")
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	// The rows are read in batches, each seeing the transaction's snapshot,
+	// so rows changed between batches are produced as they were
+	execSQL(t, b, "BEGIN")
+	scan := b.newScanOp(tbl)
+	assert.NoError(t, scan.open(context.Background()))
+	for i := 0; i < n; i++ {
+		if i == 1 {
+			execSQL(t, a, "DELETE FROM t WHERE a >= 1")
+		}
+		r, err := scan.next()
+		if assert.NoError(t, err) && assert.NotNil(t, r, "row %d", i) {
+			v, _ := decodeRecord(r[0], columnTypeInt)
+			assert.Equal(t, int64(i), v)
+		}
+	}
+	r, err := scan.next()
+	assert.NoError(t, err)
+	assert.Nil(t, r)
+	scan.close()
+	execSQL(t, b, "COMMIT")
+
+	// A scan stops once the context is canceled
+	ctx, cancel := context.WithCancel(context.Background())
+	execSQL(t, b, "BEGIN")
+	assert.NoError(t, scan.open(ctx))
+	cancel()
+	_, err = scan.next()
+	assert.True(t, errors.Is(err, ErrCanceled))
+	scan.close()
+	execSQL(t, b, "ROLLBACK")
+}
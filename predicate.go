@@ -0,0 +1,290 @@
+package lbadd
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// assignment sets the column at the given index to a value.
+type assignment struct {
+	column int
+	value  interface{}
+}
+
+// splitCondition splits a condition such as age>=3 into the text of its
+// operands and its operator. Operator characters within quoted strings are
+// ignored. ok is false if the param doesn't contain an operator.
+func splitCondition(param string) (lhs string, op operatorType, rhs string, ok bool) {
+	for i := 0; i < len(param); i++ {
+		switch param[i] {
+		case '\'':
+			end, _ := scanQuoted(param, i)
+			i = end - 1
+		case '=', '!', '<', '>':
+			symbol := param[i : i+1]
+			if i+1 < len(param) && newOperator(param[i:i+2]) != unknownOperator {
+				symbol = param[i : i+2]
+			}
+
+			op = newOperator(symbol)
+			if op == unknownOperator {
+				return "", unknownOperator, "", false
+			}
+			return param[:i], op, param[i+len(symbol):], true
+		}
+	}
+
+	return "", unknownOperator, "", false
+}
+
+// isAssignment reports whether the param assigns a value to a column, such as
+// name='John'.
+func isAssignment(param string) bool {
+	lhs, op, _, ok := splitCondition(param)
+	return ok && op == equal && identifierPattern.MatchString(lhs)
+}
+
+// selectParams are the parts of a select instruction's params.
+type selectParams struct {
+	fields  []string   // the columns and aggregates to return
+	conds   []string   // the conditions rows must satisfy
+	groupBy []string   // the columns rows are grouped by
+	orderBy []orderKey // the columns and aggregates rows are ordered by
+	limit   int        // the number of rows returned at most, -1 if unlimited
+	offset  int        // the number of rows skipped
+	lock    lockMode   // the mode the rows returned are locked in, 0 if they aren't
+}
+
+// parseSelectParams splits the params of a select instruction into its
+// parts. The fields are column names, *, or aggregates such as count(*) and
+// max(age), and the conditions are any other params. They may be followed by
+// the group, order, limit, offset and for keywords, in that order, each
+// followed by its own params: the columns rows are grouped by, the fields rows
+// are ordered by, each followed by desc if it is descending, the number of
+// rows, and share or update for rows locked shared or exclusive.
+func parseSelectParams(params []string) (selectParams, error) {
+	sel := selectParams{limit: -1}
+
+	clause := ""
+	for i := 0; i < len(params); i++ {
+		p := params[i]
+		switch word := strings.ToLower(p); {
+		case clauseOrder(word) > clauseOrder(clause) && (word == "group" || word == "order"):
+			if i+1 == len(params) || clauseOrder(strings.ToLower(params[i+1])) > 0 {
+				return selectParams{}, fmt.Errorf("%s expects fields", word)
+			}
+			clause = word
+
+		case clauseOrder(word) > clauseOrder(clause) && word == "for":
+			if i+1 == len(params) {
+				return selectParams{}, fmt.Errorf("for expects share or update")
+			}
+			switch strings.ToLower(params[i+1]) {
+			case "share":
+				sel.lock = lockShared
+			case "update":
+				sel.lock = lockExclusive
+			default:
+				return selectParams{}, fmt.Errorf("for expects share or update")
+			}
+			clause = word
+			i++
+
+		case clauseOrder(word) > clauseOrder(clause):
+			if i+1 == len(params) || !countPattern.MatchString(params[i+1]) {
+				return selectParams{}, fmt.Errorf("%s expects a number of rows", word)
+			}
+			n, err := strconv.Atoi(params[i+1])
+			if err != nil {
+				return selectParams{}, fmt.Errorf("invalid %s %s", word, params[i+1])
+			}
+			if word == "limit" {
+				sel.limit = n
+			} else {
+				sel.offset = n
+			}
+			clause = word
+			i++
+
+		case clause == "":
+			if p == "*" || identifierPattern.MatchString(p) || isAggregate(p) {
+				sel.fields = append(sel.fields, p)
+			} else {
+				sel.conds = append(sel.conds, p)
+			}
+
+		case clause == "group":
+			sel.groupBy = append(sel.groupBy, p)
+
+		case clause == "order" && (word == "asc" || word == "desc") && len(sel.orderBy) > 0:
+			sel.orderBy[len(sel.orderBy)-1].desc = word == "desc"
+
+		case clause == "order":
+			sel.orderBy = append(sel.orderBy, orderKey{field: p})
+
+		default:
+			return selectParams{}, fmt.Errorf("unexpected param %s after %s", p, clause)
+		}
+	}
+
+	return sel, nil
+}
+
+// selectClauses are the keywords starting the clauses of a select
+// instruction, in the order they're given.
+var selectClauses = []string{"group", "order", "limit", "offset", "for"}
+
+// clauseOrder returns the position of the clause among the clauses of a
+// select instruction, from 1, or 0 if it isn't one.
+func clauseOrder(clause string) int {
+	for i, c := range selectClauses {
+		if c == clause {
+			return i + 1
+		}
+	}
+
+	return 0
+}
+
+// isAggregate reports whether the field is an aggregate, such as max(age).
+func isAggregate(field string) bool {
+	_, _, ok := parseAggregate(field)
+	return ok
+}
+
+// parseAggregate splits an aggregate such as max(age) or count(*) into its
+// function and the field it aggregates. ok is false if the field isn't an
+// aggregate.
+func parseAggregate(field string) (fn aggregateFunc, arg string, ok bool) {
+	open := strings.IndexByte(field, '(')
+	if open == -1 || !strings.HasSuffix(field, ")") {
+		return 0, "", false
+	}
+
+	fn, ok = parseAggregateFunc(field[:open])
+	return fn, field[open+1 : len(field)-1], ok
+}
+
+// parsePredicates parses the conditions as expressions, checking each against
+// the columns, which must be a boolean. A row satisfies a condition only if
+// it's true, rather than false or NULL. The $n placeholders of the conditions
+// take the values of the arguments.
+func parsePredicates(cols []column, conds []string, args []interface{}) ([]expr, error) {
+	preds := make([]expr, 0, len(conds))
+
+	for _, c := range conds {
+		tokens, err := lexExpr(c)
+		if err != nil {
+			return nil, fmt.Errorf("invalid condition %s: %v", c, err)
+		}
+		pred, err := (&exprParser{tokens: tokens, args: args}).parse()
+		if err != nil {
+			return nil, fmt.Errorf("invalid condition %s: %v", c, err)
+		}
+
+		typ, err := pred.check(cols)
+		if err != nil {
+			return nil, fmt.Errorf("invalid condition %s: %v", c, err)
+		}
+		if typ != columnTypeBool && typ != typeNull {
+			return nil, fmt.Errorf("invalid condition %s: expected a boolean, not %s", c, typeName(typ))
+		}
+
+		preds = append(preds, pred)
+	}
+
+	return preds, nil
+}
+
+// parseAssignments resolves assignments such as name='John' or name=$1
+// against the table's columns, converting the values to the columns' types.
+func parseAssignments(t table, params []string, args []interface{}) ([]assignment, error) {
+	assigns := make([]assignment, 0, len(params))
+
+	for _, p := range params {
+		if !isAssignment(p) {
+			return nil, fmt.Errorf("invalid assignment: %s", p)
+		}
+		field, _, lit, _ := splitCondition(p)
+
+		i := t.columnIndex(field)
+		if i == -1 {
+			return nil, fmt.Errorf("column %s does not exist in table %s", field, t.name)
+		}
+
+		col := t.columns[i]
+		v, err := parseValue(lit, args)
+		if err == nil {
+			v, err = convertValue(v, col.dataType)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("column %s: %v", col.name, err)
+		}
+		if v == nil && !col.isNullable {
+			return nil, fmt.Errorf("column %s can't be NULL", col.name)
+		}
+
+		assigns = append(assigns, assignment{column: i, value: v})
+	}
+
+	return assigns, nil
+}
+
+// matchAll reports whether the row satisfies every one of the predicates,
+// each being true.
+func matchAll(preds []expr, r row) (bool, error) {
+	for _, p := range preds {
+		v, err := p.eval(r)
+		if err != nil || v != true {
+			return false, err
+		}
+	}
+
+	return true, nil
+}
+
+// columnIndex returns the index of the column with the given name, or -1 if
+// the table has no such column. Column names are matched case insensitively.
+func (t table) columnIndex(name string) int {
+	return columnIndex(t.columns, name)
+}
+
+// columnIndex returns the index of the column with the given name among the
+// columns, or -1 if there is none.
+func columnIndex(cols []column, name string) int {
+	for i, c := range cols {
+		if strings.EqualFold(c.name, name) {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// resolveColumn returns the index of the column a name refers to among the
+// columns. Besides its own name, a column qualified by the name of its table,
+// such as the columns of a join, is referred to by its unqualified name if no
+// other column has it.
+func resolveColumn(cols []column, name string) (int, error) {
+	if i := columnIndex(cols, name); i != -1 {
+		return i, nil
+	}
+
+	found := -1
+	for i, c := range cols {
+		dot := strings.LastIndexByte(c.name, '.')
+		if dot == -1 || !strings.EqualFold(c.name[dot+1:], name) {
+			continue
+		}
+		if found != -1 {
+			return -1, fmt.Errorf("column %s is ambiguous", name)
+		}
+		found = i
+	}
+	if found == -1 {
+		return -1, fmt.Errorf("column %s does not exist", name)
+	}
+
+	return found, nil
+}
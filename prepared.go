@@ -0,0 +1,361 @@
+package lbadd
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// preparedStmt is a statement which has been parsed once, and can then be
+// executed any number of times with different values bound to its
+// parameters.
+//
+// Parameters are written as placeholders in the statement, which are either
+// positional (? or $1) or named (:name). A ? takes the position following the
+// highest position used before it, and a named parameter takes the next
+// position the first time it is used. Values can be bound by position, or by
+// name with sql.Named.
+type preparedStmt struct {
+	executor *executor
+	parsed   query
+	params   []parameter // the parameters, in order of their positions
+}
+
+// parameter of a prepared statement.
+type parameter struct {
+	name string     // the name of a named parameter, without the colon
+	typ  columnType // inferred from the column the parameter is used with
+}
+
+// prepare parses the sql into a statement which can be executed repeatedly.
+// The types of the statement's parameters are inferred from the columns they
+// are assigned to, and the columns and expressions they are compared with, so
+// the table must already exist.
+func (e *executor) prepare(sql string) (*preparedStmt, error) {
+	q, err := parse(sql)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &preparedStmt{executor: e, parsed: q}
+	if err := s.inferParams(); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// exec binds the arguments to the statement's parameters and executes it.
+func (s *preparedStmt) exec(ctx context.Context, args ...interface{}) (result, error) {
+	q, err := s.bind(args)
+	if err != nil {
+		return result{}, err
+	}
+
+	return s.executor.executeQuery(ctx, q)
+}
+
+// query binds the arguments to the parameters of a SELECT statement and
+// executes it, returning the selected rows.
+func (s *preparedStmt) query(ctx context.Context, args ...interface{}) (result, error) {
+	if s.parsed.queryType != selectQuery {
+		return result{}, fmt.Errorf("%s statement does not return rows", s.parsed.queryType)
+	}
+
+	return s.exec(ctx, args...)
+}
+
+// inferParams assigns every placeholder of the statement a position, and
+// infers the type of each parameter from the table's schema.
+func (s *preparedStmt) inferParams() error {
+	q := s.parsed
+	if !hasPlaceholders(q) {
+		return nil
+	}
+
+	t, err := s.executor.lookupTable(q.tableName)
+	if err != nil {
+		return err
+	}
+
+	typeOf := func(field string) (columnType, error) {
+		i := t.columnIndex(field)
+		if i == -1 {
+			return columnTypeInvalid, fmt.Errorf("column %s does not exist in table %s", field, t.name)
+		}
+		return t.columns[i].dataType, nil
+	}
+
+	// Walk the values in the order they are written in the statement, so
+	// that positions are assigned in the same order.
+	visit := func(value string, typ columnType) {
+		if err == nil && isPlaceholder(value) {
+			err = s.addParam(value, typ)
+		}
+	}
+
+	for _, u := range q.updates {
+		typ, terr := typeOf(u.field)
+		if terr != nil {
+			return terr
+		}
+		visit(u.value, typ)
+	}
+
+	for _, values := range q.inserts {
+		for i, v := range values {
+			field := ""
+			switch {
+			case len(q.fields) > 0:
+				field = q.fields[i]
+			case i < len(t.columns):
+				field = t.columns[i].name
+			default:
+				return fmt.Errorf("table %s has %d columns but %d values were given", t.name, len(t.columns), len(values))
+			}
+
+			typ, terr := typeOf(field)
+			if terr != nil {
+				return terr
+			}
+			visit(v, typ)
+		}
+	}
+
+	// The placeholders of the condition take the types of what they're
+	// compared with, found by checking it
+	if q.where != "" {
+		tokens, lerr := lexExpr(q.where)
+		if lerr != nil {
+			return lerr
+		}
+		p := &exprParser{tokens: tokens, placeholders: true}
+		x, perr := p.parse()
+		if perr != nil {
+			return perr
+		}
+		if _, cerr := x.check(t.keyedColumns()); cerr != nil {
+			return cerr
+		}
+		for _, param := range p.params {
+			visit(param.placeholder, param.typ)
+		}
+	}
+
+	if err != nil {
+		return err
+	}
+
+	for i, p := range s.params {
+		if p.typ == columnTypeInvalid {
+			return fmt.Errorf("could not infer the type of parameter $%d", i+1)
+		}
+	}
+
+	return nil
+}
+
+// addParam records the use of the placeholder with a value of the given type.
+func (s *preparedStmt) addParam(placeholder string, typ columnType) error {
+	pos := s.position(placeholder)
+	if pos == 0 {
+		pos = len(s.params) + 1
+	}
+
+	for len(s.params) < pos {
+		s.params = append(s.params, parameter{})
+	}
+
+	p := &s.params[pos-1]
+	if placeholder[0] == ':' {
+		p.name = placeholder[1:]
+	}
+
+	switch {
+	case typ == columnTypeInvalid:
+	case p.typ == columnTypeInvalid:
+		p.typ = typ
+	case p.typ != typ:
+		return fmt.Errorf("parameter %s is used as both %s and %s", placeholder, p.typ, typ)
+	}
+
+	return nil
+}
+
+// position returns the 1-based position of the placeholder, or 0 for a ?
+// or a named parameter which hasn't been used yet, which take the next
+// position.
+func (s *preparedStmt) position(placeholder string) int {
+	switch placeholder[0] {
+	case '$':
+		pos, _ := strconv.Atoi(placeholder[1:])
+		return pos
+	case ':':
+		for i, p := range s.params {
+			if p.name == placeholder[1:] {
+				return i + 1
+			}
+		}
+	}
+
+	return 0
+}
+
+// bind returns a copy of the statement's query with the arguments, converted
+// to the types of their parameters, bound to it. Every placeholder is replaced
+// by the $n placeholder of its position, so the values are passed on as they
+// are rather than written as literals. The statement itself is left
+// untouched, so it can be bound again.
+func (s *preparedStmt) bind(args []interface{}) (query, error) {
+	values := make([]interface{}, len(s.params))
+	bound := make([]bool, len(s.params))
+
+	for i, arg := range args {
+		pos := i + 1
+		if named, ok := arg.(sql.NamedArg); ok {
+			pos = s.position(":" + named.Name)
+			if pos == 0 {
+				return query{}, fmt.Errorf("no parameter named :%s", named.Name)
+			}
+			arg = named.Value
+		}
+
+		if pos > len(s.params) {
+			return query{}, fmt.Errorf("got %d arguments, but the statement has %d parameters", len(args), len(s.params))
+		}
+
+		v, err := bindValue(arg, s.params[pos-1].typ)
+		if err != nil {
+			return query{}, fmt.Errorf("parameter $%d: %v", pos, err)
+		}
+		values[pos-1], bound[pos-1] = v, true
+	}
+
+	for i, ok := range bound {
+		if !ok {
+			return query{}, fmt.Errorf("no value given for parameter $%d", i+1)
+		}
+	}
+
+	// Placeholders are resolved in the same order as in inferParams, so
+	// that ? and new named parameters get the same positions.
+	seen := &preparedStmt{}
+	replace := func(value string) string {
+		if !isPlaceholder(value) {
+			return value
+		}
+
+		pos := seen.position(value)
+		if pos == 0 {
+			pos = len(seen.params) + 1
+		}
+		_ = seen.addParam(value, columnTypeInvalid)
+
+		return "$" + strconv.Itoa(pos)
+	}
+
+	q := s.parsed
+	q.args = values
+	q.updates = make([]update, len(s.parsed.updates))
+	for i, u := range s.parsed.updates {
+		q.updates[i] = update{field: u.field, value: replace(u.value)}
+	}
+
+	q.inserts = make([][]string, len(s.parsed.inserts))
+	for i, vals := range s.parsed.inserts {
+		q.inserts[i] = make([]string, len(vals))
+		for j, v := range vals {
+			q.inserts[i][j] = replace(v)
+		}
+	}
+
+	if s.parsed.where != "" {
+		tokens, err := lexExpr(s.parsed.where)
+		if err != nil {
+			return query{}, err
+		}
+		for i, tok := range tokens {
+			tokens[i] = replace(tok)
+		}
+		q.where = joinExpr(tokens)
+	}
+
+	return q, nil
+}
+
+// bindValue converts the Go value into the value of a parameter of the given
+// type, returning an error if the value does not fit the type.
+func bindValue(arg interface{}, typ columnType) (interface{}, error) {
+	var v interface{}
+
+	switch arg := arg.(type) {
+	case nil:
+		return nil, nil
+	case int:
+		v = int64(arg)
+	case int8:
+		v = int64(arg)
+	case int16:
+		v = int64(arg)
+	case int32:
+		v = int64(arg)
+	case int64:
+		v = arg
+	case uint8:
+		v = int64(arg)
+	case uint16:
+		v = int64(arg)
+	case uint32:
+		v = int64(arg)
+	case float32:
+		v = float64(arg)
+	case float64, bool, string, time.Time:
+		v = arg
+	case []byte:
+		v = string(arg)
+	default:
+		return nil, fmt.Errorf("unsupported argument type %T", arg)
+	}
+
+	return convertValue(v, typ)
+}
+
+// boundValue returns the argument bound to a $n placeholder. ok is false for
+// other placeholders, and positions beyond the arguments.
+func boundValue(placeholder string, args []interface{}) (v interface{}, ok bool) {
+	if placeholder[0] != '$' {
+		return nil, false
+	}
+	pos, err := strconv.Atoi(placeholder[1:])
+	if err != nil || pos < 1 || pos > len(args) {
+		return nil, false
+	}
+
+	return args[pos-1], true
+}
+
+// hasPlaceholders reports whether any of the query's values are placeholders.
+func hasPlaceholders(q query) bool {
+	for _, u := range q.updates {
+		if isPlaceholder(u.value) {
+			return true
+		}
+	}
+	for _, values := range q.inserts {
+		for _, v := range values {
+			if isPlaceholder(v) {
+				return true
+			}
+		}
+	}
+	tokens, _ := lexExpr(q.where)
+	for _, tok := range tokens {
+		if isPlaceholder(tok) {
+			return true
+		}
+	}
+
+	return false
+}
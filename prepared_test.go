@@ -0,0 +1,240 @@
+package lbadd
+
+import (
+	"context"
+	"database/sql"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newPreparedTestExecutor(t *testing.T) *executor {
+	e := newExecutor(exeConfig{order: 3})
+	_, err := e.execute(context.Background(), instruction{
+		command: commandCreateTable,
+		table:   "users",
+		params:  []string{"name", "string", "false", "age", "integer", "true", "joined", "datetime", "true"},
+	})
+	assert.NoError(t, err)
+
+	return e
+}
+
+func Test_executor_prepare(t *testing.T) {
+	cases := []struct {
+		name       string
+		sql        string
+		wantParams []parameter
+		wantErr    bool
+	}{
+		{
+			name:       "no parameters",
+			sql:        "SELECT name FROM users",
+			wantParams: nil,
+		},
+		{
+			name:       "positional parameters",
+			sql:        "SELECT name FROM users WHERE age > ? AND name = ?",
+			wantParams: []parameter{{typ: columnTypeInt}, {typ: columnTypeString}},
+		},
+		{
+			name:       "numbered parameters used twice",
+			sql:        "SELECT name FROM users WHERE age > $1 AND age < $2 AND age != $1",
+			wantParams: []parameter{{typ: columnTypeInt}, {typ: columnTypeInt}},
+		},
+		{
+			name:       "named parameters",
+			sql:        "UPDATE users SET joined = :when WHERE name = :name AND joined < :when",
+			wantParams: []parameter{{name: "when", typ: columnTypeDateTime}, {name: "name", typ: columnTypeString}},
+		},
+		{
+			name:       "insert without fields uses the column order",
+			sql:        "INSERT INTO users VALUES (?, ?, NULL)",
+			wantParams: []parameter{{typ: columnTypeString}, {typ: columnTypeInt}},
+		},
+		{
+			name:       "insert with fields",
+			sql:        "INSERT INTO users (age, name) VALUES (?, ?), (?, 'x')",
+			wantParams: []parameter{{typ: columnTypeInt}, {typ: columnTypeString}, {typ: columnTypeInt}},
+		},
+		{
+			name:       "parameters within expressions",
+			sql:        "SELECT name FROM users WHERE coalesce(age, 0) + 1 > ? OR CASE WHEN age IS NULL THEN joined END = ?",
+			wantParams: []parameter{{typ: columnTypeInt}, {typ: columnTypeDateTime}},
+		},
+		{
+			name:       "parameter compared with the row id",
+			sql:        "DELETE FROM users WHERE rowid >= ?",
+			wantParams: []parameter{{typ: columnTypeInt}},
+		},
+		{
+			name:    "parameter with conflicting types",
+			sql:     "SELECT name FROM users WHERE age = $1 AND name = $1",
+			wantErr: true,
+		},
+		{
+			name:    "parameter without a column",
+			sql:     "SELECT name FROM users WHERE ? = 1",
+			wantErr: true,
+		},
+		{
+			name:    "unused parameter position",
+			sql:     "SELECT name FROM users WHERE age = $2",
+			wantErr: true,
+		},
+		{
+			name:    "unknown column",
+			sql:     "SELECT name FROM users WHERE email = ?",
+			wantErr: true,
+		},
+		{
+			name:    "unknown table",
+			sql:     "SELECT name FROM accounts WHERE name = ?",
+			wantErr: true,
+		},
+		{
+			name:    "syntax error",
+			sql:     "SELECT name users",
+			wantErr: true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			stmt, err := newPreparedTestExecutor(t).prepare(tc.sql)
+			if tc.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			if assert.NoError(t, err) {
+				assert.Equal(t, tc.wantParams, stmt.params)
+			}
+		})
+	}
+}
+
+func Test_preparedStmt_exec(t *testing.T) {
+	e := newPreparedTestExecutor(t)
+	joined := time.Date(2019, 12, 1, 10, 30, 0, 0, time.UTC)
+
+	insert, err := e.prepare("INSERT INTO users (name, age, joined) VALUES (:name, :age, :joined)")
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	// The same statement can be executed many times, by position or name
+	for _, args := range [][]interface{}{
+		{"Robert'); DROP TABLE users; --", 30, joined},
+		{sql.Named("age", nil), sql.Named("name", "Jane"), sql.Named("joined", "2020-02-01")},
+		{"John", int64(51), nil},
+	} {
+		res, err := insert.exec(context.Background(), args...)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, res.rowsAffected)
+	}
+
+	// Arguments which don't fit the inferred types are rejected
+	_, err = insert.exec(context.Background(), "Ann", "thirty", nil)
+	assert.Error(t, err)
+	_, err = insert.exec(context.Background(), "Ann", 30)
+	assert.Error(t, err)
+	_, err = insert.exec(context.Background(), "Ann", 30, nil, 1)
+	assert.Error(t, err)
+	_, err = insert.exec(context.Background(), sql.Named("nickname", "Annie"))
+	assert.Error(t, err)
+
+	_, err = insert.query(context.Background(), "Ann", 30, nil)
+	assert.Error(t, err, "inserts don't return rows")
+
+	sel, err := e.prepare("SELECT name, joined FROM users WHERE age > ? AND age < ?")
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	res, err := sel.query(context.Background(), 18, 40)
+	if assert.NoError(t, err) && assert.Len(t, res.rows, 1) {
+		name, _ := decodeRecord(res.rows[0][0], columnTypeString)
+		assert.Equal(t, "Robert'); DROP TABLE users; --", name)
+
+		when, _ := decodeRecord(res.rows[0][1], columnTypeDateTime)
+		assert.Equal(t, joined, when)
+	}
+
+	res, err = sel.query(context.Background(), 40, 60)
+	if assert.NoError(t, err) && assert.Len(t, res.rows, 1) {
+		name, _ := decodeRecord(res.rows[0][0], columnTypeString)
+		assert.Equal(t, "John", name)
+	}
+
+	upd, err := e.prepare("UPDATE users SET age = ? WHERE name = ?")
+	if !assert.NoError(t, err) {
+		return
+	}
+	res, err = upd.exec(context.Background(), nil, "John")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, res.rowsAffected)
+
+	del, err := e.prepare("DELETE FROM users WHERE joined >= $1")
+	if !assert.NoError(t, err) {
+		return
+	}
+	res, err = del.exec(context.Background(), joined)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, res.rowsAffected)
+
+	res, err = e.executeQuery(context.Background(), query{queryType: selectQuery, tableName: "users", fields: []string{"name"}})
+	if assert.NoError(t, err) && assert.Len(t, res.rows, 1) {
+		name, _ := decodeRecord(res.rows[0][0], columnTypeString)
+		assert.Equal(t, "John", name)
+	}
+}
+
+func Test_preparedStmt_bind(t *testing.T) {
+	e := newExecutor(exeConfig{order: 3})
+	execSQL(t, e, "CREATE TABLE scores (name string NOT NULL, score float, at datetime)")
+
+	insert, err := e.prepare("INSERT INTO scores (name, score, at) VALUES (:name, :score, '2020-01-02')")
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	// Arguments are passed on as values converted to the parameters' types,
+	// rather than as literals
+	q, err := insert.bind([]interface{}{sql.Named("score", 1), sql.Named("name", "it's")})
+	if assert.NoError(t, err) {
+		assert.Equal(t, [][]string{{"$1", "$2", "'2020-01-02'"}}, q.inserts)
+		assert.Equal(t, []interface{}{"it's", 1.0}, q.args)
+	}
+
+	// Which lets floats without literals be bound
+	for _, args := range [][]interface{}{
+		{"nan", math.NaN()},
+		{"inf", math.Inf(1)},
+	} {
+		_, err := insert.exec(context.Background(), args...)
+		assert.NoError(t, err)
+	}
+
+	sel, err := e.prepare("SELECT score FROM scores WHERE name = ? AND at < ?")
+	if !assert.NoError(t, err) {
+		return
+	}
+	q, err = sel.bind([]interface{}{"nan", "2020-02-01"})
+	if assert.NoError(t, err) {
+		assert.Equal(t, "name = $1 AND at < $2", q.where)
+		assert.Equal(t, []interface{}{"nan", time.Date(2020, 2, 1, 0, 0, 0, 0, time.UTC)}, q.args)
+	}
+
+	res, err := sel.query(context.Background(), "nan", "2020-02-01")
+	if assert.NoError(t, err) && assert.Len(t, res.rows, 1) {
+		score, _ := decodeRecord(res.rows[0][0], columnTypeFloat)
+		assert.True(t, math.IsNaN(score.(float64)))
+	}
+	res, err = sel.query(context.Background(), "inf", "2020-02-01")
+	if assert.NoError(t, err) && assert.Len(t, res.rows, 1) {
+		score, _ := decodeRecord(res.rows[0][0], columnTypeFloat)
+		assert.Equal(t, math.Inf(1), score)
+	}
+}
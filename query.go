@@ -1,12 +1,27 @@
 package lbadd
 
 type query struct {
-	queryType  queryType
-	tableName  string
-	conditions []condition
-	updates    map[string]string
-	inserts    [][]string
-	fields     []string
+	queryType queryType
+	tableName string
+	where     string // the condition of a WHERE, an expression as parseExpr takes it
+	updates   []update
+	inserts   [][]string
+	fields    []string      // the fields of a SELECT may also be aggregates, as in count(*)
+	columns   []column      // the columns of a created table
+	args      []interface{} // the values bound to the $n placeholders of a prepared statement, by position
+
+	groupBy []string   // the fields a SELECT groups its rows by
+	orderBy []orderKey // the fields a SELECT orders its rows by
+	limit   string     // the number of rows a SELECT returns at most, empty if it isn't limited
+	offset  string     // the number of rows a SELECT skips, empty if it skips none
+	locking string     // SHARE or UPDATE, how a SELECT ... FOR locks the rows it reads, empty if it doesn't
+
+	copyTo      bool         // whether a COPY writes the table to the file, rather than reading it
+	copyFile    string       // the file of a COPY, as a string literal
+	copyOptions []copyOption // the options of a COPY
+
+	savepoint string         // the savepoint of a SAVEPOINT, RELEASE or ROLLBACK TO
+	isolation isolationLevel // the isolation level of a SET TRANSACTION
 }
 
 // The type of the parsed query
@@ -19,6 +34,15 @@ const (
 	updateQuery
 	insertQuery
 	deleteQuery
+	beginQuery
+	commitQuery
+	rollbackQuery
+	createTableQuery
+	dropTableQuery
+	copyQuery
+	savepointQuery
+	releaseQuery
+	setTransactionQuery
 )
 
 func (qt queryType) String() string {
@@ -31,6 +55,24 @@ func (qt queryType) String() string {
 		return "INSERT"
 	case deleteQuery:
 		return "DELETE"
+	case beginQuery:
+		return "BEGIN"
+	case commitQuery:
+		return "COMMIT"
+	case rollbackQuery:
+		return "ROLLBACK"
+	case createTableQuery:
+		return "CREATE TABLE"
+	case dropTableQuery:
+		return "DROP TABLE"
+	case copyQuery:
+		return "COPY"
+	case savepointQuery:
+		return "SAVEPOINT"
+	case releaseQuery:
+		return "RELEASE"
+	case setTransactionQuery:
+		return "SET TRANSACTION"
 	default:
 		return "UNKNOWN"
 	}
@@ -49,6 +91,68 @@ const (
 	lesserOrEqual                // <=
 )
 
+// newOperator returns the operator for the given symbol, or unknownOperator
+// if the symbol is not an operator.
+func newOperator(symbol string) operatorType {
+	switch symbol {
+	case "=":
+		return equal
+	case "!=", "<>":
+		return notEqual
+	case ">":
+		return greater
+	case "<":
+		return lesser
+	case ">=":
+		return greaterOrEqual
+	case "<=":
+		return lesserOrEqual
+	default:
+		return unknownOperator
+	}
+}
+
+// symbol returns the symbol used for the operator within SQL and the IR.
+func (ot operatorType) symbol() string {
+	switch ot {
+	case equal:
+		return "="
+	case notEqual:
+		return "!="
+	case greater:
+		return ">"
+	case lesser:
+		return "<"
+	case greaterOrEqual:
+		return ">="
+	case lesserOrEqual:
+		return "<="
+	default:
+		return ""
+	}
+}
+
+// holds reports whether the operator is satisfied given the result of
+// comparing its operands, as returned by compareValues.
+func (ot operatorType) holds(cmp int) bool {
+	switch ot {
+	case equal:
+		return cmp == 0
+	case notEqual:
+		return cmp != 0
+	case greater:
+		return cmp > 0
+	case lesser:
+		return cmp < 0
+	case greaterOrEqual:
+		return cmp >= 0
+	case lesserOrEqual:
+		return cmp <= 0
+	default:
+		return false
+	}
+}
+
 func (ot operatorType) String() string {
 	switch ot {
 	case equal:
@@ -68,11 +172,22 @@ func (ot operatorType) String() string {
 	}
 }
 
-// Condition
-type condition struct {
-	lhs        string
-	lhsIsField bool
-	operator   operatorType
-	rhs        string
-	rhsIsField bool
+// Option of a COPY, as in DELIMITER ';'. Values are kept exactly as written
+// in the query.
+type copyOption struct {
+	name  string
+	value string
+}
+
+// Update of a single field, as in SET field = value. Values are kept exactly
+// as written in the query, e.g. 'text', 12 or NULL.
+type update struct {
+	field string
+	value string
+}
+
+// Key of an ORDER BY, as in age DESC. The field may also be an aggregate.
+type orderKey struct {
+	field string
+	desc  bool
 }
@@ -0,0 +1,338 @@
+package lbadd
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Values are handled by the executor as Go values, with the type depending
+// on the type of the column they belong to:
+//
+//	integer   int64
+//	float     float64
+//	boolean   bool
+//	string    string
+//	datetime  time.Time
+//
+// A NULL value is represented by nil, both as a value and as a record.
+
+// The layouts accepted for datetime literals
+var dateTimeLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02",
+}
+
+// parseLiteral parses a literal as written in SQL or the IR, being a quoted
+// string, a number, TRUE, FALSE or NULL. Any other word is taken to be a
+// string as is.
+func parseLiteral(lit string) interface{} {
+	if len(lit) >= 2 && lit[0] == '\'' && lit[len(lit)-1] == '\'' {
+		return strings.Replace(lit[1:len(lit)-1], "''", "'", -1)
+	}
+
+	switch toUp(lit) {
+	case "NULL":
+		return nil
+	case "TRUE":
+		return true
+	case "FALSE":
+		return false
+	}
+
+	if i, err := strconv.ParseInt(lit, 10, 64); err == nil {
+		return i
+	}
+	if numberPattern.MatchString(lit) {
+		if f, err := strconv.ParseFloat(lit, 64); err == nil {
+			return f
+		}
+	}
+
+	return lit
+}
+
+// parseValue parses a literal like parseLiteral, unless it's a parameter
+// placeholder, which takes the value of the argument bound to it.
+func parseValue(lit string, args []interface{}) (interface{}, error) {
+	if !isPlaceholder(lit) {
+		return parseLiteral(lit), nil
+	}
+	if v, ok := boundValue(lit, args); ok {
+		return v, nil
+	}
+
+	return nil, fmt.Errorf("no value bound to parameter %s", lit)
+}
+
+// formatLiteral formats a value as a literal which parses back into the same
+// value with parseLiteral. Datetimes and floats which aren't finite have no
+// literals of their own, and are formatted as strings, which convertValue
+// converts back into them.
+func formatLiteral(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return "NULL"
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		switch {
+		case math.IsNaN(v):
+			return quoteString("NaN")
+		case math.IsInf(v, 1):
+			return quoteString("Infinity")
+		case math.IsInf(v, -1):
+			return quoteString("-Infinity")
+		}
+
+		s := strconv.FormatFloat(v, 'g', -1, 64)
+		// Keep floats recognisable as such, an integral float would
+		// otherwise be read back as an integer
+		if !strings.ContainsAny(s, ".e") {
+			s += ".0"
+		}
+		return s
+	case bool:
+		if v {
+			return "TRUE"
+		}
+		return "FALSE"
+	case time.Time:
+		return quoteString(v.Format(time.RFC3339Nano))
+	default:
+		return quoteString(fmt.Sprint(v))
+	}
+}
+
+// quoteString quotes s as a string literal, escaping any quotes within it.
+func quoteString(s string) string {
+	return "'" + strings.Replace(s, "'", "''", -1) + "'"
+}
+
+// convertValue converts the value into the Go type used for columns of the
+// given type, returning an error if the value can't be represented by it.
+// NULL values are returned as is.
+func convertValue(v interface{}, t columnType) (interface{}, error) {
+	if v == nil {
+		return nil, nil
+	}
+
+	switch t {
+	case columnTypeInt:
+		switch v := v.(type) {
+		case int64:
+			return v, nil
+		case float64:
+			if v != math.Trunc(v) {
+				break
+			}
+			// float64(math.MaxInt64) rounds up to 2^63, which is out of
+			// range itself
+			if v < math.MinInt64 || v >= math.MaxInt64 {
+				return nil, fmt.Errorf("%s value out of range: %s", t, formatLiteral(v))
+			}
+			return int64(v), nil
+		}
+	case columnTypeFloat:
+		switch v := v.(type) {
+		case float64:
+			return v, nil
+		case int64:
+			return float64(v), nil
+		case string:
+			switch toUp(v) {
+			case "NAN":
+				return math.NaN(), nil
+			case "INFINITY", "+INFINITY":
+				return math.Inf(1), nil
+			case "-INFINITY":
+				return math.Inf(-1), nil
+			}
+		}
+	case columnTypeBool:
+		if b, ok := v.(bool); ok {
+			return b, nil
+		}
+	case columnTypeString:
+		if s, ok := v.(string); ok {
+			return s, nil
+		}
+	case columnTypeDateTime:
+		switch v := v.(type) {
+		case time.Time:
+			return v, nil
+		case string:
+			for _, layout := range dateTimeLayouts {
+				if tm, err := time.Parse(layout, v); err == nil {
+					return tm, nil
+				}
+			}
+		}
+	}
+
+	return nil, fmt.Errorf("invalid %s value: %s", t, formatLiteral(v))
+}
+
+// encodeValue encodes a value, which must already have been converted to the
+// column's type, into a record.
+func encodeValue(v interface{}, t columnType) (record, error) {
+	if v == nil {
+		return nil, nil
+	}
+
+	switch t {
+	case columnTypeInt:
+		r := make(record, 8)
+		binary.BigEndian.PutUint64(r, uint64(v.(int64)))
+		return r, nil
+	case columnTypeFloat:
+		r := make(record, 8)
+		binary.BigEndian.PutUint64(r, math.Float64bits(v.(float64)))
+		return r, nil
+	case columnTypeBool:
+		if v.(bool) {
+			return record{1}, nil
+		}
+		return record{0}, nil
+	case columnTypeString:
+		return append(record{}, v.(string)...), nil
+	case columnTypeDateTime:
+		return v.(time.Time).MarshalBinary()
+	default:
+		return nil, fmt.Errorf("can't encode value of type %s", t)
+	}
+}
+
+// encodeRow converts and encodes one value for each of the table's columns into
+// a row, checking that NULL is only given for nullable columns.
+func encodeRow(t table, values []interface{}) (row, error) {
+	r := make(row, len(t.columns))
+	for i, col := range t.columns {
+		v, err := convertValue(values[i], col.dataType)
+		if err != nil {
+			return nil, fmt.Errorf("column %s: %v", col.name, err)
+		}
+		if v == nil && !col.isNullable {
+			return nil, fmt.Errorf("column %s can't be NULL", col.name)
+		}
+
+		if r[i], err = encodeValue(v, col.dataType); err != nil {
+			return nil, fmt.Errorf("column %s: %v", col.name, err)
+		}
+	}
+
+	return r, nil
+}
+
+// decodeRecord decodes a record of a column with the given type into its
+// value.
+func decodeRecord(r record, t columnType) (interface{}, error) {
+	if r == nil {
+		return nil, nil
+	}
+
+	switch t {
+	case columnTypeInt:
+		if len(r) != 8 {
+			return nil, fmt.Errorf("invalid %s record of length %d", t, len(r))
+		}
+		return int64(binary.BigEndian.Uint64(r)), nil
+	case columnTypeFloat:
+		if len(r) != 8 {
+			return nil, fmt.Errorf("invalid %s record of length %d", t, len(r))
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(r)), nil
+	case columnTypeBool:
+		if len(r) != 1 {
+			return nil, fmt.Errorf("invalid %s record of length %d", t, len(r))
+		}
+		return r[0] != 0, nil
+	case columnTypeString:
+		return string(r), nil
+	case columnTypeDateTime:
+		var tm time.Time
+		if err := tm.UnmarshalBinary(r); err != nil {
+			return nil, fmt.Errorf("invalid %s record: %v", t, err)
+		}
+		return tm, nil
+	default:
+		return nil, fmt.Errorf("can't decode record of type %s", t)
+	}
+}
+
+// compareValues compares two non-NULL values, returning -1, 0 or 1 if a is
+// less than, equal to or greater than b respectively. Integers and floats can
+// be compared with each other, other values only with values of the same
+// type.
+func compareValues(a, b interface{}) (int, error) {
+	switch a := a.(type) {
+	case int64:
+		switch b := b.(type) {
+		case int64:
+			return compareInts(a, b), nil
+		case float64:
+			return compareFloats(float64(a), b), nil
+		}
+	case float64:
+		switch b := b.(type) {
+		case int64:
+			return compareFloats(a, float64(b)), nil
+		case float64:
+			return compareFloats(a, b), nil
+		}
+	case bool:
+		if b, ok := b.(bool); ok {
+			switch {
+			case a == b:
+				return 0, nil
+			case b:
+				return -1, nil
+			default:
+				return 1, nil
+			}
+		}
+	case string:
+		if b, ok := b.(string); ok {
+			return strings.Compare(a, b), nil
+		}
+	case time.Time:
+		if b, ok := b.(time.Time); ok {
+			switch {
+			case a.Before(b):
+				return -1, nil
+			case a.After(b):
+				return 1, nil
+			default:
+				return 0, nil
+			}
+		}
+	}
+
+	return 0, fmt.Errorf("can't compare %s with %s", formatLiteral(a), formatLiteral(b))
+}
+
+func compareInts(a, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	default:
+		return 0
+	}
+}
+
+func compareFloats(a, b float64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	default:
+		return 0
+	}
+}
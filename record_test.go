@@ -0,0 +1,168 @@
+package lbadd
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseLiteral(t *testing.T) {
+	cases := []struct {
+		lit  string
+		want interface{}
+	}{
+		{"'text'", "text"},
+		{"'it''s'", "it's"},
+		{"''", ""},
+		{"NULL", nil},
+		{"null", nil},
+		{"TRUE", true},
+		{"false", false},
+		{"42", int64(42)},
+		{"-7", int64(-7)},
+		{"1.5", 1.5},
+		{"1e3", 1000.0},
+		{"word", "word"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.lit, func(t *testing.T) {
+			assert.Equal(t, tc.want, parseLiteral(tc.lit))
+		})
+	}
+}
+
+func TestFormatLiteral(t *testing.T) {
+	cases := []struct {
+		value interface{}
+		want  string
+	}{
+		{nil, "NULL"},
+		{int64(-3), "-3"},
+		{2.5, "2.5"},
+		{2.0, "2.0"},
+		{1e21, "1e+21"},
+		{true, "TRUE"},
+		{"it's", "'it''s'"},
+		{time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC), "'2020-01-02T03:04:05Z'"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.want, func(t *testing.T) {
+			lit := formatLiteral(tc.value)
+			assert.Equal(t, tc.want, lit)
+
+			// Literals parse back into the same value, except for times
+			// which are read as strings until converted
+			if _, isTime := tc.value.(time.Time); !isTime {
+				assert.Equal(t, tc.value, parseLiteral(lit))
+			}
+		})
+	}
+}
+
+func TestConvertValue(t *testing.T) {
+	date := time.Date(2020, 1, 2, 0, 0, 0, 0, time.UTC)
+
+	cases := []struct {
+		name    string
+		value   interface{}
+		typ     columnType
+		want    interface{}
+		wantErr bool
+	}{
+		{name: "null", value: nil, typ: columnTypeInt, want: nil},
+		{name: "int", value: int64(1), typ: columnTypeInt, want: int64(1)},
+		{name: "integral float to int", value: 2.0, typ: columnTypeInt, want: int64(2)},
+		{name: "fractional float to int", value: 2.5, typ: columnTypeInt, wantErr: true},
+		{name: "smallest int from float", value: -9223372036854775808.0, typ: columnTypeInt, want: int64(math.MinInt64)},
+		{name: "float above the largest int", value: 9223372036854775808.0, typ: columnTypeInt, wantErr: true},
+		{name: "infinite float to int", value: math.Inf(-1), typ: columnTypeInt, wantErr: true},
+		{name: "int to float", value: int64(2), typ: columnTypeFloat, want: 2.0},
+		{name: "string to int", value: "2", typ: columnTypeInt, wantErr: true},
+		{name: "bool", value: true, typ: columnTypeBool, want: true},
+		{name: "int to bool", value: int64(1), typ: columnTypeBool, wantErr: true},
+		{name: "string", value: "x", typ: columnTypeString, want: "x"},
+		{name: "int to string", value: int64(1), typ: columnTypeString, wantErr: true},
+		{name: "date string to datetime", value: "2020-01-02", typ: columnTypeDateTime, want: date},
+		{name: "rfc3339 string to datetime", value: "2020-01-02T00:00:00Z", typ: columnTypeDateTime, want: date},
+		{name: "invalid datetime", value: "yesterday", typ: columnTypeDateTime, wantErr: true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := convertValue(tc.value, tc.typ)
+			if tc.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func TestEncodeDecodeRecord(t *testing.T) {
+	cases := []struct {
+		name  string
+		value interface{}
+		typ   columnType
+	}{
+		{"null", nil, columnTypeString},
+		{"int", int64(-1234567890123), columnTypeInt},
+		{"float", -12.75, columnTypeFloat},
+		{"true", true, columnTypeBool},
+		{"false", false, columnTypeBool},
+		{"string", "héllo", columnTypeString},
+		{"empty string", "", columnTypeString},
+		{"datetime", time.Date(2020, 1, 2, 3, 4, 5, 6, time.UTC), columnTypeDateTime},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			r, err := encodeValue(tc.value, tc.typ)
+			assert.NoError(t, err)
+
+			// Only NULL is encoded as a nil record
+			assert.Equal(t, tc.value == nil, r == nil)
+
+			got, err := decodeRecord(r, tc.typ)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.value, got)
+		})
+	}
+}
+
+func TestCompareValues(t *testing.T) {
+	early := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	late := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	cases := []struct {
+		name    string
+		a, b    interface{}
+		want    int
+		wantErr bool
+	}{
+		{name: "ints", a: int64(1), b: int64(2), want: -1},
+		{name: "int and float", a: int64(2), b: 1.5, want: 1},
+		{name: "equal floats", a: 1.5, b: 1.5, want: 0},
+		{name: "bools", a: true, b: false, want: 1},
+		{name: "strings", a: "a", b: "b", want: -1},
+		{name: "datetimes", a: late, b: early, want: 1},
+		{name: "mismatched types", a: "1", b: int64(1), wantErr: true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := compareValues(tc.a, tc.b)
+			if tc.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.want, got)
+		})
+	}
+}
@@ -0,0 +1,373 @@
+package lbadd
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// The format the rows of results are written in
+type outputFormat int
+
+const (
+	outputTable outputFormat = iota
+	outputCSV
+	outputJSON
+	outputJSONLines
+	outputMarkdown
+)
+
+var outputFormatNames = []string{"table", "csv", "json", "jsonl", "markdown"}
+
+func (f outputFormat) String() string {
+	return outputFormatNames[f]
+}
+
+// parseOutputFormat returns the output format with the given name.
+func parseOutputFormat(name string) (outputFormat, error) {
+	for i, n := range outputFormatNames {
+		if strings.EqualFold(name, n) {
+			return outputFormat(i), nil
+		}
+	}
+
+	return outputTable, fmt.Errorf("unknown format %s, expected one of %s", name, strings.Join(outputFormatNames, ", "))
+}
+
+// renderResult writes the result to w. The rows of a select are written in
+// the given format, any other result as a summary of the rows affected and
+// resources created. NULL values are displayed as null, except in JSON where
+// they are always null.
+func renderResult(w io.Writer, res result, format outputFormat, null string) error {
+	if res.columns == nil {
+		return renderSummary(w, res)
+	}
+
+	switch format {
+	case outputCSV:
+		return renderCSV(w, res.columns, res.rows, null)
+	case outputJSON, outputJSONLines:
+		return renderJSON(w, res.columns, res.rows, format == outputJSONLines)
+	case outputMarkdown:
+		return renderMarkdown(w, res.columns, res.rows, null)
+	default:
+		if err := renderTable(w, res.columns, res.rows, null); err != nil {
+			return err
+		}
+
+		_, err := fmt.Fprintf(w, "(%s)\n", plural(len(res.rows), "row"))
+		return err
+	}
+}
+
+// renderSummary writes the number of rows affected and resources created by a
+// statement which doesn't return rows, and the number of rows rejected by a
+// copy, or the outcome of a transaction command.
+func renderSummary(w io.Writer, res result) error {
+	if res.status != "" {
+		_, err := fmt.Fprintln(w, res.status)
+		return err
+	}
+	if res.created > 0 {
+		if _, err := fmt.Fprintf(w, "%d created\n", res.created); err != nil {
+			return err
+		}
+	}
+	if res.rowsAffected > 0 || res.created == 0 {
+		if _, err := fmt.Fprintf(w, "%s affected\n", plural(res.rowsAffected, "row")); err != nil {
+			return err
+		}
+	}
+	if len(res.rejected) > 0 {
+		if _, err := fmt.Fprintf(w, "%s rejected\n", plural(len(res.rejected), "row")); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// renderTable writes the rows as a table with a header of the column names,
+// e.g.
+//
+//	+------+-----+
+//	| name | age |
+//	+------+-----+
+//	| Jane |   7 |
+//	+------+-----+
+//
+// Numbers are aligned to the right, all other values to the left.
+func renderTable(w io.Writer, columns []column, rows []row, null string) error {
+	cells, err := displayCells(columns, rows, null)
+	if err != nil {
+		return err
+	}
+	widths := cellWidths(namesOf(columns), cells)
+
+	var b strings.Builder
+
+	border := func() {
+		b.WriteString("+")
+		for _, width := range widths {
+			b.WriteString(strings.Repeat("-", width+2) + "+")
+		}
+		b.WriteString("\n")
+	}
+	line := func(values []string, alignNumbers bool) {
+		b.WriteString("|")
+		for i, v := range values {
+			b.WriteString(" " + pad(v, widths[i], alignNumbers && isNumeric(columns[i])) + " |")
+		}
+		b.WriteString("\n")
+	}
+
+	border()
+	line(namesOf(columns), false)
+	border()
+	for _, values := range cells {
+		line(values, true)
+	}
+	if len(cells) > 0 {
+		border()
+	}
+
+	_, err = io.WriteString(w, b.String())
+	return err
+}
+
+// renderCSV writes the rows as CSV, with a header record of the column names.
+func renderCSV(w io.Writer, columns []column, rows []row, null string) error {
+	cells, err := displayCells(columns, rows, null)
+	if err != nil {
+		return err
+	}
+
+	cw := csv.NewWriter(w)
+	if err := cw.Write(namesOf(columns)); err != nil {
+		return err
+	}
+	if err := cw.WriteAll(cells); err != nil {
+		return err
+	}
+
+	return cw.Error()
+}
+
+// renderJSON writes the rows as a JSON array of objects, with a key for each
+// column in order, or with lines set as one object per line (JSON Lines).
+// Values keep their types, datetimes are written as RFC 3339 strings.
+func renderJSON(w io.Writer, columns []column, rows []row, lines bool) error {
+	keys := make([][]byte, len(columns))
+	for i, c := range columns {
+		k, err := json.Marshal(c.name)
+		if err != nil {
+			return err
+		}
+		keys[i] = k
+	}
+
+	var b bytes.Buffer
+	if !lines {
+		b.WriteString("[")
+	}
+
+	for i, r := range rows {
+		switch {
+		case lines:
+		case i == 0:
+			b.WriteString("\n  ")
+		default:
+			b.WriteString(",\n  ")
+		}
+
+		b.WriteString("{")
+		for j, c := range columns {
+			v, err := decodeRecord(r[j], c.dataType)
+			if err != nil {
+				return fmt.Errorf("column %s: %v", c.name, err)
+			}
+			if tm, ok := v.(time.Time); ok {
+				v = tm.Format(time.RFC3339Nano)
+			}
+
+			val, err := json.Marshal(v)
+			if err != nil {
+				return fmt.Errorf("column %s: %v", c.name, err)
+			}
+
+			if j > 0 {
+				b.WriteString(",")
+			}
+			b.Write(keys[j])
+			b.WriteString(":")
+			b.Write(val)
+		}
+		b.WriteString("}")
+
+		if lines {
+			b.WriteString("\n")
+		}
+	}
+
+	if !lines {
+		if len(rows) > 0 {
+			b.WriteString("\n")
+		}
+		b.WriteString("]\n")
+	}
+
+	_, err := w.Write(b.Bytes())
+	return err
+}
+
+// renderMarkdown writes the rows as a GitHub flavoured Markdown table. Pipes
+// within values are escaped, and line breaks replaced by <br>.
+func renderMarkdown(w io.Writer, columns []column, rows []row, null string) error {
+	cells, err := displayCells(columns, rows, null)
+	if err != nil {
+		return err
+	}
+
+	escape := strings.NewReplacer("|", `\|`, "\r\n", "<br>", "\n", "<br>")
+	names := namesOf(columns)
+	for i := range names {
+		names[i] = escape.Replace(names[i])
+	}
+	for _, values := range cells {
+		for i := range values {
+			values[i] = escape.Replace(values[i])
+		}
+	}
+
+	// Delimiter rows need at least three dashes
+	widths := cellWidths(names, cells)
+	for i := range widths {
+		if widths[i] < 3 {
+			widths[i] = 3
+		}
+	}
+
+	var b strings.Builder
+	line := func(values []string, alignNumbers bool) {
+		b.WriteString("|")
+		for i, v := range values {
+			b.WriteString(" " + pad(v, widths[i], alignNumbers && isNumeric(columns[i])) + " |")
+		}
+		b.WriteString("\n")
+	}
+
+	line(names, false)
+	b.WriteString("|")
+	for i, width := range widths {
+		if isNumeric(columns[i]) {
+			b.WriteString(" " + strings.Repeat("-", width-1) + ": |")
+		} else {
+			b.WriteString(" " + strings.Repeat("-", width) + " |")
+		}
+	}
+	b.WriteString("\n")
+	for _, values := range cells {
+		line(values, true)
+	}
+
+	_, err = io.WriteString(w, b.String())
+	return err
+}
+
+// displayCells decodes the rows, and formats each of their values for
+// display.
+func displayCells(columns []column, rows []row, null string) ([][]string, error) {
+	cells := make([][]string, len(rows))
+	for i, r := range rows {
+		cells[i] = make([]string, len(columns))
+		for j, c := range columns {
+			v, err := decodeRecord(r[j], c.dataType)
+			if err != nil {
+				return nil, fmt.Errorf("column %s: %v", c.name, err)
+			}
+
+			cells[i][j] = displayValue(v, null)
+		}
+	}
+
+	return cells, nil
+}
+
+// cellWidths returns the width of each column, being the number of characters
+// of its longest cell or its header.
+func cellWidths(header []string, cells [][]string) []int {
+	widths := make([]int, len(header))
+	for i, h := range header {
+		widths[i] = utf8.RuneCountInString(h)
+	}
+
+	for _, values := range cells {
+		for i, v := range values {
+			if n := utf8.RuneCountInString(v); n > widths[i] {
+				widths[i] = n
+			}
+		}
+	}
+
+	return widths
+}
+
+// pad pads the value with spaces to the given width, on the left if it is
+// aligned to the right.
+func pad(v string, width int, alignRight bool) string {
+	padding := strings.Repeat(" ", width-utf8.RuneCountInString(v))
+	if alignRight {
+		return padding + v
+	}
+
+	return v + padding
+}
+
+func namesOf(columns []column) []string {
+	names := make([]string, len(columns))
+	for i, c := range columns {
+		names[i] = c.name
+	}
+
+	return names
+}
+
+func isNumeric(c column) bool {
+	return c.dataType == columnTypeInt || c.dataType == columnTypeFloat
+}
+
+// displayValue formats a decoded value for display, as opposed to
+// formatLiteral which formats it as a literal. NULL values are displayed as
+// null.
+func displayValue(v interface{}, null string) string {
+	switch v := v.(type) {
+	case nil:
+		return null
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	case time.Time:
+		return v.Format(time.RFC3339Nano)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// plural returns the count followed by the noun, which is pluralised unless
+// the count is exactly one.
+func plural(n int, noun string) string {
+	if n == 1 {
+		return "1 " + noun
+	}
+
+	return strconv.Itoa(n) + " " + noun + "s"
+}
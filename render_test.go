@@ -0,0 +1,182 @@
+package lbadd
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_renderResult(t *testing.T) {
+	enc := func(v interface{}, typ columnType) record { r, _ := encodeValue(v, typ); return r }
+
+	nameCol := column{dataType: columnTypeString, name: "name"}
+	ageCol := column{dataType: columnTypeInt, name: "age", isNullable: true}
+
+	cases := []struct {
+		name     string
+		res      result
+		null     string
+		expected string
+	}{
+		{
+			name: "rows",
+			res: result{
+				columns: []column{nameCol, ageCol},
+				rows: []row{
+					{enc("Jane", columnTypeString), enc(int64(7), columnTypeInt)},
+					{enc("Björn Borg", columnTypeString), nil},
+				},
+			},
+			null: "NULL",
+			expected: "" +
+				"+------------+------+\n" +
+				"| name       | age  |\n" +
+				"+------------+------+\n" +
+				"| Jane       |    7 |\n" +
+				"| Björn Borg | NULL |\n" +
+				"+------------+------+\n" +
+				"(2 rows)\n",
+		},
+		{
+			name: "single row with custom null",
+			res: result{
+				columns: []column{ageCol, {dataType: columnTypeBool, name: "ok"}, {dataType: columnTypeDateTime, name: "at"}},
+				rows: []row{
+					{nil, enc(true, columnTypeBool), enc(time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC), columnTypeDateTime)},
+				},
+			},
+			null: "-",
+			expected: "" +
+				"+-----+------+----------------------+\n" +
+				"| age | ok   | at                   |\n" +
+				"+-----+------+----------------------+\n" +
+				"|   - | true | 2020-01-02T03:04:05Z |\n" +
+				"+-----+------+----------------------+\n" +
+				"(1 row)\n",
+		},
+		{
+			name: "no rows",
+			res:  result{columns: []column{nameCol}},
+			expected: "" +
+				"+------+\n" +
+				"| name |\n" +
+				"+------+\n" +
+				"(0 rows)\n",
+		},
+		{
+			name:     "rows affected",
+			res:      result{rowsAffected: 3},
+			expected: "3 rows affected\n",
+		},
+		{
+			name:     "no rows affected",
+			res:      result{},
+			expected: "0 rows affected\n",
+		},
+		{
+			name:     "created",
+			res:      result{created: 1},
+			expected: "1 created\n",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			var b bytes.Buffer
+			assert.NoError(t, renderResult(&b, tc.res, outputTable, tc.null))
+			assert.Equal(t, tc.expected, b.String())
+		})
+	}
+}
+
+func Test_renderResult_invalidRecord(t *testing.T) {
+	res := result{
+		columns: []column{{dataType: columnTypeInt, name: "age"}},
+		rows:    []row{{record{1, 2}}},
+	}
+
+	var b bytes.Buffer
+	assert.EqualError(t, renderResult(&b, res, outputTable, "NULL"), "column age: invalid integer record of length 2")
+}
+
+func Test_renderResult_formats(t *testing.T) {
+	enc := func(v interface{}, typ columnType) record { r, _ := encodeValue(v, typ); return r }
+
+	res := result{
+		columns: []column{
+			{dataType: columnTypeString, name: "name"},
+			{dataType: columnTypeFloat, name: "score", isNullable: true},
+			{dataType: columnTypeBool, name: "ok"},
+			{dataType: columnTypeDateTime, name: "at"},
+		},
+		rows: []row{
+			{enc(`say "hi", | bye`, columnTypeString), enc(1.5, columnTypeFloat), enc(true, columnTypeBool), enc(time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC), columnTypeDateTime)},
+			{enc("two\nlines", columnTypeString), nil, enc(false, columnTypeBool), enc(time.Date(2021, 6, 7, 8, 9, 10, 500, time.UTC), columnTypeDateTime)},
+		},
+	}
+
+	cases := []struct {
+		name     string
+		format   outputFormat
+		expected string
+	}{
+		{
+			name:   "csv",
+			format: outputCSV,
+			expected: "" +
+				"name,score,ok,at\n" +
+				"\"say \"\"hi\"\", | bye\",1.5,true,2020-01-02T03:04:05Z\n" +
+				"\"two\nlines\",NULL,false,2021-06-07T08:09:10.0000005Z\n",
+		},
+		{
+			name:   "json",
+			format: outputJSON,
+			expected: "" +
+				"[\n" +
+				"  {\"name\":\"say \\\"hi\\\", | bye\",\"score\":1.5,\"ok\":true,\"at\":\"2020-01-02T03:04:05Z\"},\n" +
+				"  {\"name\":\"two\\nlines\",\"score\":null,\"ok\":false,\"at\":\"2021-06-07T08:09:10.0000005Z\"}\n" +
+				"]\n",
+		},
+		{
+			name:   "json lines",
+			format: outputJSONLines,
+			expected: "" +
+				"{\"name\":\"say \\\"hi\\\", | bye\",\"score\":1.5,\"ok\":true,\"at\":\"2020-01-02T03:04:05Z\"}\n" +
+				"{\"name\":\"two\\nlines\",\"score\":null,\"ok\":false,\"at\":\"2021-06-07T08:09:10.0000005Z\"}\n",
+		},
+		{
+			name:   "markdown",
+			format: outputMarkdown,
+			expected: "" +
+				"| name             | score | ok    | at                           |\n" +
+				"| ---------------- | ----: | ----- | ---------------------------- |\n" +
+				"| say \"hi\", \\| bye |   1.5 | true  | 2020-01-02T03:04:05Z         |\n" +
+				"| two<br>lines     |  NULL | false | 2021-06-07T08:09:10.0000005Z |\n",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			var b bytes.Buffer
+			assert.NoError(t, renderResult(&b, res, tc.format, "NULL"))
+			assert.Equal(t, tc.expected, b.String())
+		})
+	}
+}
+
+func Test_renderResult_emptyJSON(t *testing.T) {
+	var b bytes.Buffer
+	assert.NoError(t, renderResult(&b, result{columns: []column{{dataType: columnTypeInt, name: "a"}}}, outputJSON, "NULL"))
+	assert.Equal(t, "[]\n", b.String())
+}
+
+func Test_parseOutputFormat(t *testing.T) {
+	format, err := parseOutputFormat("JSONL")
+	assert.NoError(t, err)
+	assert.Equal(t, outputJSONLines, format)
+
+	_, err = parseOutputFormat("xml")
+	assert.EqualError(t, err, "unknown format xml, expected one of table, csv, json, jsonl, markdown")
+}
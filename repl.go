@@ -2,80 +2,684 @@ package lbadd
 
 import (
 	"bufio"
+	"context"
+	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
 	"strings"
+	"time"
 )
 
-// Repl is an interactive print loop which accepts instructions in the form of
-// the database's intermediary representation, and executes the statements
+// The language the repl reads its input in
+type replMode int
+
+const (
+	modeSQL replMode = iota
+	modeIR
+)
+
+// Repl is an interactive print loop which accepts statements, either in SQL
+// or in the database's intermediary representation, and executes them
 // against the database.
 type Repl struct {
 	executor *executor
+	mode     replMode
+	out      io.Writer    // where results are written to
+	errOut   io.Writer    // where errors are written to
+	format   outputFormat // the format rows are written in
+	null     string       // how NULL values are displayed
+
+	interactive     bool // whether to show prompts and messages
+	continueOnError bool // whether to carry on after errors when not interactive
+
+	timing      bool   // whether to show how long statements take
+	buffer      string // the SQL entered which hasn't been executed yet
+	last        string // the SQL executed last
+	historyFile string // the file the lines entered on a terminal are kept in
+
+	timeout    time.Duration  // how long a statement may run, no limit if zero
+	interrupts chan os.Signal // interrupts which cancel the running statement, nil if they aren't caught
 }
 
-// NewRepl creates a new repl instance
+// NewRepl creates a new repl instance, reading SQL by default
 func NewRepl() *Repl {
 	return &Repl{
 		executor: newExecutor(exeConfig{
 			order: defaultOrder,
 		}),
+		mode:        modeSQL,
+		out:         os.Stdout,
+		errOut:      os.Stderr,
+		null:        "NULL",
+		historyFile: defaultHistoryFile(),
+	}
+}
+
+// SetFormat sets the format the rows of results are written in, one of
+// table, csv, json, jsonl or markdown.
+func (r *Repl) SetFormat(name string) error {
+	format, err := parseOutputFormat(name)
+	if err != nil {
+		return err
+	}
+
+	r.format = format
+	return nil
+}
+
+// SetInteractive sets whether the repl is used interactively, in which case
+// prompts and informational messages are shown, and errors never stop it.
+func (r *Repl) SetInteractive(interactive bool) {
+	r.interactive = interactive
+}
+
+// SetContinueOnError sets whether a non-interactive repl continues with the
+// rest of its input after a statement fails, rather than stopping.
+func (r *Repl) SetContinueOnError(continueOnError bool) {
+	r.continueOnError = continueOnError
+}
+
+// SetHistoryFile sets the file the history of lines entered on a terminal is
+// kept in, by default .lbadd_history in the home directory. History isn't kept
+// if the file is empty.
+func (r *Repl) SetHistoryFile(path string) {
+	r.historyFile = path
+}
+
+// SetTimeout sets how long a statement may run for before it is canceled.
+// Statements may run for any length of time if the timeout is zero, which is
+// the default.
+func (r *Repl) SetTimeout(timeout time.Duration) {
+	r.timeout = timeout
+}
+
+// defaultHistoryFile returns the file history is kept in by default, or
+// nothing if there is no home directory.
+func defaultHistoryFile() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
 	}
+
+	return filepath.Join(home, ".lbadd_history")
 }
 
-// Start begings the execution of the given repl instance
+// Dump writes the database to w as a script of SQL statements, which
+// recreates it when run.
+func (r *Repl) Dump(w io.Writer) error {
+	ctx, cancel := r.statementContext()
+	defer cancel()
+
+	return r.executor.dump(ctx, w)
+}
+
+// Start begings the execution of the given repl instance, reading from
+// standard input interactively. On a terminal, lines can be edited, are kept
+// in the history, and can be completed with tab, and Ctrl-C cancels the
+// statement running rather than exiting.
 func (r *Repl) Start() {
-	sc := bufio.NewScanner(os.Stdin)
-	fmt.Println("Starting Bad SQL repl")
+	r.interactive = true
+	fmt.Fprintln(r.out, "Starting Bad SQL repl")
+
+	raw, ok := rawMode(os.Stdin)
+	if !ok {
+		_ = r.Run(os.Stdin)
+		return
+	}
+
+	// While a line is edited the terminal is in raw mode, where Ctrl-C is
+	// read as a key, so interrupts are only received while statements run
+	r.interrupts = make(chan os.Signal, 1)
+	signal.Notify(r.interrupts, os.Interrupt)
+	defer func() {
+		signal.Stop(r.interrupts)
+		r.interrupts = nil
+	}()
+
+	editor := newLineEditor(os.Stdin, r.out, r.historyFile, r.complete)
+	editor.raw = raw
+	_ = r.loop(editor)
+}
+
+// Run reads and executes the input line by line, until the end of the input
+// or a quit command. SQL statements may span several lines, and are executed
+// once terminated by a semicolon, or at the end of the input. Errors are
+// reported as they happen. Unless the repl is interactive, it stops at the
+// first error and returns it, or if it continues on errors, returns the first
+// error once all of the input has been run.
+func (r *Repl) Run(in io.Reader) error {
+	sc := bufio.NewScanner(in)
+	sc.Buffer(nil, maxLineLength)
+
+	return r.loop(&scanLines{sc: sc, out: r.out, prompts: r.interactive})
+}
+
+// loop reads and executes lines from the reader, as described by Run.
+func (r *Repl) loop(lr lineReader) error {
+	var first error
+	stop := func(err error) bool {
+		if err == nil || r.interactive {
+			return false
+		}
+		if first == nil {
+			first = err
+		}
+		return !r.continueOnError
+	}
 
 	for {
-		fmt.Print("$ ")
-		sc.Scan()
+		line, err := lr.readLine(r.prompt())
+		if err == errInterrupted {
+			r.buffer = ""
+			continue
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		err = r.handle(line)
+		if err == errQuit {
+			return first
+		}
+		if stop(err) {
+			return err
+		}
+	}
+
+	if r.interactive {
+		// Discard any unterminated statement, as with a terminal the input
+		// is usually ended deliberately
+		r.buffer = ""
+	} else if err := r.flush(true); stop(err) {
+		return err
+	}
+
+	return first
+}
+
+// lineReader reads lines of input, showing the prompt given if it is
+// interactive. io.EOF is returned at the end of the input.
+type lineReader interface {
+	readLine(prompt string) (string, error)
+}
+
+// scanLines reads lines of input without editing them.
+type scanLines struct {
+	sc      *bufio.Scanner
+	out     io.Writer
+	prompts bool // whether to show prompts
+}
+
+func (s *scanLines) readLine(prompt string) (string, error) {
+	if s.prompts {
+		fmt.Fprint(s.out, prompt)
+	}
+	if s.sc.Scan() {
+		return s.sc.Text(), nil
+	}
+
+	if s.prompts {
+		fmt.Fprintln(s.out)
+	}
+	if err := s.sc.Err(); err != nil {
+		return "", err
+	}
+	return "", io.EOF
+}
+
+// The maximum length of a line of input
+const maxLineLength = 1 << 20
 
-		input := sc.Text()
+// errQuit is returned by handle when the input asks the repl to quit.
+var errQuit = errors.New("quit")
+
+// handle handles a line of input, which is either a meta-command, an
+// instruction in the intermediary representation, or part of SQL
+// statements, which are buffered until terminated. Results are written out,
+// and errors reported before being returned.
+func (r *Repl) handle(line string) error {
+	input := strings.TrimSpace(line)
+	if !r.pending() {
 		switch input {
-		case "help", "h", "?", "\\?":
-			fmt.Println(`Available Commands:
-// TODO`)
-		case "q", "exit", "\\q":
-			fmt.Println("Bye!")
-			return
+		case "":
+			return nil
+		case "help", "h", "?":
+			return r.meta("\\?")
+		case "q", "exit":
+			return r.meta("\\q")
 		}
+	}
+
+	if strings.HasPrefix(input, "\\") {
+		return r.meta(input)
+	}
+
+	if r.mode == modeIR {
+		return r.execute(input)
+	}
+
+	r.buffer += line + "\n"
+	return r.flush(false)
+}
+
+// meta executes a meta-command.
+func (r *Repl) meta(input string) error {
+	name := strings.Fields(input)[0]
+	arg := strings.TrimSpace(strings.TrimPrefix(input, name))
+
+	switch name {
+	case "\\?":
+		fmt.Fprintln(r.out, `Available commands:
+  help, \?          show this help
+  \q, q, exit       quit
+
+Input:
+  \sql              read SQL statements (default), which end with a semicolon
+  \ir               read instructions in the intermediary representation
+  \e                edit the current statement, or the last one, in $EDITOR
+  \r                discard the current statement
+  \explain <sql>    show the instructions generated for SQL statements
+  \copy <args>      copy rows between a table and a CSV file, as COPY does
+
+Schema:
+  \dt               list tables
+  \d [table]        describe the columns and key of a table, or list tables
+  \stats            show the storage statistics of each table
+  \dump [file]      write the database as SQL to a file, or the output
+  \restore <file>   run the SQL of a dump to recreate its tables
+
+Output:
+  \format [name]    show or set the format of rows: table, csv, json, jsonl or markdown
+  \null [text]      show or set how NULL values are displayed
+  \timing [on|off]  toggle showing how long each statement takes
+
+On a terminal, tab completes keywords, tables and columns, Ctrl-R
+searches the history of lines entered, kept in ~/.lbadd_history, and
+Ctrl-C cancels the statement running.`)
+	case "\\q":
+		r.info("Bye!")
+		return errQuit
+	case "\\sql":
+		r.mode = modeSQL
+		r.info("Reading SQL")
+	case "\\ir":
+		r.mode = modeIR
+		r.buffer = ""
+		r.info("Reading intermediary representation")
+	case "\\explain":
+		instrs, err := r.explain(arg)
+		if err != nil {
+			return r.reportError(err)
+		}
+		for _, instr := range instrs {
+			fmt.Fprintln(r.out, instr)
+		}
+	case "\\format":
+		if arg != "" {
+			if err := r.SetFormat(arg); err != nil {
+				return r.reportError(err)
+			}
+		}
+		r.info("Rows are written as %s", r.format)
+	case "\\null":
+		if arg != "" {
+			r.null = arg
+		}
+		r.info("NULL is displayed as %q", r.null)
+	case "\\copy":
+		q, err := parse("COPY " + arg)
+		if err == nil && q.queryType != copyQuery {
+			err = fmt.Errorf("expected a table, found %s", arg)
+		}
+		var instrs []instruction
+		if err == nil {
+			instrs, err = codegen(q)
+		}
+		if err != nil {
+			return r.reportError(err)
+		}
+		ctx, cancel := r.statementContext()
+		defer cancel()
+
+		// The file is accessed on behalf of the REPL's user, unlike that
+		// of a COPY statement
+		return r.describe(r.executor.autocommit(func() (result, error) {
+			return r.executor.copyFile(ctx, instrs[0])
+		}))
+	case "\\dt":
+		return r.describe(r.executor.listTables())
+	case "\\d":
+		if arg == "" {
+			return r.describe(r.executor.listTables())
+		}
+		return r.describe(r.executor.describeTable(arg))
+	case "\\stats":
+		return r.describe(r.executor.storageStats())
+	case "\\timing":
+		switch strings.ToLower(arg) {
+		case "":
+			r.timing = !r.timing
+		case "on":
+			r.timing = true
+		case "off":
+			r.timing = false
+		default:
+			return r.reportError(fmt.Errorf("expected on or off, got %s", arg))
+		}
+		if r.timing {
+			r.info("Timing is on")
+		} else {
+			r.info("Timing is off")
+		}
+	case "\\dump":
+		if err := r.dump(arg); err != nil {
+			return r.reportError(err)
+		}
+	case "\\restore":
+		if arg == "" {
+			return r.reportError(fmt.Errorf("expected the file to restore"))
+		}
+		f, err := os.Open(arg)
+		if err != nil {
+			return r.reportError(err)
+		}
+		defer f.Close()
+
+		ctx, cancel := r.statementContext()
+		defer cancel()
 
+		return r.describe(r.executor.restore(ctx, f))
+	case "\\e":
+		return r.edit()
+	case "\\r":
+		r.buffer = ""
+		r.info("Statement discarded")
+	default:
+		return r.reportError(fmt.Errorf("unknown command %s, see \\? for the available commands", name))
+	}
+
+	return nil
+}
+
+// dump writes a dump of the database to the file, or if none is given, to the
+// output.
+func (r *Repl) dump(file string) error {
+	if file == "" {
+		return r.Dump(r.out)
+	}
+
+	f, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	if err := r.Dump(f); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	r.info("Database dumped to %s", file)
+	return nil
+}
+
+// describe writes out a result describing the database, in the format used
+// for the rows of results.
+func (r *Repl) describe(res result, err error) error {
+	if err == nil {
+		err = renderResult(r.out, res, r.format, r.null)
+	}
+	if err != nil {
+		return r.reportError(err)
+	}
+	r.reportRejected(res)
+
+	return nil
+}
+
+// pending reports whether the buffer holds the start of a statement which
+// hasn't been terminated yet.
+func (r *Repl) pending() bool {
+	return skipSpaceAndComments(r.buffer, 0) < len(r.buffer)
+}
+
+// flush executes the statements in the buffer which have been terminated by
+// a semicolon, or with all set, everything in the buffer. Whatever follows
+// the last semicolon is left in the buffer.
+func (r *Repl) flush(all bool) error {
+	end := len(r.buffer)
+	if !all {
+		terms := terminators(r.buffer)
+		if len(terms) == 0 {
+			return nil
+		}
+		end = terms[len(terms)-1] + 1
+	}
+
+	sql := r.buffer[:end]
+	r.buffer = r.buffer[end:]
+	if strings.TrimSpace(r.buffer) == "" {
+		r.buffer = ""
+	}
+
+	if len(splitStatements(sql)) == 0 {
+		return nil
+	}
+	r.last = strings.TrimSpace(sql)
+
+	return r.execute(sql)
+}
+
+// edit opens the buffer, or if nothing is pending the last statements
+// executed, in the editor given by $EDITOR, or vi if it isn't set. Once the
+// editor exits, the edited text replaces the buffer, and any statements in it
+// which are terminated are executed.
+func (r *Repl) edit() error {
+	text := r.last
+	if r.pending() {
+		text = r.buffer
+	}
+
+	f, err := ioutil.TempFile("", "lbadd-*.sql")
+	if err != nil {
+		return r.reportError(err)
+	}
+	defer os.Remove(f.Name())
+
+	_, err = f.WriteString(text)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return r.reportError(err)
+	}
+
+	editor := strings.Fields(os.Getenv("EDITOR"))
+	if len(editor) == 0 {
+		editor = []string{"vi"}
+	}
+
+	cmd := exec.Command(editor[0], append(editor[1:], f.Name())...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err := cmd.Run(); err != nil {
+		return r.reportError(fmt.Errorf("editor: %v", err))
+	}
+
+	edited, err := ioutil.ReadFile(f.Name())
+	if err != nil {
+		return r.reportError(err)
+	}
+
+	r.buffer = string(edited)
+	if !strings.HasSuffix(r.buffer, "\n") {
+		r.buffer += "\n"
+	}
+
+	return r.flush(false)
+}
+
+// execute executes the input in the repl's current mode, and writes out the
+// results, each followed by the time it took if timing is enabled.
+func (r *Repl) execute(input string) error {
+	err := r.run(input, func(res result, elapsed time.Duration) error {
+		if err := renderResult(r.out, res, r.format, r.null); err != nil {
+			return err
+		}
+		r.reportRejected(res)
+		if r.timing {
+			fmt.Fprintf(r.out, "Time: %.3f ms\n", float64(elapsed)/float64(time.Millisecond))
+		}
+		return nil
+	})
+	if err != nil {
+		return r.reportError(err)
+	}
+
+	return nil
+}
+
+// prompt returns the prompt shown for the repl's current mode, or while a
+// statement is pending, the continuation prompt.
+func (r *Repl) prompt() string {
+	switch {
+	case r.mode == modeIR:
+		return "ir> "
+	case r.pending():
+		return "...> "
+	default:
+		return "sql> "
+	}
+}
+
+// run executes the input in the repl's current mode, calling fn with the
+// result of each statement executed and the time it took to execute.
+func (r *Repl) run(input string, fn func(res result, elapsed time.Duration) error) error {
+	if r.mode == modeIR {
 		instr, err := r.readCommand(input)
 		if err != nil {
-			fmt.Printf("\nInvalid command: %v", err)
-			continue
+			return fmt.Errorf("invalid command: %v", err)
 		}
 
-		_, err = r.executor.execute(instr)
+		ctx, cancel := r.statementContext()
+		defer cancel()
+
+		start := time.Now()
+		res, err := r.executor.execute(ctx, instr)
 		if err != nil {
-			fmt.Printf("Err: %v\n", err)
-			continue
+			return err
+		}
+		return fn(res, time.Since(start))
+	}
+
+	stmts, err := parseScript(input)
+	if err != nil {
+		return err
+	}
+
+	for _, s := range stmts {
+		ctx, cancel := r.statementContext()
+		start := time.Now()
+		res, err := r.executor.executeQuery(ctx, s.query)
+		cancel()
+		if err != nil {
+			return err
+		}
+		if err := fn(res, time.Since(start)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// statementContext returns the context a statement is executed with, which
+// is canceled once the timeout passes, or if interrupts are caught, on an
+// interrupt. The context must be canceled once the statement has finished.
+func (r *Repl) statementContext() (context.Context, context.CancelFunc) {
+	var ctx context.Context
+	var cancel context.CancelFunc
+	if r.timeout > 0 {
+		ctx, cancel = context.WithTimeout(context.Background(), r.timeout)
+	} else {
+		ctx, cancel = context.WithCancel(context.Background())
+	}
+	if r.interrupts == nil {
+		return ctx, cancel
+	}
+
+	// Discard interrupts received while no statement was running
+	for len(r.interrupts) > 0 {
+		<-r.interrupts
+	}
+
+	go func() {
+		select {
+		case <-r.interrupts:
+			cancel()
+		case <-ctx.Done():
 		}
+	}()
+
+	return ctx, cancel
+}
+
+// explain returns the instructions which the SQL statements of the input
+// generate, without executing them.
+func (r *Repl) explain(input string) ([]instruction, error) {
+	stmts, err := parseScript(input)
+	if err != nil {
+		return nil, err
 	}
+
+	instrs := []instruction{}
+	for _, s := range stmts {
+		generated, err := codegen(s.query)
+		if err != nil {
+			return nil, err
+		}
+		instrs = append(instrs, generated...)
+	}
+
+	return instrs, nil
 }
 
+// readCommand parses the input as an instruction in the intermediary
+// representation.
 func (r *Repl) readCommand(input string) (instruction, error) {
-	tokens := strings.Split(input, " ")
-	instr := instruction{}
-
-	switch newCommand(tokens[0]) {
-	case commandInsert:
-		instr.command = commandInsert
-		instr.table = tokens[1]
-		instr.params = tokens[2:]
-	case commandSelect:
-		instr.command = commandSelect
-		instr.table = tokens[1]
-		instr.params = tokens[2:]
-	case commandDelete:
-		instr.command = commandDelete
-		instr.table = tokens[1]
-		instr.params = tokens[2:]
-	default:
-		return instr, nil
+	return parseInstruction(input)
+}
+
+// info prints an informational message, if the repl is interactive.
+func (r *Repl) info(format string, args ...interface{}) {
+	if r.interactive {
+		fmt.Fprintf(r.out, format+"\n", args...)
+	}
+}
+
+// reportRejected reports the rows of input a copy rejected, as errors which
+// don't stop the repl.
+func (r *Repl) reportRejected(res result) {
+	for _, rej := range res.rejected {
+		fmt.Fprintf(r.errOut, "Rejected %s\n", rej)
+	}
+}
+
+// reportError prints the error, pointing out the offending token of parse
+// errors, and returns it.
+func (r *Repl) reportError(err error) error {
+	fmt.Fprintf(r.errOut, "Err: %v\n", err)
+	if perr, ok := err.(*ParseError); ok {
+		fmt.Fprintln(r.errOut, perr.Caret())
 	}
 
-	return instr, nil
+	return err
 }
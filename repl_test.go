@@ -1,7 +1,14 @@
 package lbadd
 
 import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -15,22 +22,32 @@ func TestReadCommand(t *testing.T) {
 		{
 			name:     "insert command",
 			command:  "insert users a b",
-			expected: instruction{commandInsert, "users", []string{"a", "b"}},
+			expected: instruction{commandInsert, "users", []string{"a", "b"}, nil},
 		},
 		{
 			name:     "select command",
 			command:  "select table a b c",
-			expected: instruction{commandSelect, "table", []string{"a", "b", "c"}},
+			expected: instruction{commandSelect, "table", []string{"a", "b", "c"}, nil},
 		},
 		{
 			name:     "select with filter",
 			command:  "select table a b c<1",
-			expected: instruction{commandSelect, "table", []string{"a", "b", "c<1"}},
+			expected: instruction{commandSelect, "table", []string{"a", "b", "c<1"}, nil},
 		},
 		{
 			name:     "delete command",
 			command:  "delete table a>6 b=1",
-			expected: instruction{commandDelete, "table", []string{"a>6", "b=1"}},
+			expected: instruction{commandDelete, "table", []string{"a>6", "b=1"}, nil},
+		},
+		{
+			name:     "create table command",
+			command:  "create table users name string false",
+			expected: instruction{commandCreateTable, "users", []string{"name", "string", "false"}, nil},
+		},
+		{
+			name:     "repeated whitespace",
+			command:  "  insert  users\t'John  Smith' 42 ",
+			expected: instruction{commandInsert, "users", []string{"'John  Smith'", "42"}, nil},
 		},
 	}
 
@@ -42,3 +59,369 @@ func TestReadCommand(t *testing.T) {
 		})
 	}
 }
+
+func TestReplRun(t *testing.T) {
+	r := NewRepl()
+
+	run := func(input string) ([]result, error) {
+		results := []result{}
+		err := r.run(input, func(res result, _ time.Duration) error {
+			results = append(results, res)
+			return nil
+		})
+		return results, err
+	}
+
+	results, err := run("CREATE TABLE users (name string NOT NULL, age integer); INSERT INTO users VALUES ('Jane', 7)")
+	assert.NoError(t, err)
+	assert.Len(t, results, 2)
+
+	results, err = run("SELECT name FROM users WHERE age > 5")
+	assert.NoError(t, err)
+	assert.Len(t, results, 1)
+	assert.Len(t, results[0].rows, 1)
+
+	_, err = run("insert users 'John' 42")
+	assert.IsType(t, &ParseError{}, err)
+
+	r.mode = modeIR
+	_, err = run("insert users 'John' 42")
+	assert.NoError(t, err)
+
+	results, err = run("select users name")
+	assert.NoError(t, err)
+	assert.Len(t, results[0].rows, 2)
+
+	// SQL is read as IR, selecting from the table called name
+	_, err = run("SELECT name FROM users")
+	assert.EqualError(t, err, "table name does not exist")
+
+	_, err = run("truncate users")
+	assert.EqualError(t, err, "invalid command: unknown command truncate")
+}
+
+func TestReplExplain(t *testing.T) {
+	cases := []struct {
+		name     string
+		sql      string
+		expected []string
+		err      string
+	}{
+		{
+			name:     "select",
+			sql:      "SELECT name FROM users WHERE age >= 18",
+			expected: []string{"select users name (age >= 18)"},
+		},
+		{
+			name:     "multiple statements",
+			sql:      "INSERT INTO users VALUES (1), (2); DELETE FROM users",
+			expected: []string{"insert users 1", "insert users 2", "delete users"},
+		},
+		{
+			name: "parse error",
+			sql:  "SELECT FROM users",
+			err:  "line 1, column 8: at SELECT: unexpected FROM, expected field",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			instrs, err := NewRepl().explain(tc.sql)
+			if tc.err != "" {
+				assert.EqualError(t, err, tc.err)
+				return
+			}
+			assert.NoError(t, err)
+
+			actual := []string{}
+			for _, instr := range instrs {
+				actual = append(actual, instr.String())
+			}
+			assert.Equal(t, tc.expected, actual)
+		})
+	}
+}
+
+func TestRepl_Run(t *testing.T) {
+	script := strings.Join([]string{
+		`\format csv`,
+		"CREATE TABLE users (name string NOT NULL);",
+		"INSERT INTO users (email) VALUES ('x');",
+		"INSERT INTO users VALUES ('Jane');",
+		"SELECT name FROM users;",
+		`\q`,
+		"SELECT name FROM missing;",
+	}, "\n")
+
+	cases := []struct {
+		name            string
+		continueOnError bool
+		interactive     bool
+		out             string
+		err             string
+	}{
+		{
+			name: "stops at the first error",
+			out:  "1 created\n",
+			err:  "column email does not exist in table users",
+		},
+		{
+			name:            "continues after errors until quit",
+			continueOnError: true,
+			out:             "1 created\n1 row affected\nname\nJane\n",
+			err:             "column email does not exist in table users",
+		},
+		{
+			name:        "interactive shows prompts and never stops",
+			interactive: true,
+			out:         "sql> Rows are written as csv\nsql> 1 created\nsql> sql> 1 row affected\nsql> name\nJane\nsql> Bye!\n",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			var out, errOut bytes.Buffer
+			r := NewRepl()
+			r.out, r.errOut = &out, &errOut
+			r.SetInteractive(tc.interactive)
+			r.SetContinueOnError(tc.continueOnError)
+
+			err := r.Run(strings.NewReader(script))
+			if tc.err != "" {
+				assert.EqualError(t, err, tc.err)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tc.out, out.String())
+			assert.Equal(t, "Err: column email does not exist in table users\n", errOut.String())
+		})
+	}
+}
+
+func TestRepl_Run_EOF(t *testing.T) {
+	var out, errOut bytes.Buffer
+	r := NewRepl()
+	r.out, r.errOut = &out, &errOut
+
+	assert.NoError(t, r.Run(strings.NewReader("CREATE TABLE t (a integer)\n\n")))
+	assert.Equal(t, "1 created\n", out.String())
+	assert.Empty(t, errOut.String())
+}
+
+func TestRepl_Run_multiLine(t *testing.T) {
+	script := strings.Join([]string{
+		"CREATE TABLE users (",
+		"  name string",
+		");",
+		"INSERT INTO users VALUES ('a;b'); INSERT INTO users",
+		"VALUES ('c'); SELECT * FROM users WHERE name = 'nope'",
+		`\r`,
+		"SELECT *",
+		"  FROM users -- ;",
+		"  WHERE name != 'c';",
+		"SELECT",
+	}, "\n")
+
+	var out, errOut bytes.Buffer
+	r := NewRepl()
+	r.out, r.errOut = &out, &errOut
+	r.SetInteractive(true)
+
+	assert.NoError(t, r.Run(strings.NewReader(script)))
+	assert.Equal(t, ""+
+		"sql> ...> ...> 1 created\n"+
+		"sql> 1 row affected\n"+
+		"...> 1 row affected\n"+
+		"...> Statement discarded\n"+
+		"sql> ...> ...> +------+\n"+
+		"| name |\n"+
+		"+------+\n"+
+		"| a;b  |\n"+
+		"+------+\n"+
+		"(1 row)\n"+
+		"sql> ...> \n", out.String())
+	assert.Empty(t, errOut.String())
+}
+
+func TestRepl_Run_unterminatedAtEOF(t *testing.T) {
+	var out, errOut bytes.Buffer
+	r := NewRepl()
+	r.out, r.errOut = &out, &errOut
+
+	err := r.Run(strings.NewReader("CREATE TABLE t (a integer); SELECT\n  b FROM t"))
+	assert.EqualError(t, err, "column b does not exist in table t")
+	assert.Equal(t, "1 created\n", out.String())
+}
+
+func TestRepl_edit(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lbadd")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	// The editor completes the statement in the file it is given
+	editor := filepath.Join(dir, "editor.sh")
+	err = ioutil.WriteFile(editor, []byte("#!/bin/sh\nprintf 'FROM users;\\n' >> \"$1\"\n"), 0700)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.Setenv("EDITOR", os.Getenv("EDITOR"))
+	os.Setenv("EDITOR", editor)
+
+	var out, errOut bytes.Buffer
+	r := NewRepl()
+	r.out, r.errOut = &out, &errOut
+	r.SetFormat("csv")
+
+	script := "CREATE TABLE users (name string);\nINSERT INTO users VALUES ('Jane');\nSELECT name\n\\e\n"
+	assert.NoError(t, r.Run(strings.NewReader(script)))
+	assert.Equal(t, "1 created\n1 row affected\nname\nJane\n", out.String())
+	assert.Equal(t, "SELECT name\nFROM users;", r.last)
+	assert.Empty(t, errOut.String())
+}
+
+func TestRepl_meta_unknown(t *testing.T) {
+	var out, errOut bytes.Buffer
+	r := NewRepl()
+	r.out, r.errOut = &out, &errOut
+
+	assert.EqualError(t, r.Run(strings.NewReader(`\nope`)), `unknown command \nope, see \? for the available commands`)
+}
+
+func TestRepl_meta_schema(t *testing.T) {
+	script := strings.Join([]string{
+		`\format csv`,
+		"CREATE TABLE users (name string NOT NULL, age integer);",
+		`\dt`,
+		`\d users`,
+		`\timing`,
+		"INSERT INTO users VALUES ('a', 1);",
+		`\timing off`,
+		`\stats`,
+		`\d missing`,
+	}, "\n")
+
+	var out, errOut bytes.Buffer
+	r := NewRepl()
+	r.out, r.errOut = &out, &errOut
+
+	assert.EqualError(t, r.Run(strings.NewReader(script)), "table missing does not exist")
+	assert.Regexp(t, `^1 created
+table,columns,rows
+users,2,0
+column,type,nullable,key
+rowid,integer,false,true
+name,string,false,false
+age,integer,true,false
+1 row affected
+Time: \d+\.\d{3} ms
+table,rows,nodes,height,order
+users,1,1,1,\d+
+$`, out.String())
+}
+
+func TestRepl_copy(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lbadd")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "users.csv")
+	err = ioutil.WriteFile(file, []byte("name,age\nJane,7\nJohn,old\n"), 0600)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	script := strings.Join([]string{
+		"CREATE TABLE users (name string, age integer);",
+		`\copy users FROM '` + file + `'`,
+		`\copy users (name) TO '` + file + `' WITH (HEADER FALSE)`,
+		"COPY users TO '" + file + "';",
+		`\copy users FROM`,
+	}, "\n")
+
+	var out, errOut bytes.Buffer
+	r := NewRepl()
+	r.out, r.errOut = &out, &errOut
+
+	r.SetContinueOnError(true)
+	assert.Error(t, r.Run(strings.NewReader(script)))
+	assert.Equal(t, "1 created\n1 row affected\n1 row rejected\n1 row affected\n", out.String())
+	assert.Equal(t, ""+
+		"Rejected line 3: column age: invalid integer value: 'old'\n"+
+		"Err: copy can't access files unless FileCopy is set in the database's options\n"+
+		"Err: line 1, column 16: at COPY: unexpected end of input, expected file name\n"+
+		"COPY users FROM\n"+
+		"               ^\n", errOut.String())
+
+	data, err := ioutil.ReadFile(file)
+	assert.NoError(t, err)
+	assert.Equal(t, "Jane\n", string(data))
+}
+
+func TestRepl_dump(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lbadd")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "dump.sql")
+
+	var out, errOut bytes.Buffer
+	r := NewRepl()
+	r.out, r.errOut = &out, &errOut
+
+	script := "CREATE TABLE t (a integer);\nINSERT INTO t VALUES (1), (2);\n\\dump " + file + "\n"
+	assert.NoError(t, r.Run(strings.NewReader(script)))
+
+	out.Reset()
+	r = NewRepl()
+	r.out, r.errOut = &out, &errOut
+	r.SetFormat("csv")
+
+	script = "\\restore " + file + "\nSELECT a FROM t;\n\\dump\n\\restore\n"
+	assert.EqualError(t, r.Run(strings.NewReader(script)), "expected the file to restore")
+	assert.Equal(t, ""+
+		"1 created\n2 rows affected\n"+
+		"a\n1\n2\n"+
+		"-- lbadd database dump\n\nCREATE TABLE t (\n  a integer\n);\nINSERT INTO t\nVALUES\n  (1),\n  (2);\n", out.String())
+}
+
+func TestRepl_timeout(t *testing.T) {
+	var out, errOut bytes.Buffer
+	r := NewRepl()
+	r.out, r.errOut = &out, &errOut
+	r.SetContinueOnError(true)
+
+	assert.NoError(t, r.Run(strings.NewReader("CREATE TABLE t (a integer);")))
+
+	// Statements which run past the timeout are canceled, without stopping
+	// those which follow
+	r.SetTimeout(time.Nanosecond)
+	err := r.Run(strings.NewReader("SELECT * FROM t;\n\\d t\n"))
+	assert.True(t, errors.Is(err, ErrCanceled))
+	assert.Equal(t, "Err: statement timed out\n", errOut.String())
+	assert.Contains(t, out.String(), "integer")
+}
+
+func TestRepl_transactions(t *testing.T) {
+	var out, errOut bytes.Buffer
+	r := NewRepl()
+	r.out, r.errOut = &out, &errOut
+
+	script := `CREATE TABLE t (a integer);
+BEGIN;
+INSERT INTO t VALUES (1);
+ROLLBACK;
+\ir
+begin
+insert t 2
+commit
+select t a
+`
+	assert.NoError(t, r.Run(strings.NewReader(script)))
+	assert.Equal(t, "1 created\nBEGIN\n1 row affected\nROLLBACK\nBEGIN\n1 row affected\nCOMMIT\n+---+\n| a |\n+---+\n| 2 |\n+---+\n(1 row)\n", out.String())
+	assert.Equal(t, "", errOut.String())
+}
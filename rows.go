@@ -0,0 +1,187 @@
+package lbadd
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"time"
+)
+
+// Rows are the rows selected by a query. Rows are iterated over with Next,
+// and the values of each read with Scan, e.g.
+//
+//	rows, err := db.Query(ctx, "SELECT name, age FROM users WHERE age > ?", 18)
+//	...
+//	defer rows.Close()
+//	for rows.Next() {
+//		var name string
+//		var age int
+//		if err := rows.Scan(&name, &age); err != nil {
+//			...
+//		}
+//	}
+//	if err := rows.Err(); err != nil {
+//		...
+//	}
+type Rows struct {
+	columns []column
+	rows    []row
+	pos     int // the position of the current row, starting at 1
+	err     error
+	closed  bool
+}
+
+// Scanner is implemented by types which can scan a value themselves, such as
+// sql.NullString. The value is nil for NULL, or an int64, float64, bool,
+// string or time.Time.
+type Scanner interface {
+	Scan(src interface{}) error
+}
+
+// ErrRowsClosed is returned when using rows which have been closed.
+var ErrRowsClosed = errors.New("rows are closed")
+
+// Columns returns the names of the columns selected.
+func (r *Rows) Columns() []string {
+	return namesOf(r.columns)
+}
+
+// Next advances to the next row, returning false once there are no more rows
+// or after the rows are closed.
+func (r *Rows) Next() bool {
+	if r.closed || r.pos >= len(r.rows) {
+		r.closed = true
+		return false
+	}
+
+	r.pos++
+	return true
+}
+
+// Scan reads the values of the current row into dest, which holds a pointer
+// for each of the columns. The pointers may be to a value of the column's
+// type, being int64, float64, bool, string or time.Time, or to a type the
+// value can be converted to without loss:
+//
+//	integer   int, int64, float64
+//	float     float64
+//	string    string, []byte
+//
+// Any value can be read into a *string, which holds the value as displayed,
+// or an *interface{}, and NULL values only into an *interface{}, a pointer to
+// a pointer, or a Scanner.
+func (r *Rows) Scan(dest ...interface{}) error {
+	if r.closed {
+		return ErrRowsClosed
+	}
+	if r.pos == 0 {
+		return errors.New("no current row, Next must be called before Scan")
+	}
+	if len(dest) != len(r.columns) {
+		return fmt.Errorf("expected %d destinations, got %d", len(r.columns), len(dest))
+	}
+
+	row := r.rows[r.pos-1]
+	for i, c := range r.columns {
+		v, err := decodeRecord(row[i], c.dataType)
+		if err != nil {
+			return err
+		}
+		if err := scanValue(dest[i], v); err != nil {
+			return fmt.Errorf("column %s: %v", c.name, err)
+		}
+	}
+
+	return nil
+}
+
+// Err returns any error which ended the iteration of the rows early.
+func (r *Rows) Err() error {
+	return r.err
+}
+
+// Close closes the rows, which is done automatically once Next has returned
+// false.
+func (r *Rows) Close() error {
+	r.closed = true
+	r.rows = nil
+	return nil
+}
+
+// scanValue stores the decoded value in the destination.
+func scanValue(dest, v interface{}) error {
+	if s, ok := dest.(Scanner); ok {
+		return s.Scan(v)
+	}
+
+	switch d := dest.(type) {
+	case *interface{}:
+		*d = v
+		return nil
+	case *string:
+		if v == nil {
+			break
+		}
+		*d = displayValue(v, "")
+		return nil
+	}
+
+	// A pointer to a pointer is set to nil for NULL, and otherwise to a new
+	// value holding the value
+	if p := reflect.ValueOf(dest); p.Kind() == reflect.Ptr && p.Elem().Kind() == reflect.Ptr {
+		if v == nil {
+			p.Elem().Set(reflect.Zero(p.Elem().Type()))
+			return nil
+		}
+
+		value := reflect.New(p.Elem().Type().Elem())
+		if err := scanValue(value.Interface(), v); err != nil {
+			return err
+		}
+		p.Elem().Set(value)
+		return nil
+	}
+
+	if v == nil {
+		return fmt.Errorf("can't scan NULL into %T", dest)
+	}
+
+	switch d := dest.(type) {
+	case *int64:
+		if v, ok := v.(int64); ok {
+			*d = v
+			return nil
+		}
+	case *int:
+		if v, ok := v.(int64); ok && int64(int(v)) == v {
+			*d = int(v)
+			return nil
+		}
+	case *float64:
+		switch v := v.(type) {
+		case float64:
+			*d = v
+			return nil
+		case int64:
+			*d = float64(v)
+			return nil
+		}
+	case *bool:
+		if v, ok := v.(bool); ok {
+			*d = v
+			return nil
+		}
+	case *[]byte:
+		if v, ok := v.(string); ok {
+			*d = []byte(v)
+			return nil
+		}
+	case *time.Time:
+		if v, ok := v.(time.Time); ok {
+			*d = v
+			return nil
+		}
+	}
+
+	return fmt.Errorf("can't scan %s into %T", formatLiteral(v), dest)
+}
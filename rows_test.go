@@ -0,0 +1,81 @@
+package lbadd
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRows_Scan(t *testing.T) {
+	born := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	e := newExecutor(exeConfig{order: 3})
+	execSQL(t, e, "CREATE TABLE t (i integer, f float, b boolean, s string, d datetime)")
+	execSQL(t, e, "INSERT INTO t VALUES (1, 1.5, TRUE, 'x', '2020-01-02T03:04:05Z'), (NULL, NULL, NULL, NULL, NULL)")
+	res := execSQL(t, e, "SELECT * FROM t")
+
+	rows := &Rows{columns: res.columns, rows: res.rows}
+	assert.Equal(t, []string{"i", "f", "b", "s", "d"}, rows.Columns())
+
+	var s string
+	assert.EqualError(t, rows.Scan(&s), "no current row, Next must be called before Scan")
+
+	// Values of the column types, and types they convert to
+	assert.True(t, rows.Next())
+	var i int64
+	var f float64
+	var b bool
+	var d time.Time
+	assert.NoError(t, rows.Scan(&i, &f, &b, &s, &d))
+	assert.Equal(t, []interface{}{int64(1), 1.5, true, "x", born}, []interface{}{i, f, b, s, d})
+
+	var n int
+	var asFloat float64
+	var bytes []byte
+	var value interface{}
+	var text string
+	assert.NoError(t, rows.Scan(&n, &asFloat, &value, &bytes, &text))
+	assert.Equal(t, []interface{}{1, 1.5, true, []byte("x"), "2020-01-02T03:04:05Z"}, []interface{}{n, asFloat, value, bytes, text})
+
+	assert.EqualError(t, rows.Scan(&i, &i, &b, &s, &d), "column f: can't scan 1.5 into *int64")
+	assert.EqualError(t, rows.Scan(&i), "expected 5 destinations, got 1")
+
+	// NULL values
+	assert.True(t, rows.Next())
+	assert.EqualError(t, rows.Scan(&i, &f, &b, &s, &d), "column i: can't scan NULL into *int64")
+
+	ip := &i
+	var ns sql.NullString
+	var nb sql.NullBool
+	var tp *time.Time
+	assert.NoError(t, rows.Scan(&ip, &value, &nb, &ns, &tp))
+	assert.Nil(t, ip)
+	assert.Nil(t, value)
+	assert.False(t, nb.Valid)
+	assert.False(t, ns.Valid)
+	assert.Nil(t, tp)
+
+	assert.False(t, rows.Next())
+	assert.Equal(t, ErrRowsClosed, rows.Scan(&i, &f, &b, &s, &d))
+	assert.NoError(t, rows.Err())
+}
+
+func TestRows_Scan_pointers(t *testing.T) {
+	e := newExecutor(exeConfig{order: 3})
+	execSQL(t, e, "CREATE TABLE t (i integer, s string)")
+	execSQL(t, e, "INSERT INTO t VALUES (3, 'x')")
+	res := execSQL(t, e, "SELECT * FROM t")
+
+	rows := &Rows{columns: res.columns, rows: res.rows}
+	assert.True(t, rows.Next())
+
+	var i *int
+	var s *string
+	assert.NoError(t, rows.Scan(&i, &s))
+	if assert.NotNil(t, i) && assert.NotNil(t, s) {
+		assert.Equal(t, 3, *i)
+		assert.Equal(t, "x", *s)
+	}
+}
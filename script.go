@@ -0,0 +1,106 @@
+package lbadd
+
+// span marks the location of a statement within a script, as byte offsets
+// into the source. The end offset is exclusive.
+type span struct {
+	start int
+	end   int
+}
+
+// statement is a single parsed statement of a script, alongside the location
+// in the script which it was parsed from.
+type statement struct {
+	query query
+	span  span
+}
+
+// parseScript splits the sql into its individual statements, and parses each
+// of them in order. Parsing stops at the first statement which fails to parse,
+// returning the statements parsed up to that point. The positions of parse
+// errors are relative to the whole script.
+func parseScript(sql string) ([]statement, error) {
+	stmts := []statement{}
+
+	for _, s := range splitStatements(sql) {
+		q, err := parseSpan(sql, s)
+		if err != nil {
+			return stmts, err
+		}
+
+		stmts = append(stmts, statement{query: q, span: s})
+	}
+
+	return stmts, nil
+}
+
+// splitStatements returns the spans of the statements within the sql, which
+// are separated by semicolons. Semicolons inside of string literals, quoted
+// identifiers and comments do not separate statements. Each span is trimmed
+// of surrounding whitespace and leading comments, and statements which are
+// empty once trimmed are left out.
+func splitStatements(sql string) []span {
+	spans := []span{}
+	start := 0
+
+	emit := func(end int) {
+		start = skipSpaceAndComments(sql, start)
+		for end > start && isSpace(sql[end-1]) {
+			end--
+		}
+		if end > start {
+			spans = append(spans, span{start: start, end: end})
+		}
+	}
+
+	for _, end := range terminators(sql) {
+		emit(end)
+		start = end + 1
+	}
+	emit(len(sql))
+
+	return spans
+}
+
+// terminators returns the offsets of the semicolons within the sql which
+// terminate statements, leaving out those inside of string literals, quoted
+// identifiers and comments.
+func terminators(sql string) []int {
+	offsets := []int{}
+
+	for i := 0; i < len(sql); {
+		switch c := sql[i]; {
+		case c == '\'' || c == '"':
+			i, _ = scanQuoted(sql, i)
+		case c == ';':
+			offsets = append(offsets, i)
+			i++
+		default:
+			if end := scanComment(sql, i); end != i {
+				i = end
+				continue
+			}
+			i++
+		}
+	}
+
+	return offsets
+}
+
+// skipSpaceAndComments returns the offset of the first character at or after
+// offset i of sql which is neither whitespace nor part of a comment.
+func skipSpaceAndComments(sql string, i int) int {
+	for i < len(sql) {
+		if isSpace(sql[i]) {
+			i++
+			continue
+		}
+
+		end := scanComment(sql, i)
+		if end == i {
+			break
+		}
+		i = end
+	}
+
+	return i
+}
@@ -0,0 +1,145 @@
+package lbadd
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSplitStatements(t *testing.T) {
+	cases := []struct {
+		name     string
+		sql      string
+		expected []string
+	}{
+		{
+			name:     "empty script",
+			sql:      "",
+			expected: []string{},
+		},
+		{
+			name:     "single statement without semicolon",
+			sql:      "SELECT a FROM z",
+			expected: []string{"SELECT a FROM z"},
+		},
+		{
+			name:     "multiple statements",
+			sql:      "BEGIN; SELECT a FROM z;\nCOMMIT;",
+			expected: []string{"BEGIN", "SELECT a FROM z", "COMMIT"},
+		},
+		{
+			name:     "empty statements are skipped",
+			sql:      ";; SELECT a FROM z ;  ;",
+			expected: []string{"SELECT a FROM z"},
+		},
+		{
+			name:     "semicolon inside string literal",
+			sql:      "SELECT a FROM z WHERE b = 'x;y'; COMMIT",
+			expected: []string{"SELECT a FROM z WHERE b = 'x;y'", "COMMIT"},
+		},
+		{
+			name:     "semicolon inside escaped string literal",
+			sql:      "SELECT a FROM z WHERE b = 'it''s;'; COMMIT",
+			expected: []string{"SELECT a FROM z WHERE b = 'it''s;'", "COMMIT"},
+		},
+		{
+			name:     "semicolon inside quoted identifier",
+			sql:      `SELECT "a;b" FROM z; COMMIT`,
+			expected: []string{`SELECT "a;b" FROM z`, "COMMIT"},
+		},
+		{
+			name:     "semicolon inside line comment",
+			sql:      "BEGIN -- start; now\n; COMMIT",
+			expected: []string{"BEGIN -- start; now", "COMMIT"},
+		},
+		{
+			name:     "semicolon inside block comment",
+			sql:      "BEGIN /* a;\nb */; COMMIT",
+			expected: []string{"BEGIN /* a;\nb */", "COMMIT"},
+		},
+		{
+			name:     "leading comments are trimmed",
+			sql:      "-- first\n/* second */ BEGIN; -- trailing",
+			expected: []string{"BEGIN"},
+		},
+		{
+			name:     "unterminated string runs to the end",
+			sql:      "SELECT a FROM z WHERE b = 'x; COMMIT",
+			expected: []string{"SELECT a FROM z WHERE b = 'x; COMMIT"},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			actual := []string{}
+			for _, s := range splitStatements(tc.sql) {
+				actual = append(actual, tc.sql[s.start:s.end])
+			}
+			assert.Equal(t, tc.expected, actual)
+		})
+	}
+}
+
+func TestParseScript(t *testing.T) {
+	cases := []struct {
+		name     string
+		sql      string
+		expected []statement
+		wantErr  bool
+	}{
+		{
+			name:     "empty script",
+			sql:      "  -- nothing here\n",
+			expected: []statement{},
+		},
+		{
+			name: "transaction around a select",
+			sql:  "BEGIN; SELECT a FROM z;\nCOMMIT",
+			expected: []statement{
+				{query: query{queryType: beginQuery}, span: span{0, 5}},
+				{query: query{queryType: selectQuery, fields: []string{"a"}, tableName: "z"}, span: span{7, 22}},
+				{query: query{queryType: commitQuery}, span: span{24, 30}},
+			},
+		},
+		{
+			name: "rollback transaction",
+			sql:  "begin transaction;rollback transaction;",
+			expected: []statement{
+				{query: query{queryType: beginQuery}, span: span{0, 17}},
+				{query: query{queryType: rollbackQuery}, span: span{18, 38}},
+			},
+		},
+		{
+			name: "stops at the first invalid statement",
+			sql:  "BEGIN; DROP z; COMMIT",
+			expected: []statement{
+				{query: query{queryType: beginQuery}, span: span{0, 5}},
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			actual, err := parseScript(tc.sql)
+			if tc.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tc.expected, actual)
+		})
+	}
+}
+
+func TestParseScriptErrorPosition(t *testing.T) {
+	_, err := parseScript("BEGIN;\nSELECT a\nFROM;\nCOMMIT")
+	perr, ok := err.(*ParseError)
+	if !assert.True(t, ok, "expected a *ParseError, got %T", err) {
+		return
+	}
+
+	assert.Equal(t, 3, perr.Line)
+	assert.Equal(t, 5, perr.Column)
+	assert.Equal(t, "FROM\n    ^", perr.Caret())
+}
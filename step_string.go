@@ -13,11 +13,56 @@ func _() {
 	_ = x[stepSelectComma-2]
 	_ = x[stepSelectFrom-3]
 	_ = x[stepSelectTable-4]
+	_ = x[stepSelectClause-5]
+	_ = x[stepSelectGroupBy-6]
+	_ = x[stepSelectOrderBy-7]
+	_ = x[stepSelectLimit-8]
+	_ = x[stepSelectOffset-9]
+	_ = x[stepSelectFor-10]
+	_ = x[stepInsertTable-11]
+	_ = x[stepInsertFieldsOpeningParens-12]
+	_ = x[stepInsertFields-13]
+	_ = x[stepInsertFieldsCommaOrClosingParens-14]
+	_ = x[stepInsertValuesRWord-15]
+	_ = x[stepInsertValuesOpeningParens-16]
+	_ = x[stepInsertValues-17]
+	_ = x[stepInsertValuesCommaOrClosingParens-18]
+	_ = x[stepInsertValuesCommaBeforeOpeningParens-19]
+	_ = x[stepUpdateTable-20]
+	_ = x[stepUpdateSet-21]
+	_ = x[stepUpdateField-22]
+	_ = x[stepUpdateEquals-23]
+	_ = x[stepUpdateValue-24]
+	_ = x[stepUpdateComma-25]
+	_ = x[stepDeleteFromTable-26]
+	_ = x[stepWhere-27]
+	_ = x[stepWhereExpr-28]
+	_ = x[stepTransaction-29]
+	_ = x[stepCreateTableName-30]
+	_ = x[stepCreateTableOpeningParens-31]
+	_ = x[stepCreateTableColumn-32]
+	_ = x[stepCreateTableColumnType-33]
+	_ = x[stepCreateTableNotNull-34]
+	_ = x[stepCreateTableCommaOrClosingParens-35]
+	_ = x[stepDropTableName-36]
+	_ = x[stepCopyTable-37]
+	_ = x[stepCopyColumnsOpeningParens-38]
+	_ = x[stepCopyColumn-39]
+	_ = x[stepCopyColumnCommaOrClosingParens-40]
+	_ = x[stepCopyDirection-41]
+	_ = x[stepCopyFile-42]
+	_ = x[stepCopyWith-43]
+	_ = x[stepCopyOptionsOpeningParens-44]
+	_ = x[stepCopyOption-45]
+	_ = x[stepCopyOptionValue-46]
+	_ = x[stepCopyOptionCommaOrClosingParens-47]
+	_ = x[stepSavepointName-48]
+	_ = x[stepIsolationLevel-49]
 }
 
-const _step_name = "stepInitstepSelectFieldstepSelectCommastepSelectFromstepSelectTable"
+const _step_name = "stepInitstepSelectFieldstepSelectCommastepSelectFromstepSelectTablestepSelectClausestepSelectGroupBystepSelectOrderBystepSelectLimitstepSelectOffsetstepSelectForstepInsertTablestepInsertFieldsOpeningParensstepInsertFieldsstepInsertFieldsCommaOrClosingParensstepInsertValuesRWordstepInsertValuesOpeningParensstepInsertValuesstepInsertValuesCommaOrClosingParensstepInsertValuesCommaBeforeOpeningParensstepUpdateTablestepUpdateSetstepUpdateFieldstepUpdateEqualsstepUpdateValuestepUpdateCommastepDeleteFromTablestepWherestepWhereExprstepTransactionstepCreateTableNamestepCreateTableOpeningParensstepCreateTableColumnstepCreateTableColumnTypestepCreateTableNotNullstepCreateTableCommaOrClosingParensstepDropTableNamestepCopyTablestepCopyColumnsOpeningParensstepCopyColumnstepCopyColumnCommaOrClosingParensstepCopyDirectionstepCopyFilestepCopyWithstepCopyOptionsOpeningParensstepCopyOptionstepCopyOptionValuestepCopyOptionCommaOrClosingParensstepSavepointNamestepIsolationLevel"
 
-var _step_index = [...]uint8{0, 8, 23, 38, 52, 67}
+var _step_index = [...]uint16{0, 8, 23, 38, 52, 67, 83, 100, 117, 132, 148, 161, 176, 205, 221, 257, 278, 307, 323, 359, 399, 414, 427, 442, 458, 473, 488, 507, 516, 529, 544, 563, 591, 612, 637, 659, 694, 711, 724, 752, 766, 800, 817, 829, 841, 869, 883, 902, 936, 953, 971}
 
 func (i step) String() string {
 	if i < 0 || i >= step(len(_step_index)-1) {
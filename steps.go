@@ -10,4 +10,49 @@ const (
 	stepSelectComma
 	stepSelectFrom
 	stepSelectTable
+	stepSelectClause
+	stepSelectGroupBy
+	stepSelectOrderBy
+	stepSelectLimit
+	stepSelectOffset
+	stepSelectFor
+	stepInsertTable
+	stepInsertFieldsOpeningParens
+	stepInsertFields
+	stepInsertFieldsCommaOrClosingParens
+	stepInsertValuesRWord
+	stepInsertValuesOpeningParens
+	stepInsertValues
+	stepInsertValuesCommaOrClosingParens
+	stepInsertValuesCommaBeforeOpeningParens
+	stepUpdateTable
+	stepUpdateSet
+	stepUpdateField
+	stepUpdateEquals
+	stepUpdateValue
+	stepUpdateComma
+	stepDeleteFromTable
+	stepWhere
+	stepWhereExpr
+	stepTransaction
+	stepCreateTableName
+	stepCreateTableOpeningParens
+	stepCreateTableColumn
+	stepCreateTableColumnType
+	stepCreateTableNotNull
+	stepCreateTableCommaOrClosingParens
+	stepDropTableName
+	stepCopyTable
+	stepCopyColumnsOpeningParens
+	stepCopyColumn
+	stepCopyColumnCommaOrClosingParens
+	stepCopyDirection
+	stepCopyFile
+	stepCopyWith
+	stepCopyOptionsOpeningParens
+	stepCopyOption
+	stepCopyOptionValue
+	stepCopyOptionCommaOrClosingParens
+	stepSavepointName
+	stepIsolationLevel
 )
@@ -0,0 +1,11 @@
+// +build !linux,!darwin
+
+package lbadd
+
+import "os"
+
+// rawMode isn't supported on this platform, so terminals are read a line at
+// a time without line editing.
+func rawMode(f *os.File) (raw func() (restore func() error, err error), ok bool) {
+	return nil, false
+}
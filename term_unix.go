@@ -0,0 +1,56 @@
+// +build linux darwin
+
+package lbadd
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// rawMode returns a function which puts the terminal f into raw mode, where
+// input is read a key at a time without being echoed, and which returns a
+// function restoring the terminal's previous mode. ok is false if f isn't a
+// terminal.
+func rawMode(f *os.File) (raw func() (restore func() error, err error), ok bool) {
+	fd := f.Fd()
+	if _, err := getTermios(fd); err != nil {
+		return nil, false
+	}
+
+	return func() (func() error, error) {
+		old, err := getTermios(fd)
+		if err != nil {
+			return nil, err
+		}
+
+		t := *old
+		t.Iflag &^= syscall.BRKINT | syscall.ICRNL | syscall.INPCK | syscall.ISTRIP | syscall.IXON
+		t.Cflag |= syscall.CS8
+		t.Lflag &^= syscall.ECHO | syscall.ICANON | syscall.IEXTEN | syscall.ISIG
+		t.Cc[syscall.VMIN] = 1
+		t.Cc[syscall.VTIME] = 0
+		if err := setTermios(fd, &t); err != nil {
+			return nil, err
+		}
+
+		return func() error { return setTermios(fd, old) }, nil
+	}, true
+}
+
+func getTermios(fd uintptr) (*syscall.Termios, error) {
+	t := &syscall.Termios{}
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, ioctlGetTermios, uintptr(unsafe.Pointer(t))); errno != 0 {
+		return nil, errno
+	}
+
+	return t, nil
+}
+
+func setTermios(fd uintptr, t *syscall.Termios) error {
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, ioctlSetTermios, uintptr(unsafe.Pointer(t))); errno != 0 {
+		return errno
+	}
+
+	return nil
+}
@@ -0,0 +1,585 @@
+package lbadd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// transaction is a set of changes to the database which either all take
+// effect, once committed, or none do, once rolled back. Changes are made to
+// the tables as they happen, as new versions of rows and tables, and recorded
+// so they can be undone. The transaction sees the database as it was when it
+// began, along with its own changes.
+//
+// A transaction is either begun explicitly with BEGIN, or implicitly for a
+// single statement executed outside of one, which is committed as soon as
+// the statement succeeds.
+type transaction struct {
+	id         txid
+	snap       snapshot
+	level      isolationLevel
+	sx         *sxact      // tracks the conflicts of a serializable transaction
+	changes    []change    // in the order they were made
+	savepoints []savepoint // in the order they were made
+	explicit   bool        // whether the transaction was begun with BEGIN
+	used       bool        // whether a statement has been executed in it
+}
+
+// savepoint marks the changes a transaction had made when it was created,
+// so that the changes made since can be rolled back.
+type savepoint struct {
+	name string
+	mark int // the number of changes which had been made
+}
+
+// The kinds of changes made by a transaction
+type changeKind int
+
+const (
+	changeRow         changeKind = iota // a row was inserted, updated or deleted
+	changeCreateTable                   // a table was created
+	changeDropTable                     // a table was dropped
+)
+
+// change made by a transaction, holding the versions it created and ended so
+// that it can be undone, and the row after the change so that it can be
+// logged.
+type change struct {
+	kind    changeKind
+	table   table         // the table created or dropped, or whose row changed
+	key     key           // the key of the row changed
+	new     row           // the row after the change, nil if it was deleted
+	created *version      // the version of the row created, if any
+	ended   *version      // the version of the row ended, if any
+	catalog *tableVersion // the version of the table created or dropped
+}
+
+// Errors returned by transaction commands used out of turn
+var (
+	errInTransaction = errors.New("a transaction is already in progress")
+	errNoTransaction = errors.New("no transaction is in progress")
+)
+
+// executeBegin begins an explicit transaction, which holds the changes of
+// every statement until it is committed or rolled back.
+func (e *executor) executeBegin() (result, error) {
+	if e.tx != nil {
+		return result{}, errInTransaction
+	}
+
+	e.begin(true)
+	return result{status: "BEGIN"}, nil
+}
+
+// executeCommit commits the explicit transaction in progress.
+func (e *executor) executeCommit() (result, error) {
+	if e.tx == nil {
+		return result{}, errNoTransaction
+	}
+
+	if err := e.commit(); err != nil {
+		return result{}, err
+	}
+	return result{status: "COMMIT"}, nil
+}
+
+// executeRollback undoes every change of the explicit transaction in
+// progress, or only those made since the savepoint it is given, in which case
+// the transaction stays in progress.
+func (e *executor) executeRollback(instr instruction) (result, error) {
+	if e.tx == nil {
+		return result{}, errNoTransaction
+	}
+	if len(instr.params) == 0 {
+		e.rollback()
+		return result{status: "ROLLBACK"}, nil
+	}
+
+	i, err := e.findSavepoint(instr)
+	if err != nil {
+		return result{}, err
+	}
+
+	// The savepoint is kept, so it may be rolled back to again
+	e.undo(e.tx.savepoints[i].mark)
+	e.tx.savepoints = e.tx.savepoints[:i+1]
+	return result{status: "ROLLBACK"}, nil
+}
+
+// executeSavepoint creates a savepoint within the explicit transaction in
+// progress. A savepoint with the same name as an earlier one hides it until
+// released.
+func (e *executor) executeSavepoint(instr instruction) (result, error) {
+	if e.tx == nil {
+		return result{}, errNoTransaction
+	}
+	if len(instr.params) != 1 {
+		return result{}, fmt.Errorf("%s expects a savepoint name", instr.command)
+	}
+
+	e.tx.savepoints = append(e.tx.savepoints, savepoint{name: instr.params[0], mark: len(e.tx.changes)})
+	return result{status: "SAVEPOINT"}, nil
+}
+
+// executeRelease removes the savepoint, and every savepoint created after it,
+// keeping the changes made since.
+func (e *executor) executeRelease(instr instruction) (result, error) {
+	if e.tx == nil {
+		return result{}, errNoTransaction
+	}
+
+	i, err := e.findSavepoint(instr)
+	if err != nil {
+		return result{}, err
+	}
+
+	e.tx.savepoints = e.tx.savepoints[:i]
+	return result{status: "RELEASE"}, nil
+}
+
+// executeIsolation sets the isolation level of the explicit transaction in
+// progress, before any statement has been executed in it.
+func (e *executor) executeIsolation(instr instruction) (result, error) {
+	if e.tx == nil {
+		return result{}, errNoTransaction
+	}
+	if len(instr.params) != 1 {
+		return result{}, fmt.Errorf("%s expects an isolation level", instr.command)
+	}
+	level, err := parseIsolationParam(instr.params[0])
+	if err != nil {
+		return result{}, err
+	}
+	if e.tx.used {
+		return result{}, errors.New("the isolation level must be set before any statement is executed")
+	}
+
+	if e.tx.sx != nil {
+		e.db.ssi.abort(e.tx.sx)
+		e.tx.sx = nil
+	}
+	e.tx.level = level
+	if level == levelSerializable {
+		e.tx.sx = e.db.ssi.register(e.tx.id, e.tx.snap)
+	}
+	return result{status: "SET"}, nil
+}
+
+// findSavepoint returns the index of the latest savepoint of the transaction
+// in progress with the name the instruction is given.
+func (e *executor) findSavepoint(instr instruction) (int, error) {
+	if len(instr.params) != 1 {
+		return 0, fmt.Errorf("%s expects a savepoint name", instr.command)
+	}
+
+	name := instr.params[0]
+	for i := len(e.tx.savepoints) - 1; i >= 0; i-- {
+		if e.tx.savepoints[i].name == name {
+			return i, nil
+		}
+	}
+
+	return 0, fmt.Errorf("savepoint %s does not exist", name)
+}
+
+// inTransaction reports whether an explicit transaction is in progress.
+func (e *executor) inTransaction() bool {
+	return e.tx != nil && e.tx.explicit
+}
+
+// maxStatementRetries is how many times a statement at the read committed
+// level is executed again after failing with a serialization error.
+const maxStatementRetries = 10
+
+// autocommit calls fn within the transaction in progress, undoing its changes
+// if it fails, so that a statement either takes effect as a whole or not at
+// all. Outside of a transaction, fn is executed in a transaction of its own,
+// committed once fn succeeds. A transaction is rolled back as a whole if fn
+// fails with a serialization error, or to break a deadlock.
+//
+// At the read committed level, fn sees the changes committed before it was
+// called, and is called again with those committed since if it fails with a
+// serialization error, as the rows it changed are locked by then. It is
+// retried up to maxStatementRetries times, after which the transaction is
+// rolled back.
+func (e *executor) autocommit(fn func() (result, error)) (result, error) {
+	if e.tx == nil {
+		e.begin(false)
+		res, err := fn()
+		if err != nil {
+			if e.tx != nil {
+				e.rollback()
+			}
+			return result{}, err
+		}
+		if err := e.commit(); err != nil {
+			return result{}, err
+		}
+		return res, nil
+	}
+
+	e.tx.used = true
+	mark := len(e.tx.changes)
+	for retries := 0; ; retries++ {
+		if e.tx.level == levelReadCommitted {
+			e.tx.snap = e.db.txns.refresh(e.tx.id)
+		}
+
+		res, err := fn()
+		switch {
+		case err == nil:
+			return res, nil
+		case e.tx == nil:
+			// The transaction has already been rolled back
+		case e.tx.level == levelReadCommitted && errors.Is(err, ErrSerialization) && retries < maxStatementRetries:
+			e.undo(mark)
+			continue
+		case errors.Is(err, ErrSerialization), errors.Is(err, ErrDeadlock):
+			e.rollback()
+		default:
+			e.undo(mark)
+		}
+
+		return result{}, err
+	}
+}
+
+// read calls fn within the transaction in progress, or outside of one, in a
+// transaction of its own, so that fn sees a consistent snapshot of the
+// database.
+func (e *executor) read(fn func() error) error {
+	_, err := e.autocommit(func() (result, error) {
+		return result{}, fn()
+	})
+	return err
+}
+
+// begin begins a transaction.
+func (e *executor) begin(explicit bool) {
+	id, snap := e.db.txns.begin()
+	e.tx = &transaction{id: id, snap: snap, explicit: explicit}
+}
+
+// commit ends the transaction in progress, writing its changes to the log
+// if the database has one. If they can't be logged, the transaction is
+// rolled back instead.
+func (e *executor) commit() error {
+	tx := e.tx
+	if tx.sx != nil {
+		if err := e.db.ssi.commit(tx.sx); err != nil {
+			e.rollback()
+			return err
+		}
+	}
+	if len(tx.changes) == 0 {
+		e.db.txns.end(tx.id)
+		e.db.locks.releaseAll(tx.id)
+		e.tx = nil
+		return nil
+	}
+
+	// Changes are logged in the order transactions commit, which is the
+	// order in which any changes to the same rows were made.
+	e.db.commitMu.Lock()
+	if e.db.log != nil {
+		if err := e.db.log.write(tx.changes); err != nil {
+			e.db.commitMu.Unlock()
+			e.rollback()
+			return fmt.Errorf("transaction rolled back, the log couldn't be written: %v", err)
+		}
+	}
+	e.db.txns.end(tx.id)
+	e.db.commitMu.Unlock()
+	e.db.locks.releaseAll(tx.id)
+	e.tx = nil
+
+	ended := 0
+	for _, c := range tx.changes {
+		if c.ended != nil || c.kind == changeDropTable {
+			ended++
+		}
+	}
+	e.db.collect(ended)
+
+	return nil
+}
+
+// rollback ends the transaction in progress, undoing all of its changes.
+func (e *executor) rollback() {
+	if e.tx.sx != nil {
+		e.db.ssi.abort(e.tx.sx)
+	}
+	e.undo(0)
+	e.db.txns.end(e.tx.id)
+	e.db.locks.releaseAll(e.tx.id)
+	e.tx = nil
+}
+
+// undo reverts the changes the transaction made since the mark, the number
+// of changes made before it, newest first. Each version the transaction
+// created or ended is locked until it ends, so no other transaction has
+// changed it since, and it is still the newest version of its row when it is
+// undone.
+func (e *executor) undo(mark int) {
+	changes := e.tx.changes
+	for i := len(changes) - 1; i >= mark; i-- {
+		c := changes[i]
+		switch c.kind {
+		case changeRow:
+			c.table.store.update(c.key, func(v value) value {
+				if c.ended != nil {
+					c.ended.xmax = 0
+				}
+				if c.created == nil {
+					return v
+				}
+				if c.created.older == nil {
+					return nil
+				}
+				return c.created.older
+			})
+		case changeCreateTable:
+			e.db.mu.Lock()
+			if c.catalog.older == nil {
+				delete(e.db.tables, c.table.name)
+			} else {
+				e.db.tables[c.table.name] = c.catalog.older
+			}
+			e.db.mu.Unlock()
+		case changeDropTable:
+			e.db.mu.Lock()
+			c.catalog.xmax = 0
+			e.db.mu.Unlock()
+		}
+	}
+
+	e.tx.changes = changes[:mark]
+}
+
+// record adds the change to the transaction in progress.
+func (e *executor) record(c change) {
+	e.tx.changes = append(e.tx.changes, c)
+}
+
+// table returns the version of the table with the name visible to the
+// transaction in progress.
+func (e *executor) table(name string) (table, error) {
+	e.db.mu.RLock()
+	defer e.db.mu.RUnlock()
+
+	for v := e.db.tables[name]; v != nil; v = v.older {
+		if e.tx.visible(v.stamp) {
+			return v.table, nil
+		}
+	}
+
+	return table{}, fmt.Errorf("table %s does not exist", name)
+}
+
+// lookupTable returns the table with the name, as seen by the transaction in
+// progress, or outside of one, as last committed.
+func (e *executor) lookupTable(name string) (t table, err error) {
+	err = e.read(func() error {
+		t, err = e.table(name)
+		return err
+	})
+	return t, err
+}
+
+// lock locks the target in the mode for the transaction in progress, which
+// holds the lock until it ends.
+func (e *executor) lock(ctx context.Context, target lockTarget, mode lockMode) error {
+	return e.db.locks.acquire(ctx, e.tx.id, target, mode, e.cfg.lockTimeout)
+}
+
+// lockRow locks the row with the key exclusively, waiting for any other
+// transaction changing it to end, and records the transaction writing the
+// table.
+func (e *executor) lockRow(ctx context.Context, t table, k key) error {
+	if err := e.writeTable(t); err != nil {
+		return err
+	}
+	if err := e.lock(ctx, tableTarget(t.name), lockIntentExclusive); err != nil {
+		return err
+	}
+
+	return e.lock(ctx, rowTarget(t.name, k), lockExclusive)
+}
+
+// lockMatching locks the rows of the table matching the predicates in the
+// mode, shared or exclusive, for a locking read. It waits for any other
+// transaction changing the rows to end, and fails with a serialization error
+// if one of them has been changed by a transaction which isn't visible.
+func (e *executor) lockMatching(ctx context.Context, t table, preds []expr, mode lockMode) error {
+	intent := lockIntentShared
+	if mode == lockExclusive {
+		intent = lockIntentExclusive
+	}
+	if err := e.lock(ctx, tableTarget(t.name), intent); err != nil {
+		return err
+	}
+
+	// The rows can't be locked while the table is scanned, as waiting for a
+	// lock would hold up the storage
+	keys := []key{}
+	err := e.scanMatching(ctx, t, preds, func(k key, _ row) {
+		keys = append(keys, k)
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, k := range keys {
+		if err := e.lock(ctx, rowTarget(t.name, k), mode); err != nil {
+			return err
+		}
+
+		t.store.update(k, func(v value) value {
+			head, _ := v.(*version)
+			if head != nil && !(e.tx.visible(head.stamp) && head.xmax == 0) {
+				err = ErrSerialization
+			}
+			return v
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// insertRow inserts the row into the table with a new key.
+func (e *executor) insertRow(ctx context.Context, t table, r row) error {
+	if err := e.writeTable(t); err != nil {
+		return err
+	}
+	if err := e.lock(ctx, tableTarget(t.name), lockIntentExclusive); err != nil {
+		return err
+	}
+
+	k := t.newKey()
+	v := &version{stamp: stamp{xmin: e.tx.id}, row: r}
+
+	t.store.insert(k, v)
+	e.record(change{kind: changeRow, table: t, key: k, new: r, created: v})
+	return nil
+}
+
+// putRow inserts the row into the table with the given key, or replaces the
+// row with that key. It waits for any other transaction changing the row to
+// end, and fails with a serialization error if the row has been changed by a
+// transaction which isn't visible.
+func (e *executor) putRow(ctx context.Context, t table, k key, r row) error {
+	if err := e.lockRow(ctx, t, k); err != nil {
+		return err
+	}
+
+	created := &version{stamp: stamp{xmin: e.tx.id}, row: r}
+
+	var ended *version
+	var err error
+	t.store.update(k, func(v value) value {
+		head, _ := v.(*version)
+		switch {
+		case head == nil || e.tx.gone(head.stamp):
+		case e.tx.visible(head.stamp) && head.xmax == 0:
+			head.xmax = e.tx.id
+			ended = head
+		default:
+			err = ErrSerialization
+			return v
+		}
+
+		created.older = head
+		return created
+	})
+	if err != nil {
+		return err
+	}
+
+	e.record(change{kind: changeRow, table: t, key: k, new: r, created: created, ended: ended})
+	return nil
+}
+
+// removeRow deletes the row with the given key from the table. It waits for
+// any other transaction changing the row to end, and fails with a
+// serialization error if the row has been changed by a transaction which
+// isn't visible.
+func (e *executor) removeRow(ctx context.Context, t table, k key) error {
+	if err := e.lockRow(ctx, t, k); err != nil {
+		return err
+	}
+
+	var ended *version
+	var err error
+	t.store.update(k, func(v value) value {
+		head, _ := v.(*version)
+		switch {
+		case head == nil || e.tx.gone(head.stamp):
+		case e.tx.visible(head.stamp) && head.xmax == 0:
+			head.xmax = e.tx.id
+			ended = head
+		default:
+			err = ErrSerialization
+		}
+		return v
+	})
+	if err != nil || ended == nil {
+		return err
+	}
+
+	e.record(change{kind: changeRow, table: t, key: k, ended: ended})
+	return nil
+}
+
+// createTable adds the table to the catalog. It waits for any other
+// transaction using a table with the same name to end, and fails with a
+// serialization error if one has been created or dropped by a transaction
+// which isn't visible.
+func (e *executor) createTable(ctx context.Context, t table) error {
+	if err := e.lock(ctx, tableTarget(t.name), lockExclusive); err != nil {
+		return err
+	}
+
+	e.db.mu.Lock()
+	defer e.db.mu.Unlock()
+
+	head := e.db.tables[t.name]
+	if head != nil {
+		if e.tx.visible(head.stamp) {
+			return fmt.Errorf("table %s already exists", t.name)
+		}
+		if !e.tx.gone(head.stamp) {
+			return ErrSerialization
+		}
+	}
+
+	created := &tableVersion{stamp: stamp{xmin: e.tx.id}, table: t, older: head}
+	e.db.tables[t.name] = created
+	e.record(change{kind: changeCreateTable, table: t, catalog: created})
+	return nil
+}
+
+// dropTable removes the table, and with it all of its rows, from the
+// catalog. It waits for any other transaction changing the table to end, and
+// fails with a serialization error if the table has been dropped by a
+// transaction which isn't visible.
+func (e *executor) dropTable(ctx context.Context, t table) error {
+	if err := e.lock(ctx, tableTarget(t.name), lockExclusive); err != nil {
+		return err
+	}
+
+	e.db.mu.Lock()
+	defer e.db.mu.Unlock()
+
+	head := e.db.tables[t.name]
+	if head == nil || head.store != t.store || head.xmax != 0 {
+		return ErrSerialization
+	}
+
+	head.xmax = e.tx.id
+	e.record(change{kind: changeDropTable, table: t, catalog: head})
+	return nil
+}
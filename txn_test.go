@@ -0,0 +1,215 @@
+package lbadd
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_executor_transactions(t *testing.T) {
+	setup := []string{
+		"CREATE TABLE users (name string NOT NULL, age integer)",
+		"INSERT INTO users VALUES ('Jane', 7), ('John', 42)",
+		"CREATE TABLE old (a integer)",
+		"INSERT INTO old VALUES (1)",
+	}
+
+	cases := []struct {
+		name   string
+		stmts  []string
+		end    string // how the transaction ends
+		users  string // the users once it has ended
+		tables []string
+	}{
+		{
+			name:   "rollback changes to rows",
+			stmts:  []string{"INSERT INTO users VALUES ('Ann', 3)", "UPDATE users SET age = 8 WHERE name = 'Jane'", "DELETE FROM users WHERE name = 'John'"},
+			end:    "ROLLBACK",
+			users:  "Jane 7|John 42",
+			tables: []string{"old", "users"},
+		},
+		{
+			name:   "commit changes to rows",
+			stmts:  []string{"INSERT INTO users VALUES ('Ann', 3)", "UPDATE users SET age = 8 WHERE name = 'Jane'", "DELETE FROM users WHERE name = 'John'"},
+			end:    "COMMIT",
+			users:  "Jane 8|Ann 3",
+			tables: []string{"old", "users"},
+		},
+		{
+			name:   "rollback changes to tables",
+			stmts:  []string{"CREATE TABLE new (a integer)", "INSERT INTO new VALUES (1)", "DROP TABLE old", "CREATE TABLE old (b string)", "DROP TABLE users"},
+			end:    "ROLLBACK",
+			users:  "Jane 7|John 42",
+			tables: []string{"old", "users"},
+		},
+		{
+			name:   "commit changes to tables",
+			stmts:  []string{"CREATE TABLE new (a integer)", "INSERT INTO new VALUES (1)", "DROP TABLE old"},
+			end:    "COMMIT",
+			users:  "Jane 7|John 42",
+			tables: []string{"new", "users"},
+		},
+		{
+			name:   "rollback the same row changed repeatedly",
+			stmts:  []string{"UPDATE users SET age = 1", "UPDATE users SET age = 2 WHERE name = 'Jane'", "DELETE FROM users WHERE age = 2"},
+			end:    "ROLLBACK",
+			users:  "Jane 7|John 42",
+			tables: []string{"old", "users"},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			e := newExecutor(exeConfig{order: 3})
+			for _, sql := range setup {
+				execSQL(t, e, sql)
+			}
+
+			assert.Equal(t, "BEGIN", execSQL(t, e, "BEGIN").status)
+			for _, sql := range tc.stmts {
+				execSQL(t, e, sql)
+			}
+			assert.True(t, e.inTransaction())
+			assert.Equal(t, tc.end, execSQL(t, e, tc.end).status)
+			assert.False(t, e.inTransaction())
+
+			assert.Equal(t, tc.users, displayRows(t, execSQL(t, e, "SELECT * FROM users")))
+			assert.Equal(t, tc.tables, e.tableNames())
+		})
+	}
+}
+
+func Test_executor_transactions_errors(t *testing.T) {
+	e := newExecutor(exeConfig{order: 3})
+
+	_, err := execSQLErr(e, "COMMIT")
+	assert.EqualError(t, err, "no transaction is in progress")
+	_, err = execSQLErr(e, "ROLLBACK")
+	assert.EqualError(t, err, "no transaction is in progress")
+
+	_, err = execSQLErr(e, "SAVEPOINT a")
+	assert.EqualError(t, err, "no transaction is in progress")
+	_, err = execSQLErr(e, "RELEASE a")
+	assert.EqualError(t, err, "no transaction is in progress")
+
+	execSQL(t, e, "BEGIN")
+	_, err = execSQLErr(e, "BEGIN")
+	assert.EqualError(t, err, "a transaction is already in progress")
+	_, err = execSQLErr(e, "ROLLBACK TO a")
+	assert.EqualError(t, err, "savepoint a does not exist")
+	assert.True(t, e.inTransaction())
+}
+
+func Test_executor_savepoints(t *testing.T) {
+	cases := []struct {
+		name   string
+		stmts  []string
+		users  string // the users once the transaction has committed
+		tables []string
+		err    string // the error of the last statement, if it fails
+	}{
+		{
+			name:   "rollback to a savepoint",
+			stmts:  []string{"INSERT INTO users VALUES ('Ann', 3)", "SAVEPOINT a", "UPDATE users SET age = 8", "CREATE TABLE new (a integer)", "DROP TABLE old", "ROLLBACK TO a"},
+			users:  "Jane 7|Ann 3",
+			tables: []string{"old", "users"},
+		},
+		{
+			name:   "rollback to a savepoint repeatedly",
+			stmts:  []string{"SAVEPOINT a", "DELETE FROM users", "ROLLBACK TO SAVEPOINT a", "INSERT INTO users VALUES ('Ann', 3)", "ROLLBACK TO a"},
+			users:  "Jane 7",
+			tables: []string{"old", "users"},
+		},
+		{
+			name:   "rollback to an outer savepoint",
+			stmts:  []string{"SAVEPOINT a", "INSERT INTO users VALUES ('Ann', 3)", "SAVEPOINT b", "DROP TABLE old", "ROLLBACK TO a", "ROLLBACK TO b"},
+			users:  "Jane 7",
+			tables: []string{"old", "users"},
+			err:    "savepoint b does not exist",
+		},
+		{
+			name:   "release keeps the changes",
+			stmts:  []string{"SAVEPOINT a", "INSERT INTO users VALUES ('Ann', 3)", "SAVEPOINT b", "DROP TABLE old", "RELEASE a", "ROLLBACK TO b"},
+			users:  "Jane 7|Ann 3",
+			tables: []string{"users"},
+			err:    "savepoint b does not exist",
+		},
+		{
+			name:   "savepoints with the same name",
+			stmts:  []string{"SAVEPOINT a", "INSERT INTO users VALUES ('Ann', 3)", "SAVEPOINT a", "INSERT INTO users VALUES ('John', 42)", "RELEASE a", "ROLLBACK TO a"},
+			users:  "Jane 7",
+			tables: []string{"old", "users"},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			e := newExecutor(exeConfig{order: 3})
+			execSQL(t, e, "CREATE TABLE users (name string NOT NULL, age integer)")
+			execSQL(t, e, "INSERT INTO users VALUES ('Jane', 7)")
+			execSQL(t, e, "CREATE TABLE old (a integer)")
+
+			execSQL(t, e, "BEGIN")
+			last := len(tc.stmts) - 1
+			for _, sql := range tc.stmts[:last] {
+				execSQL(t, e, sql)
+			}
+			if tc.err != "" {
+				_, err := execSQLErr(e, tc.stmts[last])
+				assert.EqualError(t, err, tc.err)
+			} else {
+				execSQL(t, e, tc.stmts[last])
+			}
+			assert.True(t, e.inTransaction())
+			execSQL(t, e, "COMMIT")
+
+			assert.Equal(t, tc.users, displayRows(t, execSQL(t, e, "SELECT * FROM users")))
+			assert.Equal(t, tc.tables, e.tableNames())
+		})
+	}
+}
+
+func Test_executor_statementAtomicity(t *testing.T) {
+	e := newExecutor(exeConfig{order: 3, fileCopy: true})
+	execSQL(t, e, "CREATE TABLE users (name string NOT NULL, age integer)")
+
+	// A statement which fails outside of a transaction has no effect
+	_, err := execSQLErr(e, "INSERT INTO users VALUES ('Jane', 7), (NULL, 1)")
+	assert.Error(t, err)
+	assert.Equal(t, "", displayRows(t, execSQL(t, e, "SELECT * FROM users")))
+
+	// Within a transaction, only the changes of the statement which failed
+	// are undone
+	execSQL(t, e, "BEGIN")
+	execSQL(t, e, "INSERT INTO users VALUES ('Jane', 7)")
+	_, err = execSQLErr(e, "INSERT INTO users VALUES ('John', 42), (NULL, 1)")
+	assert.Error(t, err)
+	assert.True(t, e.inTransaction())
+	execSQL(t, e, "COMMIT")
+	assert.Equal(t, "Jane 7", displayRows(t, execSQL(t, e, "SELECT * FROM users")))
+
+	// A copy which is canceled once it has inserted a batch of rows has no
+	// effect
+	dir, err := ioutil.TempDir("", "lbadd")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "users.csv")
+	csv := strings.Repeat("Ann,3\n", copyBatchSize+2)
+	assert.NoError(t, ioutil.WriteFile(file, []byte(csv), 0644))
+
+	q, err := parse("COPY users FROM '" + file + "'")
+	if !assert.NoError(t, err) {
+		return
+	}
+	_, err = e.executeQuery(&countdownContext{Context: context.Background(), checks: copyBatchSize + 2}, q)
+	assert.True(t, errors.Is(err, ErrCanceled))
+	assert.Equal(t, "Jane 7", displayRows(t, execSQL(t, e, "SELECT * FROM users")))
+}
@@ -0,0 +1,286 @@
+package lbadd
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// wal is the write-ahead log of a database kept in a file. The changes of
+// each transaction are appended to the log as it commits, so that they last
+// until the database is next written to its file. Each change is a line,
+// and the changes of a transaction are followed by a commit line, e.g.
+//
+//	lbadd log 9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08
+//	create users name string false age integer true
+//	put users 0 x4a616e65 x0000000000000007
+//	put users 1 x4a6f686e null
+//	remove users 0
+//	commit
+//	drop users
+//	commit
+//
+// Rows are written as their records in hex, or null. The header holds the
+// checksum of the database file the log follows on from, so that once the
+// database has been written to its file, the log is no longer replayed even
+// if it couldn't be cleared.
+type wal struct {
+	f   logFile
+	err error // why the log can no longer be written, if it can't
+}
+
+// logFile is the file a log is kept in.
+type logFile interface {
+	io.ReadWriteSeeker
+	io.Closer
+	Truncate(size int64) error
+	Sync() error
+}
+
+// The prefix of the header line of a log
+const walHeader = "lbadd log "
+
+// checksum returns the checksum of a database file's contents, which
+// identifies the log following on from it.
+func checksum(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// openWAL opens the log kept in the file, replaying the transactions it
+// holds into the executor's database if it follows on from the database file with the
+// checksum given. Anything following the last transaction committed is
+// discarded, as it was never committed.
+func openWAL(path, sum string, e *executor) (*wal, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	w := &wal{f: f}
+	if err := w.recover(sum, e); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("log %s: %v", path, err)
+	}
+
+	return w, nil
+}
+
+// recover replays the log into the executor, if it follows on from the
+// database file with the checksum given, and truncates it after the last
+// transaction. Otherwise the log is cleared.
+func (w *wal) recover(sum string, e *executor) error {
+	data, err := ioutil.ReadAll(w.f)
+	if err != nil {
+		return err
+	}
+
+	header := walHeader + sum + "\n"
+	if !bytes.HasPrefix(data, []byte(header)) {
+		return w.reset(sum)
+	}
+
+	end, err := e.replay(data[len(header):])
+	if err != nil {
+		return err
+	}
+	return w.truncate(int64(len(header) + end))
+}
+
+// write appends the changes of a transaction to the log, returning once they
+// have been written to disk. If they can't be, whatever was written of them
+// is cut off again, as it would otherwise be replayed as part of the next
+// transaction logged. If that fails too, the log can't be written anymore.
+func (w *wal) write(changes []change) error {
+	if w.err != nil {
+		return fmt.Errorf("the log is unusable: %v", w.err)
+	}
+
+	var buf bytes.Buffer
+	for _, c := range changes {
+		buf.WriteString(formatChange(c))
+		buf.WriteByte('\n')
+	}
+	buf.WriteString("commit\n")
+
+	end, err := w.f.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	if _, err = w.f.Write(buf.Bytes()); err == nil {
+		err = w.f.Sync()
+	}
+	if err != nil {
+		if terr := w.truncate(end); terr != nil {
+			w.err = terr
+		}
+		return err
+	}
+
+	return nil
+}
+
+// reset clears the log, which then follows on from the database file with
+// the checksum given. A log which couldn't be written can be again once it
+// has been reset.
+func (w *wal) reset(sum string) error {
+	if err := w.truncate(0); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w.f, walHeader+sum+"\n"); err != nil {
+		return err
+	}
+	if err := w.f.Sync(); err != nil {
+		return err
+	}
+
+	w.err = nil
+	return nil
+}
+
+// truncate cuts the log off at the offset, where it is written next.
+func (w *wal) truncate(offset int64) error {
+	if err := w.f.Truncate(offset); err != nil {
+		return err
+	}
+	_, err := w.f.Seek(offset, io.SeekStart)
+	return err
+}
+
+// close closes the log's file.
+func (w *wal) close() error {
+	return w.f.Close()
+}
+
+// formatChange formats a change as a line of the log.
+func formatChange(c change) string {
+	switch c.kind {
+	case changeCreateTable:
+		tokens := []string{"create", c.table.name}
+		for _, col := range c.table.columns {
+			tokens = append(tokens, col.name, col.dataType.String(), strconv.FormatBool(col.isNullable))
+		}
+		return strings.Join(tokens, " ")
+
+	case changeDropTable:
+		return "drop " + c.table.name
+
+	default:
+		if c.new == nil {
+			return fmt.Sprintf("remove %s %d", c.table.name, c.key)
+		}
+
+		tokens := []string{"put", c.table.name, strconv.Itoa(int(c.key))}
+		for _, rec := range c.new {
+			if rec == nil {
+				tokens = append(tokens, "null")
+			} else {
+				tokens = append(tokens, "x"+hex.EncodeToString(rec))
+			}
+		}
+		return strings.Join(tokens, " ")
+	}
+}
+
+// replay applies the transactions committed in the log to the executor,
+// returning the length of the log up to the end of the last one.
+func (e *executor) replay(log []byte) (int, error) {
+	end, offset := 0, 0
+	pending := [][]string{}
+
+	sc := bufio.NewScanner(bytes.NewReader(log))
+	sc.Buffer(nil, len(log)+1)
+	for line := 1; sc.Scan(); line++ {
+		offset += len(sc.Bytes()) + 1
+		if offset > len(log) {
+			// The last line wasn't terminated, so was never committed
+			break
+		}
+
+		tokens := strings.Fields(sc.Text())
+		if len(tokens) != 1 || tokens[0] != "commit" {
+			pending = append(pending, tokens)
+			continue
+		}
+
+		_, err := e.autocommit(func() (result, error) {
+			for i, tokens := range pending {
+				if err := e.applyChange(context.Background(), tokens); err != nil {
+					return result{}, fmt.Errorf("line %d: %v", line-len(pending)+i, err)
+				}
+			}
+			return result{}, nil
+		})
+		if err != nil {
+			return 0, err
+		}
+		pending = pending[:0]
+		end = offset
+	}
+
+	return end, sc.Err()
+}
+
+// applyChange applies a change read from the log to the tables, within the
+// transaction in progress.
+func (e *executor) applyChange(ctx context.Context, tokens []string) error {
+	if len(tokens) < 2 {
+		return fmt.Errorf("invalid change")
+	}
+
+	name := tokens[1]
+	if tokens[0] == "create" {
+		cols, err := parseInsertColumns(tokens[2:])
+		if err != nil {
+			return err
+		}
+		return e.createTable(ctx, newTable(name, cols, e.cfg.order))
+	}
+
+	t, err := e.table(name)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case tokens[0] == "drop" && len(tokens) == 2:
+		return e.dropTable(ctx, t)
+	case tokens[0] == "remove" && len(tokens) == 3:
+		k, err := strconv.Atoi(tokens[2])
+		if err != nil {
+			return err
+		}
+		return e.removeRow(ctx, t, key(k))
+	case tokens[0] == "put" && len(tokens) == 3+len(t.columns):
+		k, err := strconv.Atoi(tokens[2])
+		if err != nil {
+			return err
+		}
+
+		r := make(row, len(t.columns))
+		for i, tok := range tokens[3:] {
+			if tok == "null" {
+				continue
+			}
+			if !strings.HasPrefix(tok, "x") {
+				return fmt.Errorf("invalid record %s", tok)
+			}
+			if r[i], err = hex.DecodeString(tok[1:]); err != nil {
+				return err
+			}
+		}
+
+		t.useKey(key(k))
+		return e.putRow(ctx, t, key(k), r)
+	default:
+		return fmt.Errorf("invalid change %s", tokens[0])
+	}
+}
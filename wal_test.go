@@ -0,0 +1,195 @@
+package lbadd
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_wal(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lbadd")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "data.db-wal")
+	sum := checksum(nil)
+
+	e := newExecutor(exeConfig{order: 3})
+	e.db.log, err = openWAL(path, sum, e)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	execSQL(t, e, "CREATE TABLE users (name string NOT NULL, age integer)")
+	execSQL(t, e, "INSERT INTO users VALUES ('Jane', 7), ('', NULL), ('John', 42)")
+	execSQL(t, e, "BEGIN")
+	execSQL(t, e, "DELETE FROM users WHERE name = 'Jane'")
+	execSQL(t, e, "UPDATE users SET age = 1 WHERE name = ''")
+	execSQL(t, e, "CREATE TABLE tmp (a boolean)")
+	execSQL(t, e, "DROP TABLE tmp")
+	execSQL(t, e, "COMMIT")
+
+	// Changes which are rolled back, or never committed, aren't logged
+	execSQL(t, e, "BEGIN")
+	execSQL(t, e, "INSERT INTO users VALUES ('Ann', 3)")
+	execSQL(t, e, "ROLLBACK")
+	execSQL(t, e, "BEGIN")
+	execSQL(t, e, "DELETE FROM users")
+	assert.NoError(t, e.db.log.close())
+
+	data, err := ioutil.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, walHeader+sum+`
+create users name string false age integer true
+commit
+put users 0 x4a616e65 x0000000000000007
+put users 1 x null
+put users 2 x4a6f686e x000000000000002a
+commit
+remove users 0
+put users 1 x x0000000000000001
+create tmp a boolean true
+drop tmp
+commit
+`, string(data))
+
+	// A torn change following the last commit is discarded
+	torn := append(data, "put users 3 x41"...)
+	assert.NoError(t, ioutil.WriteFile(path, torn, 0644))
+
+	replayed := newExecutor(exeConfig{order: 3})
+	replayed.db.log, err = openWAL(path, sum, replayed)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, []string{"users"}, replayed.tableNames())
+	assert.Equal(t, " 1|John 42", displayRows(t, execSQL(t, replayed, "SELECT * FROM users")))
+
+	// Rows inserted once the log has been replayed follow those replayed
+	execSQL(t, replayed, "INSERT INTO users VALUES ('Ann', 3)")
+	assert.NoError(t, replayed.db.log.close())
+
+	data, err = ioutil.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Contains(t, string(data), "commit\nput users 3 x416e6e x0000000000000003\ncommit\n")
+
+	// The log is cleared if it doesn't follow on from the database's file
+	other := newExecutor(exeConfig{order: 3})
+	other.db.log, err = openWAL(path, checksum([]byte("-- lbadd database dump\n")), other)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, []string{}, other.tableNames())
+	assert.NoError(t, other.db.log.close())
+}
+
+func Test_executor_replay_errors(t *testing.T) {
+	cases := []struct {
+		name string
+		log  string
+		err  string
+	}{
+		{
+			name: "unknown table",
+			log:  "remove users 1\ncommit\n",
+			err:  "line 1: table users does not exist",
+		},
+		{
+			name: "table exists",
+			log:  "create a\ncommit\ncreate b\ncreate a\ncommit\n",
+			err:  "line 4: table a already exists",
+		},
+		{
+			name: "wrong number of records",
+			log:  "create a a integer true\nput a 1 x00 x00\ncommit\n",
+			err:  "line 2: invalid change put",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			e := newExecutor(exeConfig{order: 3})
+			_, err := e.replay([]byte(tc.log))
+			assert.EqualError(t, err, tc.err)
+		})
+	}
+}
+
+// faultyFile is a log file which fails to write all of what it's given, and
+// to be truncated if truncateErr is set.
+type faultyFile struct {
+	*os.File
+	failWrites  bool
+	truncateErr error
+}
+
+func (f *faultyFile) Write(p []byte) (int, error) {
+	if !f.failWrites {
+		return f.File.Write(p)
+	}
+
+	n, _ := f.File.Write(p[:len(p)/2])
+	return n, errors.New("disk full")
+}
+
+func (f *faultyFile) Truncate(size int64) error {
+	if f.truncateErr != nil {
+		return f.truncateErr
+	}
+	return f.File.Truncate(size)
+}
+
+func Test_wal_writeErrors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lbadd")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "data.db-wal")
+	sum := checksum(nil)
+
+	e := newExecutor(exeConfig{order: 3})
+	e.db.log, err = openWAL(path, sum, e)
+	if !assert.NoError(t, err) {
+		return
+	}
+	f := &faultyFile{File: e.db.log.f.(*os.File)}
+	e.db.log.f = f
+
+	execSQL(t, e, "CREATE TABLE t (a integer)")
+
+	// What was written of a transaction which couldn't be logged is cut off,
+	// rather than being replayed with the next
+	f.failWrites = true
+	_, err = execSQLErr(e, "INSERT INTO t VALUES (1)")
+	assert.EqualError(t, err, "transaction rolled back, the log couldn't be written: disk full")
+	f.failWrites = false
+	execSQL(t, e, "INSERT INTO t VALUES (2)")
+
+	data, err := ioutil.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, walHeader+sum+"\ncreate t a integer true\ncommit\nput t 1 x0000000000000002\ncommit\n", string(data))
+
+	// If it can't be cut off, the log can't be written until it's reset
+	f.failWrites, f.truncateErr = true, errors.New("read-only file system")
+	_, err = execSQLErr(e, "INSERT INTO t VALUES (3)")
+	assert.EqualError(t, err, "transaction rolled back, the log couldn't be written: disk full")
+	f.failWrites = false
+	_, err = execSQLErr(e, "INSERT INTO t VALUES (4)")
+	assert.EqualError(t, err, "transaction rolled back, the log couldn't be written: the log is unusable: read-only file system")
+	assert.Equal(t, "2", displayRows(t, execSQL(t, e, "SELECT a FROM t")))
+
+	f.truncateErr = nil
+	assert.NoError(t, e.db.log.reset(sum))
+	execSQL(t, e, "INSERT INTO t VALUES (5)")
+	assert.NoError(t, e.db.log.close())
+
+	data, err = ioutil.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, walHeader+sum+"\nput t 4 x0000000000000005\ncommit\n", string(data))
+}